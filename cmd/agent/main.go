@@ -23,7 +23,10 @@ func main() {
 	log.Printf("Build commit: %s", buildCommit)
 	config := agent.NewConfig()
 	config.ParseConfig()
-	agent := agent.New(config)
+	agent, err := agent.New(config)
+	if err != nil {
+		log.Fatal(err)
+	}
 	logger.Info("Agent", config.Address)
 	exit, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM|syscall.SIGINT|syscall.SIGQUIT)
 	defer stop()
@@ -1,17 +1,128 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/Nexadis/metalert/internal/auth"
 	"github.com/Nexadis/metalert/internal/utils/asymcrypt"
+	"github.com/Nexadis/metalert/internal/utils/keyring"
 	"github.com/Nexadis/metalert/internal/utils/logger"
 )
 
 func main() {
-	var keyfile string
+	var keyfile, apiKey, scopes, prefixes string
 	logger.Enable()
+	if len(os.Args) > 1 && os.Args[1] == "rotate" {
+		err := rotate(os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	flag.StringVar(&keyfile, "o", "key", "Prefix for public and private keys")
+	flag.StringVar(&apiKey, "api-key", "", "Create API key with this name instead of RSA keys")
+	flag.StringVar(&scopes, "scopes", string(auth.Write), "Comma separated scopes of API key: read, write, admin")
+	flag.StringVar(&prefixes, "prefixes", "", "Comma separated metric name prefixes of API key, all metrics if empty")
 	flag.Parse()
+	if apiKey != "" {
+		err := newAPIKey(apiKey, scopes, prefixes)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	log.Fatal(asymcrypt.NewPem(keyfile))
 }
+
+// rotate Создаёт пару RSA-ключей с ID и, если задан файл связки, добавляет в неё приватный ключ
+// и новый ключ подписи с тем же ID, делая его текущим. Прежние ключи остаются в связке,
+// пока агенты не перейдут на новые. Ключ подписи для агентов выводится в stderr
+func rotate(args []string) error {
+	var keyfile, id, keyringFile string
+	set := flag.NewFlagSet("rotate", flag.ExitOnError)
+	set.StringVar(&keyfile, "o", "key", "Prefix for public and private keys, ID is appended")
+	set.StringVar(&id, "id", time.Now().UTC().Format("20060102150405"), "ID of new keys")
+	set.StringVar(&keyringFile, "keyring", "", "Keyring of server to add new keys to")
+	set.Parse(args)
+	prefix := keyfile + "_" + id
+	if keyringFile == "" {
+		return asymcrypt.NewPemID(prefix, id)
+	}
+	f, err := keyring.ReadFile(keyringFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if _, ok := f.SignKeys[id]; ok {
+		return fmt.Errorf("%w: %q already in keyring", keyring.ErrInvalidKey, id)
+	}
+	err = asymcrypt.NewPemID(prefix, id)
+	if err != nil {
+		return err
+	}
+	signKey, err := keyring.NewSignKey()
+	if err != nil {
+		return err
+	}
+	privname, err := filepath.Abs(prefix + "_priv.pem")
+	if err != nil {
+		return err
+	}
+	if f.SignKeys == nil {
+		f.SignKeys = make(map[string]string)
+	}
+	f.SignKeys[id] = signKey
+	f.CryptoKeys = append(f.CryptoKeys, privname)
+	f.Current = id
+	err = f.Write(keyringFile)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Sign key %s: %s\n", id, signKey)
+	return nil
+}
+
+// newAPIKey Создаёт токен и печатает его вместе с записью ключа для api_keys в конфигурации сервера.
+// Токен выводится один раз, сервер хранит только хеш
+func newAPIKey(name, scopes, prefixes string) error {
+	token, err := auth.NewToken()
+	if err != nil {
+		return err
+	}
+	k := auth.Key{
+		Name: name,
+		Hash: auth.HashToken(token),
+	}
+	for _, s := range split(scopes) {
+		k.Scopes = append(k.Scopes, auth.Scope(s))
+	}
+	k.Prefixes = split(prefixes)
+	err = k.Check()
+	if err != nil {
+		return err
+	}
+	entry, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Token:", token)
+	fmt.Println(string(entry))
+	return nil
+}
+
+func split(s string) []string {
+	var result []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
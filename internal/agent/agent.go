@@ -6,9 +6,11 @@ package agent
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math/rand"
 	"reflect"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
@@ -16,9 +18,11 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/Nexadis/metalert/internal/agent/client"
+	"github.com/Nexadis/metalert/internal/agent/spool"
 	"github.com/Nexadis/metalert/internal/models"
 	"github.com/Nexadis/metalert/internal/utils/asymcrypt"
 	"github.com/Nexadis/metalert/internal/utils/logger"
+	"github.com/Nexadis/metalert/internal/utils/tlsconfig"
 )
 
 // TransportType создаёт тип для видов передачи метрик
@@ -49,24 +53,33 @@ const (
 
 const MetricsBufSize = 100
 
+// ShutdownTimeout - Время на отправку последней пачки после завершения контекста агента
+const ShutdownTimeout = 5 * time.Second
+
 // RuntimeNames - набор Runtime метрик, список которых заполняется один раз с помощью reflect и многократно используется
 var RuntimeNames []string
 
 // MetricPoster интерфейс для отправки метрик как через URL, так и JSON-объектами.
 type MetricPoster interface {
 	Post(ctx context.Context, m models.Metric) error
+	PostBatch(ctx context.Context, ms models.Metrics) error
 }
 
 // Agent собирает и отправляет метрики
 type Agent struct {
-	config  *Config
-	counter models.Counter
-	client  MetricPoster
+	config      *Config
+	counter     models.Counter
+	numGC       uint32 // количество сборок мусора, паузы которых уже учтены
+	client      MetricPoster
+	spool       *spool.Spool
+	replayMutex sync.Mutex
+	cumulative  map[string]models.Counter // последние отправленные накопленные counter по ключу серии, под replayMutex
 }
 
-// New - Конструктор для Agent
-func New(config *Config) *Agent {
-	key, err := asymcrypt.ReadPem(config.CryptoKey)
+// New - Конструктор для Agent. Возвращает ошибку, если не удалось загрузить настройки TLS
+// или открыть спул: без них агент не сможет отправлять метрики так, как настроен
+func New(config *Config) (*Agent, error) {
+	key, keyID, err := asymcrypt.ReadPemID(config.CryptoKey)
 	if err != nil {
 		logger.Error(err)
 	}
@@ -75,14 +88,35 @@ func New(config *Config) *Agent {
 
 	generalOps := []client.FOption{
 		client.SetSignKey(config.Key),
+		client.SetKeyID(config.KeyID),
+		client.SetAPIKey(config.APIKey),
 		client.SetPubKey(key),
+		client.SetCryptoKeyID(keyID),
+		client.SetScheme(config.Scheme),
+	}
+	if config.Scheme == client.HTTPSScheme {
+		tlsConfig, err := tlsconfig.Client(config.TLSCA, config.TLSCert, config.TLSKey, config.TLSMinVersion)
+		if err != nil {
+			return nil, fmt.Errorf("can't load TLS config: %w", err)
+		}
+		generalOps = append(generalOps, client.SetTLS(tlsConfig))
 	}
 	c := chooseClient(config, generalOps)
 	agent := &Agent{
 		config: config,
 		client: c,
 	}
-	return agent
+	if config.SpoolDir != "" {
+		agent.spool, err = spool.Open(
+			config.SpoolDir,
+			config.SpoolMaxSize,
+			time.Duration(config.SpoolMaxAge)*time.Second,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("can't open spool: %w", err)
+		}
+	}
+	return agent, nil
 }
 
 func chooseClient(c *Config, ops []client.FOption) MetricPoster {
@@ -93,7 +127,7 @@ func chooseClient(c *Config, ops []client.FOption) MetricPoster {
 	case JSONType:
 		choosenClient = client.NewJSON(c.Address, ops...)
 	case GRPCType:
-		choosenClient = client.NewGRPC(c.Address)
+		choosenClient = client.NewGRPC(c.Address, ops...)
 	}
 	return choosenClient
 }
@@ -101,15 +135,23 @@ func chooseClient(c *Config, ops []client.FOption) MetricPoster {
 // Run запускает в фоне агент, начинает собирать и отправлять метрики с заданными интервалами
 func (ha *Agent) Run(ctx context.Context) error {
 	mchan := make(chan models.Metric, MetricsBufSize)
+	batches := make(chan models.Metrics, 1)
 	grp, ctx := errgroup.WithContext(ctx)
+	// отправители отменяются не сразу, чтобы успеть отправить последнюю пачку из Collect
+	reportCtx, cancel := graceContext(ctx, ShutdownTimeout)
+	defer cancel()
 	for i := 1; int64(i) <= ha.config.RateLimit; i++ {
 		i := i
 		logger.Info("Start reporter", i)
 		grp.Go(func() error {
-			return ha.Report(ctx, mchan)
+			return ha.Report(reportCtx, batches)
 		})
 	}
-	pullTicker := time.NewTicker(time.Duration(ha.config.PollInterval) * time.Second)
+	grp.Go(func() error {
+		ha.Collect(ctx, mchan, batches)
+		return nil
+	})
+	pullTicker := time.NewTicker(interval(ha.config.PollInterval))
 	for {
 		select {
 		case <-ctx.Done():
@@ -121,6 +163,26 @@ func (ha *Agent) Run(ctx context.Context) error {
 	}
 }
 
+// graceContext Возвращает контекст без значений ctx, который отменяется через timeout после отмены ctx
+func graceContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	grace, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-grace.Done():
+			return
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-grace.Done():
+		}
+	}()
+	return grace, cancel
+}
+
 // pullCustom получает нестандартные метрики, определенные разработчиком
 func (ha *Agent) pullCustom(ctx context.Context, mchan chan models.Metric) {
 	customMetrics := make([]models.Metric, 0, 5)
@@ -132,12 +194,15 @@ func (ha *Agent) pullCustom(ctx context.Context, mchan chan models.Metric) {
 		return
 	}
 	customMetrics = append(customMetrics, m)
+	// PollCount отправляется накопленным значением: повторная отправка пачки не удваивает счётчик,
+	// а после перезапуска агента сервер распознаёт сброс
 	ha.counter += 1
 	m, err = models.NewMetric("PollCount", models.CounterType, ha.counter.String())
 	if err != nil {
 		logger.Error(err)
 		return
 	}
+	m.Kind = models.CumulativeKind
 	customMetrics = append(customMetrics, m)
 	v, _ := memStat.VirtualMemory()
 	totalMemory := models.Gauge(v.Total)
@@ -198,6 +263,36 @@ func (ha *Agent) pullRuntime(ctx context.Context, mchan chan models.Metric) {
 			return
 		}
 	}
+	h, err := gcPauses(memStats, ha.numGC, ha.config.GCPauseBuckets)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	ha.numGC = memStats.NumGC
+	select {
+	case mchan <- models.Metric{ID: "GCPause", MType: models.HistogramType, Histogram: h}:
+	case <-ctx.Done():
+	}
+}
+
+// gcPauses Строит гистограмму пауз GC в секундах для сборок мусора, прошедших после numGC.
+// runtime хранит только 256 последних пауз, более старые не учитываются
+func gcPauses(memStats *runtime.MemStats, numGC uint32, bounds []float64) (*models.Histogram, error) {
+	h, err := models.NewHistogram(bounds)
+	if err != nil {
+		return nil, err
+	}
+	if memStats.NumGC < numGC {
+		numGC = 0
+	}
+	pauses := uint32(len(memStats.PauseNs))
+	if memStats.NumGC-numGC > pauses {
+		numGC = memStats.NumGC - pauses
+	}
+	for i := numGC; i < memStats.NumGC; i++ {
+		h.Observe(time.Duration(memStats.PauseNs[i%pauses]).Seconds())
+	}
+	return h, nil
 }
 
 // Pull внешняя функция для получения всех метрик
@@ -207,17 +302,253 @@ func (ha *Agent) Pull(ctx context.Context, mchan chan models.Metric) {
 	logger.Info("Metrics pulled")
 }
 
-// Report отправляет метрики на адрес, заданный в конфигурации, всеми доступными способами
-func (ha *Agent) Report(ctx context.Context, input chan models.Metric) error {
-	for m := range input {
-		logger.Info("Post metric", m.ID)
-		err := ha.client.Post(ctx, m)
+// Collect накапливает собранные метрики и раз в ReportInterval передаёт их пачкой на отправку.
+// При завершении контекста или закрытии input накопленная пачка передаётся на отправку
+// в течение ShutdownTimeout, чтобы последние метрики не терялись
+func (ha *Agent) Collect(ctx context.Context, input chan models.Metric, output chan models.Metrics) {
+	defer close(output)
+	acc := newAccumulator()
+	add := func(m models.Metric) {
+		if len(ha.config.Labels) != 0 {
+			m.Labels = ha.config.Labels
+		}
+		acc.add(m)
+	}
+	reportTicker := time.NewTicker(interval(ha.config.ReportInterval))
+	defer reportTicker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// метрики, собранные до завершения, ещё могут лежать в буфере
+			for drained := false; !drained; {
+				select {
+				case m, ok := <-input:
+					if !ok {
+						drained = true
+						continue
+					}
+					add(m)
+				default:
+					drained = true
+				}
+			}
+			flush(acc, output)
+			return
+		case m, ok := <-input:
+			if !ok {
+				flush(acc, output)
+				return
+			}
+			add(m)
+		case <-reportTicker.C:
+			if acc.Len() == 0 {
+				continue
+			}
+			batch := acc.flush()
+			select {
+			case output <- batch:
+			case <-ctx.Done():
+				// пачка возвращается в накопитель и передаётся на отправку при завершении
+				for _, m := range batch {
+					acc.add(m)
+				}
+			}
+		}
+	}
+}
+
+// flush Передаёт накопленную пачку на отправку при завершении. Если отправители уже остановлены,
+// пачка отбрасывается через ShutdownTimeout
+func flush(acc *accumulator, output chan models.Metrics) {
+	if acc.Len() == 0 {
+		return
+	}
+	batch := acc.flush()
+	timer := time.NewTimer(ShutdownTimeout)
+	defer timer.Stop()
+	select {
+	case output <- batch:
+	case <-timer.C:
+		logger.Error("Drop metrics on shutdown:", len(batch))
+	}
+}
+
+// Report отправляет пачки метрик на адрес, заданный в конфигурации.
+// Если настроено хранилище на диске, неотправленные пачки сохраняются в нём и отправляются позже.
+// Накопленные counter отправляются отдельно от остальных метрик, см. reportCumulative
+func (ha *Agent) Report(ctx context.Context, input chan models.Metrics) error {
+	for ms := range input {
+		logger.Info("Post metrics", len(ms))
+		ms, cumulative := splitCumulative(ms)
+		if len(ms) != 0 {
+			err := ha.report(ctx, ms)
+			if err != nil {
+				return err
+			}
+		}
+		if len(cumulative) != 0 {
+			err := ha.reportCumulative(ctx, cumulative)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// report Отправляет пачку, а если настроено хранилище на диске - сначала сохранённые пачки
+func (ha *Agent) report(ctx context.Context, ms models.Metrics) error {
+	if ha.spool != nil {
+		ha.reportSpooled(ctx, ms)
+		return nil
+	}
+	err := ha.client.PostBatch(ctx, ms)
+	if retry, ok := rejected(err, ms); ok && len(retry) == 0 {
+		return nil
+	}
+	if err != nil {
+		logger.Error("Can't report metrics")
+		return err
+	}
+	return nil
+}
+
+// reportCumulative Отправляет накопленные counter по одной пачке за раз под replayMutex,
+// после сохранённых на диске пачек. Отправители работают параллельно, и без этого старое значение
+// могло бы прийти на сервер после нового и считаться сбросом счётчика
+func (ha *Agent) reportCumulative(ctx context.Context, ms models.Metrics) error {
+	ha.replayMutex.Lock()
+	defer ha.replayMutex.Unlock()
+	ms = ha.dropStale(ms)
+	if len(ms) == 0 {
+		return nil
+	}
+	if ha.spool != nil {
+		ha.sendSpooled(ctx, ms, ha.replay(ctx))
+		return nil
+	}
+	err := ha.client.PostBatch(ctx, ms)
+	if retry, ok := rejected(err, ms); ok && len(retry) == 0 {
+		return nil
+	}
+	if err != nil {
+		logger.Error("Can't report metrics")
+		return err
+	}
+	return nil
+}
+
+// dropStale Убирает накопленные counter, значение которых меньше уже отправленного этим агентом:
+// значения только растут, поэтому меньшее значение старее и уже учтено в отправленном.
+// Вызывается под replayMutex
+func (ha *Agent) dropStale(ms models.Metrics) models.Metrics {
+	if ha.cumulative == nil {
+		ha.cumulative = make(map[string]models.Counter)
+	}
+	fresh := make(models.Metrics, 0, len(ms))
+	for _, m := range ms {
+		key := m.Series()
+		if last, ok := ha.cumulative[key]; ok && *m.Delta < last {
+			logger.Info("Drop stale cumulative counter", key)
+			continue
+		}
+		ha.cumulative[key] = *m.Delta
+		fresh = append(fresh, m)
+	}
+	return fresh
+}
+
+// splitCumulative Отделяет накопленные counter от остальных метрик пачки
+func splitCumulative(ms models.Metrics) (models.Metrics, models.Metrics) {
+	var rest, cumulative models.Metrics
+	for _, m := range ms {
+		if m.IsCumulative() && m.Delta != nil {
+			cumulative = append(cumulative, m)
+			continue
+		}
+		rest = append(rest, m)
+	}
+	return rest, cumulative
+}
+
+// rejected Разбирает ошибку отправки пачки ms. Если сервер отклонил отдельные метрики,
+// возвращает те из них, которые можно отправить повторно, остальные только записываются в лог
+func rejected(err error, ms models.Metrics) (models.Metrics, bool) {
+	var batchErr *models.BatchError
+	if !errors.As(err, &batchErr) {
+		return nil, false
+	}
+	retry := batchErr.Retry(ms)
+	if dropped := len(batchErr.Errors) - len(retry); dropped != 0 {
+		logger.Error("Server rejected metrics:", batchErr)
+	}
+	return retry, true
+}
+
+// reportSpooled отправляет сохранённые пачки, затем текущую. При ошибке текущая пачка сохраняется на диск.
+// Если сервер отклонил отдельные метрики, сохраняются только те, которые можно повторить
+func (ha *Agent) reportSpooled(ctx context.Context, ms models.Metrics) {
+	ha.sendSpooled(ctx, ms, ha.Replay(ctx))
+}
+
+// sendSpooled Отправляет пачку, если сохранённые пачки отправлены без ошибки err, иначе сохраняет её на диск
+func (ha *Agent) sendSpooled(ctx context.Context, ms models.Metrics, err error) {
+	if err == nil {
+		err = ha.client.PostBatch(ctx, ms)
+		if retry, ok := rejected(err, ms); ok {
+			ms = retry
+		}
+	}
+	if err == nil || len(ms) == 0 {
+		return
+	}
+	logger.Error("Can't report metrics, save to spool:", err)
+	err = ha.spool.Push(ms)
+	if err != nil {
+		logger.Error("Can't save metrics to spool:", err)
+	}
+}
+
+// Replay отправляет сохранённые на диске пачки в порядке их сохранения
+func (ha *Agent) Replay(ctx context.Context) error {
+	ha.replayMutex.Lock()
+	defer ha.replayMutex.Unlock()
+	return ha.replay(ctx)
+}
+
+// replay Отправляет сохранённые пачки. Вызывается под replayMutex
+func (ha *Agent) replay(ctx context.Context) error {
+	for {
+		ms, err := ha.spool.Peek()
+		if errors.Is(err, spool.ErrEmpty) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		err = ha.client.PostBatch(ctx, ms)
+		// пачка, в которой нечего повторять, удаляется из очереди.
+		// Метрики, которые можно повторить, отклоняются вместе со всей пачкой, поэтому она отправляется заново целиком
+		if retry, ok := rejected(err, ms); ok && len(retry) == 0 {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		err = ha.spool.Pop()
 		if err != nil {
-			logger.Error("Can't report metrics")
 			return err
 		}
+		logger.Info("Replayed metrics from spool", len(ms))
 	}
-	return nil
+}
+
+// interval переводит интервал в секундах в time.Duration, неположительные значения заменяются на секунду
+func interval(seconds int64) time.Duration {
+	if seconds <= 0 {
+		return time.Second
+	}
+	return time.Duration(seconds) * time.Second
 }
 
 // defineRuntimes получает имена всех метрик из runtime
@@ -2,12 +2,22 @@ package agent
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
 
+	"github.com/Nexadis/metalert/internal/agent/client"
+	"github.com/Nexadis/metalert/internal/agent/spool"
 	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage/mem"
 )
 
 var endpoint = "localhost:8080"
@@ -137,6 +147,16 @@ func (c *testClient) Post(ctx context.Context, m models.Metric) error {
 	return nil
 }
 
+func (c *testClient) PostBatch(ctx context.Context, ms models.Metrics) error {
+	for _, m := range ms {
+		err := c.Post(ctx, m)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func TestReport(t *testing.T) {
 	t.Log("Run goroutine report")
 	type want struct {
@@ -217,11 +237,11 @@ func TestReport(t *testing.T) {
 				config: config,
 				client: testClient,
 			}
-			mchan := make(chan models.Metric, 1)
+			mchan := make(chan models.Metrics, 1)
 			ctx := context.Background()
 			m, err := models.NewMetric(test.want.name, test.want.valType, test.want.value)
 			assert.NoError(t, err)
-			mchan <- m
+			mchan <- models.Metrics{m}
 			close(mchan)
 			ha.Report(ctx, mchan)
 			ctx.Done()
@@ -232,23 +252,212 @@ func TestReport(t *testing.T) {
 	}
 }
 
+type batchClient struct {
+	batches []models.Metrics
+}
+
+func (c *batchClient) Post(ctx context.Context, m models.Metric) error {
+	return c.PostBatch(ctx, models.Metrics{m})
+}
+
+func (c *batchClient) PostBatch(ctx context.Context, ms models.Metrics) error {
+	c.batches = append(c.batches, ms)
+	return nil
+}
+
+func TestCollect(t *testing.T) {
+	ha := &Agent{
+		config: &Config{
+			ReportInterval: 1,
+		},
+	}
+	input := make(chan models.Metric, MetricsBufSize)
+	output := make(chan models.Metrics)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ha.Collect(ctx, input, output)
+	for i := 0; i < 3; i++ {
+		ha.pullCustom(ctx, input)
+	}
+	batch := <-output
+	cancel()
+	metrics := make(map[string]string, len(batch))
+	for _, m := range batch {
+		v, err := m.GetValue()
+		assert.NoError(t, err)
+		metrics[m.ID] = v
+	}
+	assert.Len(t, batch, 5)
+	assert.Equal(t, "3", metrics["PollCount"])
+	_, ok := <-output
+	assert.False(t, ok)
+
+	client := &batchClient{}
+	ha.client = client
+	batches := make(chan models.Metrics, 1)
+	batches <- batch
+	close(batches)
+	assert.NoError(t, ha.Report(context.Background(), batches))
+	// накопленный PollCount отправляется отдельной пачкой
+	rest, cumulative := splitCumulative(batch)
+	assert.Len(t, rest, 4)
+	assert.Equal(t, []models.Metrics{rest, cumulative}, client.batches)
+}
+
+func TestCollectShutdown(t *testing.T) {
+	ha := &Agent{
+		config: &Config{
+			ReportInterval: 100,
+		},
+	}
+	input := make(chan models.Metric, MetricsBufSize)
+	output := make(chan models.Metrics)
+	ctx, cancel := context.WithCancel(context.Background())
+	ha.pullCustom(ctx, input)
+	cancel()
+	go ha.Collect(ctx, input, output)
+	// пачка, накопленная до завершения, передаётся на отправку, не дожидаясь ReportInterval
+	batch, ok := <-output
+	require.True(t, ok)
+	assert.Len(t, batch, 5)
+	_, ok = <-output
+	assert.False(t, ok)
+
+	grace, stop := graceContext(ctx, 50*time.Millisecond)
+	defer stop()
+	assert.NoError(t, grace.Err())
+	<-grace.Done()
+}
+
+type flakyClient struct {
+	batchClient
+	fail bool
+}
+
+func (c *flakyClient) PostBatch(ctx context.Context, ms models.Metrics) error {
+	if c.fail {
+		return errors.New("server is unavailable")
+	}
+	return c.batchClient.PostBatch(ctx, ms)
+}
+
+func TestReportSpooled(t *testing.T) {
+	s, err := spool.Open(t.TempDir(), 0, 0)
+	assert.NoError(t, err)
+	client := &flakyClient{fail: true}
+	ha := &Agent{
+		config: &Config{},
+		client: client,
+		spool:  s,
+	}
+	batches := make(chan models.Metrics, 3)
+	for i := 0; i < 3; i++ {
+		m, err := models.NewMetric("PollCount", models.CounterType, fmt.Sprint(i+1))
+		assert.NoError(t, err)
+		batches <- models.Metrics{m}
+		if i == 1 {
+			close(batches)
+			assert.NoError(t, ha.Report(context.Background(), batches))
+			assert.Equal(t, 2, s.Len())
+			assert.Empty(t, client.batches)
+			client.fail = false
+			batches = make(chan models.Metrics, 1)
+		}
+	}
+	close(batches)
+	assert.NoError(t, ha.Report(context.Background(), batches))
+	assert.Equal(t, 0, s.Len())
+	assert.Len(t, client.batches, 3)
+	for i, ms := range client.batches {
+		v, err := ms[0].GetValue()
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprint(i+1), v)
+	}
+}
+
+// rejectingClient Отклоняет метрики с заданными номерами в каждой пачке
+type rejectingClient struct {
+	batchClient
+	errors []models.ItemError
+}
+
+func (c *rejectingClient) PostBatch(ctx context.Context, ms models.Metrics) error {
+	c.batchClient.PostBatch(ctx, ms)
+	if len(c.errors) == 0 {
+		return nil
+	}
+	return &models.BatchError{Errors: c.errors}
+}
+
+func TestReportRejected(t *testing.T) {
+	s, err := spool.Open(t.TempDir(), 0, 0)
+	assert.NoError(t, err)
+	client := &rejectingClient{errors: []models.ItemError{
+		{Index: 0, ID: "a", Code: models.CodeInvalidValue},
+		{Index: 2, ID: "c", Code: models.CodeStorage, Retryable: true},
+	}}
+	ha := &Agent{
+		config: &Config{},
+		client: client,
+		spool:  s,
+	}
+	batch := make(models.Metrics, 0, 3)
+	for _, id := range []string{"a", "b", "c"} {
+		m, err := models.NewMetric(id, models.GaugeType, "1")
+		assert.NoError(t, err)
+		batch = append(batch, m)
+	}
+	batches := make(chan models.Metrics, 1)
+	batches <- batch
+	close(batches)
+	assert.NoError(t, ha.Report(context.Background(), batches))
+	// в очередь попадает только метрика, которую можно повторить
+	spooled, err := s.Peek()
+	assert.NoError(t, err)
+	assert.Equal(t, models.Metrics{batch[2]}, spooled)
+
+	// пачка без метрик для повтора удаляется из очереди
+	client.errors = client.errors[:1]
+	client.errors[0].Index = 0
+	assert.NoError(t, ha.Replay(context.Background()))
+	assert.Equal(t, 0, s.Len())
+
+	ha.spool = nil
+	batches = make(chan models.Metrics, 1)
+	batches <- batch
+	close(batches)
+	assert.NoError(t, ha.Report(context.Background(), batches))
+}
+
 func TestNew(t *testing.T) {
 	c := NewConfig()
-	a := New(c)
-	assert.NotNil(t, a)
-	c.Transport = JSONType
-	a = New(c)
-	assert.NotNil(t, a)
-	c.Transport = RESTType
-	a = New(c)
-	assert.NotNil(t, a)
+	for _, transport := range Transports {
+		c.Transport = transport
+		a, err := New(c)
+		require.NoError(t, err)
+		assert.NotNil(t, a)
+	}
+
+	// агент не запускается, если не может отправлять метрики так, как настроен
+	c = NewConfig()
+	c.Scheme = client.HTTPSScheme
+	c.TLSCA = filepath.Join(t.TempDir(), "missing.pem")
+	_, err := New(c)
+	assert.Error(t, err)
+
+	c = NewConfig()
+	c.SpoolDir = filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(c.SpoolDir, nil, 0600))
+	_, err = New(c)
+	assert.Error(t, err)
 }
 
 func TestRun(t *testing.T) {
 	c := NewConfig()
 	c.PollInterval = 1
 	c.RateLimit = 1
-	a := New(c)
+	a, err := New(c)
+	require.NoError(t, err)
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second+100*time.Millisecond)
 	defer cancel()
 	a.Run(ctx)
@@ -261,3 +470,89 @@ func TestTransport(t *testing.T) {
 	err = tt.Set(string(GRPCType))
 	assert.NoError(t, err)
 }
+
+func TestLabelsFlag(t *testing.T) {
+	var l Labels
+	assert.NoError(t, l.Set("service=api, host=a"))
+	assert.Equal(t, Labels{"host": "a", "service": "api"}, l)
+	assert.Equal(t, "host=a,service=api", l.String())
+	assert.ErrorIs(t, l.Set("host"), models.ErrorLabels)
+	assert.ErrorIs(t, l.Set("bad-name=a"), models.ErrorLabels)
+}
+
+func TestGCPauses(t *testing.T) {
+	stats := &runtime.MemStats{NumGC: 3}
+	stats.PauseNs[0] = uint64(50 * time.Microsecond)
+	stats.PauseNs[1] = uint64(2 * time.Millisecond)
+	stats.PauseNs[2] = uint64(20 * time.Millisecond)
+	h, err := gcPauses(stats, 1, []float64{0.001, 0.01})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{0, 1, 1}, h.Counts)
+	assert.Equal(t, uint64(2), h.Count)
+	assert.InDelta(t, 0.022, h.Sum, 1e-9)
+
+	stats.NumGC = 300
+	h, err = gcPauses(stats, 3, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(len(stats.PauseNs)), h.Count)
+}
+
+// storeClient Применяет пачки к хранилищу сервера в памяти
+type storeClient struct {
+	storage *mem.Storage
+}
+
+func (c *storeClient) Post(ctx context.Context, m models.Metric) error {
+	return c.PostBatch(ctx, models.Metrics{m})
+}
+
+func (c *storeClient) PostBatch(ctx context.Context, ms models.Metrics) error {
+	// пачки с чётными значениями задерживаются, чтобы отправители обгоняли друг друга
+	if *ms[0].Delta%2 == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	return c.storage.SetMany(ctx, ms)
+}
+
+func TestReportCumulativeOrder(t *testing.T) {
+	client := &storeClient{storage: mem.NewMetricsStorage()}
+	ha := &Agent{
+		config: &Config{},
+		client: client,
+	}
+	cumulative := func(v int) models.Metrics {
+		m, err := models.NewMetric("PollCount", models.CounterType, fmt.Sprint(v))
+		require.NoError(t, err)
+		m.Kind = models.CumulativeKind
+		return models.Metrics{m}
+	}
+	total := func() string {
+		m, err := client.storage.Get(context.Background(), models.CounterType, "PollCount")
+		require.NoError(t, err)
+		v, err := m.GetValue()
+		require.NoError(t, err)
+		return v
+	}
+
+	// старое значение после нового не считается сбросом счётчика
+	batches := make(chan models.Metrics, 2)
+	batches <- cumulative(5)
+	batches <- cumulative(3)
+	close(batches)
+	require.NoError(t, ha.Report(context.Background(), batches))
+	assert.Equal(t, "5", total())
+
+	batches = make(chan models.Metrics, 100)
+	grp, ctx := errgroup.WithContext(context.Background())
+	for i := 0; i < 4; i++ {
+		grp.Go(func() error {
+			return ha.Report(ctx, batches)
+		})
+	}
+	for v := 6; v <= 100; v++ {
+		batches <- cumulative(v)
+	}
+	close(batches)
+	require.NoError(t, grp.Wait())
+	assert.Equal(t, "100", total())
+}
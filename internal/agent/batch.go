@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+// accumulator Накапливает метрики между отправками.
+// Для gauge и накопленных counter сохраняется последнее значение, для counter приращения суммируются,
+// гистограммы с одинаковыми границами складываются.
+type accumulator struct {
+	order   []string
+	metrics map[string]models.Metric
+}
+
+func newAccumulator() *accumulator {
+	return &accumulator{
+		order:   make([]string, 0, MetricsBufSize),
+		metrics: make(map[string]models.Metric, MetricsBufSize),
+	}
+}
+
+// add Добавляет метрику в пачку
+func (a *accumulator) add(m models.Metric) {
+	key := m.MType + "/" + m.Series()
+	prev, ok := a.metrics[key]
+	if !ok {
+		a.order = append(a.order, key)
+	}
+	if ok && m.MType == models.CounterType && !m.IsCumulative() && !prev.IsCumulative() &&
+		prev.Delta != nil && m.Delta != nil {
+		sum := *prev.Delta + *m.Delta
+		m.Delta = &sum
+	}
+	if ok && m.MType == models.HistogramType && prev.Histogram != nil && m.Histogram != nil {
+		if merged, ok := prev.Histogram.Merge(*m.Histogram); ok {
+			m.Histogram = &merged
+		}
+	}
+	a.metrics[key] = m
+}
+
+// Len Возвращает количество метрик в пачке
+func (a *accumulator) Len() int {
+	return len(a.order)
+}
+
+// flush Возвращает накопленную пачку в порядке поступления и очищает accumulator
+func (a *accumulator) flush() models.Metrics {
+	batch := make(models.Metrics, 0, len(a.order))
+	for _, key := range a.order {
+		batch = append(batch, a.metrics[key])
+	}
+	a.order = a.order[:0]
+	a.metrics = make(map[string]models.Metric, len(batch))
+	return batch
+}
@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+func TestAccumulator(t *testing.T) {
+	acc := newAccumulator()
+	for _, m := range []struct{ id, mtype, value string }{
+		{"Alloc", models.GaugeType, "1"},
+		{"PollCount", models.CounterType, "1"},
+		{"Alloc", models.GaugeType, "3"},
+		{"PollCount", models.CounterType, "2"},
+		{"Alloc", models.CounterType, "5"},
+		{"GCPause", models.HistogramType, "0.1;1,0;0.05"},
+		{"GCPause", models.HistogramType, "0.1;1,1;0.25"},
+	} {
+		metric, err := models.NewMetric(m.id, m.mtype, m.value)
+		assert.NoError(t, err)
+		acc.add(metric)
+	}
+	assert.Equal(t, 4, acc.Len())
+	batch := acc.flush()
+	want := []struct{ id, mtype, value string }{
+		{"Alloc", models.GaugeType, "3"},
+		{"PollCount", models.CounterType, "3"},
+		{"Alloc", models.CounterType, "5"},
+		{"GCPause", models.HistogramType, "0.1;2,1;0.3"},
+	}
+	assert.Len(t, batch, len(want))
+	for i, w := range want {
+		assert.Equal(t, w.id, batch[i].ID)
+		assert.Equal(t, w.mtype, batch[i].MType)
+		v, err := batch[i].GetValue()
+		assert.NoError(t, err)
+		assert.Equal(t, w.value, v)
+	}
+	assert.Equal(t, 0, acc.Len())
+	assert.Empty(t, acc.flush())
+
+	// накопленные значения не суммируются, отправляется последнее
+	for _, v := range []string{"5", "7"} {
+		metric, err := models.NewMetric("PollCount", models.CounterType, v)
+		assert.NoError(t, err)
+		metric.Kind = models.CumulativeKind
+		acc.add(metric)
+	}
+	batch = acc.flush()
+	assert.Len(t, batch, 1)
+	assert.Equal(t, models.Counter(7), *batch[0].Delta)
+	assert.True(t, batch[0].IsCumulative())
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/Nexadis/metalert/internal/models"
@@ -10,54 +11,170 @@ import (
 	"github.com/Nexadis/metalert/internal/utils/logger"
 	pb "github.com/Nexadis/metalert/proto/metrics/v1"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 var ErrConnection = errors.New("can't connect to server")
 
+// Параметры потока PostStream
+var (
+	StreamWindow    = 8                      // максимальное количество неподтверждённых пачек
+	StreamRetryWait = 100 * time.Millisecond // пауза перед первым переподключением, каждая следующая удваивается
+	StreamMaxWait   = 10 * time.Second       // максимальная пауза перед переподключением
+)
+
+// GRPCClient отправляет метрики через долгоживущий поток PostStream.
+// Если сервер не поддерживает PostStream, используется унарный Post
 type GRPCClient struct {
-	gc   pb.MetricsCollectorServiceClient
-	conn *grpc.ClientConn
+	gc     pb.MetricsCollectorServiceClient
+	conn   *grpc.ClientConn
+	window chan struct{}
+
+	mutex   sync.Mutex
+	stream  *postStream
+	wait    time.Duration // текущая пауза перед переподключением
+	retryAt time.Time
+	unary   bool
 }
 
-func NewGRPC(server string) *GRPCClient {
+// NewGRPC Подключается к серверу. Принимает те же опции, что и HTTP-клиенты:
+// ключ подписи запросов, публичный ключ для шифрования сообщений и настройки TLS
+func NewGRPC(server string, ops ...FOption) *GRPCClient {
 	if server == "" {
 		logger.Error("empty address of server")
 		return &GRPCClient{}
-
+	}
+	var o options
+	for _, op := range ops {
+		op(&o)
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
-	conn, err := grpc.DialContext(ctx, server, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.DialContext(ctx, server, o.dialOptions()...)
 	if err != nil {
 		logger.Error(err)
 		return &GRPCClient{}
 	}
 	c := pb.NewMetricsCollectorServiceClient(conn)
 	return &GRPCClient{
-		gc:   c,
-		conn: conn,
+		gc:     c,
+		conn:   conn,
+		window: make(chan struct{}, StreamWindow),
 	}
 }
 
 func (c *GRPCClient) Post(ctx context.Context, m models.Metric) error {
+	return c.PostBatch(ctx, models.Metrics{m})
+}
+
+// PostBatch отправляет пачку в поток PostStream и дожидается её подтверждения.
+// Если неподтверждённых пачек уже StreamWindow, ждёт освобождения окна
+func (c *GRPCClient) PostBatch(ctx context.Context, ms models.Metrics) error {
 	err := c.ctxClose(ctx)
 	if err != nil {
 		return err
 	}
-	var r pb.PostRequest
 	if c.gc == nil {
 		return ErrConnection
 	}
-	in, err := controller.MetricsToPB(models.Metrics{m})
+	in, err := controller.MetricsToPB(ms)
 	if err != nil {
 		return err
 	}
-	r.Metrics = in
-	_, err = c.gc.Post(ctx, &r)
+	select {
+	case c.window <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-c.window }()
+	s, err := c.getStream(ctx)
+	if errors.Is(err, errUnary) {
+		return c.postUnary(ctx, in)
+	}
+	if err != nil {
+		return err
+	}
+	ack, err := s.send(in)
+	if err != nil {
+		return err
+	}
+	select {
+	case err = <-ack:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if status.Code(err) == codes.Unimplemented {
+		logger.Info("Server doesn't support PostStream, use Post")
+		c.mutex.Lock()
+		c.unary = true
+		c.mutex.Unlock()
+		return c.postUnary(ctx, in)
+	}
 	return err
 }
 
+// errUnary - Сервер не поддерживает PostStream
+var errUnary = errors.New("stream is not supported")
+
+// getStream Возвращает открытый поток, при необходимости переподключаясь с паузой
+func (c *GRPCClient) getStream(ctx context.Context) (*postStream, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.unary {
+		return nil, errUnary
+	}
+	if c.stream != nil {
+		err := c.stream.broken()
+		if err == nil {
+			return c.stream, nil
+		}
+		c.stream = nil
+		logger.Error("PostStream is broken:", err)
+		c.backoff()
+	}
+	if wait := time.Until(c.retryAt); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	s, err := openStream(c.gc)
+	if err != nil {
+		c.backoff()
+		return nil, err
+	}
+	c.wait = 0
+	c.stream = s
+	return s, nil
+}
+
+// backoff Увеличивает паузу перед следующим переподключением. Вызывается под блокировкой
+func (c *GRPCClient) backoff() {
+	if c.wait == 0 {
+		c.wait = StreamRetryWait
+	} else {
+		c.wait *= 2
+	}
+	if c.wait > StreamMaxWait {
+		c.wait = StreamMaxWait
+	}
+	c.retryAt = time.Now().Add(c.wait)
+}
+
+// postUnary отправляет все метрики одним PostRequest
+func (c *GRPCClient) postUnary(ctx context.Context, in *pb.Metrics) error {
+	var r pb.PostRequest
+	r.Metrics = in
+	r.Partial = true
+	resp, err := c.gc.Post(ctx, &r)
+	if err != nil {
+		return err
+	}
+	return responseError(resp.GetError(), resp.GetErrors())
+}
+
 func (c *GRPCClient) Get(ctx context.Context) (models.Metrics, error) {
 	err := c.ctxClose(ctx)
 	if err != nil {
@@ -74,11 +191,23 @@ func (c *GRPCClient) Get(ctx context.Context) (models.Metrics, error) {
 	return controller.MetricsFromPB(resp.Metrics)
 }
 
+// Close Закрывает поток и соединение с сервером
+func (c *GRPCClient) Close() error {
+	c.mutex.Lock()
+	if c.stream != nil {
+		c.stream.close()
+		c.stream = nil
+	}
+	c.mutex.Unlock()
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
 func (c *GRPCClient) ctxClose(ctx context.Context) error {
 	if err := ctx.Err(); err != nil {
-		if c.conn != nil {
-			c.conn.Close()
-		}
+		c.Close()
 		return err
 	}
 	return nil
@@ -2,10 +2,20 @@ package client
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/Nexadis/metalert/internal/models"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/models/controller"
+	pb "github.com/Nexadis/metalert/proto/metrics/v1"
 )
 
 func TestNewGRPCClient(t *testing.T) {
@@ -27,3 +37,160 @@ func TestNewGRPCClient(t *testing.T) {
 	err = c.Post(ctx, m)
 	assert.Error(t, err)
 }
+
+type fakeCollector struct {
+	pb.UnimplementedMetricsCollectorServiceServer
+	mutex   sync.Mutex
+	metrics models.Metrics
+	streams int
+	entered chan struct{}
+	block   chan struct{}
+}
+
+func (f *fakeCollector) save(metrics *pb.Metrics) error {
+	if f.block != nil {
+		f.entered <- struct{}{}
+		<-f.block
+	}
+	ms, err := controller.MetricsFromPB(metrics)
+	if err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, m := range ms {
+		if m.ID == "bad" {
+			return errors.New("bad metric")
+		}
+	}
+	f.metrics = append(f.metrics, ms...)
+	return nil
+}
+
+func (f *fakeCollector) received() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.metrics)
+}
+
+type streamCollector struct {
+	*fakeCollector
+}
+
+func (f streamCollector) PostStream(stream pb.MetricsCollectorService_PostStreamServer) error {
+	f.mutex.Lock()
+	f.streams++
+	f.mutex.Unlock()
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return nil
+		}
+		resp := &pb.PostStreamResponse{Seq: req.GetSeq()}
+		err = f.save(req.GetMetrics())
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		if stream.Send(resp) != nil {
+			return nil
+		}
+	}
+}
+
+type unaryCollector struct {
+	*fakeCollector
+}
+
+func (f unaryCollector) Post(ctx context.Context, r *pb.PostRequest) (*pb.PostResponse, error) {
+	var resp pb.PostResponse
+	err := f.save(r.GetMetrics())
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	return &resp, nil
+}
+
+func serve(t *testing.T, addr string, srv pb.MetricsCollectorServiceServer) (string, *grpc.Server) {
+	lis, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	gs := grpc.NewServer()
+	pb.RegisterMetricsCollectorServiceServer(gs, srv)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+	return lis.Addr().String(), gs
+}
+
+func gauge(t *testing.T, id string) models.Metrics {
+	m, err := models.NewMetric(id, models.GaugeType, "1")
+	require.NoError(t, err)
+	return models.Metrics{m}
+}
+
+func TestPostStream(t *testing.T) {
+	f := &fakeCollector{}
+	addr, gs := serve(t, "127.0.0.1:0", streamCollector{f})
+	c := NewGRPC(addr)
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				assert.NoError(t, c.PostBatch(context.Background(), gauge(t, fmt.Sprintf("m%d_%d", i, j))))
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, 20, f.received())
+	assert.Error(t, c.PostBatch(context.Background(), gauge(t, "bad")))
+	assert.Equal(t, 1, f.streams)
+
+	// после перезапуска сервера поток переоткрывается
+	StreamRetryWait = 10 * time.Millisecond
+	gs.Stop()
+	assert.Error(t, c.PostBatch(context.Background(), gauge(t, "lost")))
+	serve(t, addr, streamCollector{f})
+	assert.Eventually(t, func() bool {
+		return c.PostBatch(context.Background(), gauge(t, "after")) == nil
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, 21, f.received())
+	assert.Equal(t, 2, f.streams)
+}
+
+func TestPostStreamWindow(t *testing.T) {
+	window := StreamWindow
+	StreamWindow = 1
+	defer func() { StreamWindow = window }()
+	f := &fakeCollector{
+		entered: make(chan struct{}, 2),
+		block:   make(chan struct{}),
+	}
+	addr, _ := serve(t, "127.0.0.1:0", streamCollector{f})
+	c := NewGRPC(addr)
+	defer c.Close()
+
+	done := make(chan error)
+	go func() {
+		done <- c.PostBatch(context.Background(), gauge(t, "first"))
+	}()
+	<-f.entered
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	// окно занято первой пачкой, вторая не отправляется
+	assert.ErrorIs(t, c.PostBatch(ctx, gauge(t, "second")), context.DeadlineExceeded)
+	close(f.block)
+	assert.NoError(t, <-done)
+	assert.Equal(t, 1, f.received())
+}
+
+func TestPostStreamUnimplemented(t *testing.T) {
+	f := &fakeCollector{}
+	addr, _ := serve(t, "127.0.0.1:0", unaryCollector{f})
+	c := NewGRPC(addr)
+	defer c.Close()
+	assert.NoError(t, c.PostBatch(context.Background(), gauge(t, "first")))
+	assert.NoError(t, c.PostBatch(context.Background(), gauge(t, "second")))
+	assert.Equal(t, 2, f.received())
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/Nexadis/metalert/internal/utils/asymcrypt"
+	"github.com/Nexadis/metalert/internal/utils/keyring"
+	"github.com/Nexadis/metalert/internal/utils/verifier"
+)
+
+// realIPMetadata - Ключ метаданных с адресом агента, аналог заголовка X-Real-IP
+const realIPMetadata = "x-real-ip"
+
+// signUnary Добавляет в метаданные адрес агента, API-ключ и подпись запроса со временем и nonce ключом signkey
+func signUnary(o options) grpc.UnaryClientInterceptor {
+	signkey := o.signkey
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, err := o.withMetadata(ctx)
+		if err != nil {
+			return err
+		}
+		if signkey != "" {
+			m, ok := req.(proto.Message)
+			if !ok {
+				return fmt.Errorf("can't sign %T", req)
+			}
+			ctx, err = withSignature(ctx, func(timestamp, nonce string) (string, error) {
+				return verifier.SignMessage(m, timestamp, nonce, []byte(signkey))
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// signStream Добавляет в метаданные потока адрес агента, API-ключ и подпись полного имени метода со временем и nonce.
+// Каждое отправленное сообщение подписывается отдельно с теми же временем и nonce, см. verifier.SignStreamMessage
+func signStream(o options) grpc.StreamClientInterceptor {
+	signkey := o.signkey
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, err := o.withMetadata(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if signkey == "" {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		signed := &signedClientStream{key: []byte(signkey)}
+		ctx, err = withSignature(ctx, func(timestamp, nonce string) (string, error) {
+			signed.timestamp, signed.nonce = timestamp, nonce
+			return verifier.SignRequest([]byte(method), timestamp, nonce, signed.key)
+		})
+		if err != nil {
+			return nil, err
+		}
+		signed.ClientStream, err = streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return signed, nil
+	}
+}
+
+// signedClientStream - Поток, подписывающий каждое отправленное сообщение временем и nonce потока
+type signedClientStream struct {
+	grpc.ClientStream
+	key       []byte
+	timestamp string
+	nonce     string
+	sent      uint64 // количество подписанных сообщений, SendMsg не вызывается одновременно
+}
+
+func (s *signedClientStream) SendMsg(m any) error {
+	pm, ok := m.(proto.Message)
+	if !ok {
+		return fmt.Errorf("can't sign %T", m)
+	}
+	hash, err := verifier.SignStreamMessage(pm, s.timestamp, s.nonce, s.sent, s.key)
+	if err != nil {
+		return err
+	}
+	err = verifier.SetStreamHash(pm, hash)
+	if err != nil {
+		return err
+	}
+	s.sent++
+	return s.ClientStream.SendMsg(m)
+}
+
+// withSignature Добавляет в метаданные время, новый nonce и подпись, созданную sign
+func withSignature(ctx context.Context, sign func(timestamp, nonce string) (string, error)) (context.Context, error) {
+	timestamp, nonce, err := verifier.NewNonce()
+	if err != nil {
+		return nil, err
+	}
+	signature, err := sign(timestamp, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.AppendToOutgoingContext(ctx,
+		verifier.TimestampMetadata, timestamp,
+		verifier.NonceMetadata, nonce,
+		verifier.HashMetadata, signature,
+	), nil
+}
+
+// withMetadata Добавляет в метаданные адрес агента, API-ключ и ID ключа подписи
+func (o options) withMetadata(ctx context.Context) (context.Context, error) {
+	realIP, err := getRealIP()
+	if err != nil {
+		return nil, err
+	}
+	if realIP != nil {
+		ctx = metadata.AppendToOutgoingContext(ctx, realIPMetadata, realIP.String())
+	}
+	if o.apikey != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+o.apikey)
+	}
+	if o.signkey != "" && o.keyID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, keyring.KeyIDMetadata, o.keyID)
+	}
+	return ctx, nil
+}
+
+// encryptCodec - Кодек protobuf, шифрующий исходящие сообщения публичным ключом сервера
+// в конверт asymcrypt.Encrypt. Ответы сервера не шифруются
+type encryptCodec struct {
+	pubkey []byte
+}
+
+func (c encryptCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("can't marshal %T", v)
+	}
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return asymcrypt.Encrypt(data, c.pubkey)
+}
+
+func (c encryptCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("can't unmarshal %T", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func (c encryptCodec) Name() string {
+	return "proto"
+}
+
+// dialOptions Возвращает опции соединения: TLS или незащищённое соединение,
+// подпись, API-ключ и адрес агента в метаданных, а если задан публичный ключ - шифрование сообщений
+func (o options) dialOptions() []grpc.DialOption {
+	creds := insecure.NewCredentials()
+	if o.secure() {
+		config := o.tls
+		if config == nil {
+			config = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		creds = credentials.NewTLS(config)
+	}
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(signUnary(o)),
+		grpc.WithStreamInterceptor(signStream(o)),
+	}
+	if o.pubkey != nil {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.ForceCodec(encryptCodec{o.pubkey})))
+	}
+	return opts
+}
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/models/controller"
+	pb "github.com/Nexadis/metalert/proto/metrics/v1"
+)
+
+// ErrStreamClosed - Поток закрыт до получения подтверждения пачки
+var ErrStreamClosed = errors.New("stream is closed")
+
+// postStream - Поток PostStream. Сопоставляет подтверждения сервера с отправленными пачками по номеру
+type postStream struct {
+	stream    pb.MetricsCollectorService_PostStreamClient
+	cancel    context.CancelFunc
+	sendMutex sync.Mutex
+	mutex     sync.Mutex
+	seq       uint64
+	pending   map[uint64]chan error
+	err       error
+}
+
+// openStream Открывает поток и запускает чтение подтверждений
+func openStream(gc pb.MetricsCollectorServiceClient) (*postStream, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := gc.PostStream(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s := &postStream{
+		stream:  stream,
+		cancel:  cancel,
+		pending: make(map[uint64]chan error),
+	}
+	go s.recv()
+	return s, nil
+}
+
+// send Отправляет пачку и возвращает канал, в который придёт результат её записи
+func (s *postStream) send(metrics *pb.Metrics) (<-chan error, error) {
+	ack := make(chan error, 1)
+	s.mutex.Lock()
+	if s.err != nil {
+		s.mutex.Unlock()
+		return nil, s.err
+	}
+	s.seq++
+	seq := s.seq
+	s.pending[seq] = ack
+	s.mutex.Unlock()
+
+	s.sendMutex.Lock()
+	err := s.stream.Send(&pb.PostStreamRequest{
+		Seq:     seq,
+		Metrics: metrics,
+		Partial: true,
+	})
+	s.sendMutex.Unlock()
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.mutex.Lock()
+		delete(s.pending, seq)
+		s.mutex.Unlock()
+		return nil, err
+	}
+	// при io.EOF поток разорван, причину вернёт Recv и результат придёт из fail
+	return ack, nil
+}
+
+func (s *postStream) recv() {
+	for {
+		resp, err := s.stream.Recv()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		s.mutex.Lock()
+		ack, ok := s.pending[resp.GetSeq()]
+		delete(s.pending, resp.GetSeq())
+		s.mutex.Unlock()
+		if !ok {
+			continue
+		}
+		ack <- responseError(resp.GetError(), resp.GetErrors())
+	}
+}
+
+// responseError Возвращает ошибку ответа на пачку: *models.BatchError, если сервер
+// перечислил отклонённые метрики, иначе текст ошибки или nil
+func responseError(text string, errs []*pb.ItemError) error {
+	if len(errs) != 0 {
+		return &models.BatchError{Errors: controller.ItemErrorsFromPB(errs)}
+	}
+	if text != "" {
+		return errors.New(text)
+	}
+	return nil
+}
+
+// fail Помечает поток как разорванный и завершает ожидание всех неподтверждённых пачек
+func (s *postStream) fail(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	for seq, ack := range s.pending {
+		ack <- s.err
+		delete(s.pending, seq)
+	}
+	s.cancel()
+}
+
+// broken Возвращает ошибку, с которой разорван поток, или nil
+func (s *postStream) broken() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+
+// close Закрывает поток
+func (s *postStream) close() {
+	s.fail(ErrStreamClosed)
+}
@@ -5,21 +5,25 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 
 	"github.com/Nexadis/metalert/internal/models"
 	"github.com/Nexadis/metalert/internal/utils/asymcrypt"
+	"github.com/Nexadis/metalert/internal/utils/keyring"
 	"github.com/Nexadis/metalert/internal/utils/verifier"
 )
 
 type httpType int
 
+var ErrResponse = errors.New("server returned error")
+
 const (
 	JSONType httpType = iota
 	RESTType
@@ -35,9 +39,8 @@ const (
 
 // httpClient отправляет метрики и подписывает их ключом key.
 type httpClient struct {
+	options
 	client    *resty.Client
-	signkey   string
-	pubkey    []byte
 	transport httpType
 	server    string
 }
@@ -51,7 +54,16 @@ func newClient(server string, options ...FOption) *httpClient {
 		server: server,
 	}
 	for _, o := range options {
-		o(client)
+		o(&client.options)
+	}
+	if client.scheme == "" {
+		client.scheme = HTTPScheme
+	}
+	if client.tls != nil {
+		client.client.SetTLSClientConfig(client.tls)
+	}
+	if client.signkey != "" {
+		client.client.SetPreRequestHook(client.sign)
 	}
 	return client
 }
@@ -79,11 +91,47 @@ func (c *httpClient) Post(ctx context.Context, m models.Metric) error {
 	case RESTType:
 		return c.postREST(ctx, c.server, m)
 	case JSONType:
-		return c.postJSON(ctx, c.server, m)
+		_, err := c.postJSON(ctx, c.server, JSONUpdateURL, m)
+		return err
+	}
+	return fmt.Errorf("unknown transport type")
+}
+
+// PostBatch отправляет пачку метрик. Для JSON пачка отправляется одним запросом на JSONUpdatesURL,
+// для REST каждая метрика отправляется отдельно
+func (c *httpClient) PostBatch(ctx context.Context, ms models.Metrics) error {
+	if len(ms) == 0 {
+		return nil
+	}
+	switch c.transport {
+	case RESTType:
+		for _, m := range ms {
+			err := c.postREST(ctx, c.server, m)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case JSONType:
+		return c.postBatchJSON(ctx, ms)
 	}
 	return fmt.Errorf("unknown transport type")
 }
 
+// postBatchJSON отправляет пачку с частичным приёмом: сервер записывает верные метрики,
+// а об отклонённых сообщает в ответе, они возвращаются как *models.BatchError
+func (c *httpClient) postBatchJSON(ctx context.Context, ms models.Metrics) error {
+	resp, err := c.postJSON(ctx, c.server, JSONUpdatesURL+"?partial=true", ms)
+	if resp == nil {
+		return err
+	}
+	var result models.BatchResult
+	if json.Unmarshal(resp.Body(), &result) == nil && len(result.Errors) != 0 {
+		return &models.BatchError{Errors: result.Errors}
+	}
+	return err
+}
+
 // Post отправляет метрику через REST-запрос
 //
 // path - адрес сервера, например "localhost:8080"
@@ -97,9 +145,10 @@ func (c *httpClient) postREST(ctx context.Context, server string, m models.Metri
 		return err
 	}
 
-	query := fmt.Sprintf("http://%s%s", server, UpdateURL)
+	query := fmt.Sprintf("%s://%s%s", c.scheme, server, UpdateURL)
 	_, err = c.client.R().
-		SetContext(ctx).
+		SetContext(withSignedBody(ctx, nil)).
+		SetHeaders(c.authHeaders()).
 		SetHeader("Content-type", "text/plain").
 		SetHeader("Accept-Encoding", "gzip").
 		SetHeader("X-Real-IP", realIP.String()).
@@ -107,37 +156,51 @@ func (c *httpClient) postREST(ctx context.Context, server string, m models.Metri
 			"valType": m.MType,
 			"name":    m.ID,
 			"value":   val,
-		}).Post(query)
+		}).
+		SetQueryParams(m.Labels).
+		SetQueryParams(kindParams(m)).
+		Post(query)
 
 	return err
 }
 
-// postJSON отправляет метрику в виде JSON-строки, дополнительно сжимая её с помощью gzip и подписывая с помощью httpClient.key.
-func (c *httpClient) postJSON(ctx context.Context, server string, m models.Metric) error {
-	buf, err := json.Marshal(m)
+// kindParams Возвращает параметр запроса со способом передачи counter, если он задан
+func kindParams(m models.Metric) map[string]string {
+	if m.Kind == "" {
+		return nil
+	}
+	return map[string]string{models.KindParam: m.Kind}
+}
+
+// postJSON отправляет метрику или пачку метрик в виде JSON-строки на адрес path,
+// дополнительно сжимая её с помощью gzip и подписывая с помощью httpClient.key.
+// Если задан публичный ключ, тело шифруется в конверт asymcrypt.Encrypt.
+// Ответ возвращается и вместе с ошибкой, если сервер ответил кодом ошибки
+func (c *httpClient) postJSON(ctx context.Context, server, path string, v any) (*resty.Response, error) {
+	buf, err := json.Marshal(v)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	encrypted := buf
 	if c.pubkey != nil {
 		encrypted, err = asymcrypt.Encrypt(buf, c.pubkey)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 	body := &bytes.Buffer{}
 	g := gzip.NewWriter(body)
 	_, err = g.Write(encrypted)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	err = g.Close()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	realIP, err := getRealIP()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	Headers := map[string]string{
@@ -146,21 +209,67 @@ func (c *httpClient) postJSON(ctx context.Context, server string, m models.Metri
 		"Content-Encoding": "gzip",
 		"X-Real-IP":        realIP.String(),
 	}
-	if c.signkey != "" {
-		signature, err := verifier.Sign(buf, []byte(c.signkey))
-		if err != nil {
-			return err
-		}
-		Headers[verifier.HashHeader] = base64.StdEncoding.EncodeToString(signature)
+	for k, v := range c.authHeaders() {
+		Headers[k] = v
 	}
-	query := fmt.Sprintf("http://%s%s", server, JSONUpdateURL)
+	if c.pubkey != nil && c.cryptoID != "" {
+		Headers[keyring.CryptoKeyIDHeader] = c.cryptoID
+	}
+	query := fmt.Sprintf("%s://%s%s", c.scheme, server, path)
 
-	_, err = c.client.R().
-		SetContext(ctx).
+	resp, err := c.client.R().
+		SetContext(withSignedBody(ctx, buf)).
 		SetHeaders(Headers).
 		SetBody(body).
 		Post(query)
-	return err
+	if err != nil {
+		return nil, err
+	}
+	if resp.IsError() {
+		return resp, fmt.Errorf("%w: %s", ErrResponse, resp.Status())
+	}
+	return resp, nil
+}
+
+type signedBody struct{}
+
+// withSignedBody Сохраняет в контексте запроса тело до шифрования и сжатия, его подписывает sign
+func withSignedBody(ctx context.Context, body []byte) context.Context {
+	return context.WithValue(ctx, signedBody{}, body)
+}
+
+// sign Подписывает запрос перед каждой попыткой отправки: повтор resty получает новые время и nonce,
+// иначе сервер отклонил бы его как повторный. Подпись покрывает метод, путь и строку запроса,
+// см. verifier.HTTPRequest, и тело из withSignedBody
+func (c *httpClient) sign(_ *resty.Client, r *http.Request) error {
+	body, ok := r.Context().Value(signedBody{}).([]byte)
+	if !ok {
+		return nil
+	}
+	timestamp, nonce, err := verifier.NewNonce()
+	if err != nil {
+		return err
+	}
+	data := verifier.HTTPRequest(r.Method, r.URL.Path, r.URL.RawQuery, body)
+	signature, err := verifier.SignRequest(data, timestamp, nonce, []byte(c.signkey))
+	if err != nil {
+		return err
+	}
+	r.Header.Set(verifier.TimestampHeader, timestamp)
+	r.Header.Set(verifier.NonceHeader, nonce)
+	r.Header.Set(verifier.HashHeader, signature)
+	if c.keyID != "" {
+		r.Header.Set(keyring.KeyIDHeader, c.keyID)
+	}
+	return nil
+}
+
+// authHeaders Возвращает заголовок с API-ключом, если он задан
+func (c *httpClient) authHeaders() map[string]string {
+	if c.apikey == "" {
+		return nil
+	}
+	return map[string]string{"Authorization": "Bearer " + c.apikey}
 }
 
 func getRealIP() (net.Addr, error) {
@@ -3,6 +3,8 @@ package client
 import (
 	"compress/gzip"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -13,7 +15,9 @@ import (
 
 	"github.com/Nexadis/metalert/internal/models"
 	"github.com/Nexadis/metalert/internal/utils/asymcrypt"
+	"github.com/Nexadis/metalert/internal/utils/verifier"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHTTP(t *testing.T) {
@@ -27,6 +31,7 @@ type reqLogger struct {
 	method string
 	body   string
 	url    string
+	query  string
 }
 
 func (l *reqLogger) showHandler(w http.ResponseWriter, r *http.Request) {
@@ -37,6 +42,7 @@ func (l *reqLogger) showHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	l.body = string(body)
 	l.url = r.URL.Path
+	l.query = r.URL.RawQuery
 	l.method = r.Method
 }
 
@@ -127,6 +133,13 @@ func TestPostREST(t *testing.T) {
 		},
 		)
 	}
+
+	// способ передачи counter уходит параметром kind
+	m, err := models.NewMetric("PollCount", models.CounterType, "10")
+	assert.NoError(t, err)
+	m.Kind = models.CumulativeKind
+	assert.NoError(t, c.Post(ctx, m))
+	assert.Equal(t, "kind=cumulative", r.query)
 }
 
 var postObjTests = []testReq{
@@ -237,3 +250,80 @@ func TestPostJSON(t *testing.T) {
 		)
 	}
 }
+
+func TestPostBatch(t *testing.T) {
+	r := reqLogger{}
+	s := httptest.NewServer(http.HandlerFunc(r.showHandler))
+	defer s.Close()
+	server := s.URL[len("http://"):]
+	ms := make(models.Metrics, 0, 2)
+	for _, test := range postObjTests[1:] {
+		m, err := models.NewMetric(test.m.name, test.m.mtype, test.m.val)
+		assert.NoError(t, err)
+		ms = append(ms, m)
+	}
+	want, err := json.Marshal(ms)
+	assert.NoError(t, err)
+
+	c := NewJSON(server)
+	err = c.PostBatch(context.Background(), ms)
+	assert.NoError(t, err)
+	assert.Equal(t, JSONUpdatesURL, r.url)
+	g, err := gzip.NewReader(strings.NewReader(r.body))
+	assert.NoError(t, err)
+	buf, err := io.ReadAll(g)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(want), string(buf))
+
+	c = NewREST(server)
+	err = c.PostBatch(context.Background(), ms)
+	assert.NoError(t, err)
+	assert.Equal(t, "/update/gauge/someg/123", r.url)
+}
+
+func TestPostTLS(t *testing.T) {
+	r := reqLogger{}
+	s := httptest.NewTLSServer(http.HandlerFunc(r.showHandler))
+	defer s.Close()
+	server := s.URL[len("https://"):]
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Certificate())
+	ms, err := models.NewMetric("name", models.GaugeType, "1")
+	assert.NoError(t, err)
+
+	c := NewJSON(server, SetTLS(&tls.Config{RootCAs: pool}))
+	assert.NoError(t, c.PostBatch(context.Background(), models.Metrics{ms}))
+	assert.Equal(t, JSONUpdatesURL, r.url)
+
+	// сертификат тестового сервера не подписан системными CA
+	c = NewJSON(server, SetScheme(HTTPSScheme))
+	assert.Error(t, c.PostBatch(context.Background(), models.Metrics{ms}))
+}
+
+func TestSignRetry(t *testing.T) {
+	key := "retry-key"
+	var nonces []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonces = append(nonces, r.Header.Get(verifier.NonceHeader))
+		if len(nonces) == 1 {
+			// обрыв соединения до ответа, resty повторит запрос
+			conn, _, err := w.(http.Hijacker).Hijack()
+			assert.NoError(t, err)
+			conn.Close()
+			return
+		}
+		data := verifier.HTTPRequest(r.Method, r.URL.Path, r.URL.RawQuery, nil)
+		want, err := verifier.SignRequest(data, r.Header.Get(verifier.TimestampHeader), r.Header.Get(verifier.NonceHeader), []byte(key))
+		assert.NoError(t, err)
+		assert.Equal(t, want, r.Header.Get(verifier.HashHeader))
+	}))
+	defer ts.Close()
+
+	c := NewREST(strings.TrimPrefix(ts.URL, "http://"), SetSignKey(key))
+	m, err := models.NewMetric("cpu", models.GaugeType, "1")
+	require.NoError(t, err)
+	m.Labels = map[string]string{"host": "a"}
+	require.NoError(t, c.Post(context.TODO(), m))
+	require.Len(t, nonces, 2)
+	assert.NotEqual(t, nonces[0], nonces[1])
+}
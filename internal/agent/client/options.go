@@ -1,18 +1,80 @@
-// Задает опции для конструктора httpClient.
+// Задает опции для конструкторов httpClient и GRPCClient.
 package client
 
+import "crypto/tls"
+
+// Схемы подключения к серверу
+const (
+	HTTPScheme  = "http"
+	HTTPSScheme = "https"
+)
+
+// options - Общие параметры клиентов
+type options struct {
+	signkey  string
+	keyID    string
+	apikey   string
+	pubkey   []byte
+	cryptoID string
+	scheme   string
+	tls      *tls.Config
+}
+
+// secure Сообщает, нужно ли подключаться к серверу по TLS
+func (o options) secure() bool {
+	return o.scheme == HTTPSScheme || o.tls != nil
+}
+
 // SetSignKey определяет ключ для подписи отправляемых метрик.
 func SetSignKey(key string) FOption {
-	return func(hc *httpClient) {
-		hc.signkey = key
+	return func(o *options) {
+		o.signkey = key
+	}
+}
+
+// SetKeyID задаёт ID ключа подписи, по которому сервер выбирает ключ из своей связки
+func SetKeyID(id string) FOption {
+	return func(o *options) {
+		o.keyID = id
+	}
+}
+
+// SetAPIKey задаёт токен API-ключа, который передаётся серверу как Authorization: Bearer
+func SetAPIKey(token string) FOption {
+	return func(o *options) {
+		o.apikey = token
 	}
 }
 
 // SetPubKey устанавливает публичный ключ, с помощью которого будет производиться шифрование трафика
 func SetPubKey(key []byte) FOption {
-	return func(hc *httpClient) {
-		hc.pubkey = key
+	return func(o *options) {
+		o.pubkey = key
+	}
+}
+
+// SetCryptoKeyID задаёт ID публичного ключа, по которому сервер выбирает приватный ключ
+func SetCryptoKeyID(id string) FOption {
+	return func(o *options) {
+		o.cryptoID = id
+	}
+}
+
+// SetScheme задаёт схему подключения: HTTPScheme или HTTPSScheme.
+// Для gRPC HTTPSScheme включает TLS с системными корневыми сертификатами
+func SetScheme(scheme string) FOption {
+	return func(o *options) {
+		o.scheme = scheme
+	}
+}
+
+// SetTLS задаёт настройки TLS, например сертификат CA сервера и сертификат клиента для mTLS.
+// Включает схему HTTPSScheme
+func SetTLS(config *tls.Config) FOption {
+	return func(o *options) {
+		o.tls = config
+		o.scheme = HTTPSScheme
 	}
 }
 
-type FOption func(*httpClient)
+type FOption func(*options)
@@ -3,10 +3,16 @@ package agent
 import (
 	"flag"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/caarlos0/env/v8"
 
+	"github.com/Nexadis/metalert/internal/agent/client"
+	"github.com/Nexadis/metalert/internal/models"
 	"github.com/Nexadis/metalert/internal/utils/logger"
+	"github.com/Nexadis/metalert/internal/utils/tlsconfig"
 )
 
 // Config содержит в себе конфигурацию агента
@@ -14,16 +20,109 @@ type Config struct {
 	Address        string        `env:"ADDRESS"` // адрес сервера для отправки метрик
 	ReportInterval int64         `env:"REPORT_INTERVAL"`
 	PollInterval   int64         `env:"POLL_INTERVAL"`
-	Key            string        `env:"KEY"`        // ключ для подписи отправляемых метрик
-	CryptoKey      string        `env:"CRYPTO_KEY"` // ключ для шифрования трафика
-	RateLimit      int64         `env:"RATE_LIMIT"` // количество воркеров для отправки метрик
-	Verbose        bool          `env:"VERBOSE"`    // Включить логгирование
-	Transport      TransportType `env:"TRANSPORT"`  // тип транспорта для передачи метрик
+	Key            string        `env:"KEY"`              // ключ для подписи отправляемых метрик
+	KeyID          string        `env:"KEY_ID"`           // ID ключа подписи в связке ключей сервера
+	CryptoKey      string        `env:"CRYPTO_KEY"`       // ключ для шифрования трафика
+	APIKey         string        `env:"API_KEY"`          // токен API-ключа с правом записи
+	RateLimit      int64         `env:"RATE_LIMIT"`       // количество воркеров для отправки метрик
+	Verbose        bool          `env:"VERBOSE"`          // Включить логгирование
+	Transport      TransportType `env:"TRANSPORT"`        // тип транспорта для передачи метрик
+	SpoolDir       string        `env:"SPOOL_DIR"`        // каталог для хранения неотправленных метрик, пустой - не хранить
+	SpoolMaxSize   int64         `env:"SPOOL_MAX_SIZE"`   // максимальный размер хранилища неотправленных метрик в байтах
+	SpoolMaxAge    int64         `env:"SPOOL_MAX_AGE"`    // максимальный возраст неотправленных метрик в секундах
+	Labels         Labels        `env:"LABELS"`           // метки, добавляемые ко всем метрикам, например host=a,service=b
+	GCPauseBuckets Buckets       `env:"GC_PAUSE_BUCKETS"` // границы корзин гистограммы пауз GC в секундах
+	Scheme         string        `env:"SCHEME"`           // схема подключения к серверу: http или https
+	TLSCA          string        `env:"TLS_CA"`           // сертификат CA сервера, пустой - системные сертификаты
+	TLSCert        string        `env:"TLS_CERT"`         // сертификат агента для mTLS
+	TLSKey         string        `env:"TLS_KEY"`          // приватный ключ сертификата агента
+	TLSMinVersion  string        `env:"TLS_MIN_VERSION"`  // минимальная версия TLS: 1.2 или 1.3
+}
+
+// DefaultGCPauseBuckets - Границы корзин гистограммы пауз GC по умолчанию, в секундах
+var DefaultGCPauseBuckets = Buckets{0.00001, 0.0001, 0.001, 0.01, 0.1, 1}
+
+// Buckets - Границы корзин гистограммы в виде b1,b2,b3
+type Buckets []float64
+
+func (b Buckets) String() string {
+	bounds := make([]string, 0, len(b))
+	for _, v := range b {
+		bounds = append(bounds, strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	return strings.Join(bounds, ",")
+}
+
+func (b *Buckets) Set(value string) error {
+	bounds := make(Buckets, 0)
+	for _, s := range strings.Split(value, ",") {
+		if strings.TrimSpace(s) == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return err
+		}
+		bounds = append(bounds, v)
+	}
+	_, err := models.NewHistogram(bounds)
+	if err != nil {
+		return err
+	}
+	*b = bounds
+	return nil
+}
+
+func (b *Buckets) UnmarshalText(text []byte) error {
+	return b.Set(string(text))
+}
+
+// Labels - Набор меток в виде name=value,name=value
+type Labels map[string]string
+
+func (l Labels) String() string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(l))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+l[name])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (l *Labels) Set(value string) error {
+	labels := make(Labels)
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, val, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%w: %q", models.ErrorLabels, pair)
+		}
+		labels[strings.TrimSpace(name)] = strings.TrimSpace(val)
+	}
+	err := models.CheckLabels(labels)
+	if err != nil {
+		return err
+	}
+	*l = labels
+	return nil
+}
+
+func (l *Labels) UnmarshalText(text []byte) error {
+	return l.Set(string(text))
 }
 
 func NewConfig() *Config {
 	return &Config{
-		Transport: JSONType,
+		Transport:      JSONType,
+		GCPauseBuckets: DefaultGCPauseBuckets,
+		Scheme:         client.HTTPScheme,
+		TLSMinVersion:  tlsconfig.DefaultMinVersion,
 	}
 }
 
@@ -33,10 +132,22 @@ func (c *Config) parseCmd() {
 	flag.Int64Var(&c.PollInterval, "p", 2, "Poll Interval")
 	flag.Int64Var(&c.ReportInterval, "r", 10, "Report Interval")
 	flag.StringVar(&c.Key, "k", "", "Key to sign body")
+	flag.StringVar(&c.KeyID, "key-id", "", "ID of sign key in server keyring")
 	flag.StringVar(&c.CryptoKey, "crypto-key", "", "Path to file with public-key")
+	flag.StringVar(&c.APIKey, "api-key", "", "API key token with write scope")
 	flag.Int64Var(&c.RateLimit, "l", 1, "Workers for report")
 	flag.BoolVar(&c.Verbose, "v", true, "Verbose logging")
 	flag.Var(&c.Transport, "t", fmt.Sprintf("Choose type of transport for posting metrics: %v", Transports))
+	flag.StringVar(&c.SpoolDir, "spool-dir", "", "Directory for unsent metrics, disabled if empty")
+	flag.Int64Var(&c.SpoolMaxSize, "spool-size", 64<<20, "Max size of unsent metrics in bytes")
+	flag.Int64Var(&c.SpoolMaxAge, "spool-age", 24*60*60, "Max age of unsent metrics in seconds")
+	flag.Var(&c.Labels, "labels", "Labels for all metrics, e.g. host=a,service=b")
+	flag.Var(&c.GCPauseBuckets, "gc-buckets", "Bucket bounds in seconds for GC pause histogram")
+	flag.StringVar(&c.Scheme, "scheme", client.HTTPScheme, "Scheme of server: http or https, https enables TLS for GRPC too")
+	flag.StringVar(&c.TLSCA, "tls-ca", "", "Path to CA of server certificate, system CAs if empty")
+	flag.StringVar(&c.TLSCert, "tls-cert", "", "Path to agent certificate for mTLS")
+	flag.StringVar(&c.TLSKey, "tls-key", "", "Path to agent certificate key")
+	flag.StringVar(&c.TLSMinVersion, "tls-min-version", tlsconfig.DefaultMinVersion, "Min TLS version: 1.2 or 1.3")
 	flag.Parse()
 }
 
@@ -60,5 +171,8 @@ func (c *Config) ParseConfig() {
 		"\nPollInterval", c.PollInterval,
 		"\nKey", c.Key,
 		"\nTransport", c.Transport,
+		"\nScheme", c.Scheme,
+		"\nSpoolDir", c.SpoolDir,
+		"\nLabels", c.Labels,
 	)
 }
@@ -0,0 +1,284 @@
+// spool реализует очередь неотправленных пачек метрик на диске.
+//
+// Каждая пачка хранится в отдельном файле-сегменте с контрольной суммой.
+// Очередь ограничена по суммарному размеру и возрасту сегментов, при превышении
+// ограничений удаляются самые старые сегменты.
+package spool
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/utils/logger"
+)
+
+// Формат сегмента: magic (4 байта) | crc32 (4 байта) | время создания в наносекундах (8 байт) | JSON пачки.
+// Контрольная сумма считается по времени создания и JSON.
+const (
+	segmentMagic  = "MSP1"
+	segmentExt    = ".seg"
+	segmentHeader = len(segmentMagic) + 4 + 8
+)
+
+// Ошибки при работе с очередью
+var (
+	ErrEmpty   = errors.New("spool is empty")
+	ErrCorrupt = errors.New("corrupted segment")
+)
+
+// segment - Описание файла с одной пачкой
+type segment struct {
+	seq     uint64
+	size    int64
+	created time.Time
+}
+
+// Spool - Очередь пачек метрик на диске
+type Spool struct {
+	dir      string
+	maxSize  int64
+	maxAge   time.Duration
+	mutex    sync.Mutex
+	segments []segment
+	size     int64
+	next     uint64
+}
+
+// Open Открывает очередь в каталоге dir, создавая его при необходимости.
+// maxSize - максимальный суммарный размер сегментов в байтах, maxAge - максимальный возраст сегмента.
+// Нулевые значения отключают соответствующее ограничение
+func Open(dir string, maxSize int64, maxAge time.Duration) (*Spool, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	s := &Spool{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, segmentExt+".tmp") {
+			// сегмент, запись которого не была завершена
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		created, size, err := s.readHeader(seq)
+		if err != nil {
+			logger.Error("Drop spool segment", name, err)
+			s.remove(seq)
+			continue
+		}
+		s.segments = append(s.segments, segment{
+			seq:     seq,
+			size:    size,
+			created: created,
+		})
+		s.size += size
+		if seq >= s.next {
+			s.next = seq + 1
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].seq < s.segments[j].seq
+	})
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.enforce(time.Now())
+	return s, nil
+}
+
+// Push Сохраняет пачку в конец очереди
+func (s *Spool) Push(ms models.Metrics) error {
+	payload, err := json.Marshal(ms)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	data := make([]byte, segmentHeader, segmentHeader+len(payload))
+	copy(data, segmentMagic)
+	binary.BigEndian.PutUint64(data[len(segmentMagic)+4:], uint64(now.UnixNano()))
+	data = append(data, payload...)
+	binary.BigEndian.PutUint32(data[len(segmentMagic):], crc32.ChecksumIEEE(data[len(segmentMagic)+4:]))
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	seq := s.next
+	s.next++
+	path := s.path(seq)
+	tmp := path + ".tmp"
+	err = writeFile(tmp, data)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	s.segments = append(s.segments, segment{
+		seq:     seq,
+		size:    int64(len(data)),
+		created: now,
+	})
+	s.size += int64(len(data))
+	s.enforce(now)
+	return nil
+}
+
+// Peek Возвращает самую старую пачку, не удаляя её из очереди.
+// Повреждённые сегменты удаляются
+func (s *Spool) Peek() (models.Metrics, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.enforce(time.Now())
+	for len(s.segments) != 0 {
+		ms, err := s.read(s.segments[0].seq)
+		if err == nil {
+			return ms, nil
+		}
+		logger.Error("Drop spool segment", s.segments[0].seq, err)
+		s.drop()
+	}
+	return nil, ErrEmpty
+}
+
+// Pop Удаляет самую старую пачку из очереди
+func (s *Spool) Pop() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.segments) == 0 {
+		return ErrEmpty
+	}
+	s.drop()
+	return nil
+}
+
+// Len Возвращает количество пачек в очереди
+func (s *Spool) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.segments)
+}
+
+// Size Возвращает суммарный размер пачек в очереди
+func (s *Spool) Size() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.size
+}
+
+// enforce Удаляет самые старые сегменты, пока не выполнены ограничения. Вызывается под блокировкой
+func (s *Spool) enforce(now time.Time) {
+	for len(s.segments) != 0 {
+		oldest := s.segments[0]
+		tooOld := s.maxAge > 0 && now.Sub(oldest.created) > s.maxAge
+		tooBig := s.maxSize > 0 && s.size > s.maxSize
+		if !tooOld && !tooBig {
+			return
+		}
+		logger.Info("Spool limit exceeded, drop segment", oldest.seq)
+		s.drop()
+	}
+}
+
+// drop Удаляет первый сегмент. Вызывается под блокировкой
+func (s *Spool) drop() {
+	oldest := s.segments[0]
+	s.remove(oldest.seq)
+	s.size -= oldest.size
+	s.segments = s.segments[1:]
+}
+
+func (s *Spool) remove(seq uint64) {
+	err := os.Remove(s.path(seq))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Error(err)
+	}
+}
+
+func (s *Spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// readHeader Проверяет сегмент и возвращает время его создания и размер
+func (s *Spool) readHeader(seq uint64) (time.Time, int64, error) {
+	data, err := os.ReadFile(s.path(seq))
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	created, _, err := decode(data)
+	return created, int64(len(data)), err
+}
+
+func (s *Spool) read(seq uint64) (models.Metrics, error) {
+	data, err := os.ReadFile(s.path(seq))
+	if err != nil {
+		return nil, err
+	}
+	_, payload, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	ms := make(models.Metrics, 0)
+	err = json.Unmarshal(payload, &ms)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return ms, nil
+}
+
+func decode(data []byte) (time.Time, []byte, error) {
+	if len(data) < segmentHeader || string(data[:len(segmentMagic)]) != segmentMagic {
+		return time.Time{}, nil, fmt.Errorf("%w: invalid header", ErrCorrupt)
+	}
+	sum := binary.BigEndian.Uint32(data[len(segmentMagic):])
+	if crc32.ChecksumIEEE(data[len(segmentMagic)+4:]) != sum {
+		return time.Time{}, nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+	created := time.Unix(0, int64(binary.BigEndian.Uint64(data[len(segmentMagic)+4:])))
+	return created, data[segmentHeader:], nil
+}
+
+// writeFile Записывает данные и сбрасывает их на диск
+func writeFile(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Sync()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,101 @@
+package spool
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+func batch(t *testing.T, n int) models.Metrics {
+	m, err := models.NewMetric(fmt.Sprintf("metric%d", n), models.GaugeType, fmt.Sprint(n))
+	require.NoError(t, err)
+	return models.Metrics{m}
+}
+
+func TestPushPop(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0, 0)
+	require.NoError(t, err)
+	_, err = s.Peek()
+	assert.ErrorIs(t, err, ErrEmpty)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, s.Push(batch(t, i)))
+	}
+	assert.Equal(t, 3, s.Len())
+
+	reopened, err := Open(dir, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, reopened.Len())
+	assert.Equal(t, s.Size(), reopened.Size())
+	for i := 0; i < 3; i++ {
+		ms, err := reopened.Peek()
+		assert.NoError(t, err)
+		assert.Equal(t, batch(t, i), ms)
+		assert.NoError(t, reopened.Pop())
+	}
+	assert.ErrorIs(t, reopened.Pop(), ErrEmpty)
+	assert.NoError(t, reopened.Push(batch(t, 4)))
+	ms, err := reopened.Peek()
+	assert.NoError(t, err)
+	assert.Equal(t, batch(t, 4), ms)
+}
+
+func TestCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0, 0)
+	require.NoError(t, err)
+	assert.NoError(t, s.Push(batch(t, 0)))
+	assert.NoError(t, s.Push(batch(t, 1)))
+	first := s.path(s.segments[0].seq)
+	data, err := os.ReadFile(first)
+	require.NoError(t, err)
+	data[len(data)-2] ^= 0xff
+	require.NoError(t, os.WriteFile(first, data, 0644))
+	ms, err := s.Peek()
+	assert.NoError(t, err)
+	assert.Equal(t, batch(t, 1), ms)
+	assert.Equal(t, 1, s.Len())
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000099.seg"), []byte("garbage"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000100.seg.tmp"), []byte("partial"), 0644))
+	reopened, err := Open(dir, 0, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reopened.Len())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestLimits(t *testing.T) {
+	s, err := Open(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	assert.NoError(t, s.Push(batch(t, 0)))
+	size := s.Size()
+
+	s, err = Open(t.TempDir(), 2*size, 0)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, s.Push(batch(t, i)))
+	}
+	assert.Equal(t, 2, s.Len())
+	ms, err := s.Peek()
+	assert.NoError(t, err)
+	assert.Equal(t, batch(t, 3), ms)
+
+	s, err = Open(t.TempDir(), 0, 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.NoError(t, s.Push(batch(t, 0)))
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, s.Push(batch(t, 1)))
+	ms, err = s.Peek()
+	assert.NoError(t, err)
+	assert.Equal(t, batch(t, 1), ms)
+	assert.Equal(t, 1, s.Len())
+}
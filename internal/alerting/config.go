@@ -0,0 +1,59 @@
+package alerting
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/caarlos0/env/v8"
+
+	"github.com/Nexadis/metalert/internal/utils/logger"
+)
+
+// Config - Конфиг оповещений
+type Config struct {
+	Rules    []string `env:"ALERT_RULES" envSeparator:";" json:"rules,omitempty"` // правила оповещений
+	Interval int64    `env:"ALERT_INTERVAL" json:"interval,omitempty"`            // интервал проверки правил в секундах
+}
+
+func NewConfig() *Config {
+	return &Config{}
+}
+
+var DefaultInterval = int64(10)
+
+// rules позволяет задавать флаг с правилом несколько раз
+type rules struct {
+	c *Config
+}
+
+func (r rules) String() string {
+	if r.c == nil {
+		return ""
+	}
+	return strings.Join(r.c.Rules, "; ")
+}
+
+func (r rules) Set(value string) error {
+	_, err := ParseRule(value)
+	if err != nil {
+		return err
+	}
+	r.c.Rules = append(r.c.Rules, value)
+	return nil
+}
+
+func (c *Config) ParseCmd(set *flag.FlagSet) {
+	set.Int64Var(&c.Interval, "alert-interval", DefaultInterval, "Interval in seconds between alert rules evaluations")
+	set.Var(rules{c}, "alert", `Alert rule, e.g. "gauge CPUUtilization1 > 90 for 5m", can be repeated`)
+}
+
+func (c *Config) ParseEnv() {
+	err := env.Parse(c)
+	logger.Info("Parse alerts environment:",
+		"\nRules", c.Rules,
+		"\nInterval", c.Interval,
+	)
+	if err != nil {
+		logger.Error(err.Error())
+	}
+}
@@ -0,0 +1,198 @@
+package alerting
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage"
+	"github.com/Nexadis/metalert/internal/utils/logger"
+)
+
+// State - Состояние оповещения
+type State string
+
+// Состояния оповещений
+const (
+	StateInactive State = "inactive"
+	StatePending  State = "pending"
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+// Alert - Текущее состояние правила
+type Alert struct {
+	Rule       string    `json:"rule"`
+	MType      string    `json:"type"`
+	ID         string    `json:"id"`
+	State      State     `json:"state"`
+	Value      string    `json:"value,omitempty"` // последнее значение метрики
+	ActiveAt   time.Time `json:"active_at"`       // когда условие начало выполняться
+	FiredAt    time.Time `json:"fired_at"`        // когда оповещение сработало
+	ResolvedAt time.Time `json:"resolved_at"`     // когда оповещение прекратилось
+}
+
+// ruleState хранит состояние одного правила между проверками
+type ruleState struct {
+	rule       Rule
+	alert      Alert
+	lastValue  float64
+	lastChange time.Time
+	seen       bool
+}
+
+// Engine Периодически проверяет правила по данным хранилища
+type Engine struct {
+	getter   storage.Getter
+	interval time.Duration
+	mutex    sync.RWMutex
+	states   []*ruleState
+}
+
+// New Конструктор Engine. Разбирает все правила из конфига
+func New(getter storage.Getter, config *Config) (*Engine, error) {
+	if config == nil {
+		config = NewConfig()
+	}
+	e := &Engine{
+		getter:   getter,
+		interval: time.Duration(config.Interval) * time.Second,
+		states:   make([]*ruleState, 0, len(config.Rules)),
+	}
+	for _, text := range config.Rules {
+		r, err := ParseRule(text)
+		if err != nil {
+			return nil, err
+		}
+		e.states = append(e.states, &ruleState{
+			rule: r,
+			alert: Alert{
+				Rule:  r.String(),
+				MType: r.MType,
+				ID:    r.ID,
+				State: StateInactive,
+			},
+		})
+	}
+	return e, nil
+}
+
+// Run Запускает проверку правил с заданным интервалом до завершения контекста
+func (e *Engine) Run(ctx context.Context) error {
+	if len(e.states) == 0 {
+		return nil
+	}
+	interval := e.interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			err := e.Evaluate(ctx, now)
+			if err != nil {
+				logger.Error("Can't evaluate alerts:", err)
+			}
+		}
+	}
+}
+
+// Evaluate Проверяет все правила на момент now
+func (e *Engine) Evaluate(ctx context.Context, now time.Time) error {
+	metrics, err := e.getter.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+	values := make(map[string]models.Metric, len(metrics))
+	for _, m := range metrics {
+		// правила относятся к сериям без меток
+		values[m.MType+"/"+m.Series()] = m
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, s := range e.states {
+		m, ok := values[s.rule.MType+"/"+s.rule.ID]
+		s.evaluate(m, ok, now)
+	}
+	return nil
+}
+
+func (s *ruleState) evaluate(m models.Metric, ok bool, now time.Time) {
+	var value float64
+	if ok {
+		v, err := m.GetFloat()
+		if err != nil {
+			ok = false
+		} else {
+			value = v
+			s.alert.Value, _ = m.GetValue()
+		}
+	}
+	var holds bool
+	if s.rule.Stale {
+		if !s.seen || (ok && value > s.lastValue) {
+			s.lastChange = now
+		}
+		s.seen = true
+		if ok {
+			s.lastValue = value
+		}
+		holds = now.Sub(s.lastChange) >= s.rule.For
+	} else {
+		holds = ok && s.rule.Cond.Match(value)
+	}
+	s.transit(holds, now)
+}
+
+// transit Переводит оповещение в следующее состояние
+func (s *ruleState) transit(holds bool, now time.Time) {
+	a := &s.alert
+	if !holds {
+		switch a.State {
+		case StatePending:
+			a.State = StateInactive
+		case StateFiring:
+			a.State = StateResolved
+			a.ResolvedAt = now
+		}
+		return
+	}
+	switch a.State {
+	case StateInactive, StateResolved:
+		a.State = StatePending
+		a.ActiveAt = now
+		a.FiredAt = time.Time{}
+		a.ResolvedAt = time.Time{}
+		if s.rule.Stale {
+			a.ActiveAt = s.lastChange.Add(s.rule.For)
+		}
+		fallthrough
+	case StatePending:
+		if s.rule.Stale || now.Sub(a.ActiveAt) >= s.rule.For {
+			a.State = StateFiring
+			a.FiredAt = now
+		}
+	}
+}
+
+// Alerts Возвращает все активные и недавно завершившиеся оповещения
+func (e *Engine) Alerts() []Alert {
+	if e == nil {
+		return []Alert{}
+	}
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	alerts := make([]Alert, 0, len(e.states))
+	for _, s := range e.states {
+		if s.alert.State == StateInactive {
+			continue
+		}
+		alerts = append(alerts, s.alert)
+	}
+	return alerts
+}
@@ -0,0 +1,98 @@
+package alerting
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage/mem"
+)
+
+func set(t *testing.T, s *mem.Storage, mtype, id, value string) {
+	m, err := models.NewMetric(id, mtype, value)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Set(context.TODO(), m))
+}
+
+func TestThreshold(t *testing.T) {
+	s := mem.NewMetricsStorage()
+	e, err := New(s, &Config{
+		Rules: []string{"gauge CPU > 90 for 5m"},
+	})
+	assert.NoError(t, err)
+	ctx := context.TODO()
+	start := time.Now()
+
+	set(t, s, models.GaugeType, "CPU", "10")
+	assert.NoError(t, e.Evaluate(ctx, start))
+	assert.Empty(t, e.Alerts())
+
+	set(t, s, models.GaugeType, "CPU", "95")
+	assert.NoError(t, e.Evaluate(ctx, start.Add(time.Minute)))
+	alerts := e.Alerts()
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, StatePending, alerts[0].State)
+	assert.Equal(t, "95", alerts[0].Value)
+
+	assert.NoError(t, e.Evaluate(ctx, start.Add(6*time.Minute)))
+	alerts = e.Alerts()
+	assert.Equal(t, StateFiring, alerts[0].State)
+	assert.Equal(t, start.Add(6*time.Minute), alerts[0].FiredAt)
+
+	set(t, s, models.GaugeType, "CPU", "50")
+	assert.NoError(t, e.Evaluate(ctx, start.Add(7*time.Minute)))
+	alerts = e.Alerts()
+	assert.Equal(t, StateResolved, alerts[0].State)
+	assert.Equal(t, start.Add(7*time.Minute), alerts[0].ResolvedAt)
+}
+
+func TestPendingReset(t *testing.T) {
+	s := mem.NewMetricsStorage()
+	e, err := New(s, &Config{
+		Rules: []string{"gauge CPU > 90 for 5m"},
+	})
+	assert.NoError(t, err)
+	ctx := context.TODO()
+	start := time.Now()
+	set(t, s, models.GaugeType, "CPU", "95")
+	assert.NoError(t, e.Evaluate(ctx, start))
+	assert.Equal(t, StatePending, e.Alerts()[0].State)
+	set(t, s, models.GaugeType, "CPU", "50")
+	assert.NoError(t, e.Evaluate(ctx, start.Add(time.Minute)))
+	assert.Empty(t, e.Alerts())
+}
+
+func TestStale(t *testing.T) {
+	s := mem.NewMetricsStorage()
+	e, err := New(s, &Config{
+		Rules: []string{"counter PollCount has not increased in 2m"},
+	})
+	assert.NoError(t, err)
+	ctx := context.TODO()
+	start := time.Now()
+
+	set(t, s, models.CounterType, "PollCount", "1")
+	assert.NoError(t, e.Evaluate(ctx, start))
+	assert.Empty(t, e.Alerts())
+
+	assert.NoError(t, e.Evaluate(ctx, start.Add(3*time.Minute)))
+	alerts := e.Alerts()
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, StateFiring, alerts[0].State)
+
+	set(t, s, models.CounterType, "PollCount", "1")
+	assert.NoError(t, e.Evaluate(ctx, start.Add(4*time.Minute)))
+	assert.Equal(t, StateResolved, e.Alerts()[0].State)
+}
+
+func TestInvalidRule(t *testing.T) {
+	_, err := New(mem.NewMetricsStorage(), &Config{
+		Rules: []string{"gauge CPU"},
+	})
+	assert.Error(t, err)
+	var e *Engine
+	assert.Empty(t, e.Alerts())
+}
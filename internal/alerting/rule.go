@@ -0,0 +1,152 @@
+// alerting реализует правила оповещений и их периодическую проверку
+package alerting
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+// Ошибки разбора правил
+var (
+	ErrInvalidRule      = errors.New("invalid rule")
+	ErrInvalidCondition = errors.New("invalid condition")
+)
+
+// Операторы сравнения, доступные в условиях
+const (
+	OpGreater      = ">"
+	OpGreaterEqual = ">="
+	OpLess         = "<"
+	OpLessEqual    = "<="
+	OpEqual        = "=="
+	OpNotEqual     = "!="
+)
+
+// Condition - Условие сравнения значения метрики с порогом
+type Condition struct {
+	Op        string
+	Threshold float64
+}
+
+// ParseCondition Разбирает условие вида "> 90"
+func ParseCondition(s string) (Condition, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return Condition{}, fmt.Errorf("%w: %q", ErrInvalidCondition, s)
+	}
+	return newCondition(fields[0], fields[1])
+}
+
+func newCondition(op, threshold string) (Condition, error) {
+	switch op {
+	case OpGreater, OpGreaterEqual, OpLess, OpLessEqual, OpEqual, OpNotEqual:
+	default:
+		return Condition{}, fmt.Errorf("%w: unknown operator %q", ErrInvalidCondition, op)
+	}
+	v, err := strconv.ParseFloat(threshold, 64)
+	if err != nil {
+		return Condition{}, fmt.Errorf("%w: %v", ErrInvalidCondition, err)
+	}
+	return Condition{
+		Op:        op,
+		Threshold: v,
+	}, nil
+}
+
+// Match Проверяет, выполняется ли условие для значения v
+func (c Condition) Match(v float64) bool {
+	switch c.Op {
+	case OpGreater:
+		return v > c.Threshold
+	case OpGreaterEqual:
+		return v >= c.Threshold
+	case OpLess:
+		return v < c.Threshold
+	case OpLessEqual:
+		return v <= c.Threshold
+	case OpEqual:
+		return v == c.Threshold
+	case OpNotEqual:
+		return v != c.Threshold
+	}
+	return false
+}
+
+func (c Condition) String() string {
+	return fmt.Sprintf("%s %s", c.Op, strconv.FormatFloat(c.Threshold, 'f', -1, 64))
+}
+
+// Rule - Правило оповещения для одной метрики.
+//
+// Поддерживаются два вида правил:
+//
+//	gauge CPUUtilization1 > 90 for 5m
+//	counter PollCount has not increased in 2m
+type Rule struct {
+	Expr  string        // исходный текст правила
+	MType string        // тип метрики
+	ID    string        // имя метрики
+	Cond  Condition     // условие для пороговых правил
+	Stale bool          // правило срабатывает, если значение не росло в течение For
+	For   time.Duration // сколько условие должно выполняться перед срабатыванием
+}
+
+// ParseRule Разбирает правило из строки
+func ParseRule(s string) (Rule, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 4 {
+		return Rule{}, fmt.Errorf("%w: %q", ErrInvalidRule, s)
+	}
+	r := Rule{
+		Expr:  strings.Join(fields, " "),
+		MType: strings.ToLower(fields[0]),
+		ID:    fields[1],
+	}
+	if r.MType != models.GaugeType && r.MType != models.CounterType {
+		return Rule{}, fmt.Errorf("%w: %v %q", ErrInvalidRule, models.ErrorType, fields[0])
+	}
+	rest := fields[2:]
+	if rest[0] == "has" {
+		// has not increased in <duration>
+		if len(rest) != 5 || rest[1] != "not" || rest[2] != "increased" || rest[3] != "in" {
+			return Rule{}, fmt.Errorf("%w: %q", ErrInvalidRule, s)
+		}
+		d, err := time.ParseDuration(rest[4])
+		if err != nil {
+			return Rule{}, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+		r.Stale = true
+		r.For = d
+		return r, nil
+	}
+	cond, err := newCondition(rest[0], rest[1])
+	if err != nil {
+		return Rule{}, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+	}
+	r.Cond = cond
+	rest = rest[2:]
+	switch len(rest) {
+	case 0:
+	case 2:
+		if rest[0] != "for" {
+			return Rule{}, fmt.Errorf("%w: %q", ErrInvalidRule, s)
+		}
+		d, err := time.ParseDuration(rest[1])
+		if err != nil {
+			return Rule{}, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+		r.For = d
+	default:
+		return Rule{}, fmt.Errorf("%w: %q", ErrInvalidRule, s)
+	}
+	return r, nil
+}
+
+func (r Rule) String() string {
+	return r.Expr
+}
@@ -0,0 +1,91 @@
+package alerting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		name string
+		rule string
+		want Rule
+		err  bool
+	}{
+		{
+			name: "Threshold with duration",
+			rule: "gauge CPUUtilization1 > 90 for 5m",
+			want: Rule{
+				Expr:  "gauge CPUUtilization1 > 90 for 5m",
+				MType: "gauge",
+				ID:    "CPUUtilization1",
+				Cond:  Condition{Op: OpGreater, Threshold: 90},
+				For:   5 * time.Minute,
+			},
+		},
+		{
+			name: "Threshold without duration",
+			rule: "counter PollCount >= 10",
+			want: Rule{
+				Expr:  "counter PollCount >= 10",
+				MType: "counter",
+				ID:    "PollCount",
+				Cond:  Condition{Op: OpGreaterEqual, Threshold: 10},
+			},
+		},
+		{
+			name: "Stale counter",
+			rule: "counter PollCount has not increased in 2m",
+			want: Rule{
+				Expr:  "counter PollCount has not increased in 2m",
+				MType: "counter",
+				ID:    "PollCount",
+				Stale: true,
+				For:   2 * time.Minute,
+			},
+		},
+		{
+			name: "Invalid type",
+			rule: "histogram name > 1",
+			err:  true,
+		},
+		{
+			name: "Invalid operator",
+			rule: "gauge name => 1",
+			err:  true,
+		},
+		{
+			name: "Invalid duration",
+			rule: "gauge name > 1 for ever",
+			err:  true,
+		},
+		{
+			name: "Too short",
+			rule: "gauge name",
+			err:  true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := ParseRule(test.rule)
+			if test.err {
+				assert.ErrorIs(t, err, ErrInvalidRule)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, r)
+		})
+	}
+}
+
+func TestCondition(t *testing.T) {
+	c, err := ParseCondition("> 90")
+	assert.NoError(t, err)
+	assert.True(t, c.Match(91))
+	assert.False(t, c.Match(90))
+	assert.Equal(t, "> 90", c.String())
+	_, err = ParseCondition(">90")
+	assert.ErrorIs(t, err, ErrInvalidCondition)
+}
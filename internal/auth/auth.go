@@ -0,0 +1,212 @@
+// auth реализует проверку API-ключей и их прав на метрики
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+// Scope - Право API-ключа
+type Scope string
+
+// Права API-ключей
+const (
+	Read  Scope = "read"  // чтение метрик
+	Write Scope = "write" // запись метрик
+	Admin Scope = "admin" // все права на все метрики, без ограничения по префиксам
+)
+
+// Ошибки проверки ключей
+var (
+	ErrNoKey        = errors.New("no api key")
+	ErrInvalidKey   = errors.New("invalid api key")
+	ErrInvalidScope = errors.New("invalid scope")
+	ErrForbidden    = errors.New("forbidden")
+)
+
+// Key - API-ключ. Сам токен не хранится, хранится только его хеш SHA-256 в hex, см. HashToken
+type Key struct {
+	Name     string   `json:"name"`
+	Hash     string   `json:"hash"`
+	Scopes   []Scope  `json:"scopes"`
+	Prefixes []string `json:"prefixes,omitempty"` // префиксы имён доступных метрик, пустой - все метрики
+}
+
+// Check Проверяет имя, хеш и права ключа
+func (k Key) Check() error {
+	if k.Name == "" {
+		return fmt.Errorf("%w: empty name", ErrInvalidKey)
+	}
+	hash, err := hex.DecodeString(k.Hash)
+	if err != nil || len(hash) != sha256.Size {
+		return fmt.Errorf("%w: %s: hash must be hex of sha256", ErrInvalidKey, k.Name)
+	}
+	for _, s := range k.Scopes {
+		switch s {
+		case Read, Write, Admin:
+		default:
+			return fmt.Errorf("%w: %s: %q", ErrInvalidScope, k.Name, s)
+		}
+	}
+	return nil
+}
+
+// Has Проверяет, что у ключа есть право scope. Admin включает все права
+func (k Key) Has(scope Scope) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == Admin {
+			return true
+		}
+	}
+	return false
+}
+
+// Unrestricted Сообщает, что ключу доступны все метрики
+func (k Key) Unrestricted() bool {
+	return len(k.Prefixes) == 0 || k.Has(Admin)
+}
+
+// Allows Проверяет, что имя метрики начинается с одного из префиксов ключа
+func (k Key) Allows(id string) bool {
+	if k.Unrestricted() {
+		return true
+	}
+	for _, p := range k.Prefixes {
+		if strings.HasPrefix(id, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// HashToken Возвращает хеш токена в том виде, в котором он хранится в Key.Hash
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewToken Создаёт случайный токен для нового ключа
+func NewToken() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// ParseBearer Получает токен из заголовка вида "Bearer <token>"
+func ParseBearer(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// Store - Внешнее хранилище ключей, например таблица в Postgres.
+// Если ключа с таким хешем нет, возвращает ErrInvalidKey
+type Store interface {
+	LookupKey(ctx context.Context, hash string) (Key, error)
+}
+
+// Authenticator Находит ключи по токену в конфигурации и во внешнем хранилище
+type Authenticator struct {
+	keys  map[string]Key
+	store Store
+}
+
+// New Создаёт Authenticator из ключей конфигурации и хранилища, store может быть nil.
+// Без ключей и хранилища проверка выключена
+func New(keys []Key, store Store) (*Authenticator, error) {
+	a := &Authenticator{
+		keys:  make(map[string]Key, len(keys)),
+		store: store,
+	}
+	names := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		err := k.Check()
+		if err != nil {
+			return nil, err
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("%w: duplicate name %s", ErrInvalidKey, k.Name)
+		}
+		names[k.Name] = true
+		a.keys[strings.ToLower(k.Hash)] = k
+	}
+	return a, nil
+}
+
+// Enabled Сообщает, что запросы должны предъявлять ключ
+func (a *Authenticator) Enabled() bool {
+	return a != nil && (len(a.keys) != 0 || a.store != nil)
+}
+
+// Authenticate Находит ключ по токену
+func (a *Authenticator) Authenticate(ctx context.Context, token string) (Key, error) {
+	if token == "" {
+		return Key{}, ErrNoKey
+	}
+	hash := HashToken(token)
+	if k, ok := a.keys[hash]; ok {
+		return k, nil
+	}
+	if a.store == nil {
+		return Key{}, ErrInvalidKey
+	}
+	return a.store.LookupKey(ctx, hash)
+}
+
+type keyContext struct{}
+
+// NewContext Сохраняет проверенный ключ в контексте запроса
+func NewContext(ctx context.Context, k Key) context.Context {
+	return context.WithValue(ctx, keyContext{}, k)
+}
+
+// FromContext Возвращает ключ запроса
+func FromContext(ctx context.Context) (Key, bool) {
+	k, ok := ctx.Value(keyContext{}).(Key)
+	return k, ok
+}
+
+// Allowed Проверяет право scope на метрику id у ключа запроса.
+// Запрос без ключа проходит: без ключа до обработчика доходят только запросы при выключенной проверке
+func Allowed(ctx context.Context, scope Scope, id string) bool {
+	k, ok := FromContext(ctx)
+	if !ok {
+		return true
+	}
+	return k.Has(scope) && k.Allows(id)
+}
+
+// Unrestricted Проверяет, что ключу запроса доступны все метрики.
+// Нужно для запросов, которые читают метрики без указания имени: выражения и оповещения
+func Unrestricted(ctx context.Context) bool {
+	k, ok := FromContext(ctx)
+	return !ok || k.Unrestricted()
+}
+
+// Filter Оставляет метрики, доступные ключу запроса для чтения
+func Filter(ctx context.Context, ms models.Metrics) models.Metrics {
+	if Unrestricted(ctx) {
+		return ms
+	}
+	result := make(models.Metrics, 0, len(ms))
+	for _, m := range ms {
+		if Allowed(ctx, Read, m.ID) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+type mapStore map[string]Key
+
+func (s mapStore) LookupKey(ctx context.Context, hash string) (Key, error) {
+	k, ok := s[hash]
+	if !ok {
+		return Key{}, ErrInvalidKey
+	}
+	return k, nil
+}
+
+func TestKey(t *testing.T) {
+	agent := Key{Name: "agent", Hash: HashToken("a"), Scopes: []Scope{Write}, Prefixes: []string{"host1."}}
+	assert.NoError(t, agent.Check())
+	assert.True(t, agent.Has(Write))
+	assert.False(t, agent.Has(Read))
+	assert.True(t, agent.Allows("host1.cpu"))
+	assert.False(t, agent.Allows("host2.cpu"))
+
+	admin := Key{Name: "admin", Hash: HashToken("b"), Scopes: []Scope{Admin}, Prefixes: []string{"host1."}}
+	assert.True(t, admin.Has(Read))
+	assert.True(t, admin.Allows("host2.cpu"))
+
+	assert.ErrorIs(t, Key{Name: "x", Hash: "abc"}.Check(), ErrInvalidKey)
+	assert.ErrorIs(t, Key{Hash: HashToken("c")}.Check(), ErrInvalidKey)
+	assert.ErrorIs(t, Key{Name: "x", Hash: HashToken("c"), Scopes: []Scope{"delete"}}.Check(), ErrInvalidScope)
+}
+
+func TestAuthenticate(t *testing.T) {
+	disabled, err := New(nil, nil)
+	require.NoError(t, err)
+	assert.False(t, disabled.Enabled())
+
+	reader := Key{Name: "reader", Hash: HashToken("read-token"), Scopes: []Scope{Read}}
+	_, err = New([]Key{reader, reader}, nil)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	stored := Key{Name: "stored", Hash: HashToken("stored-token"), Scopes: []Scope{Write}}
+	a, err := New([]Key{reader}, mapStore{stored.Hash: stored})
+	require.NoError(t, err)
+	assert.True(t, a.Enabled())
+	ctx := context.Background()
+	k, err := a.Authenticate(ctx, "read-token")
+	assert.NoError(t, err)
+	assert.Equal(t, reader, k)
+	k, err = a.Authenticate(ctx, "stored-token")
+	assert.NoError(t, err)
+	assert.Equal(t, stored, k)
+	_, err = a.Authenticate(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, err = a.Authenticate(ctx, "")
+	assert.ErrorIs(t, err, ErrNoKey)
+
+	token, ok := ParseBearer("Bearer read-token")
+	assert.True(t, ok)
+	assert.Equal(t, "read-token", token)
+	_, ok = ParseBearer("Basic dXNlcg==")
+	assert.False(t, ok)
+}
+
+func TestFilter(t *testing.T) {
+	ms := models.Metrics{{ID: "host1.cpu"}, {ID: "host2.cpu"}}
+	ctx := context.Background()
+	assert.Equal(t, ms, Filter(ctx, ms))
+	assert.True(t, Allowed(ctx, Write, "host2.cpu"))
+
+	ctx = NewContext(ctx, Key{Name: "dashboard", Scopes: []Scope{Read}, Prefixes: []string{"host1."}})
+	assert.Equal(t, ms[:1], Filter(ctx, ms))
+	assert.False(t, Allowed(ctx, Write, "host1.cpu"))
+	assert.False(t, Unrestricted(ctx))
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Коды ошибок отдельных метрик пачки
+const (
+	CodeInvalidType   = "invalid_type"   // неизвестный тип метрики
+	CodeInvalidValue  = "invalid_value"  // значение не подходит к типу метрики
+	CodeInvalidLabels = "invalid_labels" // неверные имена меток
+	CodeStorage       = "storage"        // хранилище не смогло записать метрику, можно повторить
+	CodeForbidden     = "forbidden"      // ключу запроса запрещена запись метрики
+)
+
+// ItemError - Ошибка отдельной метрики пачки. Index - номер метрики в пачке
+type ItemError struct {
+	Index     int    `json:"index"`
+	ID        string `json:"id"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"`
+}
+
+// NewItemError Описывает ошибку метрики с номером index. Код определяется по типу ошибки,
+// ошибки не из models считаются ошибками хранилища, их можно повторить
+func NewItemError(index int, id string, err error) ItemError {
+	e := ItemError{
+		Index:   index,
+		ID:      id,
+		Message: err.Error(),
+	}
+	switch {
+	case errors.Is(err, ErrorType):
+		e.Code = CodeInvalidType
+	case errors.Is(err, ErrorLabels):
+		e.Code = CodeInvalidLabels
+	case errors.Is(err, ErrorMetrics):
+		e.Code = CodeInvalidValue
+	default:
+		e.Code = CodeStorage
+		e.Retryable = true
+	}
+	return e
+}
+
+// BatchResult - Результат записи пачки: количество принятых метрик и ошибки отклонённых
+type BatchResult struct {
+	Accepted int         `json:"accepted"`
+	Errors   []ItemError `json:"errors,omitempty"`
+}
+
+// BatchError - Часть метрик пачки отклонена сервером
+type BatchError struct {
+	Errors []ItemError
+}
+
+func (e *BatchError) Error() string {
+	items := make([]string, 0, len(e.Errors))
+	for _, item := range e.Errors {
+		items = append(items, fmt.Sprintf("#%d %s: %s", item.Index, item.ID, item.Message))
+	}
+	return fmt.Sprintf("%d metrics rejected: %s", len(e.Errors), strings.Join(items, "; "))
+}
+
+// Retry Возвращает метрики пачки ms, отклонённые с ошибкой, которую можно повторить
+func (e *BatchError) Retry(ms Metrics) Metrics {
+	retry := make(Metrics, 0, len(e.Errors))
+	for _, item := range e.Errors {
+		if item.Retryable && item.Index >= 0 && item.Index < len(ms) {
+			retry = append(retry, ms[item.Index])
+		}
+	}
+	return retry
+}
+
+// Validate Проверяет имя, значение и метки метрики перед записью
+func (m Metric) Validate() error {
+	err := CheckID(m.ID)
+	if err != nil {
+		return err
+	}
+	_, err = m.GetValue()
+	if err != nil {
+		return err
+	}
+	return CheckLabels(m.Labels)
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewItemError(t *testing.T) {
+	_, err := NewMetric("a", "unknown", "1")
+	assert.Equal(t, CodeInvalidType, NewItemError(0, "a", err).Code)
+	_, err = NewMetric("a", CounterType, "1.5")
+	assert.Equal(t, CodeInvalidValue, NewItemError(0, "a", err).Code)
+	err = CheckLabels(map[string]string{"1": "a"})
+	assert.Equal(t, CodeInvalidLabels, NewItemError(0, "a", err).Code)
+	e := NewItemError(3, "a", fmt.Errorf("retry db %w", errors.New("connection refused")))
+	assert.Equal(t, CodeStorage, e.Code)
+	assert.True(t, e.Retryable)
+	assert.Equal(t, 3, e.Index)
+}
+
+func TestBatchErrorRetry(t *testing.T) {
+	ms := Metrics{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	err := &BatchError{Errors: []ItemError{
+		{Index: 0, ID: "a", Code: CodeInvalidValue, Message: "bad"},
+		{Index: 2, ID: "c", Code: CodeStorage, Message: "down", Retryable: true},
+		{Index: 5, ID: "x", Code: CodeStorage, Retryable: true},
+	}}
+	assert.Equal(t, Metrics{{ID: "c"}}, err.Retry(ms))
+	assert.Contains(t, err.Error(), "#0 a: bad")
+}
@@ -1,13 +1,16 @@
 package controller
 
 import (
+	"google.golang.org/protobuf/types/known/timestamppb"
+
 	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/query"
 	pb "github.com/Nexadis/metalert/proto/metrics/v1"
 )
 
 func MetricsFromPB(ms *pb.Metrics) (models.Metrics, error) {
-	result := make(models.Metrics, 0, len(ms.Metrics))
-	for _, m := range ms.Metrics {
+	result := make(models.Metrics, 0, len(ms.GetMetrics()))
+	for _, m := range ms.GetMetrics() {
 		newm, err := MetricFromPB(m)
 		if err != nil {
 			return nil, err
@@ -17,22 +20,74 @@ func MetricsFromPB(ms *pb.Metrics) (models.Metrics, error) {
 	return result, nil
 }
 
-func typeFromPB(t pb.Metric_MType) (string, error) {
+// BatchFromPB Преобразует пачку из protobuf, не останавливаясь на ошибках. Метрики с ошибками
+// остаются в пачке пустыми на своих местах, а их ошибки возвращаются отдельно
+func BatchFromPB(ms *pb.Metrics) (models.Metrics, []models.ItemError) {
+	result := make(models.Metrics, len(ms.GetMetrics()))
+	var rejected []models.ItemError
+	for i, m := range ms.GetMetrics() {
+		newm, err := MetricFromPB(m)
+		if err != nil {
+			rejected = append(rejected, models.NewItemError(i, m.GetId(), err))
+			continue
+		}
+		result[i] = newm
+	}
+	return result, rejected
+}
+
+// ItemErrorsToPB Преобразует ошибки отдельных метрик пачки в protobuf
+func ItemErrorsToPB(errs []models.ItemError) []*pb.ItemError {
+	result := make([]*pb.ItemError, 0, len(errs))
+	for _, e := range errs {
+		result = append(result, &pb.ItemError{
+			Index:     uint32(e.Index),
+			Id:        e.ID,
+			Code:      e.Code,
+			Message:   e.Message,
+			Retryable: e.Retryable,
+		})
+	}
+	return result
+}
+
+// ItemErrorsFromPB Преобразует ошибки отдельных метрик пачки из protobuf
+func ItemErrorsFromPB(errs []*pb.ItemError) []models.ItemError {
+	result := make([]models.ItemError, 0, len(errs))
+	for _, e := range errs {
+		result = append(result, models.ItemError{
+			Index:     int(e.GetIndex()),
+			ID:        e.GetId(),
+			Code:      e.GetCode(),
+			Message:   e.GetMessage(),
+			Retryable: e.GetRetryable(),
+		})
+	}
+	return result
+}
+
+// TypeFromPB Возвращает тип метрики по значению из protobuf
+func TypeFromPB(t pb.Metric_MType) (string, error) {
 	switch t {
 	case pb.Metric_M_TYPE_GAUGE:
 		return models.GaugeType, nil
 	case pb.Metric_M_TYPE_COUNTER:
 		return models.CounterType, nil
+	case pb.Metric_M_TYPE_HISTOGRAM:
+		return models.HistogramType, nil
 	}
 	return "", models.ErrorType
 }
 
-func typeToPB(t string) (pb.Metric_MType, error) {
+// TypeToPB Возвращает значение protobuf по типу метрики
+func TypeToPB(t string) (pb.Metric_MType, error) {
 	switch t {
 	case models.GaugeType:
 		return pb.Metric_M_TYPE_GAUGE, nil
 	case models.CounterType:
 		return pb.Metric_M_TYPE_COUNTER, nil
+	case models.HistogramType:
+		return pb.Metric_M_TYPE_HISTOGRAM, nil
 	}
 	return pb.Metric_M_TYPE_UNSPECIFIED, models.ErrorType
 }
@@ -52,25 +107,75 @@ func MetricsToPB(ms models.Metrics) (*pb.Metrics, error) {
 }
 
 func MetricFromPB(m *pb.Metric) (models.Metric, error) {
-	t, err := typeFromPB(m.GetType())
+	t, err := TypeFromPB(m.GetType())
 	if err != nil {
 		return models.Metric{}, err
 	}
+	if t == models.HistogramType {
+		return histogramFromPB(m)
+	}
 	newm, err := models.NewMetric(m.GetId(), t, m.GetValue())
 	if err != nil {
 		return models.Metric{}, err
 	}
+	if len(m.GetLabels()) != 0 {
+		newm.Labels = m.GetLabels()
+	}
+	newm.Kind, err = kindFromPB(m.GetKind())
+	if err != nil {
+		return models.Metric{}, err
+	}
+	_, err = newm.GetValue()
+	if err != nil {
+		return models.Metric{}, err
+	}
 	return newm, nil
 }
 
+func kindFromPB(k pb.Metric_Kind) (string, error) {
+	switch k {
+	case pb.Metric_KIND_UNSPECIFIED:
+		return "", nil
+	case pb.Metric_KIND_DELTA:
+		return models.DeltaKind, nil
+	case pb.Metric_KIND_CUMULATIVE:
+		return models.CumulativeKind, nil
+	}
+	return "", models.ErrorMetrics
+}
+
+func kindToPB(k string) pb.Metric_Kind {
+	switch k {
+	case models.DeltaKind:
+		return pb.Metric_KIND_DELTA
+	case models.CumulativeKind:
+		return pb.Metric_KIND_CUMULATIVE
+	}
+	return pb.Metric_KIND_UNSPECIFIED
+}
+
 func MetricToPB(m models.Metric) (*pb.Metric, error) {
 	var pm pb.Metric
-	t, err := typeToPB(m.MType)
+	t, err := TypeToPB(m.MType)
 	if err != nil {
 		return nil, err
 	}
 	pm.Id = m.ID
 	pm.Type = t
+	pm.Labels = m.Labels
+	pm.Kind = kindToPB(m.Kind)
+	if m.MType == models.HistogramType {
+		if m.Histogram == nil {
+			return nil, models.ErrorMetrics
+		}
+		pm.Histogram = &pb.Histogram{
+			Bounds: m.Histogram.Bounds,
+			Counts: m.Histogram.Counts,
+			Sum:    m.Histogram.Sum,
+			Count:  m.Histogram.Count,
+		}
+		return &pm, nil
+	}
 	v, err := m.GetValue()
 	if err != nil {
 		return nil, err
@@ -78,3 +183,69 @@ func MetricToPB(m models.Metric) (*pb.Metric, error) {
 	pm.Value = v
 	return &pm, nil
 }
+
+func histogramFromPB(m *pb.Metric) (models.Metric, error) {
+	ph := m.GetHistogram()
+	if ph == nil {
+		return models.Metric{}, models.ErrorMetrics
+	}
+	h := models.Histogram{
+		Bounds: ph.GetBounds(),
+		Counts: ph.GetCounts(),
+		Sum:    ph.GetSum(),
+		Count:  ph.GetCount(),
+	}
+	err := h.Check()
+	if err != nil {
+		return models.Metric{}, err
+	}
+	newm := models.Metric{
+		ID:        m.GetId(),
+		MType:     models.HistogramType,
+		Histogram: &h,
+	}
+	if len(m.GetLabels()) != 0 {
+		newm.Labels = m.GetLabels()
+	}
+	return newm, nil
+}
+
+func MatchersFromPB(ms []*pb.LabelMatcher) ([]models.Matcher, error) {
+	result := make([]models.Matcher, 0, len(ms))
+	for _, m := range ms {
+		matcher, err := models.NewMatcher(m.GetName(), m.GetOp(), m.GetValue())
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, matcher)
+	}
+	return result, nil
+}
+
+// QueryResultToPB Преобразует результат запроса. Серии без имени передаются с типом M_TYPE_UNSPECIFIED
+func QueryResultToPB(r query.Result) (*pb.QueryResponse, error) {
+	resp := &pb.QueryResponse{
+		Type:   r.Type,
+		Time:   timestamppb.New(r.Time),
+		Series: make([]*pb.QuerySeries, 0, len(r.Series)),
+	}
+	if r.Scalar != nil {
+		resp.Scalar = *r.Scalar
+	}
+	for _, s := range r.Series {
+		ps := &pb.QuerySeries{
+			Id:     s.Name,
+			Labels: s.Labels,
+			Value:  s.Value,
+		}
+		if s.MType != "" {
+			t, err := TypeToPB(s.MType)
+			if err != nil {
+				return nil, err
+			}
+			ps.Type = t
+		}
+		resp.Series = append(resp.Series, ps)
+	}
+	return resp, nil
+}
@@ -0,0 +1,142 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Histogram - Распределение значений по корзинам с заданными границами.
+//
+// Counts[i] - количество значений в промежутке (Bounds[i-1], Bounds[i]],
+// последний элемент Counts - количество значений больше всех границ, поэтому len(Counts) == len(Bounds)+1.
+// Значения передаются приращениями, как и для counter.
+type Histogram struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`   // сумма всех значений
+	Count  uint64    `json:"count"` // количество значений
+}
+
+// NewHistogram Конструктор пустой гистограммы. Границы должны строго возрастать
+func NewHistogram(bounds []float64) (*Histogram, error) {
+	h := &Histogram{
+		Bounds: append([]float64(nil), bounds...),
+		Counts: make([]uint64, len(bounds)+1),
+	}
+	return h, h.Check()
+}
+
+// Observe Добавляет значение в гистограмму
+func (h *Histogram) Observe(v float64) {
+	i := 0
+	for i < len(h.Bounds) && v > h.Bounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Sum += v
+	h.Count++
+}
+
+// Check Проверяет согласованность границ, корзин и количества значений
+func (h Histogram) Check() error {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("%w: histogram has %d bounds and %d buckets", ErrorMetrics, len(h.Bounds), len(h.Counts))
+	}
+	for i, b := range h.Bounds {
+		if math.IsNaN(b) || math.IsInf(b, 0) || i > 0 && b <= h.Bounds[i-1] {
+			return fmt.Errorf("%w: histogram bounds must be finite and increasing", ErrorMetrics)
+		}
+	}
+	var count uint64
+	for _, c := range h.Counts {
+		count += c
+	}
+	if count != h.Count {
+		return fmt.Errorf("%w: histogram count %d doesn't match buckets %d", ErrorMetrics, h.Count, count)
+	}
+	return nil
+}
+
+// Merge Складывает гистограммы с одинаковыми границами.
+// Если границы отличаются, возвращает false
+func (h Histogram) Merge(o Histogram) (Histogram, bool) {
+	if !equalBounds(h.Bounds, o.Bounds) || len(h.Counts) != len(o.Counts) {
+		return Histogram{}, false
+	}
+	result := h.Copy()
+	for i, c := range o.Counts {
+		result.Counts[i] += c
+	}
+	result.Sum += o.Sum
+	result.Count += o.Count
+	return result, true
+}
+
+// Copy Возвращает копию гистограммы, не разделяющую с ней память
+func (h Histogram) Copy() Histogram {
+	h.Bounds = append([]float64(nil), h.Bounds...)
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+// String Возвращает гистограмму в текстовом виде "границы;корзины;сумма", например "0.1,1;3,2,0;1.5"
+func (h Histogram) String() string {
+	bounds := make([]string, 0, len(h.Bounds))
+	for _, b := range h.Bounds {
+		bounds = append(bounds, strconv.FormatFloat(b, 'f', -1, 64))
+	}
+	counts := make([]string, 0, len(h.Counts))
+	for _, c := range h.Counts {
+		counts = append(counts, strconv.FormatUint(c, 10))
+	}
+	return strings.Join(bounds, ",") + ";" +
+		strings.Join(counts, ",") + ";" +
+		strconv.FormatFloat(h.Sum, 'f', -1, 64)
+}
+
+// ParseHistogram Получает Histogram из строки вида "0.1,1;3,2,0;1.5".
+// Количество значений вычисляется по корзинам
+func ParseHistogram(value string) (Histogram, error) {
+	parts := strings.Split(value, ";")
+	if len(parts) != 3 {
+		return Histogram{}, fmt.Errorf("%w: histogram must be in form bounds;counts;sum", ErrorMetrics)
+	}
+	var h Histogram
+	if parts[0] != "" {
+		for _, s := range strings.Split(parts[0], ",") {
+			b, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return Histogram{}, fmt.Errorf("%w: %v", ErrorMetrics, err)
+			}
+			h.Bounds = append(h.Bounds, b)
+		}
+	}
+	for _, s := range strings.Split(parts[1], ",") {
+		c, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return Histogram{}, fmt.Errorf("%w: %v", ErrorMetrics, err)
+		}
+		h.Counts = append(h.Counts, c)
+		h.Count += c
+	}
+	sum, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return Histogram{}, fmt.Errorf("%w: %v", ErrorMetrics, err)
+	}
+	h.Sum = sum
+	return h, h.Check()
+}
+
+func equalBounds(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
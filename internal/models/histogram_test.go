@@ -0,0 +1,55 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	h, err := NewHistogram([]float64{0.1, 1})
+	require.NoError(t, err)
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.Observe(v)
+	}
+	assert.Equal(t, []uint64{2, 1, 1}, h.Counts)
+	assert.Equal(t, uint64(4), h.Count)
+	assert.InDelta(t, 3.65, h.Sum, 1e-9)
+	assert.Equal(t, "0.1,1;2,1,1;3.65", h.String())
+
+	m, err := NewMetric("latency", HistogramType, h.String())
+	require.NoError(t, err)
+	assert.Equal(t, h.Counts, m.Histogram.Counts)
+	assert.Equal(t, h.Count, m.Histogram.Count)
+	v, err := m.GetValue()
+	assert.NoError(t, err)
+	assert.Equal(t, h.String(), v)
+	_, err = m.GetFloat()
+	assert.Error(t, err)
+
+	merged, ok := h.Merge(*m.Histogram)
+	assert.True(t, ok)
+	assert.Equal(t, []uint64{4, 2, 2}, merged.Counts)
+	assert.Equal(t, []uint64{2, 1, 1}, h.Counts)
+	_, ok = h.Merge(Histogram{Bounds: []float64{1}, Counts: []uint64{0, 0}})
+	assert.False(t, ok)
+}
+
+func TestParseHistogram(t *testing.T) {
+	h, err := ParseHistogram(";5;2.5")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(5), h.Count)
+	for _, value := range []string{
+		"1,2;1,1;0.5",
+		"2,1;1,1,1;0.5",
+		"1;1,x;0.5",
+		"1;1,1",
+		"NaN;1,1;0",
+	} {
+		_, err := ParseHistogram(value)
+		assert.ErrorIs(t, err, ErrorMetrics, value)
+	}
+	_, err = NewHistogram([]float64{1, 1})
+	assert.Error(t, err)
+}
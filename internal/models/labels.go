@@ -0,0 +1,204 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Операции сравнения меток
+const (
+	MatchEqual     = "="
+	MatchNotEqual  = "!="
+	MatchRegexp    = "=~"
+	MatchNotRegexp = "!~"
+)
+
+// Ошибки при работе с метками
+var (
+	ErrorLabels  = errors.New("invalid labels")
+	ErrorMatcher = errors.New("invalid label matcher")
+)
+
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// CheckLabels Проверяет, что имена меток имеют вид [a-zA-Z_][a-zA-Z0-9_]*
+func CheckLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelName.MatchString(name) {
+			return fmt.Errorf("%w: %q", ErrorLabels, name)
+		}
+	}
+	return nil
+}
+
+// LabelsString Возвращает метки в каноническом виде {a="1",b="2"}, для пустого набора - пустую строку
+func LabelsString(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// seriesChars - Символы записи меток, которые не допускаются в имени метрики
+const seriesChars = `{}="`
+
+// CheckID Проверяет, что имя метрики не содержит символов записи меток {}=",
+// иначе имя foo{a="b"} без меток совпало бы в Series с метрикой foo с меткой a="b"
+func CheckID(id string) error {
+	if strings.ContainsAny(id, seriesChars) {
+		return fmt.Errorf("%w: id %q contains one of %s", ErrorMetrics, id, seriesChars)
+	}
+	return nil
+}
+
+// Series Возвращает имя серии - имя метрики вместе с метками
+func (m Metric) Series() string {
+	return m.ID + LabelsString(m.Labels)
+}
+
+// Matcher - Условие на значение метки
+type Matcher struct {
+	Name  string `json:"name"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+	re    *regexp.Regexp
+}
+
+// NewMatcher Конструктор Matcher. Для регулярных выражений значение должно совпадать полностью
+func NewMatcher(name, op, value string) (Matcher, error) {
+	m := Matcher{
+		Name:  name,
+		Op:    op,
+		Value: value,
+	}
+	switch op {
+	case MatchEqual, MatchNotEqual:
+	case MatchRegexp, MatchNotRegexp:
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return Matcher{}, fmt.Errorf("%w: %v", ErrorMatcher, err)
+		}
+		m.re = re
+	default:
+		return Matcher{}, fmt.Errorf("%w: unknown operation %q", ErrorMatcher, op)
+	}
+	return m, nil
+}
+
+// ParseMatcher Разбирает условие вида host="a", host!=a, host=~"web.*" или host!~web.*
+func ParseMatcher(text string) (Matcher, error) {
+	i := strings.IndexAny(text, "=!")
+	if i <= 0 {
+		return Matcher{}, fmt.Errorf("%w: %q", ErrorMatcher, text)
+	}
+	name := strings.TrimSpace(text[:i])
+	rest := text[i:]
+	var op string
+	for _, o := range []string{MatchRegexp, MatchNotRegexp, MatchNotEqual, MatchEqual} {
+		if strings.HasPrefix(rest, o) {
+			op = o
+			break
+		}
+	}
+	if op == "" {
+		return Matcher{}, fmt.Errorf("%w: %q", ErrorMatcher, text)
+	}
+	value := strings.TrimSpace(rest[len(op):])
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+	return NewMatcher(name, op, value)
+}
+
+// Match Проверяет значение метки. Отсутствующая метка считается пустой
+func (m Matcher) Match(labels map[string]string) bool {
+	v := labels[m.Name]
+	switch m.Op {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re != nil && m.re.MatchString(v)
+	case MatchNotRegexp:
+		return m.re != nil && !m.re.MatchString(v)
+	}
+	return false
+}
+
+func (m Matcher) String() string {
+	return m.Name + m.Op + strconv.Quote(m.Value)
+}
+
+// MatchLabels Проверяет, что метки подходят под все условия
+func MatchLabels(labels map[string]string, matchers ...Matcher) bool {
+	for _, m := range matchers {
+		if !m.Match(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// EqualMatchers Создаёт условия на точное совпадение для каждой метки
+func EqualMatchers(labels map[string]string) []Matcher {
+	matchers := make([]Matcher, 0, len(labels))
+	for name, value := range labels {
+		matchers = append(matchers, Matcher{
+			Name:  name,
+			Op:    MatchEqual,
+			Value: value,
+		})
+	}
+	return matchers
+}
+
+// Filter Возвращает метрики, метки которых подходят под все условия
+func (ms Metrics) Filter(matchers ...Matcher) Metrics {
+	if len(matchers) == 0 {
+		return ms
+	}
+	result := make(Metrics, 0, len(ms))
+	for _, m := range ms {
+		if MatchLabels(m.Labels, matchers...) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// Pick Выбирает серию с наименьшим количеством меток, при равенстве - первую по имени серии.
+// Используется, когда под условия подходит несколько серий одной метрики
+func (ms Metrics) Pick() (Metric, bool) {
+	if len(ms) == 0 {
+		return Metric{}, false
+	}
+	best := ms[0]
+	for _, m := range ms[1:] {
+		if len(m.Labels) < len(best.Labels) ||
+			len(m.Labels) == len(best.Labels) && m.Series() < best.Series() {
+			best = m
+		}
+	}
+	return best, true
+}
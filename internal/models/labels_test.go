@@ -0,0 +1,76 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelsString(t *testing.T) {
+	assert.Equal(t, "", LabelsString(nil))
+	assert.Equal(t, `{a="1",b="x \"y\""}`, LabelsString(map[string]string{"b": `x "y"`, "a": "1"}))
+	m := Metric{ID: "cpu", Labels: map[string]string{"host": "a"}}
+	assert.Equal(t, `cpu{host="a"}`, m.Series())
+}
+
+func TestCheckLabels(t *testing.T) {
+	assert.NoError(t, CheckLabels(map[string]string{"host": "a", "_svc2": "b"}))
+	assert.ErrorIs(t, CheckLabels(map[string]string{"2host": "a"}), ErrorLabels)
+	assert.ErrorIs(t, CheckLabels(map[string]string{"host-name": "a"}), ErrorLabels)
+}
+
+func TestCheckID(t *testing.T) {
+	assert.NoError(t, CheckID("http.requests-total:5m"))
+	for _, id := range []string{`foo{a="b"}`, "foo{", "foo}", "a=b", `a"b`} {
+		assert.ErrorIs(t, CheckID(id), ErrorMetrics, id)
+	}
+	// имя с записью меток не совпадает в Series с серией foo{a="b"}
+	g := Gauge(1)
+	spoofed := Metric{ID: `foo{a="b"}`, MType: GaugeType, Value: &g}
+	assert.ErrorIs(t, spoofed.Validate(), ErrorMetrics)
+}
+
+func TestMatcher(t *testing.T) {
+	labels := map[string]string{"host": "web-1", "env": "prod"}
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{`host="web-1"`, true},
+		{`host=web-2`, false},
+		{`host!=web-2`, true},
+		{`host=~"web-.*"`, true},
+		{`host=~web`, false},
+		{`env!~"dev|test"`, true},
+		{`dc=""`, true},
+		{`dc!=""`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			m, err := ParseMatcher(test.text)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, m.Match(labels))
+		})
+	}
+	for _, text := range []string{"host", "=a", "host<a", `host=~"("`} {
+		_, err := ParseMatcher(text)
+		assert.ErrorIs(t, err, ErrorMatcher, text)
+	}
+}
+
+func TestFilterPick(t *testing.T) {
+	ms := Metrics{
+		{ID: "cpu", Labels: map[string]string{"host": "b", "core": "0"}},
+		{ID: "cpu", Labels: map[string]string{"host": "b"}},
+		{ID: "cpu", Labels: map[string]string{"host": "a"}},
+	}
+	m, ok := ms.Pick()
+	assert.True(t, ok)
+	assert.Equal(t, ms[2], m)
+	m, ok = ms.Filter(EqualMatchers(map[string]string{"host": "b"})...).Pick()
+	assert.True(t, ok)
+	assert.Equal(t, ms[1], m)
+	_, ok = ms.Filter(EqualMatchers(map[string]string{"host": "c"})...).Pick()
+	assert.False(t, ok)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type (
@@ -15,10 +16,20 @@ type (
 
 // Типы метрик
 const (
-	GaugeType   = `gauge`
-	CounterType = `counter`
+	GaugeType     = `gauge`
+	CounterType   = `counter`
+	HistogramType = `histogram`
 )
 
+// Способы передачи counter. Пустой способ означает приращение
+const (
+	DeltaKind      = `delta`      // значение - приращение счётчика с прошлой отправки
+	CumulativeKind = `cumulative` // значение - накопленное значение счётчика у источника
+)
+
+// KindParam - Параметр запроса REST со способом передачи counter, он не считается меткой
+const KindParam = `kind`
+
 // Ошибки возникающие при обработке метрик
 var (
 	ErrorMetrics = errors.New("invalid metrics")
@@ -50,12 +61,25 @@ type Metrics []Metric
 
 // Metric - Структура для хранения метрики
 type Metric struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *Counter `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *Gauge   `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID     string            `json:"id"`               // имя метрики
+	MType  string            `json:"type"`             // параметр, принимающий значение gauge или counter
+	Delta  *Counter          `json:"delta,omitempty"`  // значение метрики в случае передачи counter
+	Kind   string            `json:"kind,omitempty"`   // способ передачи counter: delta или cumulative
+	Value  *Gauge            `json:"value,omitempty"`  // значение метрики в случае передачи gauge
+	Labels map[string]string `json:"labels,omitempty"` // метки серии, например host или service
+	// значение метрики в случае передачи histogram
+	Histogram *Histogram `json:"histogram,omitempty"`
 }
 
+// Sample - Значение метрики в момент времени
+type Sample struct {
+	Metric
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Samples - История значений метрики
+type Samples []Sample
+
 // NewMetric - Конструктор метрики, сам конвертирует строку в значение на основе типа
 func NewMetric(id, mtype, value string) (Metric, error) {
 	m := &Metric{
@@ -72,25 +96,62 @@ func (m *Metric) SetValue(value string) error {
 	case CounterType:
 		v, err := ParseCounter(value)
 		if err != nil {
-			return fmt.Errorf("%v: %v", ErrorMetrics, err)
+			return fmt.Errorf("%w: %v", ErrorMetrics, err)
 		}
 		m.Delta = &v
 		m.Value = nil
+		m.Histogram = nil
 	case GaugeType:
 		v, err := ParseGauge(value)
 		if err != nil {
-			return fmt.Errorf("%v: %v", ErrorMetrics, err)
+			return fmt.Errorf("%w: %v", ErrorMetrics, err)
 		}
 		m.Value = &v
 		m.Delta = nil
+		m.Histogram = nil
+	case HistogramType:
+		h, err := ParseHistogram(value)
+		if err != nil {
+			return err
+		}
+		m.Histogram = &h
+		m.Delta = nil
+		m.Value = nil
 	default:
-		return fmt.Errorf("%v: %v", ErrorType, m)
+		return fmt.Errorf("%w: %v", ErrorType, m)
 	}
 	return nil
 }
 
+// IsCumulative Сообщает, передано ли накопленное значение counter вместо приращения
+func (m Metric) IsCumulative() bool {
+	return m.Kind == CumulativeKind
+}
+
+// checkKind Проверяет способ передачи: он задаётся только для counter,
+// накопленное значение не может быть отрицательным
+func (m Metric) checkKind() error {
+	switch m.Kind {
+	case "":
+		return nil
+	case DeltaKind, CumulativeKind:
+		if m.MType != CounterType {
+			return fmt.Errorf("%w: kind %v for %v", ErrorMetrics, m.Kind, m.MType)
+		}
+		if m.IsCumulative() && m.Delta != nil && *m.Delta < 0 {
+			return fmt.Errorf("%w: negative cumulative counter", ErrorMetrics)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unknown kind %v", ErrorMetrics, m.Kind)
+}
+
 // GetValue() Возвращает значение метрики в виде строки
 func (m Metric) GetValue() (string, error) {
+	err := m.checkKind()
+	if err != nil {
+		return "", err
+	}
 	switch m.MType {
 	case CounterType:
 		if m.Delta == nil {
@@ -102,6 +163,32 @@ func (m Metric) GetValue() (string, error) {
 			return "", ErrorMetrics
 		}
 		return m.Value.String(), nil
+	case HistogramType:
+		if m.Histogram == nil {
+			return "", ErrorMetrics
+		}
+		err := m.Histogram.Check()
+		if err != nil {
+			return "", err
+		}
+		return m.Histogram.String(), nil
+	}
+	return "", fmt.Errorf("%w: %v", ErrorType, m.MType)
+}
+
+// GetFloat() Возвращает значение метрики в виде числа. Для histogram единственного значения нет
+func (m Metric) GetFloat() (float64, error) {
+	switch m.MType {
+	case CounterType:
+		if m.Delta == nil {
+			return 0, ErrorMetrics
+		}
+		return float64(*m.Delta), nil
+	case GaugeType:
+		if m.Value == nil {
+			return 0, ErrorMetrics
+		}
+		return float64(*m.Value), nil
 	}
-	return "", fmt.Errorf("%v: %v", ErrorType, m.MType)
+	return 0, fmt.Errorf("%w: %v", ErrorType, m.MType)
 }
@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// Rollup - Агрегат значений серии за интервал [Start, Start+Resolution).
+//
+// Для gauge используются Min, Max, Sum (среднее - Avg) и Last,
+// для counter - Increase, прирост за интервал с учётом сбросов счётчика, и Last
+type Rollup struct {
+	Metric
+	Start      time.Time     `json:"start"`
+	Resolution time.Duration `json:"resolution"`
+	Count      uint64        `json:"count"` // количество исходных значений
+	Min        float64       `json:"min"`
+	Max        float64       `json:"max"`
+	Sum        float64       `json:"sum"`
+	Last       float64       `json:"last"`
+	Increase   float64       `json:"increase"`
+}
+
+// Avg Возвращает среднее значение за интервал
+func (r Rollup) Avg() float64 {
+	if r.Count == 0 {
+		return 0
+	}
+	return r.Sum / float64(r.Count)
+}
+
+// Rollups - Агрегаты серии, упорядоченные по времени
+type Rollups []Rollup
@@ -0,0 +1,113 @@
+package query
+
+import "math"
+
+// increase Возвращает прирост counter за период. Уменьшение значения считается сбросом счётчика
+func increase(points []point) (float64, bool) {
+	if len(points) < 2 {
+		return 0, false
+	}
+	var inc float64
+	for i := 1; i < len(points); i++ {
+		d := points[i].v - points[i-1].v
+		if d < 0 {
+			// после сброса счётчик начинает отсчёт с нуля
+			d = points[i].v
+		}
+		inc += d
+	}
+	return inc, true
+}
+
+// rate Возвращает средний прирост counter в секунду между первым и последним значением периода
+func rate(points []point) (float64, bool) {
+	inc, ok := increase(points)
+	if !ok {
+		return 0, false
+	}
+	seconds := points[len(points)-1].t.Sub(points[0].t).Seconds()
+	if seconds <= 0 {
+		return 0, false
+	}
+	return inc / seconds, true
+}
+
+func avgOverTime(points []point) (float64, bool) {
+	if len(points) == 0 {
+		return 0, false
+	}
+	s, _ := sumOverTime(points)
+	return s / float64(len(points)), true
+}
+
+func minOverTime(points []point) (float64, bool) {
+	if len(points) == 0 {
+		return 0, false
+	}
+	v := math.Inf(1)
+	for _, p := range points {
+		v = math.Min(v, p.v)
+	}
+	return v, true
+}
+
+func maxOverTime(points []point) (float64, bool) {
+	if len(points) == 0 {
+		return 0, false
+	}
+	v := math.Inf(-1)
+	for _, p := range points {
+		v = math.Max(v, p.v)
+	}
+	return v, true
+}
+
+func sumOverTime(points []point) (float64, bool) {
+	if len(points) == 0 {
+		return 0, false
+	}
+	var s float64
+	for _, p := range points {
+		s += p.v
+	}
+	return s, true
+}
+
+func countOverTime(points []point) (float64, bool) {
+	if len(points) == 0 {
+		return 0, false
+	}
+	return float64(len(points)), true
+}
+
+func aggSum(values []float64) float64 {
+	var s float64
+	for _, v := range values {
+		s += v
+	}
+	return s
+}
+
+func aggAvg(values []float64) float64 {
+	return aggSum(values) / float64(len(values))
+}
+
+func aggMin(values []float64) float64 {
+	v := math.Inf(1)
+	for _, x := range values {
+		v = math.Min(v, x)
+	}
+	return v
+}
+
+func aggMax(values []float64) float64 {
+	v := math.Inf(-1)
+	for _, x := range values {
+		v = math.Max(v, x)
+	}
+	return v
+}
+
+func aggCount(values []float64) float64 {
+	return float64(len(values))
+}
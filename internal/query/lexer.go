@@ -0,0 +1,127 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokDuration // содержимое [...] у выборки за период
+	tokLParen
+	tokRParen
+	tokLBrace
+	tokRBrace
+	tokComma
+	tokOp    // + - * /
+	tokMatch // = != =~ !~
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of query"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// lex Разбивает запрос на лексемы
+func lex(s string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isIdentStart(c):
+			start := i
+			for i < len(s) && isIdentChar(s[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, s[start:i], start})
+		case c >= '0' && c <= '9' || c == '.':
+			start := i
+			for i < len(s) && (isIdentChar(s[i]) || s[i] == '.' ||
+				(s[i] == '+' || s[i] == '-') && (s[i-1] == 'e' || s[i-1] == 'E')) {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, s[start:i], start})
+		case c == '"' || c == '\'':
+			start := i
+			i++
+			var b strings.Builder
+			for i < len(s) && s[i] != c {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+				i++
+			}
+			if i >= len(s) {
+				return nil, fmt.Errorf("%w: unterminated string at %d", ErrInvalidQuery, start)
+			}
+			i++
+			tokens = append(tokens, token{tokString, b.String(), start})
+		case c == '[':
+			start := i
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unterminated range at %d", ErrInvalidQuery, start)
+			}
+			tokens = append(tokens, token{tokDuration, strings.TrimSpace(s[i+1 : i+end]), start})
+			i += end + 1
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")", i})
+			i++
+		case c == '{':
+			tokens = append(tokens, token{tokLBrace, "{", i})
+			i++
+		case c == '}':
+			tokens = append(tokens, token{tokRBrace, "}", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokComma, ",", i})
+			i++
+		case c == '+' || c == '-' || c == '*' || c == '/':
+			tokens = append(tokens, token{tokOp, string(c), i})
+			i++
+		case c == '=' || c == '!':
+			op := string(c)
+			if i+1 < len(s) && (s[i+1] == '=' || s[i+1] == '~') {
+				op += string(s[i+1])
+			}
+			if op == "!" || op == "==" {
+				return nil, fmt.Errorf("%w: unexpected %q at %d", ErrInvalidQuery, op, i)
+			}
+			tokens = append(tokens, token{tokMatch, op, i})
+			i += len(op)
+		default:
+			return nil, fmt.Errorf("%w: unexpected %q at %d", ErrInvalidQuery, c, i)
+		}
+	}
+	tokens = append(tokens, token{tokEOF, "", len(s)})
+	return tokens, nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == ':' || c < unicode.MaxASCII && unicode.IsLetter(rune(c))
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
@@ -0,0 +1,398 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+// Expr - Узел разобранного выражения
+type Expr interface {
+	String() string
+}
+
+// NumberExpr - Числовая константа
+type NumberExpr struct {
+	Value float64
+}
+
+// SelectorExpr - Выборка серий метрики по имени и условиям на метки.
+// Если Range не нулевой, выбираются значения за период, такая выборка допустима только как аргумент функции
+type SelectorExpr struct {
+	Name     string
+	Matchers []models.Matcher
+	Range    time.Duration
+}
+
+// CallExpr - Вызов функции над выборкой за период, например rate(requests[5m])
+type CallExpr struct {
+	Func string
+	Arg  *SelectorExpr
+}
+
+// AggregateExpr - Агрегация серий, например sum by (host) (requests)
+type AggregateExpr struct {
+	Op   string
+	By   []string
+	Expr Expr
+}
+
+// BinaryExpr - Арифметика между сериями и числами
+type BinaryExpr struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+// rangeFuncs - Функции над выборкой за период
+var rangeFuncs = map[string]func(points []point) (float64, bool){
+	"rate":            rate,
+	"increase":        increase,
+	"avg_over_time":   avgOverTime,
+	"min_over_time":   minOverTime,
+	"max_over_time":   maxOverTime,
+	"sum_over_time":   sumOverTime,
+	"count_over_time": countOverTime,
+}
+
+// aggregations - Функции агрегации серий
+var aggregations = map[string]func(values []float64) float64{
+	"sum":   aggSum,
+	"avg":   aggAvg,
+	"min":   aggMin,
+	"max":   aggMax,
+	"count": aggCount,
+}
+
+// Parse Разбирает выражение запроса
+func Parse(s string) (Expr, error) {
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.unexpected(t)
+	}
+	err = checkInstant(expr)
+	if err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, p.unexpected(t)
+	}
+	return t, nil
+}
+
+func (p *parser) unexpected(t token) error {
+	return fmt.Errorf("%w: unexpected %s at %d", ErrInvalidQuery, t, t.pos)
+}
+
+// parseExpr Разбирает сложение и вычитание
+func (p *parser) parseExpr() (Expr, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == tokOp && (t.text == "+" || t.text == "-"); t = p.peek() {
+		p.next()
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left, err = binary(t.text, left, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+// parseTerm Разбирает умножение и деление
+func (p *parser) parseTerm() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.kind == tokOp && (t.text == "*" || t.text == "/"); t = p.peek() {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left, err = binary(t.text, left, right)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	t := p.peek()
+	if t.kind == tokOp && (t.text == "-" || t.text == "+") {
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if t.text == "+" {
+			return expr, nil
+		}
+		return binary("*", &NumberExpr{Value: -1}, expr)
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid number %q at %d", ErrInvalidQuery, t.text, t.pos)
+		}
+		return &NumberExpr{Value: v}, nil
+	case tokLParen:
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(tokRParen)
+		return expr, err
+	case tokIdent:
+		if _, ok := aggregations[t.text]; ok && (p.peek().kind == tokLParen || isBy(p.peek())) {
+			return p.parseAggregate(t.text)
+		}
+		if _, ok := rangeFuncs[t.text]; ok && p.peek().kind == tokLParen {
+			return p.parseCall(t.text)
+		}
+		if p.peek().kind == tokLParen {
+			return nil, fmt.Errorf("%w: unknown function %q at %d", ErrInvalidQuery, t.text, t.pos)
+		}
+		return p.parseSelector(t.text)
+	}
+	return nil, p.unexpected(t)
+}
+
+// parseSelector Разбирает name{label="value"}[5m], имя уже прочитано
+func (p *parser) parseSelector(name string) (*SelectorExpr, error) {
+	sel := &SelectorExpr{Name: name}
+	if p.peek().kind == tokLBrace {
+		p.next()
+		for p.peek().kind != tokRBrace {
+			label, err := p.expect(tokIdent)
+			if err != nil {
+				return nil, err
+			}
+			op, err := p.expect(tokMatch)
+			if err != nil {
+				return nil, err
+			}
+			value, err := p.expect(tokString)
+			if err != nil {
+				return nil, err
+			}
+			m, err := models.NewMatcher(label.text, op.text, value.text)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+			}
+			sel.Matchers = append(sel.Matchers, m)
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+		_, err := p.expect(tokRBrace)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if t := p.peek(); t.kind == tokDuration {
+		p.next()
+		d, err := time.ParseDuration(t.text)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%w: invalid range %q at %d", ErrInvalidQuery, t.text, t.pos)
+		}
+		sel.Range = d
+	}
+	return sel, nil
+}
+
+// parseCall Разбирает func(selector[range]), имя функции уже прочитано
+func (p *parser) parseCall(name string) (Expr, error) {
+	_, err := p.expect(tokLParen)
+	if err != nil {
+		return nil, err
+	}
+	t, err := p.expect(tokIdent)
+	if err != nil {
+		return nil, err
+	}
+	sel, err := p.parseSelector(t.text)
+	if err != nil {
+		return nil, err
+	}
+	if sel.Range == 0 {
+		return nil, fmt.Errorf("%w: %s expects a range selector like %s[5m]", ErrInvalidQuery, name, sel)
+	}
+	_, err = p.expect(tokRParen)
+	if err != nil {
+		return nil, err
+	}
+	return &CallExpr{Func: name, Arg: sel}, nil
+}
+
+// parseAggregate Разбирает op by (labels) (expr) или op (expr) by (labels), имя агрегации уже прочитано
+func (p *parser) parseAggregate(op string) (Expr, error) {
+	agg := &AggregateExpr{Op: op}
+	var err error
+	if isBy(p.peek()) {
+		p.next()
+		agg.By, err = p.parseLabels()
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = p.expect(tokLParen)
+	if err != nil {
+		return nil, err
+	}
+	agg.Expr, err = p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.expect(tokRParen)
+	if err != nil {
+		return nil, err
+	}
+	if isBy(p.peek()) {
+		if agg.By != nil {
+			return nil, p.unexpected(p.peek())
+		}
+		p.next()
+		agg.By, err = p.parseLabels()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := agg.Expr.(*NumberExpr); ok {
+		return nil, fmt.Errorf("%w: %s expects series, got number", ErrInvalidQuery, op)
+	}
+	err = checkInstant(agg.Expr)
+	if err != nil {
+		return nil, err
+	}
+	return agg, nil
+}
+
+func isBy(t token) bool {
+	return t.kind == tokIdent && t.text == "by"
+}
+
+// parseLabels Разбирает список меток (a, b)
+func (p *parser) parseLabels() ([]string, error) {
+	_, err := p.expect(tokLParen)
+	if err != nil {
+		return nil, err
+	}
+	labels := []string{}
+	for p.peek().kind != tokRParen {
+		t, err := p.expect(tokIdent)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, t.text)
+		if p.peek().kind != tokComma {
+			break
+		}
+		p.next()
+	}
+	_, err = p.expect(tokRParen)
+	return labels, err
+}
+
+// binary Создаёт арифметическое выражение
+func binary(op string, left, right Expr) (Expr, error) {
+	for _, e := range []Expr{left, right} {
+		err := checkInstant(e)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &BinaryExpr{Op: op, Left: left, Right: right}, nil
+}
+
+// checkInstant Проверяет, что выражение не является выборкой за период.
+// Такая выборка допустима только как аргумент функции
+func checkInstant(e Expr) error {
+	if sel, ok := e.(*SelectorExpr); ok && sel.Range != 0 {
+		return fmt.Errorf("%w: range selector %s must be used in a function", ErrInvalidQuery, sel)
+	}
+	return nil
+}
+
+func (e *NumberExpr) String() string {
+	return strconv.FormatFloat(e.Value, 'f', -1, 64)
+}
+
+func (e *SelectorExpr) String() string {
+	var b strings.Builder
+	b.WriteString(e.Name)
+	if len(e.Matchers) != 0 {
+		matchers := make([]string, 0, len(e.Matchers))
+		for _, m := range e.Matchers {
+			matchers = append(matchers, m.String())
+		}
+		b.WriteString("{" + strings.Join(matchers, ",") + "}")
+	}
+	if e.Range != 0 {
+		b.WriteString("[" + e.Range.String() + "]")
+	}
+	return b.String()
+}
+
+func (e *CallExpr) String() string {
+	return e.Func + "(" + e.Arg.String() + ")"
+}
+
+func (e *AggregateExpr) String() string {
+	s := e.Op
+	if e.By != nil {
+		s += " by (" + strings.Join(e.By, ", ") + ")"
+	}
+	return s + " (" + e.Expr.String() + ")"
+}
+
+func (e *BinaryExpr) String() string {
+	return "(" + e.Left.String() + " " + e.Op + " " + e.Right.String() + ")"
+}
@@ -0,0 +1,370 @@
+// query выполняет запросы к сохранённым метрикам на небольшом языке выражений.
+//
+// Выражение строится из выборок серий name{label="value"}, функций над выборкой за период
+// (rate(name[5m]), avg_over_time(name[1h]) и другие), агрегаций (sum by (label) (expr))
+// и арифметики + - * / между сериями и числами
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage"
+)
+
+// ErrInvalidQuery - Запрос не удалось разобрать или его части несовместимы
+var ErrInvalidQuery = errors.New("invalid query")
+
+// LookBack - Насколько далеко в прошлом искать значение серии при запросе на заданный момент
+var LookBack = 5 * time.Minute
+
+// Типы результата запроса
+const (
+	ResultScalar = "scalar"
+	ResultVector = "vector"
+)
+
+// Storage - Хранилище, к которому выполняются запросы
+type Storage interface {
+	storage.Getter
+	storage.RangeGetter
+}
+
+// Series - Значение серии в результате запроса.
+// После функций, агрегаций и арифметики имя и тип метрики не сохраняются
+type Series struct {
+	Name   string            `json:"name,omitempty"`
+	MType  string            `json:"type,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// Result - Результат запроса: число или набор серий
+type Result struct {
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Scalar *float64  `json:"scalar,omitempty"`
+	Series []Series  `json:"series,omitempty"`
+}
+
+// Engine Выполняет запросы к хранилищу
+type Engine struct {
+	storage Storage
+}
+
+// New Конструктор Engine
+func New(s Storage) *Engine {
+	return &Engine{
+		storage: s,
+	}
+}
+
+// Query Разбирает и выполняет запрос на момент at.
+// Если at нулевой, выборки берут текущие значения метрик
+func (e *Engine) Query(ctx context.Context, q string, at time.Time) (Result, error) {
+	expr, err := Parse(q)
+	if err != nil {
+		return Result{}, err
+	}
+	return e.Eval(ctx, expr, at)
+}
+
+// Eval Выполняет разобранный запрос на момент at
+func (e *Engine) Eval(ctx context.Context, expr Expr, at time.Time) (Result, error) {
+	ev := &evaluator{
+		ctx:     ctx,
+		storage: e.storage,
+		at:      at,
+		current: at.IsZero(),
+	}
+	if ev.current {
+		ev.at = time.Now()
+	}
+	v, err := ev.eval(expr)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{
+		Time: ev.at,
+	}
+	if v.scalar {
+		result.Type = ResultScalar
+		result.Scalar = &v.num
+		return result, nil
+	}
+	result.Type = ResultVector
+	result.Series = v.vec
+	sortSeries(result.Series)
+	return result, nil
+}
+
+// value - Промежуточное значение: число или набор серий
+type value struct {
+	scalar bool
+	num    float64
+	vec    []Series
+}
+
+// point - Значение серии в момент времени
+type point struct {
+	t time.Time
+	v float64
+}
+
+type evaluator struct {
+	ctx     context.Context
+	storage Storage
+	at      time.Time
+	current bool // брать текущие значения вместо истории
+}
+
+func (ev *evaluator) eval(expr Expr) (value, error) {
+	switch e := expr.(type) {
+	case *NumberExpr:
+		return value{scalar: true, num: e.Value}, nil
+	case *SelectorExpr:
+		vec, err := ev.instant(e)
+		return value{vec: vec}, err
+	case *CallExpr:
+		vec, err := ev.call(e)
+		return value{vec: vec}, err
+	case *AggregateExpr:
+		return ev.aggregate(e)
+	case *BinaryExpr:
+		return ev.binary(e)
+	}
+	return value{}, fmt.Errorf("%w: unsupported expression %s", ErrInvalidQuery, expr)
+}
+
+// series Находит серии метрики с числовыми значениями, подходящие под выборку
+func (ev *evaluator) series(sel *SelectorExpr) (models.Metrics, error) {
+	metrics, err := ev.storage.GetAll(ev.ctx, sel.Matchers...)
+	if err != nil {
+		return nil, err
+	}
+	result := make(models.Metrics, 0)
+	for _, m := range metrics {
+		if m.ID != sel.Name || m.MType != models.GaugeType && m.MType != models.CounterType {
+			continue
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+// points Возвращает значения серии в промежутке [from, at]
+func (ev *evaluator) points(m models.Metric, from time.Time) ([]point, error) {
+	samples, err := ev.storage.GetRange(ev.ctx, m.MType, m.ID, from, ev.at, models.EqualMatchers(m.Labels)...)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]point, 0, len(samples))
+	for _, s := range samples {
+		v, err := s.GetFloat()
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point{s.Timestamp, v})
+	}
+	return points, nil
+}
+
+// instant Возвращает значения серий выборки на момент запроса
+func (ev *evaluator) instant(sel *SelectorExpr) ([]Series, error) {
+	metrics, err := ev.series(sel)
+	if err != nil {
+		return nil, err
+	}
+	vec := make([]Series, 0, len(metrics))
+	for _, m := range metrics {
+		s := Series{
+			Name:   m.ID,
+			MType:  m.MType,
+			Labels: m.Labels,
+		}
+		if ev.current {
+			s.Value, err = m.GetFloat()
+			if err != nil {
+				return nil, err
+			}
+			vec = append(vec, s)
+			continue
+		}
+		points, err := ev.points(m, ev.at.Add(-LookBack))
+		if err != nil {
+			return nil, err
+		}
+		if len(points) == 0 {
+			continue
+		}
+		s.Value = points[len(points)-1].v
+		vec = append(vec, s)
+	}
+	return vec, nil
+}
+
+// call Применяет функцию к значениям каждой серии за период
+func (ev *evaluator) call(c *CallExpr) ([]Series, error) {
+	fn := rangeFuncs[c.Func]
+	metrics, err := ev.series(c.Arg)
+	if err != nil {
+		return nil, err
+	}
+	vec := make([]Series, 0, len(metrics))
+	for _, m := range metrics {
+		points, err := ev.points(m, ev.at.Add(-c.Arg.Range))
+		if err != nil {
+			return nil, err
+		}
+		v, ok := fn(points)
+		if !ok {
+			continue
+		}
+		vec = append(vec, Series{
+			Labels: m.Labels,
+			Value:  v,
+		})
+	}
+	return vec, nil
+}
+
+// aggregate Объединяет серии с одинаковыми значениями меток из By
+func (ev *evaluator) aggregate(a *AggregateExpr) (value, error) {
+	v, err := ev.eval(a.Expr)
+	if err != nil {
+		return value{}, err
+	}
+	if v.scalar {
+		return value{}, fmt.Errorf("%w: %s expects series, got number", ErrInvalidQuery, a.Op)
+	}
+	type group struct {
+		labels map[string]string
+		values []float64
+	}
+	groups := make(map[string]*group)
+	order := make([]string, 0)
+	for _, s := range v.vec {
+		var labels map[string]string
+		for _, name := range a.By {
+			if l, ok := s.Labels[name]; ok {
+				if labels == nil {
+					labels = make(map[string]string, len(a.By))
+				}
+				labels[name] = l
+			}
+		}
+		key := models.LabelsString(labels)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: labels}
+			groups[key] = g
+			order = append(order, key)
+		}
+		g.values = append(g.values, s.Value)
+	}
+	fn := aggregations[a.Op]
+	vec := make([]Series, 0, len(groups))
+	for _, key := range order {
+		g := groups[key]
+		vec = append(vec, Series{
+			Labels: g.labels,
+			Value:  fn(g.values),
+		})
+	}
+	return value{vec: vec}, nil
+}
+
+// binary Выполняет арифметику. Серии сопоставляются по одинаковому набору меток,
+// серии без пары и результаты деления на ноль в ответ не попадают
+func (ev *evaluator) binary(b *BinaryExpr) (value, error) {
+	left, err := ev.eval(b.Left)
+	if err != nil {
+		return value{}, err
+	}
+	right, err := ev.eval(b.Right)
+	if err != nil {
+		return value{}, err
+	}
+	switch {
+	case left.scalar && right.scalar:
+		v, ok := apply(b.Op, left.num, right.num)
+		if !ok {
+			return value{}, fmt.Errorf("%w: %s is not a finite number", ErrInvalidQuery, b)
+		}
+		return value{scalar: true, num: v}, nil
+	case right.scalar:
+		vec := make([]Series, 0, len(left.vec))
+		for _, s := range left.vec {
+			if v, ok := apply(b.Op, s.Value, right.num); ok {
+				vec = append(vec, Series{Labels: s.Labels, Value: v})
+			}
+		}
+		return value{vec: vec}, nil
+	case left.scalar:
+		vec := make([]Series, 0, len(right.vec))
+		for _, s := range right.vec {
+			if v, ok := apply(b.Op, left.num, s.Value); ok {
+				vec = append(vec, Series{Labels: s.Labels, Value: v})
+			}
+		}
+		return value{vec: vec}, nil
+	}
+	rights := make(map[string]Series, len(right.vec))
+	for _, s := range right.vec {
+		key := models.LabelsString(s.Labels)
+		if _, ok := rights[key]; ok {
+			return value{}, fmt.Errorf("%w: several series with labels %s in %s", ErrInvalidQuery, key, b.Right)
+		}
+		rights[key] = s
+	}
+	seen := make(map[string]struct{}, len(left.vec))
+	vec := make([]Series, 0, len(left.vec))
+	for _, s := range left.vec {
+		key := models.LabelsString(s.Labels)
+		if _, ok := seen[key]; ok {
+			return value{}, fmt.Errorf("%w: several series with labels %s in %s", ErrInvalidQuery, key, b.Left)
+		}
+		seen[key] = struct{}{}
+		r, ok := rights[key]
+		if !ok {
+			continue
+		}
+		if v, ok := apply(b.Op, s.Value, r.Value); ok {
+			vec = append(vec, Series{Labels: s.Labels, Value: v})
+		}
+	}
+	return value{vec: vec}, nil
+}
+
+// apply Выполняет арифметическую операцию, false - если результат не является конечным числом
+func apply(op string, a, b float64) (float64, bool) {
+	var v float64
+	switch op {
+	case "+":
+		v = a + b
+	case "-":
+		v = a - b
+	case "*":
+		v = a * b
+	case "/":
+		v = a / b
+	}
+	return v, !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+func sortSeries(vec []Series) {
+	sort.Slice(vec, func(i, j int) bool {
+		if vec[i].Name != vec[j].Name {
+			return vec[i].Name < vec[j].Name
+		}
+		if vec[i].MType != vec[j].MType {
+			return vec[i].MType < vec[j].MType
+		}
+		return models.LabelsString(vec[i].Labels) < models.LabelsString(vec[j].Labels)
+	})
+}
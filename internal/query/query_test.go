@@ -0,0 +1,197 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+// fakeStorage Хранит историю серий и отдаёт последнее значение как текущее
+type fakeStorage struct {
+	history map[string]models.Samples
+}
+
+func (f *fakeStorage) add(t *testing.T, id, mtype, value string, labels map[string]string, ts time.Time) {
+	m, err := models.NewMetric(id, mtype, value)
+	require.NoError(t, err)
+	m.Labels = labels
+	key := m.MType + "/" + m.Series()
+	f.history[key] = append(f.history[key], models.Sample{Metric: m, Timestamp: ts})
+}
+
+func (f *fakeStorage) Get(ctx context.Context, mtype, id string, matchers ...models.Matcher) (models.Metric, error) {
+	panic("not used")
+}
+
+func (f *fakeStorage) GetAll(ctx context.Context, matchers ...models.Matcher) (models.Metrics, error) {
+	var ms models.Metrics
+	for _, samples := range f.history {
+		ms = append(ms, samples[len(samples)-1].Metric)
+	}
+	return ms.Filter(matchers...), nil
+}
+
+func (f *fakeStorage) GetRange(ctx context.Context, mtype, id string, from, to time.Time, matchers ...models.Matcher) (models.Samples, error) {
+	var result models.Samples
+	for _, samples := range f.history {
+		m := samples[0].Metric
+		if m.MType != mtype || m.ID != id || !models.MatchLabels(m.Labels, matchers...) ||
+			len(m.Labels) != len(matchers) {
+			continue
+		}
+		for _, s := range samples {
+			if !s.Timestamp.Before(from) && !s.Timestamp.After(to) {
+				result = append(result, s)
+			}
+		}
+	}
+	return result, nil
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+		err   bool
+	}{
+		{query: "requests", want: "requests"},
+		{query: `requests{host="a", dc=~"eu.*"}`, want: `requests{host="a",dc=~"eu.*"}`},
+		{query: "rate(requests[5m])", want: "rate(requests[5m0s])"},
+		{query: "sum by (host) (rate(requests[1m]))", want: "sum by (host) (rate(requests[1m0s]))"},
+		{query: "sum(requests) by (host, dc)", want: "sum by (host, dc) (requests)"},
+		{query: "a + b * 2", want: "(a + (b * 2))"},
+		{query: "(a + b) / -2", want: "((a + b) / (-1 * 2))"},
+		{query: "max(cpu) - 1e3", want: "(max (cpu) - 1000)"},
+		{query: "requests[5m]", err: true},
+		{query: "sum(requests[5m])", err: true},
+		{query: "rate(requests)", err: true},
+		{query: "rate(requests[five])", err: true},
+		{query: "unknown(requests)", err: true},
+		{query: `requests{host="a"`, err: true},
+		{query: `requests{host=a}`, err: true},
+		{query: "a +", err: true},
+		{query: "sum(1)", err: true},
+		{query: "a b", err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			expr, err := Parse(test.query)
+			if test.err {
+				assert.ErrorIs(t, err, ErrInvalidQuery)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, expr.String())
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	now := time.Now()
+	s := &fakeStorage{history: make(map[string]models.Samples)}
+	hostA := map[string]string{"host": "a", "dc": "x"}
+	hostB := map[string]string{"host": "b", "dc": "x"}
+	// requests растёт на 10 в секунду, для host b счётчик сбрасывается
+	for i, v := range []string{"0", "100", "200"} {
+		s.add(t, "requests", models.CounterType, v, hostA, now.Add(time.Duration(i-2)*10*time.Second))
+	}
+	for i, v := range []string{"500", "50", "150"} {
+		s.add(t, "requests", models.CounterType, v, hostB, now.Add(time.Duration(i-2)*10*time.Second))
+	}
+	for i, v := range []string{"1", "2", "6"} {
+		s.add(t, "cpu", models.GaugeType, v, hostA, now.Add(time.Duration(i-2)*time.Minute))
+	}
+	s.add(t, "cpu", models.GaugeType, "4", hostB, now)
+	s.add(t, "cpu", models.CounterType, "100", nil, now)
+	s.add(t, "mem", models.GaugeType, "1", nil, now)
+	s.add(t, "mem", models.CounterType, "1", nil, now)
+	e := New(s)
+	ctx := context.Background()
+
+	series := func(value float64, labels map[string]string) Series {
+		return Series{Labels: labels, Value: value}
+	}
+	tests := []struct {
+		query  string
+		want   []Series
+		scalar float64
+	}{
+		{
+			query: `cpu{host="a"}`,
+			want:  []Series{{Name: "cpu", MType: models.GaugeType, Labels: hostA, Value: 6}},
+		},
+		{
+			query: "rate(requests[1m])",
+			want:  []Series{series(10, hostA), series(7.5, hostB)},
+		},
+		{
+			query: "increase(requests[15s])",
+			want:  []Series{series(100, hostA), series(100, hostB)},
+		},
+		{
+			query: `avg_over_time(cpu{host="a"}[5m])`,
+			want:  []Series{series(3, hostA)},
+		},
+		{
+			query: `max_over_time(cpu{host="a"}[90s])`,
+			want:  []Series{series(6, hostA)},
+		},
+		{
+			query: `max(cpu{dc="x"})`,
+			want:  []Series{series(6, nil)},
+		},
+		{
+			query: `sum by (dc) (requests)`,
+			want:  []Series{series(350, map[string]string{"dc": "x"})},
+		},
+		{
+			query: `count(cpu)`,
+			want:  []Series{series(3, nil)},
+		},
+		{
+			query: `requests / cpu{dc="x"} * 2`,
+			want:  []Series{series(200.0/6*2, hostA), series(75, hostB)},
+		},
+		{
+			query: `cpu{host="b"} / 0`,
+			want:  []Series{},
+		},
+		{
+			query:  "(1 + 2) * 4",
+			scalar: 12,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			r, err := e.Query(ctx, test.query, time.Time{})
+			require.NoError(t, err)
+			if test.want == nil {
+				assert.Equal(t, ResultScalar, r.Type)
+				require.NotNil(t, r.Scalar)
+				assert.Equal(t, test.scalar, *r.Scalar)
+				return
+			}
+			assert.Equal(t, ResultVector, r.Type)
+			if len(test.want) == 0 {
+				assert.Empty(t, r.Series)
+				return
+			}
+			assert.Equal(t, test.want, r.Series)
+		})
+	}
+
+	r, err := e.Query(ctx, `cpu{host="a"}`, now.Add(-90*time.Second))
+	require.NoError(t, err)
+	require.Len(t, r.Series, 1)
+	assert.Equal(t, 1.0, r.Series[0].Value)
+
+	_, err = e.Query(ctx, "1 / 0", time.Time{})
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+	_, err = e.Query(ctx, "mem + mem", time.Time{})
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Nexadis/metalert/internal/agent/client"
+	"github.com/Nexadis/metalert/internal/auth"
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage/mem"
+)
+
+func authConfig() *Config {
+	c := NewConfig()
+	c.APIKeys = []auth.Key{
+		{Name: "reader", Hash: auth.HashToken("reader"), Scopes: []auth.Scope{auth.Read}},
+		{Name: "agent", Hash: auth.HashToken("agent"), Scopes: []auth.Scope{auth.Write}, Prefixes: []string{"host1."}},
+		{Name: "dashboard", Hash: auth.HashToken("dashboard"), Scopes: []auth.Scope{auth.Read}, Prefixes: []string{"host1."}},
+		{Name: "admin", Hash: auth.HashToken("admin"), Scopes: []auth.Scope{auth.Admin}},
+	}
+	return c
+}
+
+func TestAuth(t *testing.T) {
+	server, err := NewHTTPServer(authConfig(), mem.NewMetricsStorage(), nil, nil)
+	require.NoError(t, err)
+	do := func(method, target, token, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		server.router.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodPost, "/update/gauge/host1.cpu/1", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, do(http.MethodGet, "/value/", "unknown", "").Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/update/gauge/host1.cpu/1", "reader", "").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/update/gauge/host1.cpu/1", "agent", "").Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/update/gauge/host2.cpu/1", "agent", "").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/update/gauge/host2.cpu/2", "admin", "").Code)
+
+	// метрики чужого префикса отклоняются по отдельности
+	w := do(http.MethodPost, "/updates/?partial=true", "agent",
+		`[{"id":"host1.mem","type":"gauge","value":1},{"id":"host2.mem","type":"gauge","value":1}]`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var result models.BatchResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, 1, result.Accepted)
+	require.Len(t, result.Errors, 1)
+	assert.Equal(t, models.CodeForbidden, result.Errors[0].Code)
+	assert.Equal(t, 1, result.Errors[0].Index)
+
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/value/", "agent", "").Code)
+	w = do(http.MethodGet, "/value/", "dashboard", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "host1.cpu")
+	assert.NotContains(t, w.Body.String(), "host2.cpu")
+	assert.Contains(t, do(http.MethodGet, "/value/", "reader", "").Body.String(), "host2.cpu")
+	assert.Equal(t, http.StatusForbidden, do(http.MethodGet, "/value/gauge/host2.cpu", "dashboard", "").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/value/gauge/host1.cpu", "dashboard", "").Code)
+
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/api/v1/query", "dashboard", `{"query":"host1.cpu"}`).Code)
+	assert.NotEqual(t, http.StatusForbidden, do(http.MethodPost, "/api/v1/query", "reader", `{"query":"host1.cpu"}`).Code)
+
+	// панель и проверка базы доступны без ключа
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/", "", "").Code)
+	assert.NotEqual(t, http.StatusUnauthorized, do(http.MethodGet, "/ping", "", "").Code)
+}
+
+func TestGRPCAuth(t *testing.T) {
+	s := mem.NewMetricsStorage()
+	gs, err := NewGRPCServer(authConfig(), s, nil, nil)
+	require.NoError(t, err)
+	addr := listenGRPC(t, gs)
+	ctx := context.TODO()
+
+	anonymous := client.NewGRPC(addr)
+	t.Cleanup(func() { anonymous.Close() })
+	_, err = anonymous.Get(ctx)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	agent := client.NewGRPC(addr, client.SetAPIKey("agent"))
+	t.Cleanup(func() { agent.Close() })
+	ms := models.Metrics{gaugeMetric(t, "host1.cpu"), gaugeMetric(t, "host2.cpu")}
+	err = agent.PostBatch(ctx, ms)
+	var batchErr *models.BatchError
+	require.ErrorAs(t, err, &batchErr)
+	require.Len(t, batchErr.Errors, 1)
+	assert.Equal(t, models.CodeForbidden, batchErr.Errors[0].Code)
+	_, err = agent.Get(ctx)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	require.NoError(t, s.Set(ctx, ms[1]))
+	dashboard := client.NewGRPC(addr, client.SetAPIKey("dashboard"))
+	t.Cleanup(func() { dashboard.Close() })
+	got, err := dashboard.Get(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, ms[:1], got)
+}
+
+func gaugeMetric(t *testing.T, id string) models.Metric {
+	m, err := models.NewMetric(id, models.GaugeType, "1")
+	require.NoError(t, err)
+	return m
+}
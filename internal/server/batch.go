@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"sort"
+
+	"github.com/Nexadis/metalert/internal/auth"
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage"
+)
+
+// applyBatch Записывает пачку метрик и возвращает ошибки отдельных метрик.
+// rejected - метрики, отклонённые при разборе запроса, они пропускаются по номеру.
+// Метрики, которые ключ запроса не может записывать, отклоняются с кодом models.CodeForbidden.
+// Без partial при любой ошибке пачка не применяется, с partial верные метрики записываются
+func applyBatch(ctx context.Context, s storage.BatchSetter, ms models.Metrics, rejected []models.ItemError, partial bool) models.BatchResult {
+	skip := make(map[int]bool, len(rejected))
+	for _, e := range rejected {
+		skip[e.Index] = true
+	}
+	result := models.BatchResult{
+		Errors: rejected,
+	}
+	valid := make(models.Metrics, 0, len(ms))
+	indexes := make([]int, 0, len(ms))
+	for i, m := range ms {
+		if skip[i] {
+			continue
+		}
+		if !auth.Allowed(ctx, auth.Write, m.ID) {
+			result.Errors = append(result.Errors, models.ItemError{
+				Index:   i,
+				ID:      m.ID,
+				Code:    models.CodeForbidden,
+				Message: auth.ErrForbidden.Error(),
+			})
+			continue
+		}
+		err := m.Validate()
+		if err != nil {
+			result.Errors = append(result.Errors, models.NewItemError(i, m.ID, err))
+			continue
+		}
+		valid = append(valid, m)
+		indexes = append(indexes, i)
+	}
+	if len(result.Errors) == 0 || partial {
+		err := s.SetMany(ctx, valid)
+		if err != nil {
+			for j, i := range indexes {
+				result.Errors = append(result.Errors, models.NewItemError(i, valid[j].ID, err))
+			}
+		} else {
+			result.Accepted = len(valid)
+		}
+	}
+	sort.Slice(result.Errors, func(i, j int) bool {
+		return result.Errors[i].Index < result.Errors[j].Index
+	})
+	return result
+}
+
+// retryable Сообщает, есть ли в результате ошибки, которые можно повторить
+func retryable(result models.BatchResult) bool {
+	for _, e := range result.Errors {
+		if e.Retryable {
+			return true
+		}
+	}
+	return false
+}
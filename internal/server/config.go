@@ -4,30 +4,51 @@ import (
 	"encoding/json"
 	"flag"
 	"os"
+	"time"
 
 	"github.com/caarlos0/env/v8"
 
+	"github.com/Nexadis/metalert/internal/alerting"
+	"github.com/Nexadis/metalert/internal/auth"
 	"github.com/Nexadis/metalert/internal/storage"
 	"github.com/Nexadis/metalert/internal/utils/logger"
+	"github.com/Nexadis/metalert/internal/utils/tlsconfig"
+	"github.com/Nexadis/metalert/internal/utils/verifier"
+	"github.com/Nexadis/metalert/internal/webhook"
 )
 
 // Config - Конфиг сервера
 type Config struct {
-	Address       string          `env:"ADDRESS" json:"address,omitempty"`
-	Verbose       bool            `env:"VERBOSE" json:"verbose,omitempty"`       // Включить логгирование
-	SignKey       string          `env:"KEY" json:"key,omitempty"`               // Ключ для подписи всех пакетов
-	CryptoKey     string          `env:"CRYPTO_KEY" json:"crypto_key,omitempty"` // Приватный ключ для расшифровки метрик
-	Config        string          `env:"CONFIG"`                                 // Путь к json-файлу с конфигурацией
-	TrustedSubnet string          `env:"TRUSTED_SUBNET" json:"trusted_subnet,omitempty"`
-	GRPC          string          `env:"GRPC" json:"grpc,omitempty"` // Адрес для запуска grpc-сервера
-	DB            *storage.Config `json:"db,omitempty"`
+	Address       string                 `env:"ADDRESS" json:"address,omitempty"`
+	Verbose       bool                   `env:"VERBOSE" json:"verbose,omitempty"`       // Включить логгирование
+	SignKey       string                 `env:"KEY" json:"key,omitempty"`               // Ключ для подписи всех пакетов
+	CryptoKey     string                 `env:"CRYPTO_KEY" json:"crypto_key,omitempty"` // Приватный ключ для расшифровки метрик
+	Keyring       string                 `env:"KEYRING" json:"keyring,omitempty"`       // Файл связки ключей подписи и приватных ключей, перечитывается по SIGHUP
+	Config        string                 `env:"CONFIG"`                                 // Путь к json-файлу с конфигурацией
+	TrustedSubnet string                 `env:"TRUSTED_SUBNET" json:"trusted_subnet,omitempty"`
+	TrustedProxy  string                 `env:"TRUSTED_PROXY" json:"trusted_proxy,omitempty"`     // CIDR прокси, которым gRPC-сервер верит адрес агента из x-real-ip
+	GRPC          string                 `env:"GRPC" json:"grpc,omitempty"`                       // Адрес для запуска grpc-сервера
+	TLSCert       string                 `env:"TLS_CERT" json:"tls_cert,omitempty"`               // Сертификат сервера, пустой - без TLS
+	TLSKey        string                 `env:"TLS_KEY" json:"tls_key,omitempty"`                 // Приватный ключ сертификата сервера
+	TLSClientCA   string                 `env:"TLS_CLIENT_CA" json:"tls_client_ca,omitempty"`     // CA сертификатов клиентов, задаёт mTLS
+	TLSMinVersion string                 `env:"TLS_MIN_VERSION" json:"tls_min_version,omitempty"` // Минимальная версия TLS: 1.2 или 1.3
+	SignStrict    bool                   `env:"SIGN_STRICT" json:"sign_strict,omitempty"`         // Требовать подпись со временем и nonce на каждой записи
+	SignSkew      int64                  `env:"SIGN_SKEW" json:"sign_skew,omitempty"`             // Допустимое расхождение времени подписи в секундах
+	NonceCache    int                    `env:"NONCE_CACHE" json:"nonce_cache,omitempty"`         // Количество запоминаемых nonce
+	APIKeys       []auth.Key             `json:"api_keys,omitempty"`                              // API-ключи с хешами токенов
+	APIKeysDB     bool                   `env:"API_KEYS_DB" json:"api_keys_db,omitempty"`         // Искать API-ключи в таблице api_keys
+	DB            *storage.Config        `json:"db,omitempty"`
+	Alerts        *alerting.Config       `json:"alerts,omitempty"`
+	Webhooks      []webhook.Subscription `json:"webhooks,omitempty"` // Подписки на изменения метрик
 }
 
 // NewConfig() Конструктор для конфига
 func NewConfig() *Config {
 	db := storage.NewConfig()
+	alerts := alerting.NewConfig()
 	return &Config{
-		DB: db,
+		DB:     db,
+		Alerts: alerts,
 	}
 }
 
@@ -36,9 +57,19 @@ var (
 	defaultVerbose       = true
 	defaultSignKey       = ""
 	defaultTrustedSubnet = ""
+	defaultTrustedProxy  = ""
 	defaultCryptoKey     = ""
+	defaultKeyring       = ""
 	defaultConfig        = ""
 	defaultGRPC          = "localhost:5533"
+	defaultTLSCert       = ""
+	defaultTLSKey        = ""
+	defaultTLSClientCA   = ""
+	defaultTLSMinVersion = tlsconfig.DefaultMinVersion
+	defaultAPIKeysDB     = false
+	defaultSignStrict    = false
+	defaultSignSkew      = int64(verifier.DefaultSkew / time.Second)
+	defaultNonceCache    = verifier.DefaultNonceCache
 )
 
 func (c *Config) parseCmd(set *flag.FlagSet) {
@@ -46,9 +77,19 @@ func (c *Config) parseCmd(set *flag.FlagSet) {
 	set.BoolVar(&c.Verbose, "v", defaultVerbose, "Verbose logging")
 	set.StringVar(&c.SignKey, "k", defaultSignKey, "Key to sign body")
 	set.StringVar(&c.TrustedSubnet, "t", defaultTrustedSubnet, "CIDR of trusted subnet")
+	set.StringVar(&c.TrustedProxy, "trusted-proxy", defaultTrustedProxy, "CIDR of proxies allowed to pass agent address in gRPC x-real-ip metadata")
 	set.StringVar(&c.CryptoKey, "crypto-key", defaultCryptoKey, "Path to file with private-key")
+	set.StringVar(&c.Keyring, "keyring", defaultKeyring, "Path to keyring with sign and private keys, reloaded on SIGHUP")
 	set.StringVar(&c.Config, "config", defaultConfig, "Path to file with config")
 	set.StringVar(&c.GRPC, "grpc", defaultGRPC, "Run grpc server on address")
+	set.StringVar(&c.TLSCert, "tls-cert", defaultTLSCert, "Path to server certificate, enables TLS")
+	set.StringVar(&c.TLSKey, "tls-key", defaultTLSKey, "Path to server certificate key")
+	set.StringVar(&c.TLSClientCA, "tls-client-ca", defaultTLSClientCA, "Path to CA of client certificates, enables mTLS")
+	set.StringVar(&c.TLSMinVersion, "tls-min-version", defaultTLSMinVersion, "Min TLS version: 1.2 or 1.3")
+	set.BoolVar(&c.SignStrict, "sign-strict", defaultSignStrict, "Require signature with timestamp and nonce on every write")
+	set.Int64Var(&c.SignSkew, "sign-skew", defaultSignSkew, "Allowed clock skew of signed requests in seconds")
+	set.IntVar(&c.NonceCache, "nonce-cache", defaultNonceCache, "Max number of remembered nonces of signed requests")
+	set.BoolVar(&c.APIKeysDB, "api-keys-db", defaultAPIKeysDB, "Look up API keys in database table api_keys")
 }
 
 func (c *Config) parseEnv() {
@@ -62,6 +103,13 @@ func (c *Config) parseFile(set *flag.FlagSet) {
 	tmp := NewConfig()
 	tmp.Config = c.Config
 	loadJSON(tmp)
+	// "db": null и "alerts": null в файле обнуляют вложенные конфиги
+	if tmp.DB == nil {
+		tmp.DB = storage.NewConfig()
+	}
+	if tmp.Alerts == nil {
+		tmp.Alerts = alerting.NewConfig()
+	}
 	if tmp.Address != "" {
 		if c.Address == defaultAddress {
 			c.Address = tmp.Address
@@ -77,11 +125,21 @@ func (c *Config) parseFile(set *flag.FlagSet) {
 			c.TrustedSubnet = tmp.TrustedSubnet
 		}
 	}
+	if tmp.TrustedProxy != "" {
+		if c.TrustedProxy == defaultTrustedProxy {
+			c.TrustedProxy = tmp.TrustedProxy
+		}
+	}
 	if tmp.CryptoKey != "" {
 		if c.CryptoKey == defaultCryptoKey {
 			c.CryptoKey = tmp.CryptoKey
 		}
 	}
+	if tmp.Keyring != "" {
+		if c.Keyring == defaultKeyring {
+			c.Keyring = tmp.Keyring
+		}
+	}
 	if tmp.DB.StoreInterval != 0 {
 		if c.DB.StoreInterval == storage.DefaultStoreInterval {
 			c.DB.StoreInterval = tmp.DB.StoreInterval
@@ -107,11 +165,106 @@ func (c *Config) parseFile(set *flag.FlagSet) {
 			c.DB.Retry = tmp.DB.Retry
 		}
 	}
+	if tmp.DB.HistorySize != 0 {
+		if c.DB.HistorySize == storage.DefaultHistorySize {
+			c.DB.HistorySize = tmp.DB.HistorySize
+		}
+	}
+	if len(tmp.DB.Retention) != 0 {
+		if c.DB.Retention.String() == storage.DefaultRetention.String() {
+			c.DB.Retention = tmp.DB.Retention
+		}
+	}
+	if tmp.DB.CompactInterval != 0 {
+		if c.DB.CompactInterval == storage.DefaultCompactInterval {
+			c.DB.CompactInterval = tmp.DB.CompactInterval
+		}
+	}
+	if tmp.DB.Snapshots != 0 {
+		if c.DB.Snapshots == storage.DefaultSnapshots {
+			c.DB.Snapshots = tmp.DB.Snapshots
+		}
+	}
+	if tmp.DB.WAL != "" {
+		if c.DB.WAL == storage.DefaultWAL {
+			c.DB.WAL = tmp.DB.WAL
+		}
+	}
+	if tmp.DB.WALSync != "" {
+		if c.DB.WALSync == storage.DefaultWALSync {
+			c.DB.WALSync = tmp.DB.WALSync
+		}
+	}
+	if tmp.DB.WALSyncInterval != 0 {
+		if c.DB.WALSyncInterval == storage.DefaultWALSyncInterval {
+			c.DB.WALSyncInterval = tmp.DB.WALSyncInterval
+		}
+	}
 	if tmp.GRPC != "" {
 		if c.GRPC == defaultGRPC {
 			c.GRPC = tmp.GRPC
 		}
 	}
+	if tmp.TLSCert != "" {
+		if c.TLSCert == defaultTLSCert {
+			c.TLSCert = tmp.TLSCert
+		}
+	}
+	if tmp.TLSKey != "" {
+		if c.TLSKey == defaultTLSKey {
+			c.TLSKey = tmp.TLSKey
+		}
+	}
+	if tmp.TLSClientCA != "" {
+		if c.TLSClientCA == defaultTLSClientCA {
+			c.TLSClientCA = tmp.TLSClientCA
+		}
+	}
+	if tmp.TLSMinVersion != "" {
+		if c.TLSMinVersion == defaultTLSMinVersion {
+			c.TLSMinVersion = tmp.TLSMinVersion
+		}
+	}
+	if tmp.SignStrict {
+		if c.SignStrict == defaultSignStrict {
+			c.SignStrict = tmp.SignStrict
+		}
+	}
+	if tmp.SignSkew != 0 {
+		if c.SignSkew == defaultSignSkew {
+			c.SignSkew = tmp.SignSkew
+		}
+	}
+	if tmp.NonceCache != 0 {
+		if c.NonceCache == defaultNonceCache {
+			c.NonceCache = tmp.NonceCache
+		}
+	}
+	if len(tmp.APIKeys) != 0 {
+		if len(c.APIKeys) == 0 {
+			c.APIKeys = tmp.APIKeys
+		}
+	}
+	if tmp.APIKeysDB {
+		if c.APIKeysDB == defaultAPIKeysDB {
+			c.APIKeysDB = tmp.APIKeysDB
+		}
+	}
+	if len(tmp.Alerts.Rules) != 0 {
+		if len(c.Alerts.Rules) == 0 {
+			c.Alerts.Rules = tmp.Alerts.Rules
+		}
+	}
+	if tmp.Alerts.Interval != 0 {
+		if c.Alerts.Interval == alerting.DefaultInterval {
+			c.Alerts.Interval = tmp.Alerts.Interval
+		}
+	}
+	if len(tmp.Webhooks) != 0 {
+		if len(c.Webhooks) == 0 {
+			c.Webhooks = tmp.Webhooks
+		}
+	}
 
 	if c.DB.Restore == storage.DefaultRestore {
 		logger.Info("Restore")
@@ -126,6 +279,7 @@ func (c *Config) ParseConfig() {
 	c.parseFile(set)
 	c.parseEnv()
 	c.DB.ParseEnv()
+	c.Alerts.ParseEnv()
 	if c.Verbose {
 		logger.Enable()
 	}
@@ -133,8 +287,12 @@ func (c *Config) ParseConfig() {
 		"\nAddress: ", c.Address,
 		"\nVerbose: ", c.Verbose,
 		"\nSign Key: ", c.SignKey,
+		"\nStrict signatures: ", c.SignStrict,
 		"\nCrypto Key: ", c.CryptoKey,
+		"\nKeyring: ", c.Keyring,
 		"\nStart grpc: ", c.GRPC,
+		"\nTLS cert: ", c.TLSCert,
+		"\nTLS client CA: ", c.TLSClientCA,
 	)
 }
 
@@ -155,8 +313,12 @@ func loadJSON(c *Config) {
 
 func (c *Config) setFlags() *flag.FlagSet {
 	set := flag.NewFlagSet("", flag.ExitOnError)
+	if c.Alerts == nil {
+		c.Alerts = alerting.NewConfig()
+	}
 	c.parseCmd(set)
 	c.DB.ParseCmd(set)
+	c.Alerts.ParseCmd(set)
 	return set
 }
 
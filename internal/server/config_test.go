@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/Nexadis/metalert/internal/storage"
+	"github.com/Nexadis/metalert/internal/webhook"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -27,6 +28,14 @@ func TestLoadJSON(t *testing.T) {
 			StoreInterval:   2,
 			FileStoragePath: "some_filepath",
 		},
+		Webhooks: []webhook.Subscription{
+			{
+				URL:       "http://localhost/hook",
+				ID:        "CPUUtilization1",
+				Condition: "> 90",
+				Retries:   2,
+			},
+		},
 	}
 	testC.SetDefault()
 	data, err := json.Marshal(testC)
@@ -38,3 +47,17 @@ func TestLoadJSON(t *testing.T) {
 	loadJSON(loadedConf)
 	assert.Equal(t, testC, loadedConf)
 }
+
+func TestParseFileNull(t *testing.T) {
+	name := t.TempDir() + "/config.json"
+	data := `{"address": "file_address", "db": null, "alerts": null}`
+	assert.NoError(t, os.WriteFile(name, []byte(data), 0600))
+	c := NewConfig()
+	set := c.setFlags()
+	assert.NoError(t, set.Parse([]string{}))
+	c.Config = name
+	assert.NotPanics(t, func() { c.parseFile(set) })
+	assert.Equal(t, "file_address", c.Address)
+	assert.NotNil(t, c.DB)
+	assert.NotNil(t, c.Alerts)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Nexadis/metalert/internal/auth"
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/utils/logger"
+)
+
+// dashboardFiles - Страница панели метрик. Не использует внешние ресурсы, поэтому работает без доступа в интернет
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardFS Возвращает файлы панели без префикса каталога
+func dashboardFS() http.FileSystem {
+	sub, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+	return http.FS(sub)
+}
+
+// updateTimes Запоминает время последнего изменения каждой серии
+type updateTimes struct {
+	mutex sync.RWMutex
+	times map[string]time.Time
+}
+
+func newUpdateTimes() *updateTimes {
+	return &updateTimes{
+		times: make(map[string]time.Time),
+	}
+}
+
+// Notify Запоминает время изменения. Подходит для storage.Notifier.OnSet
+func (u *updateTimes) Notify(ctx context.Context, m models.Metric) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.times[m.MType+"/"+m.Series()] = time.Now()
+}
+
+// Get Возвращает время последнего изменения, false - если метрика не менялась после запуска сервера
+func (u *updateTimes) Get(m models.Metric) (time.Time, bool) {
+	u.mutex.RLock()
+	defer u.mutex.RUnlock()
+	t, ok := u.times[m.MType+"/"+m.Series()]
+	return t, ok
+}
+
+// MetricInfo - Строка таблицы панели метрик
+type MetricInfo struct {
+	ID      string            `json:"id"`
+	MType   string            `json:"type"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Value   string            `json:"value"`
+	Updated *time.Time        `json:"updated,omitempty"` // отсутствует, если метрика не менялась после запуска сервера
+}
+
+// InfoPage Главная страница - панель со всеми метриками
+func (s *httpServer) InfoPage(w http.ResponseWriter, r *http.Request) {
+	r.URL.Path = "/"
+	http.FileServer(dashboardFS()).ServeHTTP(w, r)
+}
+
+// MetricsInfo Возвращает все метрики со временем последнего изменения в JSON-формате, упорядоченные по имени серии
+func (s *httpServer) MetricsInfo(w http.ResponseWriter, r *http.Request) {
+	metrics, err := s.storage.GetAll(r.Context(), matchersFromQuery(r)...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	metrics = auth.Filter(r.Context(), metrics)
+	infos := make([]MetricInfo, 0, len(metrics))
+	for _, m := range metrics {
+		val, err := m.GetValue()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		info := MetricInfo{
+			ID:     m.ID,
+			MType:  m.MType,
+			Labels: m.Labels,
+			Value:  val,
+		}
+		if t, ok := s.updated.Get(m); ok {
+			info.Updated = &t
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		si := infos[i].ID + models.LabelsString(infos[i].Labels)
+		sj := infos[j].ID + models.LabelsString(infos[j].Labels)
+		if si != sj {
+			return si < sj
+		}
+		return infos[i].MType < infos[j].MType
+	})
+	w.Header().Set("Content-type", "application/json")
+	err = json.NewEncoder(w).Encode(infos)
+	if err != nil {
+		logger.Error(err)
+	}
+}
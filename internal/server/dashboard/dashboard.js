@@ -0,0 +1,146 @@
+"use strict";
+
+// Панель метрик: загружает /api/v1/metrics, фильтрует и сортирует таблицу на стороне браузера.
+// Если на сервере настроены API-ключи, токен с правом read вводится в поле и хранится в localStorage
+(function () {
+  const body = document.getElementById("metrics");
+  const status = document.getElementById("status");
+  const filter = document.getElementById("filter");
+  const type = document.getElementById("type");
+  const interval = document.getElementById("interval");
+  const token = document.getElementById("token");
+  const tokenKey = "metalert-token";
+  const headers = document.querySelectorAll("th[data-key]");
+
+  let metrics = [];
+  let sortKey = "series";
+  let sortAsc = true;
+  let timer = null;
+
+  function labels(m) {
+    if (!m.labels) {
+      return "";
+    }
+    const parts = Object.keys(m.labels).sort().map(function (k) {
+      return k + "=" + JSON.stringify(m.labels[k]);
+    });
+    return "{" + parts.join(",") + "}";
+  }
+
+  function sortValue(m) {
+    switch (sortKey) {
+      case "type":
+        return m.type;
+      case "value":
+        const v = Number(m.value);
+        return isNaN(v) ? m.value : v;
+      case "updated":
+        return m.updated ? Date.parse(m.updated) : 0;
+    }
+    return m.series;
+  }
+
+  function compare(a, b) {
+    const va = sortValue(a);
+    const vb = sortValue(b);
+    let r = 0;
+    if (typeof va === "number" && typeof vb === "number") {
+      r = va - vb;
+    } else {
+      r = String(va).localeCompare(String(vb));
+    }
+    if (r === 0) {
+      r = a.series.localeCompare(b.series);
+    }
+    return sortAsc ? r : -r;
+  }
+
+  function cell(row, text, cls) {
+    const td = document.createElement("td");
+    td.textContent = text;
+    if (cls) {
+      td.className = cls;
+    }
+    row.appendChild(td);
+  }
+
+  function render() {
+    const text = filter.value.toLowerCase();
+    const rows = metrics.filter(function (m) {
+      return (!type.value || m.type === type.value) &&
+        m.series.toLowerCase().indexOf(text) !== -1;
+    }).sort(compare);
+    body.replaceChildren();
+    rows.forEach(function (m) {
+      const row = document.createElement("tr");
+      cell(row, m.series);
+      cell(row, m.type);
+      cell(row, m.value, "value");
+      cell(row, m.updated ? new Date(m.updated).toLocaleString() : "—", "updated");
+      body.appendChild(row);
+    });
+    headers.forEach(function (th) {
+      th.classList.toggle("asc", th.dataset.key === sortKey && sortAsc);
+      th.classList.toggle("desc", th.dataset.key === sortKey && !sortAsc);
+    });
+    status.textContent = rows.length + " из " + metrics.length;
+  }
+
+  function load() {
+    const init = {};
+    if (token.value) {
+      init.headers = {"Authorization": "Bearer " + token.value};
+    }
+    fetch("/api/v1/metrics", init).then(function (resp) {
+      if (resp.status === 401 || resp.status === 403) {
+        token.focus();
+        throw new Error("нужен API-ключ с правом read");
+      }
+      if (!resp.ok) {
+        throw new Error(resp.status + " " + resp.statusText);
+      }
+      return resp.json();
+    }).then(function (data) {
+      metrics = data.map(function (m) {
+        m.series = m.id + labels(m);
+        return m;
+      });
+      status.classList.remove("error");
+      render();
+    }).catch(function (err) {
+      status.textContent = "Ошибка загрузки: " + err.message;
+      status.classList.add("error");
+    });
+  }
+
+  function schedule() {
+    clearInterval(timer);
+    const seconds = Number(interval.value);
+    if (seconds > 0) {
+      timer = setInterval(load, seconds * 1000);
+    }
+  }
+
+  headers.forEach(function (th) {
+    th.addEventListener("click", function () {
+      if (sortKey === th.dataset.key) {
+        sortAsc = !sortAsc;
+      } else {
+        sortKey = th.dataset.key;
+        sortAsc = true;
+      }
+      render();
+    });
+  });
+  filter.addEventListener("input", render);
+  type.addEventListener("change", render);
+  interval.addEventListener("change", schedule);
+  token.value = localStorage.getItem(tokenKey) || "";
+  token.addEventListener("change", function () {
+    localStorage.setItem(tokenKey, token.value);
+    load();
+  });
+
+  load();
+  schedule();
+})();
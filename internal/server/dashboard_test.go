@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage/mem"
+)
+
+func TestDashboard(t *testing.T) {
+	s := mem.NewMetricsStorage()
+	hs, err := NewHTTPServer(NewConfig(), s, nil, nil)
+	require.NoError(t, err)
+
+	get := func(url string) (*http.Response, string) {
+		w := httptest.NewRecorder()
+		hs.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		resp := w.Result()
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(body)
+	}
+
+	resp, body := get("/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/html")
+	assert.Contains(t, body, "/dashboard/dashboard.js")
+	assert.NotContains(t, body, "https://")
+	resp, _ = get("/dashboard/dashboard.js")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = get("/dashboard/dashboard.css")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	ctx := context.Background()
+	m, err := models.NewMetric("b", models.GaugeType, "1.5")
+	require.NoError(t, err)
+	require.NoError(t, s.Set(ctx, m))
+	m, err = models.NewMetric("a", models.CounterType, "3")
+	require.NoError(t, err)
+	m.Labels = map[string]string{"host": "x"}
+	require.NoError(t, s.Set(ctx, m))
+
+	resp, body = get("/api/v1/metrics")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var infos []MetricInfo
+	require.NoError(t, json.Unmarshal([]byte(body), &infos))
+	require.Len(t, infos, 2)
+	assert.Equal(t, "a", infos[0].ID)
+	assert.Equal(t, "3", infos[0].Value)
+	assert.Equal(t, map[string]string{"host": "x"}, infos[0].Labels)
+	assert.NotNil(t, infos[0].Updated)
+	assert.Equal(t, "b", infos[1].ID)
+	assert.Equal(t, models.GaugeType, infos[1].MType)
+	assert.Equal(t, "1.5", infos[1].Value)
+}
+
+func TestDashboardAuth(t *testing.T) {
+	hs, err := NewHTTPServer(authConfig(), mem.NewMetricsStorage(), nil, nil)
+	require.NoError(t, err)
+	get := func(url, token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		hs.router.ServeHTTP(w, r)
+		return w
+	}
+
+	// страница открывается без ключа, а данные запрашиваются с токеном из поля панели
+	assert.Equal(t, http.StatusOK, get("/", "").Code)
+	w := get("/dashboard/dashboard.js", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"Authorization": "Bearer "`)
+	assert.Equal(t, http.StatusUnauthorized, get("/api/v1/metrics", "").Code)
+	assert.Equal(t, http.StatusOK, get("/api/v1/metrics", "dashboard").Code)
+}
@@ -2,27 +2,79 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"io"
 	"net"
+	"time"
 
+	"github.com/Nexadis/metalert/internal/alerting"
+	"github.com/Nexadis/metalert/internal/auth"
+	"github.com/Nexadis/metalert/internal/models"
 	"github.com/Nexadis/metalert/internal/models/controller"
+	"github.com/Nexadis/metalert/internal/query"
+	"github.com/Nexadis/metalert/internal/server/interceptors"
 	"github.com/Nexadis/metalert/internal/storage"
+	"github.com/Nexadis/metalert/internal/utils/keyring"
 	"github.com/Nexadis/metalert/internal/utils/logger"
+	"github.com/Nexadis/metalert/internal/utils/verifier"
+	"github.com/Nexadis/metalert/internal/watch"
 	pb "github.com/Nexadis/metalert/proto/metrics/v1"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_zap "github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type grpcServer struct {
 	pb.UnimplementedMetricsCollectorServiceServer
-	storage storage.Storage
-	config  *Config
+	storage    storage.Storage
+	config     *Config
+	keys       *keyring.Keyring
+	trustedNet *net.IPNet
+	proxyNet   *net.IPNet
+	tlsConfig  *tls.Config
+	auth       *auth.Authenticator
+	guard      *verifier.ReplayGuard
+	alerts     *alerting.Engine
+	hub        *watch.Hub
 }
 
-func NewGRPCServer(config *Config, storage storage.Storage) (*grpcServer, error) {
+func NewGRPCServer(config *Config, storage storage.Storage, alerts *alerting.Engine, hub *watch.Hub) (*grpcServer, error) {
+	keys, trusted, err := loadSecurity(config)
+	if err != nil {
+		return nil, err
+	}
+	proxy, err := loadProxy(config)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := loadTLS(config)
+	if err != nil {
+		return nil, err
+	}
+	authenticator, err := loadAuth(config, storage)
+	if err != nil {
+		return nil, err
+	}
+	guard, err := loadGuard(config, keys)
+	if err != nil {
+		return nil, err
+	}
 	return &grpcServer{
-		storage: storage,
-		config:  config,
+		storage:    storage,
+		config:     config,
+		keys:       keys,
+		trustedNet: trusted,
+		proxyNet:   proxy,
+		tlsConfig:  tlsConfig,
+		auth:       authenticator,
+		guard:      guard,
+		alerts:     alerts,
+		hub:        hub,
 	}, nil
 }
 
@@ -32,15 +84,7 @@ func (s *grpcServer) Run(ctx context.Context) error {
 		return err
 	}
 
-	var opts []grpc.ServerOption
-	if s.config.Verbose {
-		opts = append(opts, grpc.UnaryInterceptor(
-			grpc_middleware.ChainUnaryServer(
-				grpc_zap.UnaryServerInterceptor(logger.ZapInterceptor()),
-			),
-		))
-	}
-	gs := grpc.NewServer(opts...)
+	gs := grpc.NewServer(s.serverOptions()...)
 	pb.RegisterMetricsCollectorServiceServer(gs, s)
 	go func() {
 		logger.Info("Grpc Server at ", s.config.GRPC)
@@ -51,12 +95,61 @@ func (s *grpcServer) Run(ctx context.Context) error {
 	return nil
 }
 
+// methodScopes - Права API-ключа, нужные методам сервиса
+var methodScopes = map[string]auth.Scope{
+	pb.MetricsCollectorService_Get_FullMethodName:        auth.Read,
+	pb.MetricsCollectorService_Post_FullMethodName:       auth.Write,
+	pb.MetricsCollectorService_PostStream_FullMethodName: auth.Write,
+	pb.MetricsCollectorService_Watch_FullMethodName:      auth.Read,
+	pb.MetricsCollectorService_GetAlerts_FullMethodName:  auth.Read,
+	pb.MetricsCollectorService_Query_FullMethodName:      auth.Read,
+}
+
+// serverOptions Возвращает опции сервера: логирование в подробном режиме, проверку доверенной подсети,
+// API-ключа, подписи и расшифровку запросов, TLS. Подсеть проверяется до подписи, чтобы не считать подпись чужих запросов.
+// Унарные запросы расшифровываются кодеком ещё до перехватчиков, поэтому запросы не из подсети
+// тоже расшифровываются и только потом отклоняются. Сообщения потоков расшифровываются уже после проверок
+func (s *grpcServer) serverOptions() []grpc.ServerOption {
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+	if s.config.Verbose {
+		// ZapInterceptor заменяет глобальный логгер gRPC, поэтому создаётся один раз на обе цепочки
+		zapLogger := logger.ZapInterceptor()
+		unary = append(unary, grpc_zap.UnaryServerInterceptor(zapLogger))
+		stream = append(stream, grpc_zap.StreamServerInterceptor(zapLogger))
+	}
+	unary = append(unary,
+		interceptors.TrustedUnary(s.trustedNet, s.proxyNet),
+		interceptors.AuthUnary(s.auth, methodScopes),
+		interceptors.VerifyUnary(s.keys, s.guard),
+	)
+	stream = append(stream,
+		interceptors.TrustedStream(s.trustedNet, s.proxyNet),
+		interceptors.AuthStream(s.auth, methodScopes),
+		interceptors.VerifyStream(s.keys, s.guard),
+	)
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unary...)),
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(stream...)),
+		interceptors.Decrypt(s.keys),
+	}
+	if s.tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.tlsConfig)))
+	}
+	return opts
+}
+
 func (s *grpcServer) Get(ctx context.Context, r *pb.GetRequest) (*pb.GetResponse, error) {
 	var resp pb.GetResponse
-	metrics, err := s.storage.GetAll(ctx)
+	matchers, err := controller.MatchersFromPB(r.GetMatchers())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	metrics, err := s.storage.GetAll(ctx, matchers...)
 	if err != nil {
 		return &resp, nil
 	}
+	metrics = auth.Filter(ctx, metrics)
 	resp.Metrics, err = controller.MetricsToPB(metrics)
 	if err != nil {
 		return nil, err
@@ -66,17 +159,180 @@ func (s *grpcServer) Get(ctx context.Context, r *pb.GetRequest) (*pb.GetResponse
 
 func (s *grpcServer) Post(ctx context.Context, r *pb.PostRequest) (*pb.PostResponse, error) {
 	var resp pb.PostResponse
-	ms, err := controller.MetricsFromPB(r.Metrics)
+	result, err := s.post(ctx, r.GetMetrics(), r.GetPartial())
 	if err != nil {
 		resp.Error = err.Error()
-		return &resp, nil
 	}
-	for _, m := range ms {
-		err = s.storage.Set(ctx, m)
+	resp.Errors = controller.ItemErrorsToPB(result.Errors)
+	resp.Accepted = uint32(result.Accepted)
+	return &resp, nil
+}
+
+// PostStream Принимает пачки метрик из потока и подтверждает каждую пачку.
+// Следующая пачка читается только после записи предыдущей, поэтому медленное хранилище
+// сдерживает клиента через управление потоком gRPC, а не накапливает пачки в памяти
+func (s *grpcServer) PostStream(stream pb.MetricsCollectorService_PostStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		resp := &pb.PostStreamResponse{
+			Seq: req.GetSeq(),
+		}
+		result, err := s.post(stream.Context(), req.GetMetrics(), req.GetPartial())
 		if err != nil {
 			resp.Error = err.Error()
-			return &resp, nil
 		}
+		resp.Errors = controller.ItemErrorsToPB(result.Errors)
+		resp.Accepted = uint32(result.Accepted)
+		err = stream.Send(resp)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// post Записывает пачку метрик в хранилище. Ошибка описывает все отклонённые метрики
+// для клиентов, которые не разбирают ошибки по отдельности
+func (s *grpcServer) post(ctx context.Context, metrics *pb.Metrics, partial bool) (models.BatchResult, error) {
+	ms, rejected := controller.BatchFromPB(metrics)
+	result := applyBatch(ctx, s.storage, ms, rejected, partial)
+	if len(result.Errors) != 0 {
+		return result, &models.BatchError{Errors: result.Errors}
+	}
+	return result, nil
+}
+
+// Watch Отправляет снимок подходящих метрик, затем каждое принятое изменение.
+// Изменения, принятые во время формирования снимка, могут повторить значения из снимка
+func (s *grpcServer) Watch(r *pb.WatchRequest, stream pb.MetricsCollectorService_WatchServer) error {
+	if s.hub == nil {
+		return status.Error(codes.Unavailable, "watch is not enabled")
 	}
+	var filter watch.Filter
+	var err error
+	if r.GetType() != pb.Metric_M_TYPE_UNSPECIFIED {
+		filter.MType, err = controller.TypeFromPB(r.GetType())
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	filter.Prefix = r.GetPrefix()
+	filter.Matchers, err = controller.MatchersFromPB(r.GetMatchers())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	sub := s.hub.Subscribe(filter, watch.DefaultBuffer)
+	defer sub.Close()
+
+	ctx := stream.Context()
+	metrics, err := s.storage.GetAll(ctx, filter.Matchers...)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	snapshot := make(models.Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		if filter.Match(m) && auth.Allowed(ctx, auth.Read, m.ID) {
+			snapshot = append(snapshot, m)
+		}
+	}
+	err = s.sendWatch(stream, snapshot, true)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-sub.Updates():
+			if !ok {
+				return status.Error(codes.ResourceExhausted, sub.Err().Error())
+			}
+			if !auth.Allowed(ctx, auth.Read, e.Metric.ID) {
+				continue
+			}
+			err = s.sendWatch(stream, models.Metrics{e.Metric}, false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *grpcServer) sendWatch(stream pb.MetricsCollectorService_WatchServer, ms models.Metrics, snapshot bool) error {
+	pbms, err := controller.MetricsToPB(ms)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return stream.Send(&pb.WatchResponse{
+		Metrics:  pbms,
+		Snapshot: snapshot,
+	})
+}
+
+func (s *grpcServer) GetAlerts(ctx context.Context, r *pb.GetAlertsRequest) (*pb.GetAlertsResponse, error) {
+	var resp pb.GetAlertsResponse
+	if !auth.Unrestricted(ctx) {
+		return nil, status.Error(codes.PermissionDenied, auth.ErrForbidden.Error())
+	}
+	alerts, err := alertsToPB(s.alerts.Alerts())
+	if err != nil {
+		return nil, err
+	}
+	resp.Alerts = alerts
 	return &resp, nil
 }
+
+// alertsToPB Преобразует состояния правил оповещений для ответа GetAlerts
+func alertsToPB(alerts []alerting.Alert) ([]*pb.Alert, error) {
+	result := make([]*pb.Alert, 0, len(alerts))
+	for _, a := range alerts {
+		t, err := controller.TypeToPB(a.MType)
+		if err != nil {
+			return nil, err
+		}
+		pa := &pb.Alert{
+			Rule:     a.Rule,
+			Id:       a.ID,
+			Type:     t,
+			State:    string(a.State),
+			Value:    a.Value,
+			ActiveAt: timestamppb.New(a.ActiveAt),
+		}
+		if !a.FiredAt.IsZero() {
+			pa.FiredAt = timestamppb.New(a.FiredAt)
+		}
+		if !a.ResolvedAt.IsZero() {
+			pa.ResolvedAt = timestamppb.New(a.ResolvedAt)
+		}
+		result = append(result, pa)
+	}
+	return result, nil
+}
+
+// Query Выполняет запрос на языке выражений. Ошибки разбора возвращаются с кодом InvalidArgument
+func (s *grpcServer) Query(ctx context.Context, r *pb.QueryRequest) (*pb.QueryResponse, error) {
+	if !auth.Unrestricted(ctx) {
+		return nil, status.Error(codes.PermissionDenied, auth.ErrForbidden.Error())
+	}
+	var at time.Time
+	if r.GetTime() != nil {
+		at = r.GetTime().AsTime()
+	}
+	result, err := query.New(s.storage).Query(ctx, r.GetQuery(), at)
+	if errors.Is(err, query.ErrInvalidQuery) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	resp, err := controller.QueryResultToPB(result)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return resp, nil
+}
@@ -2,21 +2,34 @@ package server
 
 import (
 	"context"
+	"io"
+	"net"
 	"testing"
 	"time"
 
+	"github.com/Nexadis/metalert/internal/agent/client"
+	"github.com/Nexadis/metalert/internal/alerting"
 	"github.com/Nexadis/metalert/internal/models"
 	"github.com/Nexadis/metalert/internal/models/controller"
+	"github.com/Nexadis/metalert/internal/server/interceptors"
 	"github.com/Nexadis/metalert/internal/storage/mem"
+	"github.com/Nexadis/metalert/internal/utils/asymcrypt"
+	"github.com/Nexadis/metalert/internal/watch"
 	pb "github.com/Nexadis/metalert/proto/metrics/v1"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 func TestNewGRPCServer(t *testing.T) {
 	c := NewConfig()
 	c.SetDefault()
 	s := mem.NewMetricsStorage()
-	gs, err := NewGRPCServer(c, s)
+	gs, err := NewGRPCServer(c, s, nil, nil)
 	assert.NoError(t, err)
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Millisecond)
 	defer cancel()
@@ -27,7 +40,7 @@ func TestNewGRPCServer(t *testing.T) {
 func TestGetPost(t *testing.T) {
 	c := NewConfig()
 	s := mem.NewMetricsStorage()
-	gs, err := NewGRPCServer(c, s)
+	gs, err := NewGRPCServer(c, s, nil, nil)
 	assert.NoError(t, err)
 	m, err := models.NewMetric("name", models.GaugeType, "123.123")
 	assert.NoError(t, err)
@@ -47,4 +60,287 @@ func TestGetPost(t *testing.T) {
 	gotms, err := controller.MetricsFromPB(getresp.Metrics)
 	assert.NoError(t, err)
 	assert.Equal(t, ms, gotms)
+
+	h, err := models.NewMetric("latency", models.HistogramType, "0.1,1;2,1,1;3.65")
+	assert.NoError(t, err)
+	pbh, err := controller.MetricToPB(h)
+	assert.NoError(t, err)
+	assert.Equal(t, pb.Metric_M_TYPE_HISTOGRAM, pbh.Type)
+	gotH, err := controller.MetricFromPB(pbh)
+	assert.NoError(t, err)
+	assert.Equal(t, h, gotH)
+	pbh.Histogram.Count = 1
+	_, err = controller.MetricFromPB(pbh)
+	assert.Error(t, err)
+
+	labeled := m
+	labeled.Labels = map[string]string{"host": "a"}
+	pbms, err = controller.MetricsToPB(models.Metrics{labeled})
+	assert.NoError(t, err)
+	_, err = gs.Post(context.TODO(), &pb.PostRequest{Metrics: pbms})
+	assert.NoError(t, err)
+	getresp, err = gs.Get(context.TODO(), &pb.GetRequest{
+		Matchers: []*pb.LabelMatcher{{Name: "host", Op: models.MatchEqual, Value: "a"}},
+	})
+	assert.NoError(t, err)
+	gotms, err = controller.MetricsFromPB(getresp.Metrics)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Metrics{labeled}, gotms)
+
+	_, err = gs.Get(context.TODO(), &pb.GetRequest{
+		Matchers: []*pb.LabelMatcher{{Name: "host", Op: "<", Value: "a"}},
+	})
+	assert.Error(t, err)
+}
+
+func TestGetAlerts(t *testing.T) {
+	c := NewConfig()
+	s := mem.NewMetricsStorage()
+	alerts, err := alerting.New(s, &alerting.Config{
+		Rules: []string{"gauge name > 100"},
+	})
+	assert.NoError(t, err)
+	gs, err := NewGRPCServer(c, s, alerts, nil)
+	assert.NoError(t, err)
+	m, err := models.NewMetric("name", models.GaugeType, "123.123")
+	assert.NoError(t, err)
+	err = s.Set(context.TODO(), m)
+	assert.NoError(t, err)
+	err = alerts.Evaluate(context.TODO(), time.Now())
+	assert.NoError(t, err)
+
+	resp, err := gs.GetAlerts(context.TODO(), &pb.GetAlertsRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Alerts, 1)
+	assert.Equal(t, "firing", resp.Alerts[0].State)
+	assert.Equal(t, pb.Metric_M_TYPE_GAUGE, resp.Alerts[0].Type)
+	assert.Equal(t, "123.123", resp.Alerts[0].Value)
+}
+
+// listenGRPC Запускает gs с его опциями на свободном порту и возвращает адрес
+func listenGRPC(t *testing.T, gs *grpcServer) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer(gs.serverOptions()...)
+	pb.RegisterMetricsCollectorServiceServer(server, gs)
+	go server.Serve(lis)
+	t.Cleanup(server.GracefulStop)
+	return lis.Addr().String()
+}
+
+// serveGRPC Запускает gs на свободном порту и возвращает клиента к нему
+func serveGRPC(t *testing.T, gs *grpcServer) pb.MetricsCollectorServiceClient {
+	conn, err := grpc.Dial(listenGRPC(t, gs), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewMetricsCollectorServiceClient(conn)
+}
+
+func TestPostStream(t *testing.T) {
+	s := mem.NewMetricsStorage()
+	gs, err := NewGRPCServer(NewConfig(), s, nil, nil)
+	require.NoError(t, err)
+	stream, err := serveGRPC(t, gs).PostStream(context.TODO())
+	require.NoError(t, err)
+	for i, value := range []string{"1", "2", "x"} {
+		m := &pb.Metric{Id: "PollCount", Type: pb.Metric_M_TYPE_COUNTER, Value: value}
+		err = stream.Send(&pb.PostStreamRequest{
+			Seq:     uint64(i + 1),
+			Metrics: &pb.Metrics{Metrics: []*pb.Metric{m}},
+		})
+		require.NoError(t, err)
+	}
+	for i := 0; i < 3; i++ {
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i+1), resp.GetSeq())
+		assert.Equal(t, i == 2, resp.GetError() != "")
+	}
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	assert.ErrorIs(t, err, io.EOF)
+	m, err := s.Get(context.TODO(), models.CounterType, "PollCount")
+	assert.NoError(t, err)
+	assert.Equal(t, models.Counter(3), *m.Delta)
+}
+
+func TestPostPartial(t *testing.T) {
+	s := mem.NewMetricsStorage()
+	gs, err := NewGRPCServer(NewConfig(), s, nil, nil)
+	require.NoError(t, err)
+	client := serveGRPC(t, gs)
+	metrics := &pb.Metrics{Metrics: []*pb.Metric{
+		{Id: "Alloc", Type: pb.Metric_M_TYPE_GAUGE, Value: "1"},
+		{Id: "Bad", Type: pb.Metric_M_TYPE_COUNTER, Value: "x"},
+		{Id: "Unknown", Value: "1"},
+		{Id: "Labeled", Type: pb.Metric_M_TYPE_GAUGE, Value: "1", Labels: map[string]string{"": "a"}},
+	}}
+
+	resp, err := client.Post(context.TODO(), &pb.PostRequest{Metrics: metrics})
+	require.NoError(t, err)
+	assert.NotEmpty(t, resp.GetError())
+	assert.Equal(t, uint32(0), resp.GetAccepted())
+	_, err = s.Get(context.TODO(), models.GaugeType, "Alloc")
+	assert.Error(t, err)
+
+	resp, err = client.Post(context.TODO(), &pb.PostRequest{Metrics: metrics, Partial: true})
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), resp.GetAccepted())
+	errs := resp.GetErrors()
+	require.Len(t, errs, 3)
+	for i, code := range []string{models.CodeInvalidValue, models.CodeInvalidType, models.CodeInvalidLabels} {
+		assert.Equal(t, uint32(i+1), errs[i].GetIndex())
+		assert.Equal(t, code, errs[i].GetCode())
+		assert.False(t, errs[i].GetRetryable())
+	}
+	assert.Equal(t, "Bad", errs[0].GetId())
+	_, err = s.Get(context.TODO(), models.GaugeType, "Alloc")
+	assert.NoError(t, err)
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := mem.NewMetricsStorage()
+	hub := watch.New()
+	s.OnSet(hub.Notify)
+	set := func(id, mtype, value string) {
+		m, err := models.NewMetric(id, mtype, value)
+		require.NoError(t, err)
+		require.NoError(t, s.Set(ctx, m))
+	}
+	set("cpu1", models.GaugeType, "1")
+	set("cpu2", models.CounterType, "1")
+	set("mem", models.GaugeType, "1")
+
+	gs, err := NewGRPCServer(NewConfig(), s, nil, hub)
+	require.NoError(t, err)
+	client := serveGRPC(t, gs)
+	stream, err := client.Watch(ctx, &pb.WatchRequest{
+		Type:   pb.Metric_M_TYPE_GAUGE,
+		Prefix: "cpu",
+	})
+	require.NoError(t, err)
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	assert.True(t, resp.GetSnapshot())
+	require.Len(t, resp.GetMetrics().GetMetrics(), 1)
+	assert.Equal(t, "cpu1", resp.GetMetrics().GetMetrics()[0].GetId())
+
+	assert.Eventually(t, func() bool { return hub.Len() == 1 }, time.Second, 10*time.Millisecond)
+	set("mem", models.GaugeType, "2")
+	set("cpu2", models.CounterType, "2")
+	set("cpu3", models.GaugeType, "5")
+	resp, err = stream.Recv()
+	require.NoError(t, err)
+	assert.False(t, resp.GetSnapshot())
+	got, err := controller.MetricsFromPB(resp.GetMetrics())
+	require.NoError(t, err)
+	require.Len(t, got, 1)
+	assert.Equal(t, "cpu3", got[0].ID)
+	assert.Equal(t, models.Gauge(5), *got[0].Value)
+
+	cancel()
+	assert.Eventually(t, func() bool { return hub.Len() == 0 }, time.Second, 10*time.Millisecond)
+
+	gs, err = NewGRPCServer(NewConfig(), s, nil, nil)
+	require.NoError(t, err)
+	stream, err = serveGRPC(t, gs).Watch(context.Background(), &pb.WatchRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestQuery(t *testing.T) {
+	s := mem.NewMetricsStorage()
+	for _, host := range []string{"a", "b"} {
+		m, err := models.NewMetric("requests", models.CounterType, "5")
+		require.NoError(t, err)
+		m.Labels = map[string]string{"host": host}
+		require.NoError(t, s.Set(context.TODO(), m))
+	}
+	gs, err := NewGRPCServer(NewConfig(), s, nil, nil)
+	require.NoError(t, err)
+
+	resp, err := gs.Query(context.TODO(), &pb.QueryRequest{Query: `requests{host="a"}`})
+	require.NoError(t, err)
+	assert.Equal(t, "vector", resp.GetType())
+	require.Len(t, resp.GetSeries(), 1)
+	assert.Equal(t, "requests", resp.GetSeries()[0].GetId())
+	assert.Equal(t, pb.Metric_M_TYPE_COUNTER, resp.GetSeries()[0].GetType())
+	assert.Equal(t, map[string]string{"host": "a"}, resp.GetSeries()[0].GetLabels())
+	assert.Equal(t, 5.0, resp.GetSeries()[0].GetValue())
+
+	resp, err = gs.Query(context.TODO(), &pb.QueryRequest{Query: "sum(requests) / 2"})
+	require.NoError(t, err)
+	require.Len(t, resp.GetSeries(), 1)
+	assert.Equal(t, pb.Metric_M_TYPE_UNSPECIFIED, resp.GetSeries()[0].GetType())
+	assert.Equal(t, 5.0, resp.GetSeries()[0].GetValue())
+
+	_, err = gs.Query(context.TODO(), &pb.QueryRequest{Query: "rate(requests)"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestGRPCSecurity(t *testing.T) {
+	keyname := t.TempDir() + "/key"
+	require.NoError(t, asymcrypt.NewPem(keyname))
+	pub, err := asymcrypt.ReadPem(keyname + "_pub.pem")
+	require.NoError(t, err)
+	c := NewConfig()
+	c.SignKey = "grpc_key"
+	c.CryptoKey = keyname + "_priv.pem"
+	s := mem.NewMetricsStorage()
+	gs, err := NewGRPCServer(c, s, nil, nil)
+	require.NoError(t, err)
+	addr := listenGRPC(t, gs)
+	m, err := models.NewMetric("secure", models.GaugeType, "1")
+	require.NoError(t, err)
+
+	// подписанные и зашифрованные пачки принимаются и через поток, и через Post
+	agent := client.NewGRPC(addr, client.SetSignKey(c.SignKey), client.SetPubKey(pub))
+	t.Cleanup(func() { agent.Close() })
+	assert.NoError(t, agent.PostBatch(context.TODO(), models.Metrics{m}))
+	got, err := agent.Get(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, models.Metrics{m}, got)
+
+	wrongKey := client.NewGRPC(addr, client.SetSignKey("wrong"), client.SetPubKey(pub))
+	t.Cleanup(func() { wrongKey.Close() })
+	_, err = wrongKey.Get(context.TODO())
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Error(t, wrongKey.PostBatch(context.TODO(), models.Metrics{m}))
+
+	plain := client.NewGRPC(addr, client.SetSignKey(c.SignKey))
+	t.Cleanup(func() { plain.Close() })
+	_, err = plain.Get(context.TODO())
+	assert.Error(t, err)
+
+	// без X-Real-IP проверяется адрес соединения
+	c = NewConfig()
+	c.TrustedSubnet = "10.0.0.0/8"
+	gs, err = NewGRPCServer(c, s, nil, nil)
+	require.NoError(t, err)
+	_, err = serveGRPC(t, gs).Get(context.TODO(), &pb.GetRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	c.TrustedSubnet = "127.0.0.0/8"
+	gs, err = NewGRPCServer(c, s, nil, nil)
+	require.NoError(t, err)
+	_, err = serveGRPC(t, gs).Get(context.TODO(), &pb.GetRequest{})
+	assert.NoError(t, err)
+
+	// x-real-ip принимается только от прокси, остальные клиенты не подменяют им свой адрес
+	spoofed := metadata.AppendToOutgoingContext(context.TODO(), interceptors.RealIPMetadata, "10.0.0.1")
+	c.TrustedSubnet = "10.0.0.0/8"
+	gs, err = NewGRPCServer(c, s, nil, nil)
+	require.NoError(t, err)
+	_, err = serveGRPC(t, gs).Get(spoofed, &pb.GetRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	c.TrustedProxy = "127.0.0.0/8"
+	gs, err = NewGRPCServer(c, s, nil, nil)
+	require.NoError(t, err)
+	_, err = serveGRPC(t, gs).Get(spoofed, &pb.GetRequest{})
+	assert.NoError(t, err)
+	c.TrustedProxy = "bad"
+	_, err = NewGRPCServer(c, s, nil, nil)
+	assert.Error(t, err)
 }
@@ -5,9 +5,12 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/Nexadis/metalert/internal/auth"
 	"github.com/Nexadis/metalert/internal/models"
 	"github.com/Nexadis/metalert/internal/storage/db"
 	"github.com/Nexadis/metalert/internal/storage/mem"
@@ -18,7 +21,11 @@ import (
 func (s *httpServer) Update(w http.ResponseWriter, r *http.Request) {
 	mtype := chi.URLParam(r, "mtype")
 	id := chi.URLParam(r, "id")
-	value := chi.URLParam(r, "value")
+	value, err := url.PathUnescape(chi.URLParam(r, "value"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if id == "" {
 		http.NotFound(w, r)
 		return
@@ -28,6 +35,16 @@ func (s *httpServer) Update(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if !auth.Allowed(r.Context(), auth.Write, id) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	m.Labels = labelsFromQuery(r)
+	m.Kind = m.Labels[models.KindParam]
+	delete(m.Labels, models.KindParam)
+	if len(m.Labels) == 0 {
+		m.Labels = nil
+	}
 	err = s.storage.Set(r.Context(), m)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -51,7 +68,11 @@ func (s *httpServer) Value(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	m, err := s.storage.Get(r.Context(), mtype, id)
+	if !auth.Allowed(r.Context(), auth.Read, id) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	m, err := s.storage.Get(r.Context(), mtype, id, matchersFromQuery(r)...)
 	if errors.Is(err, mem.ErrNotFound) {
 		logger.Error(err)
 		http.NotFound(w, r)
@@ -72,11 +93,12 @@ func (s *httpServer) Value(w http.ResponseWriter, r *http.Request) {
 // Values Возвращает все значения в текстовом формате
 func (s *httpServer) Values(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-type", "text/plain")
-	values, err := s.storage.GetAll(r.Context())
+	values, err := s.storage.GetAll(r.Context(), matchersFromQuery(r)...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	values = auth.Filter(r.Context(), values)
 	var answer string
 	for _, metric := range values {
 		val, err := metric.GetValue()
@@ -84,7 +106,7 @@ func (s *httpServer) Values(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		answer = answer + fmt.Sprintf("%s=%s\n", metric.ID, val)
+		answer = answer + fmt.Sprintf("%s=%s\n", metric.Series(), val)
 	}
 	_, err = w.Write([]byte(answer))
 	if err != nil {
@@ -103,6 +125,10 @@ func (s *httpServer) UpdateJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer r.Body.Close()
+	if !auth.Allowed(r.Context(), auth.Write, m.ID) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
 	err = s.storage.Set(r.Context(), *m)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -110,10 +136,21 @@ func (s *httpServer) UpdateJSON(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Updates Обработчик для записи списка метрик в JSON-формате
+// Updates Обработчик для записи списка метрик в JSON-формате.
+// Отвечает models.BatchResult с ошибками отдельных метрик. Без параметра partial=true
+// пачка с ошибками не применяется и возвращается 400, ошибки хранилища возвращают 500
 func (s *httpServer) Updates(w http.ResponseWriter, r *http.Request) {
+	partial := false
+	if v := r.URL.Query().Get("partial"); v != "" {
+		var err error
+		partial, err = strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 	decoder := json.NewDecoder(r.Body)
-	metrics := make([]models.Metric, 0, 50)
+	metrics := make(models.Metrics, 0, 50)
 	err := decoder.Decode(&metrics)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -121,12 +158,17 @@ func (s *httpServer) Updates(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 	logger.Info("Parse metrics in Updates handler")
-	for _, m := range metrics {
-		err = s.storage.Set(r.Context(), m)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
+	result := applyBatch(r.Context(), s.storage, metrics, nil, partial)
+	w.Header().Set("Content-type", "application/json")
+	switch {
+	case retryable(result):
+		w.WriteHeader(http.StatusInternalServerError)
+	case len(result.Errors) != 0 && !partial:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		logger.Error(err)
 	}
 }
 
@@ -141,7 +183,11 @@ func (s *httpServer) ValueJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer r.Body.Close()
-	ms, err := s.storage.Get(r.Context(), m.MType, m.ID)
+	if !auth.Allowed(r.Context(), auth.Read, m.ID) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	ms, err := s.storage.Get(r.Context(), m.MType, m.ID, models.EqualMatchers(m.Labels)...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -154,12 +200,37 @@ func (s *httpServer) ValueJSON(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// InfoPage Главная страница - заглушка
-func (s *httpServer) InfoPage(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-type", "text/html")
-	_, err := w.Write([]byte("<html><h1>Info page</h1></html>"))
+// labelsFromQuery Получает метки из параметров запроса, например /update/gauge/cpu/1?host=a
+func labelsFromQuery(r *http.Request) map[string]string {
+	query := r.URL.Query()
+	if len(query) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(query))
+	for name := range query {
+		labels[name] = query.Get(name)
+	}
+	return labels
+}
+
+// matchersFromQuery Создаёт условия на точное совпадение меток из параметров запроса
+func matchersFromQuery(r *http.Request) []models.Matcher {
+	return models.EqualMatchers(labelsFromQuery(r))
+}
+
+// Alerts Возвращает список текущих оповещений в JSON-формате.
+// Оповещения строятся по любым метрикам, поэтому недоступны ключам с ограничением по префиксам
+func (s *httpServer) Alerts(w http.ResponseWriter, r *http.Request) {
+	if !auth.Unrestricted(r.Context()) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-type", "application/json")
+	encoder := json.NewEncoder(w)
+	err := encoder.Encode(s.alerts.Alerts())
 	if err != nil {
-		logger.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 }
 
@@ -2,13 +2,18 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"net/http"
 
+	"github.com/Nexadis/metalert/internal/alerting"
+	"github.com/Nexadis/metalert/internal/auth"
 	"github.com/Nexadis/metalert/internal/server/middlewares"
 	"github.com/Nexadis/metalert/internal/storage"
-	"github.com/Nexadis/metalert/internal/utils/asymcrypt"
+	"github.com/Nexadis/metalert/internal/utils/keyring"
 	"github.com/Nexadis/metalert/internal/utils/logger"
+	"github.com/Nexadis/metalert/internal/utils/verifier"
+	"github.com/Nexadis/metalert/internal/watch"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -17,66 +22,100 @@ type httpServer struct {
 	router     http.Handler
 	storage    storage.Storage
 	config     *Config
-	privKey    []byte
+	keys       *keyring.Keyring
 	trustedNet *net.IPNet
+	tlsConfig  *tls.Config
+	auth       *auth.Authenticator
+	guard      *verifier.ReplayGuard
+	alerts     *alerting.Engine
+	hub        *watch.Hub
+	updated    *updateTimes
 }
 
-func NewHTTPServer(config *Config, storage storage.Storage) (*httpServer, error) {
-	var err error
-	var key []byte
-	if config.CryptoKey != "" {
-		key, err = asymcrypt.ReadPem(config.CryptoKey)
-		if err != nil {
-			return nil, err
-		}
+func NewHTTPServer(config *Config, storage storage.Storage, alerts *alerting.Engine, hub *watch.Hub) (*httpServer, error) {
+	keys, trusted, err := loadSecurity(config)
+	if err != nil {
+		return nil, err
 	}
-	var trusted *net.IPNet = nil
-	if config.TrustedSubnet != "" {
-		_, trusted, err = net.ParseCIDR(config.TrustedSubnet)
-		if err != nil {
-			return nil, err
-		}
+	tlsConfig, err := loadTLS(config)
+	if err != nil {
+		return nil, err
+	}
+	authenticator, err := loadAuth(config, storage)
+	if err != nil {
+		return nil, err
+	}
+	guard, err := loadGuard(config, keys)
+	if err != nil {
+		return nil, err
 	}
 	httpserver := &httpServer{
 		nil,
 		storage,
 		config,
-		key,
+		keys,
 		trusted,
+		tlsConfig,
+		authenticator,
+		guard,
+		alerts,
+		hub,
+		newUpdateTimes(),
 	}
+	storage.OnSet(httpserver.updated.Notify)
 	httpserver.MountHandlers()
 	return httpserver, nil
 }
 
-// MountHandlers Подключает все обработчики и middlewares к роутеру
+// MountHandlers Подключает все обработчики и middlewares к роутеру.
+// Панель и проверка базы доступны без ключа, остальные маршруты требуют права чтения или записи.
+// В строгом режиме маршруты записи требуют подпись со временем и nonce
 func (s *httpServer) MountHandlers() {
 	router := chi.NewRouter()
 	router.Route("/", func(r chi.Router) {
 		r.Get("/", s.InfoPage)
-		r.Post("/updates/", s.Updates)
-		r.Route("/update", func(r chi.Router) {
-			r.Post("/", s.UpdateJSON)
-			r.Post("/{mtype}/{id}/{value}", s.Update)
+		r.Handle("/dashboard/*", http.StripPrefix("/dashboard", http.FileServer(dashboardFS())))
+		r.Get("/ping", s.DBPing)
+		r.Group(func(r chi.Router) {
+			r.Use(middlewares.RequireScope(s.auth, auth.Read))
+			r.Get("/api/v1/metrics", s.MetricsInfo)
+			r.Post("/api/v1/query", s.Query)
+			r.Get("/api/v1/rollups/{mtype}/{id}", s.Rollups)
+			r.Route("/value", func(r chi.Router) {
+				r.Get("/", s.Values)
+				r.Post("/", s.ValueJSON)
+				r.Get("/{mtype}/{id}", s.Value)
+			})
+			r.Get("/alerts", s.Alerts)
+			r.Get("/metrics", s.Metrics)
+			r.Get("/stream", s.Stream)
 		})
-		r.Route("/value", func(r chi.Router) {
-			r.Get("/", s.Values)
-			r.Post("/", s.ValueJSON)
-			r.Get("/{mtype}/{id}", s.Value)
+		r.Group(func(r chi.Router) {
+			r.Use(middlewares.RequireScope(s.auth, auth.Write))
+			r.Use(middlewares.RequireSigned(s.config.SignStrict))
+			r.Post("/updates/", s.Updates)
+			r.Route("/update", func(r chi.Router) {
+				r.Post("/", s.UpdateJSON)
+				r.Post("/{mtype}/{id}/{value}", s.Update)
+			})
 		})
-		r.Get("/ping", s.DBPing)
 	})
 
 	s.router = middlewares.WithTrusted(
-		middlewares.WithDeflate(
-			middlewares.WithDecrypt(
-				middlewares.WithLogging(
-					middlewares.WithVerify(
-						router,
-						s.config.SignKey,
+		middlewares.WithAuth(
+			middlewares.WithDeflate(
+				middlewares.WithDecrypt(
+					middlewares.WithLogging(
+						middlewares.WithVerify(
+							router,
+							s.keys,
+							s.guard,
+						),
 					),
+					s.keys,
 				),
-				s.privKey,
 			),
+			s.auth,
 		),
 		s.trustedNet,
 	)
@@ -88,6 +127,9 @@ func (s *httpServer) Run(ctx context.Context) error {
 		return err
 	}
 	defer l.Close()
+	if s.tlsConfig != nil {
+		l = tls.NewListener(l, s.tlsConfig)
+	}
 	go func() {
 		logger.Info("HTTP server at ", s.config.Address)
 		err = http.Serve(l, s.router)
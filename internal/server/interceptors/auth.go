@@ -0,0 +1,75 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/Nexadis/metalert/internal/auth"
+	"github.com/Nexadis/metalert/internal/utils/logger"
+)
+
+// AuthUnary Проверяет API-ключ из метаданных authorization и право, нужное методу по scopes.
+// Методы, которых нет в scopes, требуют auth.Admin. Ключ сохраняется в контексте запроса
+func AuthUnary(a *auth.Authenticator, scopes map[string]auth.Scope) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := authorize(ctx, a, scope(scopes, info.FullMethod))
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// AuthStream Проверяет API-ключ при открытии потока, как AuthUnary
+func AuthStream(a *auth.Authenticator, scopes map[string]auth.Scope) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authorize(ss.Context(), a, scope(scopes, info.FullMethod))
+		if err != nil {
+			return err
+		}
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = ctx
+		return handler(srv, wrapped)
+	}
+}
+
+func scope(scopes map[string]auth.Scope, method string) auth.Scope {
+	if s, ok := scopes[method]; ok {
+		return s
+	}
+	return auth.Admin
+}
+
+func authorize(ctx context.Context, a *auth.Authenticator, scope auth.Scope) (context.Context, error) {
+	if !a.Enabled() {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, auth.ErrNoKey.Error())
+	}
+	token, ok := auth.ParseBearer(values[0])
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, auth.ErrNoKey.Error())
+	}
+	k, err := a.Authenticate(ctx, token)
+	if errors.Is(err, auth.ErrInvalidKey) || errors.Is(err, auth.ErrNoKey) {
+		logger.Info("Authentication failed:", err)
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !k.Has(scope) {
+		logger.Info("Key", k.Name, "has no scope", scope)
+		return nil, status.Error(codes.PermissionDenied, auth.ErrForbidden.Error())
+	}
+	return auth.NewContext(ctx, k), nil
+}
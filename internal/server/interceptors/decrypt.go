@@ -0,0 +1,54 @@
+package interceptors
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/Nexadis/metalert/internal/utils/keyring"
+)
+
+// Decrypt Возвращает опцию сервера, которая расшифровывает каждое входящее сообщение,
+// и унарное, и сообщения потоков, приватным ключом сервера. Унарные запросы расшифровываются
+// до перехватчиков, сообщения потоков - при чтении из потока, то есть после перехватчиков открытия.
+// Кодек не видит метаданных, поэтому пробуются все приватные ключи связки keys.
+// Сообщения должны быть конвертами asymcrypt.Encrypt, незашифрованные сообщения отклоняются.
+// Пока в связке нет приватных ключей, сообщения принимаются без расшифровки
+func Decrypt(keys *keyring.Keyring) grpc.ServerOption {
+	return grpc.ForceServerCodec(decryptCodec{keys})
+}
+
+// decryptCodec - Кодек protobuf, расшифровывающий входящие сообщения. Ответы не шифруются
+type decryptCodec struct {
+	keys *keyring.Keyring
+}
+
+func (c decryptCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("can't marshal %T", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (c decryptCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("can't unmarshal %T", v)
+	}
+	// ключи проверяются на каждом сообщении, так как связка перечитывается по SIGHUP
+	if !c.keys.Decrypts() {
+		return proto.Unmarshal(data, m)
+	}
+	decrypted, err := c.keys.Decrypt("", data)
+	if err != nil {
+		return fmt.Errorf("decrypt: %w", err)
+	}
+	return proto.Unmarshal(decrypted, m)
+}
+
+// Name Совпадает с именем стандартного кодека, чтобы клиенты не меняли content-type
+func (c decryptCodec) Name() string {
+	return "proto"
+}
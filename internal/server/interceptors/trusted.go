@@ -0,0 +1,97 @@
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/Nexadis/metalert/internal/utils/logger"
+)
+
+// RealIPMetadata - Ключ метаданных с адресом агента, аналог заголовка X-Real-IP
+const RealIPMetadata = "x-real-ip"
+
+// TrustedUnary Отклоняет запросы не из доверенной подсети network. nil - принимаются все запросы.
+// Адресу из метаданных RealIPMetadata верится только в соединениях из подсети прокси proxy
+func TrustedUnary(network, proxy *net.IPNet) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		err := checkTrusted(ctx, network, proxy)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// TrustedStream Отклоняет потоки не из доверенной подсети network. nil - принимаются все потоки.
+// Адресу из метаданных RealIPMetadata верится только в соединениях из подсети прокси proxy
+func TrustedStream(network, proxy *net.IPNet) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := checkTrusted(ss.Context(), network, proxy)
+		if err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func checkTrusted(ctx context.Context, network, proxy *net.IPNet) error {
+	if network == nil {
+		return nil
+	}
+	ip, err := peerIP(ctx, proxy)
+	if err != nil {
+		logger.Error(err)
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if !network.Contains(ip) {
+		logger.Error(fmt.Sprintf("Request from %s Rejected", ip))
+		return status.Error(codes.PermissionDenied, "invalid IP")
+	}
+	return nil
+}
+
+// peerIP Возвращает адрес соединения. Если соединение пришло из подсети прокси proxy,
+// возвращается адрес агента из метаданных RealIPMetadata, которые проставляет прокси.
+// Остальным клиентам метаданные не подменяют адрес, иначе любой клиент выдал бы себя за доверенный
+func peerIP(ctx context.Context, proxy *net.IPNet) (net.IP, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return nil, errors.New("unknown peer")
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+	ip, err := parseIP(host)
+	if err != nil {
+		return nil, err
+	}
+	if proxy == nil || !proxy.Contains(ip) {
+		return ip, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	if addrs := md.Get(RealIPMetadata); len(addrs) != 0 {
+		return parseIP(addrs[0])
+	}
+	return ip, nil
+}
+
+// parseIP Разбирает адрес. Агент передаёт адрес вместе с маской подсети, как и в X-Real-IP
+func parseIP(addr string) (net.IP, error) {
+	if ip, _, err := net.ParseCIDR(addr); err == nil {
+		return ip, nil
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q", addr)
+	}
+	return ip, nil
+}
@@ -0,0 +1,151 @@
+// interceptors реализует проверки gRPC-запросов: подпись, расшифровку и доверенную подсеть
+package interceptors
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/Nexadis/metalert/internal/utils/keyring"
+	"github.com/Nexadis/metalert/internal/utils/logger"
+	"github.com/Nexadis/metalert/internal/utils/verifier"
+)
+
+// Ошибки работы с подписью
+var (
+	ErrNoHash      = errors.New("no hash")
+	ErrInvalidHash = errors.New("invalid hash")
+)
+
+// VerifyUnary Проверяет подпись запроса из метаданных verifier.HashMetadata.
+// Подписываются время и nonce из метаданных вместе с детерминированно сериализованным запросом,
+// см. verifier.SignMessage. Ключ выбирается из связки keys по keyring.KeyIDMetadata.
+// guard отклоняет устаревшие и повторные запросы
+func VerifyUnary(keys *keyring.Keyring, guard *verifier.ReplayGuard) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !keys.Signs() {
+			return handler(ctx, req)
+		}
+		m, ok := req.(proto.Message)
+		if !ok {
+			return nil, status.Errorf(codes.Internal, "can't sign %T", req)
+		}
+		err := checkSignature(ctx, keys, guard, func(timestamp, nonce string, key []byte) (string, error) {
+			return verifier.SignMessage(m, timestamp, nonce, key)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// VerifyStream Проверяет подпись при открытии потока. Метаданные передаются один раз на поток,
+// поэтому при открытии подписываются время, nonce и полное имя метода, а каждое принятое сообщение
+// проверяется отдельно по подписи в поле verifier.HashField, см. verifier.SignStreamMessage
+func VerifyStream(keys *keyring.Keyring, guard *verifier.ReplayGuard) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !keys.Signs() {
+			return handler(srv, ss)
+		}
+		err := checkSignature(ss.Context(), keys, guard, func(timestamp, nonce string, key []byte) (string, error) {
+			return verifier.SignRequest([]byte(info.FullMethod), timestamp, nonce, key)
+		})
+		if err != nil {
+			return err
+		}
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		return handler(srv, &verifiedStream{
+			ServerStream: ss,
+			keys:         keys,
+			keyID:        first(md, keyring.KeyIDMetadata),
+			timestamp:    first(md, verifier.TimestampMetadata),
+			nonce:        first(md, verifier.NonceMetadata),
+		})
+	}
+}
+
+// verifiedStream - Поток, проверяющий подпись каждого принятого сообщения временем и nonce потока
+type verifiedStream struct {
+	grpc.ServerStream
+	keys      *keyring.Keyring
+	keyID     string
+	timestamp string
+	nonce     string
+	received  uint64 // количество проверенных сообщений, RecvMsg не вызывается одновременно
+}
+
+func (s *verifiedStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+	pm, ok := m.(proto.Message)
+	if !ok {
+		return status.Errorf(codes.Internal, "can't verify %T", m)
+	}
+	got, err := verifier.StreamHash(pm)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if got == "" {
+		return status.Error(codes.Unauthenticated, ErrNoHash.Error())
+	}
+	ok, err = s.keys.Match(s.keyID, got, func(key []byte) (string, error) {
+		return verifier.SignStreamMessage(pm, s.timestamp, s.nonce, s.received, key)
+	})
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if !ok {
+		logger.Info(ErrInvalidHash.Error(), got)
+		return status.Error(codes.Unauthenticated, ErrInvalidHash.Error())
+	}
+	s.received++
+	return nil
+}
+
+func checkSignature(ctx context.Context, keys *keyring.Keyring, guard *verifier.ReplayGuard, sign func(timestamp, nonce string, key []byte) (string, error)) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	got := first(md, verifier.HashMetadata)
+	if got == "" {
+		return status.Error(codes.Unauthenticated, ErrNoHash.Error())
+	}
+	timestamp := first(md, verifier.TimestampMetadata)
+	nonce := first(md, verifier.NonceMetadata)
+	ok, err := keys.Match(first(md, keyring.KeyIDMetadata), got, func(key []byte) (string, error) {
+		return sign(timestamp, nonce, key)
+	})
+	if errors.Is(err, keyring.ErrUnknownKey) {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !ok {
+		logger.Info(ErrInvalidHash.Error(), got)
+		return status.Error(codes.Unauthenticated, ErrInvalidHash.Error())
+	}
+	if guard != nil {
+		err = guard.Check(timestamp, nonce, time.Now())
+		if err != nil {
+			logger.Info("Rejected request:", err)
+			return status.Error(codes.Unauthenticated, err.Error())
+		}
+	}
+	return nil
+}
+
+func first(md metadata.MD, key string) string {
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Nexadis/metalert/internal/agent/client"
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage/mem"
+	"github.com/Nexadis/metalert/internal/utils/asymcrypt"
+	"github.com/Nexadis/metalert/internal/utils/keyring"
+	"github.com/Nexadis/metalert/internal/utils/verifier"
+)
+
+func TestKeyRotation(t *testing.T) {
+	path := t.TempDir() + "/keyring.json"
+	f := keyring.File{
+		Current:  "k2",
+		SignKeys: map[string]string{"k1": "old", "k2": "new"},
+	}
+	require.NoError(t, f.Write(path))
+	c := NewConfig()
+	c.Keyring = path
+	server, err := NewHTTPServer(c, mem.NewMetricsStorage(), nil, nil)
+	require.NoError(t, err)
+	body := `{"id":"cpu","type":"gauge","value":1}`
+	send := func(method, target, body, id, key string) *httptest.ResponseRecorder {
+		signature, err := verifier.SignString([]byte(body), []byte(key))
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set(verifier.HashHeader, signature)
+		if id != "" {
+			r.Header.Set(keyring.KeyIDHeader, id)
+		}
+		server.router.ServeHTTP(w, r)
+		return w
+	}
+	do := func(id, key string) *httptest.ResponseRecorder {
+		return send(http.MethodPost, "/update/", body, id, key)
+	}
+
+	assert.Equal(t, http.StatusOK, do("k1", "old").Code)
+	assert.Equal(t, http.StatusOK, do("", "old").Code)
+
+	// ответ подписан текущим ключом
+	w := send(http.MethodGet, "/value/gauge/cpu", "", "k1", "old")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "k2", w.Header().Get(keyring.KeyIDHeader))
+	signature, err := verifier.SignString(w.Body.Bytes(), []byte("new"))
+	require.NoError(t, err)
+	assert.Equal(t, signature, w.Header().Get(verifier.HashHeader))
+
+	assert.Equal(t, http.StatusBadRequest, do("k2", "old").Code)
+	assert.Equal(t, http.StatusBadRequest, do("k3", "old").Code)
+
+	// выведенный из связки ключ перестаёт приниматься после перезагрузки
+	f.SignKeys = map[string]string{"k2": "new"}
+	require.NoError(t, f.Write(path))
+	require.NoError(t, server.keys.Reload())
+	assert.Equal(t, http.StatusBadRequest, do("k1", "old").Code)
+	assert.Equal(t, http.StatusOK, do("k2", "new").Code)
+
+	gs, err := NewGRPCServer(c, mem.NewMetricsStorage(), nil, nil)
+	require.NoError(t, err)
+	addr := listenGRPC(t, gs)
+	agent := client.NewGRPC(addr, client.SetSignKey("new"), client.SetKeyID("k2"))
+	t.Cleanup(func() { agent.Close() })
+	assert.NoError(t, agent.PostBatch(context.TODO(), models.Metrics{gaugeMetric(t, "cpu")}))
+	stale := client.NewGRPC(addr, client.SetSignKey("old"), client.SetKeyID("k1"))
+	t.Cleanup(func() { stale.Close() })
+	_, err = stale.Get(context.TODO())
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestKeyRotationDecrypt(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/keyring.json"
+	f := keyring.File{
+		Current:  "k1",
+		SignKeys: map[string]string{"k1": "key"},
+	}
+	require.NoError(t, f.Write(path))
+	c := NewConfig()
+	c.Keyring = path
+	gs, err := NewGRPCServer(c, mem.NewMetricsStorage(), nil, nil)
+	require.NoError(t, err)
+	addr := listenGRPC(t, gs)
+	batch := models.Metrics{gaugeMetric(t, "cpu")}
+	plain := client.NewGRPC(addr, client.SetSignKey("key"), client.SetKeyID("k1"))
+	t.Cleanup(func() { plain.Close() })
+	assert.NoError(t, plain.PostBatch(context.TODO(), batch))
+
+	// приватный ключ, добавленный в связку, начинает действовать без перезапуска сервера
+	require.NoError(t, asymcrypt.NewPemID(dir+"/k2", "k2"))
+	f.CryptoKeys = []string{dir + "/k2_priv.pem"}
+	require.NoError(t, f.Write(path))
+	require.NoError(t, gs.keys.Reload())
+	assert.Error(t, plain.PostBatch(context.TODO(), batch))
+	pub, err := asymcrypt.ReadPem(dir + "/k2_pub.pem")
+	require.NoError(t, err)
+	encrypted := client.NewGRPC(addr, client.SetSignKey("key"), client.SetKeyID("k1"), client.SetPubKey(pub))
+	t.Cleanup(func() { encrypted.Close() })
+	assert.NoError(t, encrypted.PostBatch(context.TODO(), batch))
+}
@@ -0,0 +1,66 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Nexadis/metalert/internal/auth"
+	"github.com/Nexadis/metalert/internal/utils/logger"
+)
+
+// WithAuth Проверяет API-ключ из заголовка Authorization: Bearer <token> и сохраняет его в контексте запроса.
+// Запрос без заголовка проходит без ключа, права на маршруты проверяет RequireScope
+func WithAuth(h http.Handler, a *auth.Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !a.Enabled() || header == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		token, ok := auth.ParseBearer(header)
+		if !ok {
+			unauthorized(w, auth.ErrNoKey)
+			return
+		}
+		k, err := a.Authenticate(r.Context(), token)
+		if err != nil {
+			logger.Info("Authentication failed:", err)
+			unauthorized(w, err)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), k)))
+	})
+}
+
+// RequireScope Пропускает только запросы с ключом, у которого есть право scope.
+// Используется на группах маршрутов chi, при выключенной проверке пропускает все запросы
+func RequireScope(a *auth.Authenticator, scope auth.Scope) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !a.Enabled() {
+				h.ServeHTTP(w, r)
+				return
+			}
+			k, ok := auth.FromContext(r.Context())
+			if !ok {
+				unauthorized(w, auth.ErrNoKey)
+				return
+			}
+			if !k.Has(scope) {
+				logger.Info("Key", k.Name, "has no scope", scope)
+				http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+func unauthorized(w http.ResponseWriter, err error) {
+	if !errors.Is(err, auth.ErrNoKey) && !errors.Is(err, auth.ErrInvalidKey) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, err.Error(), http.StatusUnauthorized)
+}
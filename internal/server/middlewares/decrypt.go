@@ -2,16 +2,20 @@ package middlewares
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"net/http"
 
-	"github.com/Nexadis/metalert/internal/utils/asymcrypt"
+	"github.com/Nexadis/metalert/internal/utils/keyring"
 	"github.com/Nexadis/metalert/internal/utils/logger"
 )
 
-func WithDecrypt(h http.Handler, privKey []byte) http.Handler {
+// WithDecrypt Расшифровывает тело запроса приватным ключом сервера из связки keys,
+// ключ выбирается по keyring.CryptoKeyIDHeader.
+// Тело должно быть конвертом asymcrypt.Encrypt, поэтому размер пачки метрик не ограничен размером ключа
+func WithDecrypt(h http.Handler, keys *keyring.Keyring) http.Handler {
 	decrypt := func(w http.ResponseWriter, r *http.Request) {
-		if privKey == nil {
+		if !keys.Decrypts() {
 			logger.Info("No key, no decrypt")
 			h.ServeHTTP(w, r)
 			return
@@ -22,13 +26,20 @@ func WithDecrypt(h http.Handler, privKey []byte) http.Handler {
 			return
 		}
 		defer r.Body.Close()
+		// запросы без тела, например GET, расшифровывать нечего
 		if len(body) == 0 {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			h.ServeHTTP(w, r)
 			return
 		}
 		logger.Info("Begin Decrypt")
-		decrypted, err := asymcrypt.Decrypt(body, privKey)
+		decrypted, err := keys.Decrypt(r.Header.Get(keyring.CryptoKeyIDHeader), body)
 		if err != nil {
 			logger.Error(err)
+			if errors.Is(err, keyring.ErrUnknownKey) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -0,0 +1,58 @@
+package middlewares
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Nexadis/metalert/internal/utils/asymcrypt"
+	"github.com/Nexadis/metalert/internal/utils/keyring"
+)
+
+func TestWithDecrypt(t *testing.T) {
+	keyname := os.TempDir() + "/decrypt-key"
+	require.NoError(t, asymcrypt.NewPem(keyname))
+	pub, err := asymcrypt.ReadPem(keyname + "_pub.pem")
+	require.NoError(t, err)
+	priv, err := asymcrypt.ReadPem(keyname + "_priv.pem")
+	require.NoError(t, err)
+
+	body := bytes.Repeat([]byte(`{"id":"name","type":"gauge","value":123.123},`), 1000)
+	encrypted, err := asymcrypt.Encrypt(body, pub)
+	require.NoError(t, err)
+
+	keys, err := keyring.New("", "", priv)
+	require.NoError(t, err)
+	h := WithDecrypt(http.HandlerFunc(EmptyHandler), keys)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(encrypted))
+	h.ServeHTTP(w, r)
+	result := w.Result()
+	defer result.Body.Close()
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	got, err := io.ReadAll(result.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, body, got)
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(encrypted))
+	r.Header.Set(keyring.CryptoKeyIDHeader, "unknown")
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// запросы без тела передаются обработчику без расшифровки
+	get := WithDecrypt(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("metrics"))
+	}), keys)
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	get.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "metrics", w.Body.String())
+}
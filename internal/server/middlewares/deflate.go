@@ -3,7 +3,6 @@ package middlewares
 
 import (
 	"compress/gzip"
-	"io"
 	"net/http"
 	"strings"
 
@@ -24,13 +23,30 @@ func canEncode(r *http.Request, algorithm string) bool {
 
 type compressWriter struct {
 	http.ResponseWriter
-	Writer io.Writer
+	Writer *gzip.Writer
 }
 
 func (c *compressWriter) Write(data []byte) (int, error) {
 	return c.Writer.Write(data)
 }
 
+// Flush Отправляет клиенту всё, что уже сжато, не дожидаясь конца ответа.
+// Нужен для потоковых ответов, например /stream
+func (c *compressWriter) Flush() {
+	err := c.Writer.Flush()
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *compressWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
 // WithDeflate() Сжимает тело запроса с помощью gzip
 func WithDeflate(h http.Handler) http.Handler {
 	deflate := func(w http.ResponseWriter, r *http.Request) {
@@ -43,6 +43,17 @@ func (lw *logWrite) Header() http.Header {
 	return lw.w.Header()
 }
 
+// Flush Передаёт Flush исходному writer, чтобы логирование не мешало потоковым ответам
+func (lw *logWrite) Flush() {
+	if f, ok := lw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (lw *logWrite) Unwrap() http.ResponseWriter {
+	return lw.w
+}
+
 // WithLogging() Логирует информацию о запросе
 // Method
 // Status
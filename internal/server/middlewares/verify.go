@@ -2,13 +2,16 @@ package middlewares
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"time"
 
+	"github.com/Nexadis/metalert/internal/utils/keyring"
 	"github.com/Nexadis/metalert/internal/utils/logger"
 	"github.com/Nexadis/metalert/internal/utils/verifier"
 )
@@ -23,23 +26,43 @@ var (
 type verifiedWriter struct {
 	http.ResponseWriter
 	Writer io.Writer
-	key    string
+	id     string
+	key    []byte
 }
 
-// Write Подписывает данные и создает заголовок с подписью
+// Write Подписывает данные и создает заголовки с подписью и ID ключа
 func (vw *verifiedWriter) Write(data []byte) (int, error) {
-	signature, err := verifier.Sign(data, []byte(vw.key))
+	signature, err := verifier.Sign(data, vw.key)
 	if err != nil {
 		return 0, err
 	}
 	vw.Header().Set(verifier.HashHeader, base64.StdEncoding.EncodeToString(signature))
+	if vw.id != "" {
+		vw.Header().Set(keyring.KeyIDHeader, vw.id)
+	}
 	return vw.Writer.Write(data)
 }
 
-// WithVerify Middleware для подписи body запроса
-func WithVerify(h http.Handler, signKey string) http.HandlerFunc {
+// Flush Передаёт Flush исходному writer
+func (vw *verifiedWriter) Flush() {
+	if f, ok := vw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (vw *verifiedWriter) Unwrap() http.ResponseWriter {
+	return vw.ResponseWriter
+}
+
+// WithVerify Middleware для подписи body запроса.
+// Если запрос содержит verifier.TimestampHeader и verifier.NonceHeader, подпись покрывает их,
+// метод, путь и строку запроса, см. verifier.HTTPRequest,
+// а guard отклоняет устаревшие и повторные запросы. Запрос без подписи проходит дальше,
+// его отклоняет RequireSigned на маршрутах записи в строгом режиме.
+// Ключ подписи выбирается по keyring.KeyIDHeader, ответ подписывается текущим ключом связки
+func WithVerify(h http.Handler, keys *keyring.Keyring, guard *verifier.ReplayGuard) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if signKey == "" {
+		if !keys.Signs() {
 			h.ServeHTTP(w, r)
 			return
 		}
@@ -57,26 +80,67 @@ func WithVerify(h http.Handler, signKey string) http.HandlerFunc {
 		defer r.Body.Close()
 		newBody := io.NopCloser(bytes.NewBuffer(body))
 		r.Body = newBody
-		signature, err := verifier.Sign(body, []byte(signKey))
+		timestamp := r.Header.Get(verifier.TimestampHeader)
+		nonce := r.Header.Get(verifier.NonceHeader)
+		fresh := timestamp != "" || nonce != ""
+		ok, err := keys.Match(r.Header.Get(keyring.KeyIDHeader), gotSignature, func(key []byte) (string, error) {
+			if fresh {
+				data := verifier.HTTPRequest(r.Method, r.URL.Path, r.URL.RawQuery, body)
+				return verifier.SignRequest(data, timestamp, nonce, key)
+			}
+			return verifier.SignString(body, key)
+		})
+		if errors.Is(err, keyring.ErrUnknownKey) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			http.Error(w, fmt.Errorf(ErrorCheckHash, err).Error(), http.StatusInternalServerError)
 			return
 		}
-		strSignature := base64.StdEncoding.EncodeToString(signature)
-
-		if gotSignature != strSignature {
-			logger.Info(ErrorInvalidHash.Error(), gotSignature+"!="+strSignature)
+		if !ok {
+			logger.Info(ErrorInvalidHash.Error(), gotSignature)
 			http.Error(w, ErrorInvalidHash.Error(), http.StatusBadRequest)
 			return
 		}
+		// nonce запоминается только после проверки подписи, чтобы чужие запросы не вытесняли настоящие
+		if fresh && guard != nil {
+			err = guard.Check(timestamp, nonce, time.Now())
+			if err != nil {
+				logger.Info("Rejected request:", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 		logger.Info("Signature is good")
+		id, key := keys.Current()
 		w = &verifiedWriter{
 			ResponseWriter: w,
 			Writer:         w,
-			key:            signKey,
+			id:             id,
+			key:            key,
 		}
 
-		h.ServeHTTP(w, r)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), signedContext{}, fresh)))
+	}
+}
+
+type signedContext struct{}
+
+// RequireSigned В строгом режиме пропускает только запросы, подписанные вместе со временем и nonce.
+// Используется на группах маршрутов chi, которые изменяют метрики
+func RequireSigned(strict bool) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strict {
+				fresh, _ := r.Context().Value(signedContext{}).(bool)
+				if !fresh {
+					http.Error(w, verifier.ErrNoSignature.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			h.ServeHTTP(w, r)
+		})
 	}
 }
 
@@ -6,6 +6,8 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/Nexadis/metalert/internal/utils/keyring"
 )
 
 func EmptyHandler(w http.ResponseWriter, r *http.Request) {
@@ -14,8 +16,11 @@ func EmptyHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func BenchmarkWithVerify(b *testing.B) {
-	signKey := "TestKey"
-	verifier := WithVerify(http.HandlerFunc(EmptyHandler), signKey)
+	keys, err := keyring.New("", "TestKey", nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	verifier := WithVerify(http.HandlerFunc(EmptyHandler), keys, nil)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		b.StopTimer()
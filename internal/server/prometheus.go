@@ -0,0 +1,204 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Nexadis/metalert/internal/auth"
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/utils/logger"
+)
+
+// PrometheusContentType - Тип содержимого для текстового формата Prometheus 0.0.4
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metrics Возвращает все метрики в текстовом формате Prometheus
+func (s *httpServer) Metrics(w http.ResponseWriter, r *http.Request) {
+	values, err := s.storage.GetAll(r.Context(), matchersFromQuery(r)...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	values = auth.Filter(r.Context(), values)
+	body, err := renderPrometheus(values)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-type", PrometheusContentType)
+	_, err = w.Write([]byte(body))
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
+// renderPrometheus Формирует текст в формате Prometheus, метрики упорядочены по имени после приведения,
+// серии одной метрики идут подряд под общим описанием типа
+func renderPrometheus(ms models.Metrics) (string, error) {
+	sorted := make(models.Metrics, len(ms))
+	copy(sorted, ms)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].ID != sorted[j].ID {
+			return sorted[i].ID < sorted[j].ID
+		}
+		return typeOrder[sorted[i].MType] < typeOrder[sorted[j].MType]
+	})
+	names := familyNames(sorted)
+	rendered := make([]family, 0, len(sorted))
+	for _, m := range sorted {
+		name := names[familyKey{m.ID, m.MType}]
+		if name == "" {
+			continue
+		}
+		rendered = append(rendered, family{name, m})
+	}
+	sort.SliceStable(rendered, func(i, j int) bool {
+		if rendered[i].name != rendered[j].name {
+			return rendered[i].name < rendered[j].name
+		}
+		return rendered[i].metric.Series() < rendered[j].metric.Series()
+	})
+	var prev string
+	var b strings.Builder
+	for _, f := range rendered {
+		m := f.metric
+		val, err := m.GetValue()
+		if err != nil {
+			return "", err
+		}
+		if f.name != prev {
+			fmt.Fprintf(&b, "# TYPE %s %s\n", f.name, m.MType)
+			prev = f.name
+		}
+		if m.MType == models.HistogramType {
+			renderHistogram(&b, f.name, m)
+			continue
+		}
+		fmt.Fprintf(&b, "%s%s %s\n", f.name, renderLabels(m.Labels), val)
+	}
+	return b.String(), nil
+}
+
+// familyKey - Метрика, которой выдаётся имя в формате Prometheus
+type familyKey struct {
+	id, mtype string
+}
+
+// family - Серия с именем в формате Prometheus
+type family struct {
+	name   string
+	metric models.Metric
+}
+
+// familyNames Выдаёт метрикам отсортированного набора имена в формате Prometheus.
+// Имя достаётся первой метрике, у которой оно получилось, причём метрики с уже допустимым ID идут первыми,
+// метрики другого типа с тем же именем получают суффикс типа. Метрики, чьё имя всё равно занято,
+// например a.b после a-b, получают пустое имя и пропускаются, чтобы не выводить одну серию дважды
+func familyNames(sorted models.Metrics) map[familyKey]string {
+	names := make(map[familyKey]string, len(sorted))
+	owners := make(map[string]familyKey, len(sorted))
+	assign := func(m models.Metric) {
+		key := familyKey{m.ID, m.MType}
+		if _, ok := names[key]; ok {
+			return
+		}
+		name := sanitizeName(m.ID)
+		if owner, ok := owners[name]; ok && owner.mtype != m.MType {
+			name += typeSuffix[m.MType]
+		}
+		if owner, ok := owners[name]; ok && owner != key {
+			logger.Info(fmt.Sprintf("Metric %q of type %s skipped: name %s is taken by %q", m.ID, m.MType, name, owner.id))
+			names[key] = ""
+			return
+		}
+		owners[name] = key
+		names[key] = name
+	}
+	for _, m := range sorted {
+		if sanitizeName(m.ID) == m.ID {
+			assign(m)
+		}
+	}
+	for _, m := range sorted {
+		assign(m)
+	}
+	return names
+}
+
+// Порядок типов и суффиксы имён для метрик разных типов с одинаковым именем
+var (
+	typeOrder = map[string]int{
+		models.GaugeType:     0,
+		models.CounterType:   1,
+		models.HistogramType: 2,
+	}
+	typeSuffix = map[string]string{
+		models.CounterType:   "_total",
+		models.HistogramType: "_histogram",
+	}
+)
+
+// renderHistogram Формирует серии _bucket с накопленными значениями, _sum и _count
+func renderHistogram(b *strings.Builder, name string, m models.Metric) {
+	h := m.Histogram
+	labels := make(map[string]string, len(m.Labels)+1)
+	for k, v := range m.Labels {
+		labels[k] = v
+	}
+	var cumulative uint64
+	for i, c := range h.Counts {
+		cumulative += c
+		le := "+Inf"
+		if i < len(h.Bounds) {
+			le = strconv.FormatFloat(h.Bounds[i], 'f', -1, 64)
+		}
+		labels["le"] = le
+		fmt.Fprintf(b, "%s_bucket%s %d\n", name, renderLabels(labels), cumulative)
+	}
+	fmt.Fprintf(b, "%s_sum%s %s\n", name, renderLabels(m.Labels), strconv.FormatFloat(h.Sum, 'f', -1, 64))
+	fmt.Fprintf(b, "%s_count%s %d\n", name, renderLabels(m.Labels), h.Count)
+}
+
+// renderLabels Формирует набор меток {a="1",b="2"} с экранированием значений
+func renderLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", name, labelEscaper.Replace(labels[name]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sanitizeName Приводит имя метрики к виду [a-zA-Z_:][a-zA-Z0-9_:]*
+func sanitizeName(id string) string {
+	if id == "" {
+		return "_"
+	}
+	b := []byte(id)
+	for i, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
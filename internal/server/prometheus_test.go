@@ -0,0 +1,165 @@
+package server
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := map[string]string{
+		"Alloc":           "Alloc",
+		"CPUUtilization1": "CPUUtilization1",
+		"http.requests":   "http_requests",
+		"1st-metric":      "_st_metric",
+		"ns:name":         "ns:name",
+		"":                "_",
+	}
+	for id, want := range tests {
+		assert.Equal(t, want, sanitizeName(id))
+	}
+}
+
+func TestPrometheus(t *testing.T) {
+	server := testServer()
+	ctx := context.TODO()
+	for _, m := range []struct{ id, mtype, value string }{
+		{"b.gauge", models.GaugeType, "1.5"},
+		{"a", models.CounterType, "3"},
+		{"a", models.GaugeType, "-2"},
+		{"PollCount", models.CounterType, "10"},
+	} {
+		metric, err := models.NewMetric(m.id, m.mtype, m.value)
+		require.NoError(t, err)
+		require.NoError(t, server.storage.Set(ctx, metric))
+	}
+	want := "# TYPE PollCount counter\n" +
+		"PollCount 10\n" +
+		"# TYPE a gauge\n" +
+		"a -2\n" +
+		"# TYPE a_total counter\n" +
+		"a_total 3\n" +
+		"# TYPE b_gauge gauge\n" +
+		"b_gauge 1.5\n"
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, r)
+	result := w.Result()
+	defer result.Body.Close()
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, PrometheusContentType, result.Header.Get("Content-type"))
+	body, err := io.ReadAll(result.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, want, string(body))
+
+	r = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, r)
+	result = w.Result()
+	defer result.Body.Close()
+	assert.Equal(t, "gzip", result.Header.Get("Content-Encoding"))
+	g, err := gzip.NewReader(result.Body)
+	require.NoError(t, err)
+	body, err = io.ReadAll(g)
+	assert.NoError(t, err)
+	assert.Equal(t, want, string(body))
+}
+
+func TestPrometheusNameCollision(t *testing.T) {
+	gauge := func(id, value string, labels map[string]string) models.Metric {
+		m, err := models.NewMetric(id, models.GaugeType, value)
+		require.NoError(t, err)
+		m.Labels = labels
+		return m
+	}
+	got, err := renderPrometheus(models.Metrics{
+		gauge("a.b", "3", nil),
+		gauge("a_c", "5", nil),
+		gauge("a-b", "1", map[string]string{"host": "x"}),
+		gauge("a-b", "2", nil),
+		gauge("a-c", "4", nil),
+	})
+	require.NoError(t, err)
+	// имя a_b достаётся первой по порядку метрике a-b, a.b с тем же именем пропускается,
+	// а имя a_c - метрике a_c, у которой оно совпадает с ID
+	want := "# TYPE a_b gauge\n" +
+		"a_b 2\n" +
+		"a_b{host=\"x\"} 1\n" +
+		"# TYPE a_c gauge\n" +
+		"a_c 5\n"
+	assert.Equal(t, want, got)
+}
+
+func TestLabels(t *testing.T) {
+	server := testServer()
+	for _, url := range []string{
+		"/update/gauge/cpu/0.5?host=a",
+		"/update/gauge/cpu/0.7?host=b",
+		"/update/counter/requests/2?host=a&path=%22%2F%22",
+	} {
+		r := httptest.NewRequest(http.MethodPost, url, nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, r)
+		result := w.Result()
+		result.Body.Close()
+		require.Equal(t, http.StatusOK, result.StatusCode)
+	}
+	get := func(url string) string {
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, r)
+		result := w.Result()
+		defer result.Body.Close()
+		body, err := io.ReadAll(result.Body)
+		assert.NoError(t, err)
+		return string(body)
+	}
+	assert.Equal(t, "0.7", get("/value/gauge/cpu?host=b"))
+	assert.Equal(t, "# TYPE cpu gauge\n"+
+		"cpu{host=\"a\"} 0.5\n"+
+		"cpu{host=\"b\"} 0.7\n"+
+		"# TYPE requests counter\n"+
+		"requests{host=\"a\",path=\"\\\"/\\\"\"} 2\n", get("/metrics"))
+	assert.Equal(t, "cpu{host=\"b\"}=0.7\n", get("/value/?host=b"))
+}
+
+func TestPrometheusHistogram(t *testing.T) {
+	server := testServer()
+	r := httptest.NewRequest(http.MethodPost, "/update/histogram/latency/"+url.PathEscape("0.1,1;2,1,1;3.65")+"?host=a", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, r)
+	result := w.Result()
+	result.Body.Close()
+	require.Equal(t, http.StatusOK, result.StatusCode)
+	m, err := models.NewMetric("latency", models.GaugeType, "1")
+	require.NoError(t, err)
+	require.NoError(t, server.storage.Set(context.TODO(), m))
+
+	body, err := renderPrometheus(mustGetAll(t, server))
+	assert.NoError(t, err)
+	assert.Equal(t, "# TYPE latency gauge\n"+
+		"latency 1\n"+
+		"# TYPE latency_histogram histogram\n"+
+		"latency_histogram_bucket{host=\"a\",le=\"0.1\"} 2\n"+
+		"latency_histogram_bucket{host=\"a\",le=\"1\"} 3\n"+
+		"latency_histogram_bucket{host=\"a\",le=\"+Inf\"} 4\n"+
+		"latency_histogram_sum{host=\"a\"} 3.65\n"+
+		"latency_histogram_count{host=\"a\"} 4\n", body)
+}
+
+func mustGetAll(t *testing.T, server *httpServer) models.Metrics {
+	ms, err := server.storage.GetAll(context.TODO())
+	require.NoError(t, err)
+	return ms
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Nexadis/metalert/internal/auth"
+	"github.com/Nexadis/metalert/internal/query"
+	"github.com/Nexadis/metalert/internal/utils/logger"
+)
+
+// QueryRequest - Тело запроса POST /api/v1/query
+type QueryRequest struct {
+	Query string     `json:"query"`
+	Time  *time.Time `json:"time,omitempty"` // момент, на который выполняется запрос, если не задан - текущие значения
+}
+
+// Query Выполняет запрос на языке выражений и возвращает результат в JSON-формате.
+// Выражения читают любые метрики, поэтому недоступны ключам с ограничением по префиксам
+func (s *httpServer) Query(w http.ResponseWriter, r *http.Request) {
+	if !auth.Unrestricted(r.Context()) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	var req QueryRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var at time.Time
+	if req.Time != nil {
+		at = *req.Time
+	}
+	result, err := query.New(s.storage).Query(r.Context(), req.Query, at)
+	if errors.Is(err, query.ErrInvalidQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-type", "application/json")
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		logger.Error(err)
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/query"
+)
+
+func TestQueryAPI(t *testing.T) {
+	server := testServer()
+	for id, value := range map[string]string{"Alloc": "300", "TotalAlloc": "400"} {
+		m, err := models.NewMetric(id, models.GaugeType, value)
+		require.NoError(t, err)
+		require.NoError(t, server.storage.Set(context.TODO(), m))
+	}
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1/query", strings.NewReader(body))
+		server.router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := post(`{"query": "Alloc / TotalAlloc * 100"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-type"))
+	var result query.Result
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, query.ResultVector, result.Type)
+	require.Len(t, result.Series, 1)
+	assert.Equal(t, 75.0, result.Series[0].Value)
+
+	w = post(`{"query": "max(Alloc) + 1", "time": "2023-01-01T00:00:00Z"}`)
+	require.Equal(t, http.StatusOK, w.Code)
+	result = query.Result{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Empty(t, result.Series)
+
+	assert.Equal(t, http.StatusBadRequest, post(`{"query": "Alloc +"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post(`not json`).Code)
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/Nexadis/metalert/internal/agent/client"
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage/mem"
+	"github.com/Nexadis/metalert/internal/utils/verifier"
+	pb "github.com/Nexadis/metalert/proto/metrics/v1"
+)
+
+func strictConfig() *Config {
+	c := NewConfig()
+	c.SignKey = "strict_key"
+	c.SignStrict = true
+	return c
+}
+
+func TestSignStrict(t *testing.T) {
+	c := strictConfig()
+	server, err := NewHTTPServer(c, mem.NewMetricsStorage(), nil, nil)
+	require.NoError(t, err)
+	body := `{"id":"cpu","type":"gauge","value":1}`
+	do := func(headers map[string]string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		server.router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, do(nil))
+	legacy, err := verifier.SignString([]byte(body), []byte(c.SignKey))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, do(map[string]string{verifier.HashHeader: legacy}))
+
+	ts, nonce, err := verifier.NewNonce()
+	require.NoError(t, err)
+	data := verifier.HTTPRequest(http.MethodPost, "/update/", "", []byte(body))
+	signature, err := verifier.SignRequest(data, ts, nonce, []byte(c.SignKey))
+	require.NoError(t, err)
+	signed := map[string]string{
+		verifier.TimestampHeader: ts,
+		verifier.NonceHeader:     nonce,
+		verifier.HashHeader:      signature,
+	}
+	assert.Equal(t, http.StatusOK, do(signed))
+	assert.Equal(t, http.StatusBadRequest, do(signed))
+
+	// чтение не требует подписи
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/value/gauge/cpu", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// подпись REST-запроса покрывает путь и метки, изменённый адрес отклоняется
+	rest := func(target string, signed string) int {
+		u, err := url.Parse(signed)
+		require.NoError(t, err)
+		ts, nonce, err := verifier.NewNonce()
+		require.NoError(t, err)
+		data := verifier.HTTPRequest(http.MethodPost, u.Path, u.RawQuery, nil)
+		signature, err := verifier.SignRequest(data, ts, nonce, []byte(c.SignKey))
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, target, nil)
+		r.Header.Set(verifier.TimestampHeader, ts)
+		r.Header.Set(verifier.NonceHeader, nonce)
+		r.Header.Set(verifier.HashHeader, signature)
+		server.router.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, rest("/update/gauge/cpu/1?host=a", "/update/gauge/cpu/1?host=a"))
+	assert.Equal(t, http.StatusBadRequest, rest("/update/gauge/cpu/2?host=a", "/update/gauge/cpu/1?host=a"))
+	assert.Equal(t, http.StatusBadRequest, rest("/update/counter/cpu/1?host=a", "/update/gauge/cpu/1?host=a"))
+	assert.Equal(t, http.StatusBadRequest, rest("/update/gauge/cpu/1?host=b", "/update/gauge/cpu/1?host=a"))
+
+	// клиенты агента подписывают запросы так же, как проверяет сервер
+	hs := httptest.NewServer(server.router)
+	defer hs.Close()
+	addr := strings.TrimPrefix(hs.URL, "http://")
+	m := gaugeMetric(t, "labeled")
+	m.Labels = map[string]string{"host": "a"}
+	assert.NoError(t, client.NewREST(addr, client.SetSignKey(c.SignKey)).Post(context.TODO(), m))
+	assert.NoError(t, client.NewJSON(addr, client.SetSignKey(c.SignKey)).Post(context.TODO(), m))
+
+	c = NewConfig()
+	c.SignStrict = true
+	_, err = NewHTTPServer(c, mem.NewMetricsStorage(), nil, nil)
+	assert.Error(t, err)
+}
+
+func TestGRPCReplay(t *testing.T) {
+	c := strictConfig()
+	gs, err := NewGRPCServer(c, mem.NewMetricsStorage(), nil, nil)
+	require.NoError(t, err)
+	addr := listenGRPC(t, gs)
+
+	agent := client.NewGRPC(addr, client.SetSignKey(c.SignKey))
+	t.Cleanup(func() { agent.Close() })
+	assert.NoError(t, agent.PostBatch(context.TODO(), models.Metrics{gaugeMetric(t, "cpu")}))
+
+	// повтор запроса с тем же nonce отклоняется
+	req := &pb.GetRequest{}
+	ts, nonce, err := verifier.NewNonce()
+	require.NoError(t, err)
+	signature, err := verifier.SignMessage(req, ts, nonce, []byte(c.SignKey))
+	require.NoError(t, err)
+	ctx := metadata.AppendToOutgoingContext(context.TODO(),
+		verifier.TimestampMetadata, ts,
+		verifier.NonceMetadata, nonce,
+		verifier.HashMetadata, signature,
+	)
+	raw := serveGRPC(t, gs)
+	_, err = raw.Get(ctx, req)
+	assert.NoError(t, err)
+	_, err = raw.Get(ctx, req)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestGRPCStreamSignature(t *testing.T) {
+	c := strictConfig()
+	gs, err := NewGRPCServer(c, mem.NewMetricsStorage(), nil, nil)
+	require.NoError(t, err)
+	addr := listenGRPC(t, gs)
+
+	agent := client.NewGRPC(addr, client.SetSignKey(c.SignKey))
+	t.Cleanup(func() { agent.Close() })
+	assert.NoError(t, agent.PostBatch(context.TODO(), models.Metrics{gaugeMetric(t, "cpu")}))
+
+	raw := serveGRPC(t, gs)
+	key := []byte(c.SignKey)
+	gauge := func(value string) *pb.Metrics {
+		return &pb.Metrics{Metrics: []*pb.Metric{{Id: "cpu", Type: pb.Metric_M_TYPE_GAUGE, Value: value}}}
+	}
+	open := func(t *testing.T) (pb.MetricsCollectorService_PostStreamClient, string, string) {
+		ts, nonce, err := verifier.NewNonce()
+		require.NoError(t, err)
+		signature, err := verifier.SignRequest([]byte(pb.MetricsCollectorService_PostStream_FullMethodName), ts, nonce, key)
+		require.NoError(t, err)
+		ctx := metadata.AppendToOutgoingContext(context.TODO(),
+			verifier.TimestampMetadata, ts,
+			verifier.NonceMetadata, nonce,
+			verifier.HashMetadata, signature,
+		)
+		stream, err := raw.PostStream(ctx)
+		require.NoError(t, err)
+		return stream, ts, nonce
+	}
+	sign := func(t *testing.T, req *pb.PostStreamRequest, ts, nonce string, n uint64) {
+		hash, err := verifier.SignStreamMessage(req, ts, nonce, n, key)
+		require.NoError(t, err)
+		require.NoError(t, verifier.SetStreamHash(req, hash))
+	}
+
+	t.Run("tampered metrics", func(t *testing.T) {
+		stream, ts, nonce := open(t)
+		req := &pb.PostStreamRequest{Seq: 1, Metrics: gauge("1")}
+		sign(t, req, ts, nonce, 0)
+		req.Metrics = gauge("100")
+		require.NoError(t, stream.Send(req))
+		_, err := stream.Recv()
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+	t.Run("no hash", func(t *testing.T) {
+		stream, _, _ := open(t)
+		require.NoError(t, stream.Send(&pb.PostStreamRequest{Seq: 1, Metrics: gauge("1")}))
+		_, err := stream.Recv()
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+	t.Run("replayed message", func(t *testing.T) {
+		stream, ts, nonce := open(t)
+		req := &pb.PostStreamRequest{Seq: 1, Metrics: gauge("1")}
+		sign(t, req, ts, nonce, 0)
+		require.NoError(t, stream.Send(req))
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Empty(t, resp.GetError())
+		// подпись первого сообщения не подходит ко второму
+		require.NoError(t, stream.Send(req))
+		_, err = stream.Recv()
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+}
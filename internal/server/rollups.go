@@ -0,0 +1,62 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/Nexadis/metalert/internal/auth"
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage/mem"
+	"github.com/Nexadis/metalert/internal/utils/logger"
+)
+
+// Rollups Возвращает агрегаты истории метрики в JSON-формате.
+// Параметры запроса: resolution - шаг уровня хранения, from и to - промежуток в RFC 3339,
+// по умолчанию последние сутки. Остальные параметры задают метки серии
+func (s *httpServer) Rollups(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	resolution, err := time.ParseDuration(query.Get("resolution"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to := time.Now()
+	from := to.Add(-24 * time.Hour)
+	for name, t := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := query.Get(name); v != "" {
+			*t, err = time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if !auth.Allowed(r.Context(), auth.Read, chi.URLParam(r, "id")) {
+		http.Error(w, auth.ErrForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	labels := labelsFromQuery(r)
+	for _, name := range []string{"resolution", "from", "to"} {
+		delete(labels, name)
+	}
+	rollups, err := s.storage.GetRollups(r.Context(), chi.URLParam(r, "mtype"), chi.URLParam(r, "id"),
+		resolution, from, to, models.EqualMatchers(labels)...)
+	if errors.Is(err, mem.ErrNotFound) || errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-type", "application/json")
+	err = json.NewEncoder(w).Encode(rollups)
+	if err != nil {
+		logger.Error(err)
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage/mem"
+	"github.com/Nexadis/metalert/internal/storage/retention"
+)
+
+func TestRollups(t *testing.T) {
+	server := testServer()
+	storage := mem.NewMetricsStorage()
+	mem.Configure(storage, mem.SetRetention(retention.DefaultPolicy))
+	server.storage = storage
+	ctx := context.TODO()
+	now := time.Now()
+	for _, value := range []string{"1", "5"} {
+		m, err := models.NewMetric("Alloc", models.GaugeType, value)
+		require.NoError(t, err)
+		m.Labels = map[string]string{"host": "a"}
+		require.NoError(t, storage.Set(ctx, m))
+	}
+	require.NoError(t, storage.Compact(ctx, now.Add(2*time.Hour)))
+
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		server.router.ServeHTTP(w, r)
+		return w
+	}
+	w := get("/api/v1/rollups/gauge/Alloc?resolution=1h&host=a")
+	require.Equal(t, http.StatusOK, w.Code)
+	var rollups models.Rollups
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rollups))
+	require.Len(t, rollups, 1)
+	assert.Equal(t, 1.0, rollups[0].Min)
+	assert.Equal(t, 5.0, rollups[0].Max)
+	assert.Equal(t, 5.0, rollups[0].Last)
+
+	assert.Equal(t, http.StatusBadRequest, get("/api/v1/rollups/gauge/Alloc?resolution=hour").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/api/v1/rollups/gauge/Alloc?resolution=1h&from=yesterday").Code)
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/rollups/gauge/Alloc?resolution=5m").Code)
+	assert.Equal(t, http.StatusNotFound, get("/api/v1/rollups/gauge/Unknown?resolution=1h").Code)
+}
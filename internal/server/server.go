@@ -3,14 +3,32 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/Nexadis/metalert/internal/alerting"
+	"github.com/Nexadis/metalert/internal/auth"
 	"github.com/Nexadis/metalert/internal/storage"
+	"github.com/Nexadis/metalert/internal/utils/asymcrypt"
+	"github.com/Nexadis/metalert/internal/utils/keyring"
+	"github.com/Nexadis/metalert/internal/utils/logger"
+	"github.com/Nexadis/metalert/internal/utils/tlsconfig"
+	"github.com/Nexadis/metalert/internal/utils/verifier"
+	"github.com/Nexadis/metalert/internal/watch"
+	"github.com/Nexadis/metalert/internal/webhook"
 	"golang.org/x/sync/errgroup"
 )
 
 type Server struct {
-	h *httpServer
-	g *grpcServer
+	h        *httpServer
+	g        *grpcServer
+	alerts   *alerting.Engine
+	webhooks *webhook.Dispatcher
 }
 
 // Run Запуск сервера
@@ -22,10 +40,41 @@ func (s *Server) Run(ctx context.Context) error {
 	group.Go(func() error {
 		return s.g.Run(ctx)
 	})
+	group.Go(func() error {
+		return s.alerts.Run(ctx)
+	})
+	group.Go(func() error {
+		return s.webhooks.Run(ctx)
+	})
+	group.Go(func() error {
+		return s.reloadKeys(ctx)
+	})
 
 	return group.Wait()
 }
 
+// reloadKeys Перечитывает связки ключей HTTP и gRPC серверов по SIGHUP.
+// При ошибке в файле связки серверы продолжают работать с прежними ключами
+func (s *Server) reloadKeys(ctx context.Context) error {
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-reload:
+			logger.Info("Reload keyring")
+			for _, keys := range []*keyring.Keyring{s.h.keys, s.g.keys} {
+				err := keys.Reload()
+				if err != nil {
+					logger.Error("Reload keyring:", err)
+				}
+			}
+		}
+	}
+}
+
 // New Конструктор Server, для инциализации использует Config
 func New(config *Config) (*Server, error) {
 	var err error
@@ -37,18 +86,102 @@ func New(config *Config) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	httpserver, err := NewHTTPServer(config, storage)
+	alerts, err := alerting.New(storage, config.Alerts)
 	if err != nil {
 		return nil, err
 	}
+	hub := watch.New()
+	storage.OnSet(hub.Notify)
+	httpserver, err := NewHTTPServer(config, storage, alerts, hub)
+	if err != nil {
+		return nil, err
+	}
+	// вебхуки подписываются ключами HTTP сервера, которые перечитываются по SIGHUP
+	webhooks, err := webhook.New(config.Webhooks, httpserver.keys)
+	if err != nil {
+		return nil, err
+	}
+	if webhooks.Len() != 0 {
+		storage.OnSet(webhooks.Notify)
+	}
 
-	grpcserver, err := NewGRPCServer(config, storage)
+	grpcserver, err := NewGRPCServer(config, storage, alerts, hub)
 	if err != nil {
 		return nil, err
 	}
 	server := Server{
 		httpserver,
 		grpcserver,
+		alerts,
+		webhooks,
 	}
 	return &server, nil
 }
+
+// loadSecurity Читает связку ключей подписи и расшифровки и доверенную подсеть из конфигурации.
+// Пустые значения в конфигурации отключают соответствующую проверку
+func loadSecurity(config *Config) (*keyring.Keyring, *net.IPNet, error) {
+	var err error
+	var key []byte
+	if config.CryptoKey != "" {
+		key, err = asymcrypt.ReadPem(config.CryptoKey)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	keys, err := keyring.New(config.Keyring, config.SignKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	var trusted *net.IPNet
+	if config.TrustedSubnet != "" {
+		_, trusted, err = net.ParseCIDR(config.TrustedSubnet)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return keys, trusted, nil
+}
+
+// loadProxy Возвращает подсеть прокси, которым gRPC-сервер верит адрес агента из метаданных. nil - не задана
+func loadProxy(config *Config) (*net.IPNet, error) {
+	if config.TrustedProxy == "" {
+		return nil, nil
+	}
+	_, proxy, err := net.ParseCIDR(config.TrustedProxy)
+	if err != nil {
+		return nil, err
+	}
+	return proxy, nil
+}
+
+// loadTLS Возвращает настройки TLS для HTTP и gRPC серверов, nil - сертификат не задан и TLS выключен
+func loadTLS(config *Config) (*tls.Config, error) {
+	if config.TLSCert == "" && config.TLSKey == "" && config.TLSClientCA == "" {
+		return nil, nil
+	}
+	return tlsconfig.Server(config.TLSCert, config.TLSKey, config.TLSClientCA, config.TLSMinVersion)
+}
+
+// loadAuth Создаёт проверку API-ключей из конфигурации. С APIKeysDB ключи дополнительно
+// ищутся в хранилище, поэтому оно должно реализовывать auth.Store
+func loadAuth(config *Config, s storage.Storage) (*auth.Authenticator, error) {
+	var store auth.Store
+	if config.APIKeysDB {
+		var ok bool
+		store, ok = s.(auth.Store)
+		if !ok {
+			return nil, errors.New("api keys in database need postgres storage")
+		}
+	}
+	return auth.New(config.APIKeys, store)
+}
+
+// loadGuard Создаёт защиту от повтора подписанных запросов. Строгий режим без ключа подписи
+// отклонял бы все записи, поэтому считается ошибкой конфигурации
+func loadGuard(config *Config, keys *keyring.Keyring) (*verifier.ReplayGuard, error) {
+	if config.SignStrict && !keys.Signs() {
+		return nil, errors.New("strict signatures need sign key")
+	}
+	return verifier.NewReplayGuard(time.Duration(config.SignSkew)*time.Second, config.NonceCache), nil
+}
@@ -16,6 +16,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/Nexadis/metalert/internal/models"
 	"github.com/Nexadis/metalert/internal/storage"
@@ -224,6 +225,12 @@ func testServer() *httpServer {
 		config,
 		nil,
 		nil,
+		nil,
+		nil,
+		verifier.NewReplayGuard(0, 0),
+		nil,
+		nil,
+		newUpdateTimes(),
 	}
 	server.MountHandlers()
 	return server
@@ -630,6 +637,37 @@ func TestUpdatesJSON(t *testing.T) {
 			}
 		})
 	}
+
+	// пачка с неверной метрикой не применяется частично
+	body := `[{"id":"partial","type":"gauge","value":1},{"id":"ctr","type":"counter"}]`
+	r := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	_, err := server.storage.Get(ctx, models.GaugeType, "partial")
+	assert.Error(t, err)
+	var result models.BatchResult
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, 0, result.Accepted)
+	require.Len(t, result.Errors, 1)
+	assert.Equal(t, models.ItemError{
+		Index:   1,
+		ID:      "ctr",
+		Code:    models.CodeInvalidValue,
+		Message: models.ErrorMetrics.Error(),
+	}, result.Errors[0])
+
+	// с частичным приёмом верные метрики записываются
+	r = httptest.NewRequest(http.MethodPost, "/updates/?partial=true", strings.NewReader(body))
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	result = models.BatchResult{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &result))
+	assert.Equal(t, 1, result.Accepted)
+	assert.Len(t, result.Errors, 1)
+	_, err = server.storage.Get(ctx, models.GaugeType, "partial")
+	assert.NoError(t, err)
 }
 
 func BenchmarkUpdateURL(b *testing.B) {
@@ -812,3 +850,23 @@ func ExamplehttpServer_ValueJSON() {
 	// Output:
 	// 200 OK
 }
+
+func TestUpdateURLKind(t *testing.T) {
+	server := testServer()
+	for _, value := range []string{"10", "15"} {
+		r := httptest.NewRequest(http.MethodPost, "/update/counter/PollCount/"+value+"?kind=cumulative&host=a", nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	// накопленное значение не прибавляется повторно, а kind не становится меткой
+	m, err := server.storage.Get(context.TODO(), models.CounterType, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, models.Counter(15), *m.Delta)
+	assert.Equal(t, map[string]string{"host": "a"}, m.Labels)
+
+	r := httptest.NewRequest(http.MethodPost, "/update/counter/PollCount/1?kind=unknown", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Nexadis/metalert/internal/auth"
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/utils/logger"
+	"github.com/Nexadis/metalert/internal/watch"
+)
+
+// StreamPing - Интервал комментариев, поддерживающих соединение /stream, когда изменений нет
+var StreamPing = 15 * time.Second
+
+// События /stream
+const (
+	EventSnapshot = "snapshot" // текущее значение метрики на момент подключения
+	EventMetric   = "metric"   // принятое изменение метрики
+)
+
+// Stream Отправляет изменения метрик как Server-Sent Events.
+//
+// Параметры запроса type и id ограничивают тип и префикс имени метрик.
+// При подключении без Last-Event-ID сначала отправляются текущие значения метрик.
+// С Last-Event-ID отправляются сохранённые изменения после него, а если они уже вытеснены - снова текущие значения
+func (s *httpServer) Stream(w http.ResponseWriter, r *http.Request) {
+	if s.hub == nil {
+		http.Error(w, "stream is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	filter := watch.Filter{
+		MType:  r.URL.Query().Get("type"),
+		Prefix: r.URL.Query().Get("id"),
+	}
+	switch filter.MType {
+	case "", models.GaugeType, models.CounterType, models.HistogramType:
+	default:
+		http.Error(w, fmt.Sprintf("%v: %v", models.ErrorType, filter.MType), http.StatusBadRequest)
+		return
+	}
+
+	var sub *watch.Subscription
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		id, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		sub, err = s.hub.Resume(filter, watch.DefaultBuffer, id)
+		if err != nil {
+			logger.Info("Can't resume stream:", err)
+		}
+	}
+	snapshot := sub == nil
+	if snapshot {
+		sub = s.hub.Subscribe(filter, watch.DefaultBuffer)
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	ctx := r.Context()
+	if snapshot {
+		metrics, err := s.storage.GetAll(ctx)
+		if err != nil {
+			logger.Error(err)
+			return
+		}
+		for _, m := range metrics {
+			if !filter.Match(m) || !auth.Allowed(ctx, auth.Read, m.ID) {
+				continue
+			}
+			err = writeEvent(w, EventSnapshot, sub.Seq(), m)
+			if err != nil {
+				logger.Error(err)
+				return
+			}
+		}
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(StreamPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ping.C:
+			_, err := io.WriteString(w, ": ping\n\n")
+			if err != nil {
+				return
+			}
+		case e, ok := <-sub.Updates():
+			if !ok {
+				logger.Info("Stream closed:", sub.Err())
+				return
+			}
+			if !auth.Allowed(ctx, auth.Read, e.Metric.ID) {
+				continue
+			}
+			err := writeEvent(w, EventMetric, e.ID, e.Metric)
+			if err != nil {
+				logger.Error(err)
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent Записывает метрику как событие SSE
+func writeEvent(w io.Writer, event string, id uint64, m models.Metric) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, data)
+	return err
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage/mem"
+	"github.com/Nexadis/metalert/internal/watch"
+)
+
+type event struct {
+	id     string
+	name   string
+	metric models.Metric
+}
+
+// readEvent Читает следующее событие SSE, пропуская комментарии
+func readEvent(t *testing.T, r *bufio.Reader) event {
+	var e event
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if e.name != "" {
+				return e
+			}
+		case strings.HasPrefix(line, "id: "):
+			e.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			e.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e.metric))
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	s := mem.NewMetricsStorage()
+	hub := watch.New()
+	s.OnSet(hub.Notify)
+	hs, err := NewHTTPServer(NewConfig(), s, nil, hub)
+	require.NoError(t, err)
+	ts := httptest.NewServer(hs.router)
+	defer ts.Close()
+
+	ctx := context.Background()
+	set := func(id, mtype, value string) {
+		m, err := models.NewMetric(id, mtype, value)
+		require.NoError(t, err)
+		require.NoError(t, s.Set(ctx, m))
+	}
+	set("cpu1", models.GaugeType, "1")
+	set("mem", models.GaugeType, "1")
+
+	open := func(lastID string) (*http.Response, *bufio.Reader) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/stream?type=gauge&id=cpu", nil)
+		require.NoError(t, err)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		return resp, bufio.NewReader(resp.Body)
+	}
+
+	// клиент запрашивает gzip, поэтому события проходят и через сжатие
+	resp, r := open("")
+	e := readEvent(t, r)
+	assert.Equal(t, EventSnapshot, e.name)
+	assert.Equal(t, "2", e.id)
+	assert.Equal(t, "cpu1", e.metric.ID)
+
+	set("mem", models.GaugeType, "2")
+	set("cpu2", models.CounterType, "1")
+	set("cpu2", models.GaugeType, "3")
+	e = readEvent(t, r)
+	assert.Equal(t, EventMetric, e.name)
+	assert.Equal(t, "5", e.id)
+	assert.Equal(t, "cpu2", e.metric.ID)
+	resp.Body.Close()
+
+	set("cpu1", models.GaugeType, "4")
+	resp, r = open("5")
+	defer resp.Body.Close()
+	e = readEvent(t, r)
+	assert.Equal(t, EventMetric, e.name)
+	assert.Equal(t, "6", e.id)
+	assert.Equal(t, "cpu1", e.metric.ID)
+
+	resp, err = http.Get(ts.URL + "/stream?type=unknown")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Eventually(t, func() bool { return hub.Len() == 1 }, time.Second, 10*time.Millisecond)
+}
@@ -5,6 +5,8 @@ import (
 
 	"github.com/caarlos0/env/v8"
 
+	"github.com/Nexadis/metalert/internal/storage/mem"
+	"github.com/Nexadis/metalert/internal/storage/retention"
 	"github.com/Nexadis/metalert/internal/utils/logger"
 )
 
@@ -16,6 +18,14 @@ type Config struct {
 	DSN             string `env:"DATABASE_DSN" json:"db_dsn,omitempty"`           // Адрес БД
 	Retry           int    `env:"DATABASE_CONN_RETRY" json:"db_conn_retries,omitempty"`
 	Timeout         int    `env:"DATABASE_TIMEOUT" json:"db_timeout,omitempty"`
+	HistorySize     int    `env:"HISTORY_SIZE" json:"history_size,omitempty"` // количество значений в истории каждой метрики для inmemory хранилища
+	// уровни хранения истории, например raw:24h,1m:30d,1h:365d
+	Retention       retention.Policy `env:"RETENTION" json:"retention,omitempty"`
+	CompactInterval int64            `env:"COMPACT_INTERVAL" json:"compact_interval,omitempty"`   // интервал сворачивания истории в секундах
+	Snapshots       int              `env:"SNAPSHOTS" json:"snapshots,omitempty"`                 // количество хранимых поколений файла с метриками
+	WAL             string           `env:"WAL_DIR" json:"wal_dir,omitempty"`                     // каталог журнала предзаписи inmemory хранилища, по умолчанию журнал отключён
+	WALSync         string           `env:"WAL_SYNC" json:"wal_sync,omitempty"`                   // политика синхронизации журнала: always, batch или interval
+	WALSyncInterval int64            `env:"WAL_SYNC_INTERVAL" json:"wal_sync_interval,omitempty"` // интервал синхронизации журнала в миллисекундах для политики interval
 }
 
 func NewConfig() *Config {
@@ -29,6 +39,13 @@ var (
 	DefaultDSN             = ""
 	DefaultRetry           = 3
 	DefaultTimeout         = 2
+	DefaultHistorySize     = mem.DefaultHistorySize
+	DefaultRetention       = retention.DefaultPolicy
+	DefaultCompactInterval = int64(60)
+	DefaultSnapshots       = mem.DefaultSnapshots
+	DefaultWAL             = ""
+	DefaultWALSync         = string(mem.SyncBatch)
+	DefaultWALSyncInterval = int64(1000)
 )
 
 func (c *Config) ParseCmd(set *flag.FlagSet) {
@@ -38,6 +55,14 @@ func (c *Config) ParseCmd(set *flag.FlagSet) {
 	set.StringVar(&c.DSN, "d", DefaultDSN, "DSN for DB")
 	set.IntVar(&c.Retry, "rc", DefaultRetry, "number of repeated attempts to connect to DB")
 	set.IntVar(&c.Timeout, "to", DefaultTimeout, "timeout in seconds to connect to DB")
+	set.IntVar(&c.HistorySize, "history-size", DefaultHistorySize, "number of samples kept in memory for each metric")
+	c.Retention = DefaultRetention
+	set.Var(&c.Retention, "retention", "history retention tiers, e.g. raw:24h,1m:30d,1h:365d")
+	set.Int64Var(&c.CompactInterval, "compact-interval", DefaultCompactInterval, "interval in seconds between history compactions")
+	set.IntVar(&c.Snapshots, "snapshots", DefaultSnapshots, "number of metrics file generations kept on disk")
+	set.StringVar(&c.WAL, "wal", DefaultWAL, "directory for write-ahead log of in mem storage, disabled by default")
+	set.StringVar(&c.WALSync, "wal-sync", DefaultWALSync, "write-ahead log sync policy: always, batch or interval")
+	set.Int64Var(&c.WALSyncInterval, "wal-sync-interval", DefaultWALSyncInterval, "write-ahead log sync interval in milliseconds for interval policy")
 	logger.Info("Parse command flags:",
 		"\nStore Interval", c.StoreInterval,
 		"\nFile Storage Path", c.FileStoragePath,
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Nexadis/metalert/internal/auth"
+)
+
+// LookupKey Находит API-ключ по хешу токена в таблице api_keys
+func (db *DB) LookupKey(ctx context.Context, hash string) (auth.Key, error) {
+	k := auth.Key{Hash: hash}
+	var scopes, prefixes []byte
+	found := true
+	err := db.retry(func() error {
+		err := db.db.QueryRowContext(ctx,
+			`SELECT name, scopes, prefixes FROM api_keys WHERE hash=$1`, hash,
+		).Scan(&k.Name, &scopes, &prefixes)
+		if errors.Is(err, sql.ErrNoRows) {
+			found = false
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return auth.Key{}, err
+	}
+	if !found {
+		return auth.Key{}, auth.ErrInvalidKey
+	}
+	err = json.Unmarshal(scopes, &k.Scopes)
+	if err != nil {
+		return auth.Key{}, fmt.Errorf("key %s: %w", k.Name, err)
+	}
+	err = json.Unmarshal(prefixes, &k.Prefixes)
+	if err != nil {
+		return auth.Key{}, fmt.Errorf("key %s: %w", k.Name, err)
+	}
+	return k, k.Check()
+}
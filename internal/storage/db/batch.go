@@ -0,0 +1,115 @@
+package db
+
+import (
+	"encoding/json"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+// series - Серия пачки, в которую сложены все её значения из пачки по порядку
+type series struct {
+	metric models.Metric // последнее значение серии, для counter в Delta сумма приращений
+	labels string        // метки в JSON для столбца labels
+
+	first, last *models.Counter // первое и последнее накопленное значение counter
+
+	histogram *models.Histogram // гистограммы серии, сложенные по порядку
+	replaced  bool              // границы гистограммы менялись в пачке, сохранённая не учитывается
+}
+
+// key Возвращает ключ серии: тип, имя и метки
+func key(m models.Metric) string {
+	return m.MType + "/" + m.Series()
+}
+
+// mergeBatch Объединяет повторы серий в пачке, чтобы записать каждую серию одной строкой.
+// Накопленные значения counter после первого прибавляются разницей с предыдущим,
+// а после сброса счётчика целиком, как при отдельных Set
+func mergeBatch(ms models.Metrics) ([]*series, error) {
+	merged := make([]*series, 0, len(ms))
+	index := make(map[string]*series, len(ms))
+	for _, m := range ms {
+		err := m.Validate()
+		if err != nil {
+			return nil, err
+		}
+		if m.MType == models.HistogramType {
+			if m.Histogram == nil {
+				return nil, models.ErrorMetrics
+			}
+			err = m.Histogram.Check()
+			if err != nil {
+				return nil, err
+			}
+		}
+		k := key(m)
+		s, ok := index[k]
+		if !ok {
+			s = &series{}
+			s.labels, err = encodeLabels(m.Labels)
+			if err != nil {
+				return nil, err
+			}
+			index[k] = s
+			merged = append(merged, s)
+		}
+		s.add(m, ok)
+	}
+	return merged, nil
+}
+
+// add Добавляет к серии следующее значение m. seen - у серии уже есть значения из пачки
+func (s *series) add(m models.Metric, seen bool) {
+	prev := s.metric.Delta
+	s.metric = m
+	s.metric.Delta = prev
+	switch m.MType {
+	case models.CounterType:
+		delta := *m.Delta
+		if m.IsCumulative() {
+			if s.first == nil {
+				s.first = m.Delta
+			} else if delta >= *s.last {
+				delta -= *s.last
+			}
+			s.last = m.Delta
+		}
+		if prev != nil {
+			delta += *prev
+		}
+		s.metric.Delta = &delta
+	case models.HistogramType:
+		h := m.Histogram.Copy()
+		if seen {
+			if merged, ok := s.histogram.Merge(h); ok {
+				h = merged
+			} else {
+				s.replaced = true
+			}
+		}
+		s.histogram = &h
+	}
+}
+
+// mergeStored Складывает гистограммы серии с сохранённой, если у них совпадают границы
+func (s *series) mergeStored(stored *models.Histogram) {
+	if s.histogram == nil || stored == nil || s.replaced {
+		return
+	}
+	if merged, ok := stored.Merge(*s.histogram); ok {
+		s.histogram = &merged
+	}
+}
+
+// encodeHistogram Кодирует гистограммы серии в JSON для столбца histogram.
+// Для остальных типов возвращает nil
+func (s *series) encodeHistogram() (any, error) {
+	if s.histogram == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(s.histogram)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+func counter(id string, delta int64, kind string) models.Metric {
+	c := models.Counter(delta)
+	return models.Metric{ID: id, MType: models.CounterType, Delta: &c, Kind: kind}
+}
+
+func TestMergeBatch(t *testing.T) {
+	gauge := func(id string, value float64, labels map[string]string) models.Metric {
+		g := models.Gauge(value)
+		return models.Metric{ID: id, MType: models.GaugeType, Value: &g, Labels: labels}
+	}
+	histogram := func(bounds []float64, counts ...uint64) models.Metric {
+		h := models.Histogram{Bounds: bounds, Counts: counts}
+		for _, c := range counts {
+			h.Count += c
+		}
+		return models.Metric{ID: "latency", MType: models.HistogramType, Histogram: &h}
+	}
+	batch, err := mergeBatch(models.Metrics{
+		counter("PollCount", 10, models.CumulativeKind),
+		gauge("Alloc", 1, nil),
+		counter("requests", 2, ""),
+		counter("PollCount", 15, models.CumulativeKind),
+		gauge("Alloc", 2, map[string]string{"host": "a"}),
+		counter("requests", 3, models.DeltaKind),
+		// сброс счётчика у источника: значение прибавляется целиком
+		counter("PollCount", 4, models.CumulativeKind),
+		gauge("Alloc", 3, nil),
+		histogram([]float64{1}, 1, 0),
+		histogram([]float64{1}, 0, 2),
+	})
+	require.NoError(t, err)
+	require.Len(t, batch, 5)
+
+	poll := batch[0]
+	assert.Equal(t, models.Counter(19), *poll.metric.Delta)
+	assert.Equal(t, models.Counter(10), *poll.first)
+	assert.Equal(t, models.Counter(4), *poll.last)
+
+	assert.Equal(t, models.Gauge(3), *batch[1].metric.Value)
+	assert.Equal(t, "{}", batch[1].labels)
+	assert.Equal(t, models.Gauge(2), *batch[3].metric.Value)
+	assert.Equal(t, `{"host":"a"}`, batch[3].labels)
+
+	requests := batch[2]
+	assert.Equal(t, models.Counter(5), *requests.metric.Delta)
+	assert.Nil(t, requests.first)
+	assert.Nil(t, requests.last)
+
+	latency := batch[4]
+	assert.Equal(t, []uint64{1, 2}, latency.histogram.Counts)
+	latency.mergeStored(histogram([]float64{1}, 3, 0).Histogram)
+	assert.Equal(t, []uint64{4, 2}, latency.histogram.Counts)
+	data, err := latency.encodeHistogram()
+	require.NoError(t, err)
+	assert.Contains(t, data, `"counts":[4,2]`)
+	data, err = requests.encodeHistogram()
+	require.NoError(t, err)
+	assert.Nil(t, data)
+
+	// границы сменились в пачке: сохранённая гистограмма не складывается
+	batch, err = mergeBatch(models.Metrics{
+		histogram([]float64{1}, 1, 0),
+		histogram([]float64{2}, 0, 2),
+	})
+	require.NoError(t, err)
+	batch[0].mergeStored(histogram([]float64{2}, 3, 0).Histogram)
+	assert.Equal(t, []uint64{0, 2}, batch[0].histogram.Counts)
+
+	_, err = mergeBatch(models.Metrics{
+		counter("requests", 1, ""),
+		{ID: "latency", MType: models.HistogramType},
+	})
+	assert.ErrorIs(t, err, models.ErrorMetrics)
+}
+
+func TestRetry(t *testing.T) {
+	db := New()
+	Configure(db, SetRetries(3))
+	for _, tc := range []struct {
+		name  string
+		err   error
+		calls int
+	}{
+		{name: "success", calls: 1},
+		{name: "connection exception", err: &pgconn.PgError{Code: pgerrcode.ConnectionFailure}, calls: 3},
+		{name: "cannot connect now", err: fmt.Errorf("open: %w", &pgconn.PgError{Code: pgerrcode.CannotConnectNow}), calls: 3},
+		{name: "bad connection", err: driver.ErrBadConn, calls: 3},
+		{name: "unique violation", err: &pgconn.PgError{Code: pgerrcode.UniqueViolation}, calls: 1},
+		{name: "cardinality violation", err: &pgconn.PgError{Code: pgerrcode.CardinalityViolation}, calls: 1},
+		{name: "no rows", err: sql.ErrNoRows, calls: 1},
+		{name: "canceled", err: context.Canceled, calls: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			err := db.retry(func() error {
+				calls++
+				return tc.err
+			})
+			assert.Equal(t, tc.calls, calls)
+			if tc.err == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tc.err)
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage/retention"
+)
+
+// bucketSQL - Начало интервала длиной $1 секунд, в который попадает столбец, как в retention.BucketStart
+const bucketSQL = `to_timestamp((floor(extract(epoch FROM %[1]s) / $1::BIGINT) * $1::BIGINT)::DOUBLE PRECISION)`
+
+const upsertRollupSQL = `INSERT INTO metric_rollups ` +
+	`(id, type, labels, resolution, start, "count", "min", "max", "sum", "last", "increase") %s ` +
+	`ON CONFLICT (id, type, labels, resolution, start) DO UPDATE SET ` +
+	`"count"=EXCLUDED."count", "min"=EXCLUDED."min", "max"=EXCLUDED."max", "sum"=EXCLUDED."sum", ` +
+	`"last"=EXCLUDED."last", "increase"=EXCLUDED."increase"`
+
+// downsampleSQL Сворачивает исходные значения из [$2, $3) в агрегаты с шагом $1 секунд.
+// Прирост counter считается от предыдущего значения серии, в том числе из более раннего интервала
+var downsampleSQL = fmt.Sprintf(upsertRollupSQL, `SELECT id, type, labels, $1::BIGINT, bucket, `+
+	`count(*), min(v), max(v), sum(v), (array_agg(v ORDER BY ts DESC))[1], `+
+	`COALESCE(sum(CASE WHEN type = 'counter' AND prev IS NOT NULL THEN `+
+	`CASE WHEN v >= prev THEN v - prev ELSE v END END), 0) `+
+	`FROM (SELECT id, type, labels, ts, COALESCE(value, delta::DOUBLE PRECISION) AS v, `+
+	`lag(COALESCE(value, delta::DOUBLE PRECISION)) OVER (PARTITION BY id, type, labels ORDER BY ts) AS prev, `+
+	fmt.Sprintf(bucketSQL, "ts")+` AS bucket `+
+	`FROM metric_samples WHERE type IN ('gauge', 'counter') AND ts < $3) s `+
+	`WHERE ts >= $2 GROUP BY id, type, labels, bucket`)
+
+// mergeSQL Сворачивает агрегаты с шагом $2 из [$3, $4) в агрегаты с шагом $1 секунд
+var mergeSQL = fmt.Sprintf(upsertRollupSQL, `SELECT id, type, labels, $1::BIGINT, bucket, `+
+	`sum("count"), min("min"), max("max"), sum("sum"), (array_agg("last" ORDER BY start DESC))[1], sum("increase") `+
+	`FROM (SELECT *, `+fmt.Sprintf(bucketSQL, "start")+` AS bucket `+
+	`FROM metric_rollups WHERE resolution = $2 AND start >= $3 AND start < $4) r `+
+	`GROUP BY id, type, labels, bucket`)
+
+// Compact Сворачивает историю в агрегаты по уровням хранения и удаляет устаревшие значения.
+// Сворачиваются только интервалы, закончившиеся к моменту now. Гистограммы в агрегаты не попадают.
+// Повторное сворачивание интервала перезаписывает агрегат, поэтому Compact можно вызывать с нескольких серверов
+func (db *DB) Compact(ctx context.Context, now time.Time) error {
+	tiers := db.policy.Rollups()
+	for i, t := range tiers {
+		seconds := int64(t.Resolution / time.Second)
+		from, err := db.compacted(ctx, t.Resolution)
+		if err != nil {
+			return err
+		}
+		end := retention.BucketStart(now, t.Resolution)
+		if !end.After(from) {
+			continue
+		}
+		if i == 0 {
+			err = db.exec(ctx, downsampleSQL, seconds, from, end)
+		} else {
+			err = db.exec(ctx, mergeSQL, seconds, int64(tiers[i-1].Resolution/time.Second), from, end)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if raw := db.policy.RawRetention(); raw > 0 {
+		err := db.exec(ctx, `DELETE FROM metric_samples WHERE ts < $1`, now.Add(-raw))
+		if err != nil {
+			return err
+		}
+	}
+	for _, t := range tiers {
+		err := db.exec(ctx, `DELETE FROM metric_rollups WHERE resolution = $1 AND start < $2`,
+			int64(t.Resolution/time.Second), now.Add(-t.Retention))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compacted Возвращает конец последнего свёрнутого интервала с шагом resolution
+func (db *DB) compacted(ctx context.Context, resolution time.Duration) (time.Time, error) {
+	var last sql.NullTime
+	err := db.retry(func() error {
+		err := db.db.QueryRowContext(ctx,
+			`SELECT max(start) FROM metric_rollups WHERE resolution = $1`,
+			int64(resolution/time.Second),
+		).Scan(&last)
+		return err
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !last.Valid {
+		return time.Unix(0, 0), nil
+	}
+	return last.Time.Add(resolution), nil
+}
+
+func (db *DB) exec(ctx context.Context, query string, args ...any) error {
+	return db.retry(func() error {
+		_, err := db.db.ExecContext(ctx, query, args...)
+		return err
+	})
+}
+
+// GetRollups Получает агрегаты метрики с шагом resolution, начавшиеся в промежутке [from, to]
+func (db *DB) GetRollups(ctx context.Context, mtype, id string, resolution time.Duration, from, to time.Time, matchers ...models.Matcher) (models.Rollups, error) {
+	if _, ok := db.policy.Tier(resolution); !ok {
+		return nil, fmt.Errorf("%w: no rollups with resolution %v", sql.ErrNoRows, resolution)
+	}
+	m, err := db.Get(ctx, mtype, id, matchers...)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := encodeLabels(m.Labels)
+	if err != nil {
+		return nil, err
+	}
+	var rows *sql.Rows
+	err = db.retry(func() error {
+		var err error
+		rows, err = db.db.QueryContext(ctx,
+			`SELECT start, "count", "min", "max", "sum", "last", "increase" FROM metric_rollups `+
+				`WHERE type=$1 AND id=$2 AND labels=$3 AND resolution=$4 AND start >= $5 AND start <= $6 ORDER BY start`,
+			m.MType, m.ID, labels, int64(resolution/time.Second), from, to,
+		)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rollups := make(models.Rollups, 0)
+	for rows.Next() {
+		r := models.Rollup{
+			Metric: models.Metric{
+				ID:     m.ID,
+				MType:  m.MType,
+				Labels: m.Labels,
+			},
+			Resolution: resolution,
+		}
+		err = rows.Scan(&r.Start, &r.Count, &r.Min, &r.Max, &r.Sum, &r.Last, &r.Increase)
+		if err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, r)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return rollups, nil
+}
@@ -3,30 +3,92 @@ package db
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 
 	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage/retention"
+	"github.com/Nexadis/metalert/internal/storage/sequence"
 	"github.com/Nexadis/metalert/internal/utils/logger"
 )
 
-// schema - Схема для метрик
-const schema = `CREATE TABLE Metrics(
+// schema - Схема для метрик и их истории. Серия метрики определяется именем, типом и метками
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS Metrics(
 "id" VARCHAR(250) NOT NULL,
 "type" VARCHAR(100) NOT NULL,
 "delta" BIGINT,
 "value" DOUBLE PRECISION,
-CONSTRAINT ID PRIMARY KEY (id,type));
-`
+"labels" JSONB NOT NULL DEFAULT '{}'::jsonb,
+"histogram" JSONB);
+`,
+	// миграция схемы без меток и гистограмм
+	`ALTER TABLE Metrics ADD COLUMN IF NOT EXISTS "labels" JSONB NOT NULL DEFAULT '{}'::jsonb;`,
+	`ALTER TABLE Metrics ADD COLUMN IF NOT EXISTS "histogram" JSONB;`,
+	`ALTER TABLE Metrics DROP CONSTRAINT IF EXISTS ID;`,
+	// последнее накопленное значение counter, от которого считается прирост следующего
+	`ALTER TABLE Metrics ADD COLUMN IF NOT EXISTS "cumulative" BIGINT;`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS metrics_series ON Metrics (id, type, labels);`,
+	`CREATE TABLE IF NOT EXISTS metric_samples(
+"id" VARCHAR(250) NOT NULL,
+"type" VARCHAR(100) NOT NULL,
+"ts" TIMESTAMPTZ NOT NULL,
+"delta" BIGINT,
+"value" DOUBLE PRECISION,
+"labels" JSONB NOT NULL DEFAULT '{}'::jsonb,
+"histogram" JSONB);
+`,
+	`ALTER TABLE metric_samples ADD COLUMN IF NOT EXISTS "labels" JSONB NOT NULL DEFAULT '{}'::jsonb;`,
+	`ALTER TABLE metric_samples ADD COLUMN IF NOT EXISTS "histogram" JSONB;`,
+	`CREATE INDEX IF NOT EXISTS metric_samples_series ON metric_samples (id, type, ts);`,
+	`CREATE INDEX IF NOT EXISTS metric_samples_ts ON metric_samples (ts);`,
+	`CREATE TABLE IF NOT EXISTS metric_rollups(
+"id" VARCHAR(250) NOT NULL,
+"type" VARCHAR(100) NOT NULL,
+"labels" JSONB NOT NULL DEFAULT '{}'::jsonb,
+"resolution" BIGINT NOT NULL,
+"start" TIMESTAMPTZ NOT NULL,
+"count" BIGINT NOT NULL,
+"min" DOUBLE PRECISION NOT NULL,
+"max" DOUBLE PRECISION NOT NULL,
+"sum" DOUBLE PRECISION NOT NULL,
+"last" DOUBLE PRECISION NOT NULL,
+"increase" DOUBLE PRECISION NOT NULL);
+`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS metric_rollups_series ON metric_rollups (id, type, labels, resolution, start);`,
+	`CREATE INDEX IF NOT EXISTS metric_rollups_start ON metric_rollups (resolution, start);`,
+	// API-ключи, токен хранится только в виде хеша SHA-256
+	`CREATE TABLE IF NOT EXISTS api_keys(
+"name" VARCHAR(250) PRIMARY KEY,
+"hash" CHAR(64) NOT NULL UNIQUE,
+"scopes" JSONB NOT NULL DEFAULT '[]'::jsonb,
+"prefixes" JSONB NOT NULL DEFAULT '[]'::jsonb);
+`,
+}
 
 // DB Реализует логику работы с БД.
 type DB struct {
-	db   *sql.DB
-	size int
-	conn connection
+	db     *sql.DB
+	size   int
+	conn   connection
+	mutex  sync.RWMutex
+	hooks  []func(ctx context.Context, m models.Metric)
+	policy retention.Policy
+
+	// фиксация транзакций SetMany упорядочена, чтобы hooks получали изменения в порядке фиксации
+	commitMutex sync.Mutex
+	order       sequence.Sequencer
 }
 
 type connection struct {
@@ -68,9 +130,11 @@ func (db *DB) Open(ctx context.Context, DSN string) error {
 		return err
 	}
 	db.db = pgx
-	_, err = pgx.ExecContext(ctx, schema)
-	if err != nil {
-		logger.Error("Unable to create table:", err)
+	for _, query := range schema {
+		_, err = pgx.ExecContext(ctx, query)
+		if err != nil {
+			logger.Error("Unable to create table:", err)
+		}
 	}
 	return nil
 }
@@ -84,63 +148,55 @@ func (db *DB) Ping() error {
 }
 
 // Get Получает значение метрики из БД.
-func (db *DB) Get(ctx context.Context, mtype, id string) (models.Metric, error) {
-	stmt, err := db.db.PrepareContext(ctx,
-		`SELECT delta, value FROM Metrics WHERE type=$1 AND id= $2`)
-	if err != nil {
-		return models.Metric{}, err
-	}
-	var row *sql.Row
-	err = db.retry(func() error {
-		row = stmt.QueryRowContext(ctx,
-			mtype, id,
-		)
-		err = row.Err()
-		if err != nil {
-			return checkConnection(err)
-		}
-		return nil
-	})
+func (db *DB) Get(ctx context.Context, mtype, id string, matchers ...models.Matcher) (models.Metric, error) {
+	metrics, err := db.query(ctx,
+		`SELECT id, type, delta, value, labels, histogram FROM Metrics WHERE type=$1 AND id=$2`,
+		mtype, id,
+	)
 	if err != nil {
 		return models.Metric{}, err
 	}
-	m := models.Metric{
-		ID:    id,
-		MType: mtype,
-	}
-	err = row.Scan(&m.Delta, &m.Value)
-	if err != nil {
-		return models.Metric{}, err
+	m, ok := metrics.Filter(matchers...).Pick()
+	if !ok {
+		return models.Metric{}, sql.ErrNoRows
 	}
 	return m, nil
 }
 
 // GetAll Получает все метрики из БД.
-func (db *DB) GetAll(ctx context.Context) (models.Metrics, error) {
-	stmt, err := db.db.PrepareContext(ctx,
-		`SELECT * FROM Metrics`)
+func (db *DB) GetAll(ctx context.Context, matchers ...models.Matcher) (models.Metrics, error) {
+	metrics, err := db.query(ctx, `SELECT id, type, delta, value, labels, histogram FROM Metrics`)
 	if err != nil {
 		return nil, err
 	}
-	metrics := make(models.Metrics, 0, db.size)
+	return metrics.Filter(matchers...), nil
+}
+
+// query Выполняет запрос, возвращающий столбцы id, type, delta, value, labels, histogram
+func (db *DB) query(ctx context.Context, query string, args ...any) (models.Metrics, error) {
 	var rows *sql.Rows
-	err = db.retry(func() error {
-		rows, err = stmt.QueryContext(ctx)
-		if err != nil {
-			return checkConnection(err)
-		}
-		if rows.Err() != nil {
-			return checkConnection(err)
-		}
-		return nil
+	err := db.retry(func() error {
+		var err error
+		rows, err = db.db.QueryContext(ctx, query, args...)
+		return err
 	})
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
+	metrics := make(models.Metrics, 0, db.size)
 	for rows.Next() {
 		metric := models.Metric{}
-		err = rows.Scan(&metric.ID, &metric.MType, &metric.Delta, &metric.Value)
+		var labels, histogram []byte
+		err = rows.Scan(&metric.ID, &metric.MType, &metric.Delta, &metric.Value, &labels, &histogram)
+		if err != nil {
+			return nil, err
+		}
+		metric.Labels, err = decodeLabels(labels)
+		if err != nil {
+			return nil, err
+		}
+		metric.Histogram, err = decodeHistogram(histogram)
 		if err != nil {
 			return nil, err
 		}
@@ -153,53 +209,401 @@ func (db *DB) GetAll(ctx context.Context) (models.Metrics, error) {
 	return metrics, nil
 }
 
+// samplesPerInsert - Количество строк истории в одном INSERT, ограничено числом параметров запроса
+const samplesPerInsert = 1000
+
+// upsertSQL - Обновление текущих значений серий пачки одним запросом, %s - строки batch.
+// Первое накопленное значение counter прибавляется разницей с сохранённым, а после сброса
+// счётчика целиком. Остальные приращения пачки уже сложены в delta
+const upsertSQL = "WITH batch (id, type, delta, value, labels, histogram, first_cumulative, last_cumulative) " +
+	"AS (VALUES %s) " +
+	"INSERT INTO Metrics AS m (id, type, delta, value, labels, histogram, cumulative) " +
+	"SELECT id, type, delta, value, labels, histogram, last_cumulative FROM batch " +
+	"ON CONFLICT (id, type, labels) DO UPDATE SET " +
+	"delta = m.delta + EXCLUDED.delta - COALESCE((SELECT m.cumulative FROM batch b " +
+	"WHERE b.id = EXCLUDED.id AND b.type = EXCLUDED.type AND b.labels = EXCLUDED.labels " +
+	"AND b.first_cumulative >= m.cumulative), 0), " +
+	"value = EXCLUDED.value, histogram = EXCLUDED.histogram, " +
+	"cumulative = COALESCE(EXCLUDED.cumulative, m.cumulative) " +
+	"RETURNING id, type, labels, delta, value, histogram"
+
+// upsertRow - Строка batch в upsertSQL с типами столбцов
+const upsertRow = "($%d::VARCHAR,$%d::VARCHAR,$%d::BIGINT,$%d::DOUBLE PRECISION,$%d::JSONB,$%d::JSONB,$%d::BIGINT,$%d::BIGINT)"
+
 // Set Обновляет метрику в БД.
 func (db *DB) Set(ctx context.Context, m models.Metric) error {
-	tx, err := db.db.BeginTx(ctx, nil)
+	return db.SetMany(ctx, models.Metrics{m})
+}
+
+// SetMany Обновляет пачку метрик в одной транзакции: при ошибке не применяется ни одна метрика.
+// Повторы серии в пачке складываются как при отдельных Set, после записи по каждой серии
+// вызываются обработчики OnSet с её итоговым значением
+func (db *DB) SetMany(ctx context.Context, ms models.Metrics) error {
+	if len(ms) == 0 {
+		return nil
+	}
+	batch, err := mergeBatch(ms)
+	if err != nil {
+		return err
+	}
+	var results models.Metrics
+	var n uint64
+	err = db.retry(func() error {
+		var err error
+		results, n, err = db.setMany(ctx, batch)
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	db.order.Do(n, func() {
+		db.size += len(results)
+		for _, result := range results {
+			db.notify(ctx, result)
+		}
+	})
+	return nil
+}
+
+// setMany Применяет пачку в транзакции и возвращает итоговые значения серий в порядке batch
+// и номер изменения для вызова hooks
+func (db *DB) setMany(ctx context.Context, batch []*series) (models.Metrics, uint64, error) {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
 	defer tx.Rollback()
-	stmt, err := tx.PrepareContext(ctx, "INSERT INTO Metrics (id, type, delta, value) "+
-		"VALUES ($1,$2,$3,$4) ON CONFLICT(id,type) "+
-		"DO UPDATE SET delta=metrics.delta + $3, value=$4",
+	err = lockHistograms(ctx, tx, batch)
+	if err != nil {
+		return nil, 0, err
+	}
+	labels := make([]string, len(batch))
+	histograms := make([]any, len(batch))
+	for i, s := range batch {
+		labels[i] = s.labels
+		histograms[i], err = s.encodeHistogram()
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	results := make(models.Metrics, len(batch))
+	for start := 0; start < len(batch); start += samplesPerInsert {
+		end := start + samplesPerInsert
+		if end > len(batch) {
+			end = len(batch)
+		}
+		err = upsert(ctx, tx, batch[start:end], histograms[start:end], results[start:end])
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	err = insertSamples(ctx, tx, results, labels, histograms, time.Now())
+	if err != nil {
+		return nil, 0, err
+	}
+	// транзакции с общими сериями фиксируются по очереди из-за блокировок строк,
+	// номер под commitMutex повторяет этот порядок
+	db.commitMutex.Lock()
+	defer db.commitMutex.Unlock()
+	err = tx.Commit()
+	if err != nil {
+		return nil, 0, err
+	}
+	return results, db.order.Next(), nil
+}
+
+// lockHistograms Блокирует сохранённые гистограммы серий пачки одним запросом
+// и складывает их с гистограммами из пачки. Недостающие строки сначала создаются пустыми,
+// иначе FOR UPDATE не заблокирует новую серию и одновременные первые записи потеряют друг друга
+func lockHistograms(ctx context.Context, tx *sql.Tx, batch []*series) error {
+	index := make(map[string]*series)
+	ids := make(map[string]bool)
+	args := []any{models.HistogramType}
+	placeholders := make([]string, 0)
+	histograms := make([]*series, 0)
+	for _, s := range batch {
+		if s.histogram == nil {
+			continue
+		}
+		index[key(s.metric)] = s
+		histograms = append(histograms, s)
+		if ids[s.metric.ID] {
+			continue
+		}
+		ids[s.metric.ID] = true
+		args = append(args, s.metric.ID)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+	}
+	if len(placeholders) == 0 {
+		return nil
+	}
+	err := createHistograms(ctx, tx, histograms)
+	if err != nil {
+		return err
+	}
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, labels, histogram FROM Metrics WHERE type=$1 AND id IN (`+
+			strings.Join(placeholders, ",")+`) ORDER BY id, labels FOR UPDATE`,
+		args...,
 	)
 	if err != nil {
 		return err
 	}
-	err = db.retry(func() error {
-		_, err = stmt.ExecContext(ctx,
-			m.ID,
-			m.MType,
-			m.Delta,
-			m.Value,
-		)
+	defer rows.Close()
+	for rows.Next() {
+		m := models.Metric{MType: models.HistogramType}
+		var labels, histogram []byte
+		err = rows.Scan(&m.ID, &labels, &histogram)
 		if err != nil {
-			return checkConnection(err)
+			return err
 		}
-		return nil
+		m.Labels, err = decodeLabels(labels)
+		if err != nil {
+			return err
+		}
+		s := index[key(m)]
+		if s == nil {
+			continue
+		}
+		stored, err := decodeHistogram(histogram)
+		if err != nil {
+			return err
+		}
+		s.mergeStored(stored)
+	}
+	return rows.Err()
+}
+
+// createHistograms Создаёт пустые строки для новых серий гистограмм. Серии вставляются
+// в порядке ключей, чтобы одновременные транзакции ждали друг друга, а не взаимно блокировались
+func createHistograms(ctx context.Context, tx *sql.Tx, histograms []*series) error {
+	sorted := make([]*series, len(histograms))
+	copy(sorted, histograms)
+	sort.Slice(sorted, func(i, j int) bool {
+		return key(sorted[i].metric) < key(sorted[j].metric)
 	})
+	for start := 0; start < len(sorted); start += samplesPerInsert {
+		end := start + samplesPerInsert
+		if end > len(sorted) {
+			end = len(sorted)
+		}
+		values := make([]string, 0, end-start)
+		args := make([]any, 0, (end-start)*3)
+		for _, s := range sorted[start:end] {
+			n := len(args)
+			values = append(values, fmt.Sprintf("($%d::VARCHAR,$%d::VARCHAR,$%d::JSONB)", n+1, n+2, n+3))
+			args = append(args, s.metric.ID, s.metric.MType, s.labels)
+		}
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO Metrics (id, type, labels) VALUES "+strings.Join(values, ",")+
+				" ON CONFLICT (id, type, labels) DO NOTHING",
+			args...,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsert Записывает серии одним многострочным INSERT и сохраняет итоговые значения в results
+func upsert(ctx context.Context, tx *sql.Tx, batch []*series, histograms []any, results models.Metrics) error {
+	const columns = 8
+	values := make([]string, 0, len(batch))
+	args := make([]any, 0, len(batch)*columns)
+	index := make(map[string]int, len(batch))
+	for i, s := range batch {
+		n := len(args)
+		values = append(values, fmt.Sprintf(upsertRow, n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8))
+		m := s.metric
+		args = append(args, m.ID, m.MType, m.Delta, m.Value, s.labels, histograms[i], s.first, s.last)
+		index[key(m)] = i
+	}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(upsertSQL, strings.Join(values, ",")), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		result := models.Metric{}
+		var labels, histogram []byte
+		err = rows.Scan(&result.ID, &result.MType, &labels, &result.Delta, &result.Value, &histogram)
+		if err != nil {
+			return err
+		}
+		result.Labels, err = decodeLabels(labels)
+		if err != nil {
+			return err
+		}
+		result.Histogram, err = decodeHistogram(histogram)
+		if err != nil {
+			return err
+		}
+		i, ok := index[key(result)]
+		if !ok {
+			return fmt.Errorf("unexpected series %v", key(result))
+		}
+		// метки остаются в том виде, в котором пришли
+		result.Labels = batch[i].metric.Labels
+		results[i] = result
+	}
+	return rows.Err()
+}
+
+// insertSamples Записывает значения пачки в историю несколькими многострочными INSERT
+func insertSamples(ctx context.Context, tx *sql.Tx, results models.Metrics, labels []string, histograms []any, ts time.Time) error {
+	const columns = 7
+	for start := 0; start < len(results); start += samplesPerInsert {
+		end := start + samplesPerInsert
+		if end > len(results) {
+			end = len(results)
+		}
+		var query strings.Builder
+		query.WriteString("INSERT INTO metric_samples (id, type, ts, delta, value, labels, histogram) VALUES ")
+		args := make([]any, 0, (end-start)*columns)
+		for i := start; i < end; i++ {
+			if i > start {
+				query.WriteString(",")
+			}
+			n := len(args)
+			fmt.Fprintf(&query, "($%d,$%d,$%d,$%d,$%d,$%d,$%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7)
+			r := results[i]
+			args = append(args, r.ID, r.MType, ts, r.Delta, r.Value, labels[i], histograms[i])
+		}
+		_, err := tx.ExecContext(ctx, query.String(), args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRange Получает историю значений метрики в промежутке [from, to]
+func (db *DB) GetRange(ctx context.Context, mtype, id string, from, to time.Time, matchers ...models.Matcher) (models.Samples, error) {
+	m, err := db.Get(ctx, mtype, id, matchers...)
 	if err != nil {
+		return nil, err
+	}
+	labels, err := encodeLabels(m.Labels)
+	if err != nil {
+		return nil, err
+	}
+	var rows *sql.Rows
+	err = db.retry(func() error {
+		var err error
+		rows, err = db.db.QueryContext(ctx,
+			`SELECT ts, delta, value, histogram FROM metric_samples `+
+				`WHERE type=$1 AND id=$2 AND labels=$3 AND ts >= $4 AND ts <= $5 ORDER BY ts`,
+			mtype, id, labels, from, to,
+		)
 		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	samples := make(models.Samples, 0)
+	for rows.Next() {
+		s := models.Sample{
+			Metric: models.Metric{
+				ID:     id,
+				MType:  mtype,
+				Labels: m.Labels,
+			},
+		}
+		var histogram []byte
+		err = rows.Scan(&s.Timestamp, &s.Delta, &s.Value, &histogram)
+		if err != nil {
+			return nil, err
+		}
+		s.Histogram, err = decodeHistogram(histogram)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, s)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// OnSet Регистрирует функцию, которая вызывается после каждого принятого Set.
+// В функцию передаётся итоговое значение метрики, пачки передаются в порядке фиксации.
+func (db *DB) OnSet(fn func(ctx context.Context, m models.Metric)) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.hooks = append(db.hooks, fn)
+}
+
+func (db *DB) notify(ctx context.Context, m models.Metric) {
+	db.mutex.RLock()
+	hooks := db.hooks
+	db.mutex.RUnlock()
+	for _, h := range hooks {
+		h(ctx, m)
+	}
+}
+
+// encodeLabels Кодирует метки в JSON для столбца labels
+func encodeLabels(labels map[string]string) (string, error) {
+	if len(labels) == 0 {
+		return "{}", nil
+	}
+	data, err := json.Marshal(labels)
+	return string(data), err
+}
+
+func decodeLabels(data []byte) (map[string]string, error) {
+	labels := make(map[string]string)
+	if len(data) != 0 {
+		err := json.Unmarshal(data, &labels)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(labels) == 0 {
+		return nil, nil
+	}
+	return labels, nil
+}
+
+func decodeHistogram(data []byte) (*models.Histogram, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	h := &models.Histogram{}
+	err := json.Unmarshal(data, h)
+	if err != nil {
+		return nil, err
 	}
-	db.size += 1
-	return tx.Commit()
+	return h, nil
 }
 
-func checkConnection(err error) error {
-	if pgerrcode.IsConnectionException(err.Error()) {
-		return fmt.Errorf("db conenction problem %w", err)
+// retriable Проверяет, что ошибку может исправить повтор: соединение с БД потеряно
+// или запрос не дошёл до сервера. Ошибки в данных и ограничениях не повторяются
+func retriable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgerrcode.IsConnectionException(pgErr.Code) ||
+			pgErr.Code == pgerrcode.AdminShutdown ||
+			pgErr.Code == pgerrcode.CannotConnectNow
 	}
-	return err
+	var netErr net.Error
+	return pgconn.SafeToRetry(err) || errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr)
 }
 
-// retry Выполняет функцию, обернув её в retry
+// retry Выполняет функцию, повторяя её при ошибках соединения с БД
 func (db *DB) retry(fn func() error) error {
 	attempt := db.conn.retries
 	var err error
 	for attempt > 0 {
 		err = fn()
 		if err != nil {
+			if !retriable(err) {
+				return err
+			}
 			logger.Info("Retry", attempt)
 			attempt--
 			time.Sleep(db.conn.timeout)
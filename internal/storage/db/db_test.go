@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+// testDSNEnv - Переменная окружения с DSN базы для интеграционных тестов, без неё тесты пропускаются
+const testDSNEnv = "TEST_DATABASE_DSN"
+
+// openTestDB Подключается к базе из testDSNEnv и возвращает префикс имён метрик теста,
+// строки с этим префиксом удаляются после теста
+func openTestDB(t *testing.T) (*DB, string) {
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDSNEnv)
+	}
+	db := New()
+	Configure(db, SetRetries(3), SetTimeout(100*time.Millisecond))
+	require.NoError(t, db.Open(context.TODO(), dsn))
+	prefix := fmt.Sprintf("test_%d_", time.Now().UnixNano())
+	t.Cleanup(func() {
+		for _, table := range []string{"Metrics", "metric_samples"} {
+			_, err := db.db.Exec(`DELETE FROM `+table+` WHERE id LIKE $1`, prefix+"%")
+			assert.NoError(t, err)
+		}
+		db.Close()
+	})
+	return db, prefix
+}
+
+func TestSetManyPostgres(t *testing.T) {
+	db, prefix := openTestDB(t)
+	ctx := context.TODO()
+	poll := prefix + "PollCount"
+	alloc := prefix + "Alloc"
+	g := models.Gauge(1.5)
+	require.NoError(t, db.SetMany(ctx, models.Metrics{
+		counter(poll, 10, models.CumulativeKind),
+		counter(poll, 15, models.CumulativeKind),
+		{ID: alloc, MType: models.GaugeType, Value: &g},
+		counter(poll, 4, models.CumulativeKind),
+	}))
+	got, err := db.Get(ctx, models.CounterType, poll)
+	require.NoError(t, err)
+	assert.Equal(t, models.Counter(19), *got.Delta)
+
+	// первое накопленное значение следующей пачки прибавляется разницей с сохранённым
+	require.NoError(t, db.SetMany(ctx, models.Metrics{
+		counter(poll, 6, models.CumulativeKind),
+		counter(poll, 1, models.DeltaKind),
+	}))
+	got, err = db.Get(ctx, models.CounterType, poll)
+	require.NoError(t, err)
+	assert.Equal(t, models.Counter(22), *got.Delta)
+	got, err = db.Get(ctx, models.GaugeType, alloc)
+	require.NoError(t, err)
+	assert.Equal(t, g, *got.Value)
+}
+
+func TestHistogramFirstWritePostgres(t *testing.T) {
+	db, prefix := openTestDB(t)
+	ctx := context.TODO()
+	id := prefix + "latency"
+	const writers = 10
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h := models.Histogram{Bounds: []float64{1}, Counts: []uint64{1, 0}, Count: 1}
+			assert.NoError(t, db.Set(ctx, models.Metric{ID: id, MType: models.HistogramType, Histogram: &h}))
+		}()
+	}
+	wg.Wait()
+	// одновременные первые записи новой серии не теряют друг друга
+	got, err := db.Get(ctx, models.HistogramType, id)
+	require.NoError(t, err)
+	require.NotNil(t, got.Histogram)
+	assert.Equal(t, uint64(writers), got.Histogram.Count)
+	assert.Equal(t, []uint64{writers, 0}, got.Histogram.Counts)
+}
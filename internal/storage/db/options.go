@@ -1,6 +1,10 @@
 package db
 
-import "time"
+import (
+	"time"
+
+	"github.com/Nexadis/metalert/internal/storage/retention"
+)
 
 // SetTimeout Устанавливает таймаут для БД.
 func SetTimeout(timeout time.Duration) func(*DB) {
@@ -15,3 +19,10 @@ func SetRetries(retries int) func(*DB) {
 		db.conn.retries = retries
 	}
 }
+
+// SetRetention Устанавливает уровни хранения истории. Агрегаты вычисляются при вызове Compact
+func SetRetention(policy retention.Policy) func(*DB) {
+	return func(db *DB) {
+		db.policy = policy
+	}
+}
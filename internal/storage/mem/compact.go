@@ -0,0 +1,129 @@
+package mem
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage/retention"
+)
+
+// Compact Сворачивает историю в агрегаты по уровням хранения и удаляет устаревшие значения.
+// Сворачиваются только интервалы, закончившиеся к моменту now. Гистограммы в агрегаты не попадают
+func (ms *Storage) Compact(ctx context.Context, now time.Time) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	tiers := ms.policy.Rollups()
+	if len(ms.compacted) != len(tiers) {
+		ms.compacted = make([]time.Time, len(tiers))
+	}
+	if ms.rollups == nil {
+		ms.rollups = make(map[string][]models.Rollups)
+	}
+	for i, t := range tiers {
+		from := ms.compacted[i]
+		end := retention.BucketStart(now, t.Resolution)
+		if !end.After(from) {
+			continue
+		}
+		if i == 0 {
+			err := ms.downsample(from, end, t.Resolution)
+			if err != nil {
+				return err
+			}
+		} else {
+			for _, rollups := range ms.rollups {
+				src := between(rollups[i-1], from, end)
+				rollups[i] = append(rollups[i], retention.Merge(src, t.Resolution)...)
+			}
+		}
+		ms.compacted[i] = end
+	}
+	ms.expire(now)
+	return nil
+}
+
+// downsample Сворачивает исходные значения из промежутка [from, end) в агрегаты первого уровня.
+// Вызывается под блокировкой
+func (ms *Storage) downsample(from, end time.Time, resolution time.Duration) error {
+	tiers := len(ms.policy.Rollups())
+	for key, r := range ms.history {
+		if strings.HasPrefix(key, models.HistogramType+"/") {
+			continue
+		}
+		samples := r.between(time.Time{}, end.Add(-time.Nanosecond))
+		i := sort.Search(len(samples), func(i int) bool {
+			return !samples[i].Timestamp.Before(from)
+		})
+		var prev *models.Sample
+		if i > 0 {
+			prev = &samples[i-1]
+		}
+		rollups, err := retention.Downsample(samples[i:], prev, resolution)
+		if err != nil {
+			return err
+		}
+		if len(rollups) == 0 {
+			continue
+		}
+		if _, ok := ms.rollups[key]; !ok {
+			ms.rollups[key] = make([]models.Rollups, tiers)
+		}
+		ms.rollups[key][0] = append(ms.rollups[key][0], rollups...)
+	}
+	return nil
+}
+
+// expire Удаляет значения и агрегаты, срок хранения которых истёк. Вызывается под блокировкой
+func (ms *Storage) expire(now time.Time) {
+	if raw := ms.policy.RawRetention(); raw > 0 {
+		for _, r := range ms.history {
+			r.dropBefore(now.Add(-raw))
+		}
+	}
+	for key, rollups := range ms.rollups {
+		empty := true
+		for i, t := range ms.policy.Rollups() {
+			rollups[i] = between(rollups[i], now.Add(-t.Retention), time.Unix(1<<62, 0))
+			empty = empty && len(rollups[i]) == 0
+		}
+		if empty {
+			delete(ms.rollups, key)
+		}
+	}
+}
+
+// between Возвращает агрегаты, начавшиеся в промежутке [from, to)
+func between(rollups models.Rollups, from, to time.Time) models.Rollups {
+	i := sort.Search(len(rollups), func(i int) bool {
+		return !rollups[i].Start.Before(from)
+	})
+	j := sort.Search(len(rollups), func(j int) bool {
+		return !rollups[j].Start.Before(to)
+	})
+	return rollups[i:j]
+}
+
+// GetRollups Получает агрегаты метрики с шагом resolution, начавшиеся в промежутке [from, to]
+func (ms *Storage) GetRollups(ctx context.Context, mtype, id string, resolution time.Duration, from, to time.Time, matchers ...models.Matcher) (models.Rollups, error) {
+	mtype = strings.ToLower(mtype)
+	tier, ok := ms.policy.Tier(resolution)
+	if !ok {
+		return nil, fmt.Errorf("%w: no rollups with resolution %v", ErrNotFound, resolution)
+	}
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	key, err := ms.lookup(mtype, id, matchers)
+	if err != nil {
+		return nil, err
+	}
+	rollups, ok := ms.rollups[mtype+"/"+key]
+	if !ok {
+		return models.Rollups{}, nil
+	}
+	result := between(rollups[tier], from, to.Add(time.Nanosecond))
+	return append(models.Rollups{}, result...), nil
+}
@@ -0,0 +1,73 @@
+package mem
+
+import (
+	"time"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+// DefaultHistorySize - Количество значений, хранимых для одной метрики
+const DefaultHistorySize = 1024
+
+// ring - Кольцевой буфер значений одной метрики
+type ring struct {
+	samples []models.Sample
+	next    int
+	full    bool
+}
+
+func newRing(size int) *ring {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	return &ring{
+		samples: make([]models.Sample, size),
+	}
+}
+
+// push Добавляет значение, вытесняя самое старое при заполнении буфера
+func (r *ring) push(s models.Sample) {
+	r.samples[r.next] = s
+	r.next++
+	if r.next == len(r.samples) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// between Возвращает значения в промежутке [from, to] от старых к новым
+func (r *ring) between(from, to time.Time) models.Samples {
+	result := make(models.Samples, 0)
+	start, size := 0, r.next
+	if r.full {
+		start, size = r.next, len(r.samples)
+	}
+	for i := 0; i < size; i++ {
+		s := r.samples[(start+i)%len(r.samples)]
+		if s.Timestamp.Before(from) || s.Timestamp.After(to) {
+			continue
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+// dropBefore Удаляет значения старше t
+func (r *ring) dropBefore(t time.Time) {
+	kept := r.between(t, time.Unix(1<<62, 0))
+	if len(kept) == r.len() {
+		return
+	}
+	r.next, r.full = 0, false
+	for _, s := range kept {
+		r.push(s)
+	}
+}
+
+// len Возвращает количество хранимых значений
+func (r *ring) len() int {
+	if r.full {
+		return len(r.samples)
+	}
+	return r.next
+}
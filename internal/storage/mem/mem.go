@@ -7,8 +7,12 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage/retention"
+	"github.com/Nexadis/metalert/internal/storage/sequence"
+	"github.com/Nexadis/metalert/internal/utils/logger"
 )
 
 // Ошибки при работе с хранилищем.
@@ -17,11 +21,36 @@ var (
 	ErrInvalidType = errors.New(`invalid type`)
 )
 
-// Storage - Хранилище inmemory. Отдельно хранит Gauge и Counter метрики. Использует RWMutex Для доступа к элементам.
+// Storage - Хранилище inmemory. Отдельно хранит Gauge, Counter и Histogram метрики. Использует RWMutex Для доступа к элементам.
+// Ключом служит имя серии: имя метрики вместе с метками, для метрики без меток - её имя.
+// Для каждой метрики также хранится история значений в кольцевом буфере
+// и агрегаты истории по уровням хранения.
 type Storage struct {
-	Gauges   map[string]models.Gauge
-	Counters map[string]models.Counter
-	mutex    sync.RWMutex
+	Gauges      map[string]models.Gauge
+	Counters    map[string]models.Counter
+	Histograms  map[string]models.Histogram
+	cumulative  map[string]models.Counter // последнее накопленное значение counter по ключу серии
+	mutex       sync.RWMutex
+	hooks       []func(ctx context.Context, m models.Metric)
+	order       sequence.Sequencer // порядок вызова hooks
+	series      map[string]series
+	history     map[string]*ring
+	historySize int
+	policy      retention.Policy
+	rollups     map[string][]models.Rollups // агрегаты каждого уровня по ключу истории
+	compacted   []time.Time                 // конец последнего свёрнутого интервала каждого уровня
+	snapshots   int
+	saveMutex   sync.Mutex
+	walDir      string
+	walPolicy   SyncPolicy
+	walInterval time.Duration
+	wal         *wal
+}
+
+// series - Имя и метки серии с непустым набором меток
+type series struct {
+	id     string
+	labels map[string]string
 }
 
 // NewMetricsStorage Конструктор для Storage
@@ -29,74 +58,311 @@ func NewMetricsStorage() *Storage {
 	ms := new(Storage)
 	ms.Gauges = make(map[string]models.Gauge)
 	ms.Counters = make(map[string]models.Counter)
+	ms.Histograms = make(map[string]models.Histogram)
+	ms.cumulative = make(map[string]models.Counter)
+	ms.series = make(map[string]series)
+	ms.history = make(map[string]*ring)
+	ms.historySize = DefaultHistorySize
+	ms.snapshots = DefaultSnapshots
 	return ms
 }
 
+// Configure Применяет опции к Storage
+func Configure(ms *Storage, options ...func(*Storage)) {
+	for _, o := range options {
+		o(ms)
+	}
+}
+
 // Set Добавляет метрику
 func (ms *Storage) Set(ctx context.Context, m models.Metric) error {
-	_, err := m.GetValue()
-	if err != nil {
-		return err
+	return ms.SetMany(ctx, models.Metrics{m})
+}
+
+// SetMany Добавляет пачку метрик под одной блокировкой. Пачка проверяется целиком до применения,
+// поэтому при ошибке не применяется ни одна метрика. В журнал пачка пишется одной записью
+func (ms *Storage) SetMany(ctx context.Context, metrics models.Metrics) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	batch := make(models.Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		err := m.Validate()
+		if err != nil {
+			return err
+		}
+		m.Labels = copyLabels(m.Labels)
+		batch = append(batch, m)
+	}
+	ms.mutex.Lock()
+	// журнал пишется под блокировкой, чтобы порядок записей совпадал с порядком изменений
+	var record uint64
+	if ms.wal != nil {
+		var err error
+		record, err = ms.wal.append(batch)
+		if err != nil {
+			ms.mutex.Unlock()
+			return err
+		}
+	}
+	now := time.Now()
+	results := make(models.Metrics, 0, len(batch))
+	for _, m := range batch {
+		// метрики уже проверены, set не возвращает ошибку для известных типов
+		result, err := ms.set(m)
+		if err != nil {
+			ms.mutex.Unlock()
+			return err
+		}
+		ms.record(result, now)
+		results = append(results, result)
 	}
+	hooks, w := ms.hooks, ms.wal
+	// номер выдаётся под блокировкой, поэтому обработчики получают пачки в порядке применения
+	n := ms.order.Next()
+	ms.mutex.Unlock()
+	// синхронизация с диском вне блокировки позволяет объединять fsync одновременных записей.
+	// При ошибке fsync пачка остаётся применённой в памяти, но не подтверждается:
+	// клиент получает ошибку, так как запись может не пережить перезапуск
+	var err error
+	if w != nil {
+		err = w.commit(record)
+		if err != nil {
+			logger.Error("Unable to sync wal:", err)
+		}
+	}
+	// изменение уже видно читателям, поэтому обработчики вызываются и при ошибке fsync
+	ms.order.Do(n, func() {
+		for _, result := range results {
+			notify(ctx, hooks, result)
+		}
+	})
+	return err
+}
+
+// set Обновляет значение метрики и возвращает итоговое значение. Вызывается под блокировкой
+func (ms *Storage) set(m models.Metric) (models.Metric, error) {
+	key := m.Series()
 	switch strings.ToLower(m.MType) {
 	case models.CounterType:
-		ms.mutex.Lock()
-		ms.Counters[m.ID] += *m.Delta
-		ms.mutex.Unlock()
-		return nil
+		delta := *m.Delta
+		if m.IsCumulative() {
+			if ms.cumulative == nil {
+				ms.cumulative = make(map[string]models.Counter)
+			}
+			// уменьшение накопленного значения означает сброс счётчика у источника,
+			// тогда новое значение отсчитывается от нуля
+			if last, ok := ms.cumulative[key]; ok && delta >= last {
+				delta -= last
+			}
+			ms.cumulative[key] = *m.Delta
+		}
+		ms.Counters[key] += delta
+		total := ms.Counters[key]
+		m.Delta = &total
+		m.Kind = ""
 	case models.GaugeType:
-		ms.mutex.Lock()
-		ms.Gauges[m.ID] = *m.Value
-		ms.mutex.Unlock()
-		return nil
+		ms.Gauges[key] = *m.Value
+	case models.HistogramType:
+		if ms.Histograms == nil {
+			ms.Histograms = make(map[string]models.Histogram)
+		}
+		// при смене границ накопленные значения заменяются
+		h, ok := ms.Histograms[key].Merge(*m.Histogram)
+		if !ok {
+			h = m.Histogram.Copy()
+		}
+		ms.Histograms[key] = h
+		result := h.Copy()
+		m.Histogram = &result
+	default:
+		return m, fmt.Errorf("%v: %v", ErrInvalidType, m)
+	}
+	if len(m.Labels) != 0 {
+		if ms.series == nil {
+			ms.series = make(map[string]series)
+		}
+		ms.series[key] = series{
+			id:     m.ID,
+			labels: m.Labels,
+		}
+	}
+	return m, nil
+}
+
+// describe Возвращает имя метрики и метки по ключу серии. Вызывается под блокировкой
+func (ms *Storage) describe(key string) (string, map[string]string) {
+	s, ok := ms.series[key]
+	if !ok {
+		return key, nil
 	}
-	return fmt.Errorf("%v: %v", ErrInvalidType, m)
+	return s.id, copyLabels(s.labels)
 }
 
-// Get Получает метрику с типом mtype и именем id
-func (ms *Storage) Get(ctx context.Context, mtype, id string) (models.Metric, error) {
-	switch strings.ToLower(mtype) {
+// lookup Находит ключ серии метрики id, подходящей под условия. Вызывается под блокировкой
+func (ms *Storage) lookup(mtype, id string, matchers []models.Matcher) (string, error) {
+	var exists func(key string) bool
+	switch mtype {
 	case models.CounterType:
-		ms.mutex.RLock()
-		value, ok := ms.Counters[id]
-		ms.mutex.RUnlock()
-		if !ok {
-			return models.Metric{}, ErrNotFound
+		exists = func(key string) bool {
+			_, ok := ms.Counters[key]
+			return ok
 		}
-		return models.NewMetric(id, mtype, value.String())
 	case models.GaugeType:
-		ms.mutex.RLock()
-		value, ok := ms.Gauges[id]
-		ms.mutex.RUnlock()
-		if !ok {
-			return models.Metric{}, ErrNotFound
+		exists = func(key string) bool {
+			_, ok := ms.Gauges[key]
+			return ok
+		}
+	case models.HistogramType:
+		exists = func(key string) bool {
+			_, ok := ms.Histograms[key]
+			return ok
 		}
-		return models.NewMetric(id, mtype, value.String())
+	default:
+		return "", ErrInvalidType
+	}
+	// серия без меток всегда предпочтительнее
+	if exists(id) && models.MatchLabels(nil, matchers...) {
+		return id, nil
 	}
+	candidates := make(models.Metrics, 0)
+	for key, s := range ms.series {
+		if s.id != id || !exists(key) {
+			continue
+		}
+		candidates = append(candidates, models.Metric{
+			ID:     s.id,
+			Labels: s.labels,
+		})
+	}
+	m, ok := candidates.Filter(matchers...).Pick()
+	if !ok {
+		return "", ErrNotFound
+	}
+	return m.Series(), nil
+}
+
+// record Добавляет значение метрики в историю. Вызывается под блокировкой
+func (ms *Storage) record(m models.Metric, ts time.Time) {
+	key := m.MType + "/" + m.Series()
+	r, ok := ms.history[key]
+	if !ok {
+		r = newRing(ms.historySize)
+		ms.history[key] = r
+	}
+	r.push(models.Sample{
+		Metric:    m,
+		Timestamp: ts,
+	})
+}
+
+// GetRange Получает историю значений метрики в промежутке [from, to]
+func (ms *Storage) GetRange(ctx context.Context, mtype, id string, from, to time.Time, matchers ...models.Matcher) (models.Samples, error) {
+	mtype = strings.ToLower(mtype)
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	key, err := ms.lookup(mtype, id, matchers)
+	if err != nil {
+		return nil, err
+	}
+	r, ok := ms.history[mtype+"/"+key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return r.between(from, to), nil
+}
+
+// OnSet Регистрирует функцию, которая вызывается после каждого принятого Set.
+// В функцию передаётся итоговое значение метрики, пачки передаются в порядке применения.
+func (ms *Storage) OnSet(fn func(ctx context.Context, m models.Metric)) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.hooks = append(ms.hooks, fn)
+}
 
-	return models.Metric{}, ErrInvalidType
+func notify(ctx context.Context, hooks []func(ctx context.Context, m models.Metric), m models.Metric) {
+	for _, h := range hooks {
+		h(ctx, m)
+	}
 }
 
-// GetAll Получает все метрики из хранилища
-func (ms *Storage) GetAll(ctx context.Context) (models.Metrics, error) {
-	m := make(models.Metrics, 0, len(ms.Gauges)+len(ms.Counters))
+// Get Получает метрику с типом mtype и именем id
+func (ms *Storage) Get(ctx context.Context, mtype, id string, matchers ...models.Matcher) (models.Metric, error) {
+	mtype = strings.ToLower(mtype)
 	ms.mutex.RLock()
 	defer ms.mutex.RUnlock()
-	for name, value := range ms.Gauges {
+	key, err := ms.lookup(mtype, id, matchers)
+	if err != nil {
+		return models.Metric{}, err
+	}
+	m := models.Metric{
+		MType: mtype,
+	}
+	m.ID, m.Labels = ms.describe(key)
+	switch mtype {
+	case models.CounterType:
+		value := ms.Counters[key]
+		m.Delta = &value
+	case models.GaugeType:
+		value := ms.Gauges[key]
+		m.Value = &value
+	case models.HistogramType:
+		value := ms.Histograms[key].Copy()
+		m.Histogram = &value
+	}
+	return m, nil
+}
+
+// GetAll Получает все метрики из хранилища, подходящие под условия на метки
+func (ms *Storage) GetAll(ctx context.Context, matchers ...models.Matcher) (models.Metrics, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return ms.getAll(matchers...), nil
+}
+
+// getAll Получает все метрики, подходящие под условия на метки. Вызывается под блокировкой
+func (ms *Storage) getAll(matchers ...models.Matcher) models.Metrics {
+	m := make(models.Metrics, 0, len(ms.Gauges)+len(ms.Counters)+len(ms.Histograms))
+	for key, value := range ms.Gauges {
 		v := value
+		id, labels := ms.describe(key)
 		m = append(m, models.Metric{
-			MType: models.GaugeType,
-			ID:    name,
-			Value: &v,
+			MType:  models.GaugeType,
+			ID:     id,
+			Value:  &v,
+			Labels: labels,
 		})
 	}
-	for name, value := range ms.Counters {
+	for key, value := range ms.Counters {
 		v := value
+		id, labels := ms.describe(key)
 		m = append(m, models.Metric{
-			MType: models.CounterType,
-			ID:    name,
-			Delta: &v,
+			MType:  models.CounterType,
+			ID:     id,
+			Delta:  &v,
+			Labels: labels,
 		})
 	}
-	return m, nil
+	for key, value := range ms.Histograms {
+		v := value.Copy()
+		id, labels := ms.describe(key)
+		m = append(m, models.Metric{
+			MType:     models.HistogramType,
+			ID:        id,
+			Histogram: &v,
+			Labels:    labels,
+		})
+	}
+	return m.Filter(matchers...)
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	result := make(map[string]string, len(labels))
+	for k, v := range labels {
+		result[k] = v
+	}
+	return result
 }
@@ -4,11 +4,16 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage/retention"
 )
 
 func TestSet(t *testing.T) {
@@ -283,3 +288,296 @@ func BenchmarkSet(b *testing.B) {
 		storage.Set(ctx, m)
 	}
 }
+
+func TestOnSet(t *testing.T) {
+	s := NewMetricsStorage()
+	got := make(models.Metrics, 0, 2)
+	s.OnSet(func(ctx context.Context, m models.Metric) {
+		got = append(got, m)
+	})
+	ctx := context.TODO()
+	m, err := models.NewMetric("c", models.CounterType, "2")
+	assert.NoError(t, err)
+	assert.NoError(t, s.Set(ctx, m))
+	assert.NoError(t, s.Set(ctx, m))
+	m, err = models.NewMetric("c", models.GaugeType, "invalid")
+	assert.Error(t, err)
+	assert.Error(t, s.Set(ctx, m))
+	assert.Len(t, got, 2)
+	v, err := got[1].GetValue()
+	assert.NoError(t, err)
+	assert.Equal(t, "4", v)
+}
+
+func TestOnSetOrder(t *testing.T) {
+	dir := t.TempDir()
+	s := NewMetricsStorage()
+	// fsync журнала между применением и обработчиками расширяет окно для перестановок
+	Configure(s, SetWAL(filepath.Join(dir, "wal"), SyncBatch, 0))
+	require.NoError(t, s.StartWAL(context.TODO(), filepath.Join(dir, "metrics.json")))
+	var got []models.Counter
+	s.OnSet(func(ctx context.Context, m models.Metric) {
+		// обработчики вызываются по очереди, одновременный вызов заметит -race
+		got = append(got, *m.Delta)
+	})
+	const n = 200
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m, err := models.NewMetric("requests", models.CounterType, "1")
+			assert.NoError(t, err)
+			assert.NoError(t, s.Set(context.TODO(), m))
+		}()
+	}
+	wg.Wait()
+	// итоги счётчика растут в порядке применения, поэтому приходят по возрастанию
+	require.Len(t, got, n)
+	for i, total := range got {
+		assert.Equal(t, models.Counter(i+1), total)
+	}
+}
+
+func TestSetMany(t *testing.T) {
+	s := NewMetricsStorage()
+	ctx := context.TODO()
+	notified := 0
+	s.OnSet(func(ctx context.Context, m models.Metric) {
+		notified++
+	})
+	batch := make(models.Metrics, 0, 3)
+	for _, v := range []string{"1", "2"} {
+		m, err := models.NewMetric("c", models.CounterType, v)
+		require.NoError(t, err)
+		batch = append(batch, m)
+	}
+	require.NoError(t, s.SetMany(ctx, batch))
+	assert.Equal(t, models.Counter(3), s.Counters["c"])
+	assert.Equal(t, 2, notified)
+
+	// ошибка в любой метрике отменяет всю пачку
+	batch = append(batch, models.Metric{ID: "g", MType: models.GaugeType})
+	assert.Error(t, s.SetMany(ctx, batch))
+	assert.Equal(t, models.Counter(3), s.Counters["c"])
+	assert.Equal(t, 2, notified)
+	assert.NoError(t, s.SetMany(ctx, nil))
+}
+
+func TestSetCumulative(t *testing.T) {
+	s := NewMetricsStorage()
+	ctx := context.TODO()
+	set := func(value, kind string) {
+		m, err := models.NewMetric("PollCount", models.CounterType, value)
+		require.NoError(t, err)
+		m.Kind = kind
+		require.NoError(t, s.Set(ctx, m))
+	}
+	set("3", models.DeltaKind)
+	// первое накопленное значение отсчитывается от нуля
+	set("10", models.CumulativeKind)
+	assert.Equal(t, models.Counter(13), s.Counters["PollCount"])
+	set("15", models.CumulativeKind)
+	set("15", models.CumulativeKind)
+	assert.Equal(t, models.Counter(18), s.Counters["PollCount"])
+	// после перезапуска источника значение уменьшилось
+	set("4", models.CumulativeKind)
+	assert.Equal(t, models.Counter(22), s.Counters["PollCount"])
+	set("1", "")
+	assert.Equal(t, models.Counter(23), s.Counters["PollCount"])
+
+	m, err := models.NewMetric("PollCount", models.CounterType, "-1")
+	require.NoError(t, err)
+	m.Kind = models.CumulativeKind
+	assert.Error(t, s.Set(ctx, m))
+	m, err = models.NewMetric("Alloc", models.GaugeType, "1")
+	require.NoError(t, err)
+	m.Kind = models.CumulativeKind
+	assert.Error(t, s.Set(ctx, m))
+
+	// последнее накопленное значение сохраняется в снимке
+	f := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, s.Save(ctx, f))
+	restored := NewMetricsStorage()
+	require.NoError(t, restored.Restore(ctx, f))
+	m, err = models.NewMetric("PollCount", models.CounterType, "6")
+	require.NoError(t, err)
+	m.Kind = models.CumulativeKind
+	require.NoError(t, restored.Set(ctx, m))
+	assert.Equal(t, models.Counter(25), restored.Counters["PollCount"])
+}
+
+func TestGetRange(t *testing.T) {
+	s := NewMetricsStorage()
+	Configure(s, SetHistorySize(3))
+	ctx := context.TODO()
+	from := time.Now()
+	for i := 1; i <= 5; i++ {
+		m, err := models.NewMetric("c", models.CounterType, "1")
+		assert.NoError(t, err)
+		assert.NoError(t, s.Set(ctx, m))
+	}
+	samples, err := s.GetRange(ctx, models.CounterType, "c", from, time.Now())
+	assert.NoError(t, err)
+	assert.Len(t, samples, 3)
+	for i, sample := range samples {
+		v, err := sample.GetValue()
+		assert.NoError(t, err)
+		assert.Equal(t, fmt.Sprint(i+3), v)
+	}
+	samples, err = s.GetRange(ctx, models.CounterType, "c", time.Now().Add(time.Minute), time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Empty(t, samples)
+	_, err = s.GetRange(ctx, models.GaugeType, "c", from, time.Now())
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLabels(t *testing.T) {
+	s := NewMetricsStorage()
+	ctx := context.Background()
+	for _, m := range []struct {
+		value  string
+		labels map[string]string
+	}{
+		{"1", map[string]string{"host": "a"}},
+		{"2", map[string]string{"host": "b"}},
+		{"3", map[string]string{"host": "a"}},
+		{"10", nil},
+	} {
+		metric, err := models.NewMetric("requests", models.CounterType, m.value)
+		assert.NoError(t, err)
+		metric.Labels = m.labels
+		assert.NoError(t, s.Set(ctx, metric))
+	}
+	invalid, err := models.NewMetric("requests", models.CounterType, "1")
+	assert.NoError(t, err)
+	invalid.Labels = map[string]string{"bad-name": "x"}
+	assert.ErrorIs(t, s.Set(ctx, invalid), models.ErrorLabels)
+
+	all, err := s.GetAll(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, all, 3)
+
+	m, err := s.Get(ctx, models.CounterType, "requests")
+	assert.NoError(t, err)
+	assert.Equal(t, models.Counter(10), *m.Delta)
+	assert.Nil(t, m.Labels)
+
+	hostA, err := models.NewMatcher("host", models.MatchEqual, "a")
+	assert.NoError(t, err)
+	m, err = s.Get(ctx, models.CounterType, "requests", hostA)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Counter(4), *m.Delta)
+	assert.Equal(t, map[string]string{"host": "a"}, m.Labels)
+
+	samples, err := s.GetRange(ctx, models.CounterType, "requests", time.Time{}, time.Now(), hostA)
+	assert.NoError(t, err)
+	assert.Len(t, samples, 2)
+
+	anyHost, err := models.NewMatcher("host", models.MatchRegexp, ".+")
+	assert.NoError(t, err)
+	filtered, err := s.GetAll(ctx, anyHost)
+	assert.NoError(t, err)
+	assert.Len(t, filtered, 2)
+
+	hostC, err := models.NewMatcher("host", models.MatchEqual, "c")
+	assert.NoError(t, err)
+	_, err = s.Get(ctx, models.CounterType, "requests", hostC)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestHistogram(t *testing.T) {
+	s := NewMetricsStorage()
+	ctx := context.Background()
+	for _, value := range []string{"0.1,1;1,0,0;0.05", "0.1,1;0,2,1;3", "1;1,1;2"} {
+		m, err := models.NewMetric("latency", models.HistogramType, value)
+		assert.NoError(t, err)
+		assert.NoError(t, s.Set(ctx, m))
+		if value == "0.1,1;0,2,1;3" {
+			got, err := s.Get(ctx, models.HistogramType, "latency")
+			assert.NoError(t, err)
+			v, err := got.GetValue()
+			assert.NoError(t, err)
+			assert.Equal(t, "0.1,1;1,2,1;3.05", v)
+			assert.Equal(t, uint64(4), got.Histogram.Count)
+		}
+	}
+	// границы изменились, значения заменяются
+	got, err := s.Get(ctx, models.HistogramType, "latency")
+	assert.NoError(t, err)
+	v, err := got.GetValue()
+	assert.NoError(t, err)
+	assert.Equal(t, "1;1,1;2", v)
+
+	invalid := models.Metric{ID: "latency", MType: models.HistogramType}
+	assert.Error(t, s.Set(ctx, invalid))
+	invalid.Histogram = &models.Histogram{Bounds: []float64{1}, Counts: []uint64{1}}
+	assert.Error(t, s.Set(ctx, invalid))
+}
+
+func TestCompact(t *testing.T) {
+	s := NewMetricsStorage()
+	policy, err := retention.ParsePolicy("raw:1d,1m:30d,1h:365d")
+	require.NoError(t, err)
+	Configure(s, SetRetention(policy))
+	ctx := context.TODO()
+	start := time.Now()
+	for _, v := range []string{"1", "3", "2"} {
+		m, err := models.NewMetric("g", models.GaugeType, v)
+		require.NoError(t, err)
+		require.NoError(t, s.Set(ctx, m))
+	}
+	for i := 0; i < 2; i++ {
+		m, err := models.NewMetric("c", models.CounterType, "5")
+		require.NoError(t, err)
+		require.NoError(t, s.Set(ctx, m))
+	}
+	h, err := models.NewMetric("h", models.HistogramType, "1;1,0;0.5")
+	require.NoError(t, err)
+	require.NoError(t, s.Set(ctx, h))
+
+	require.NoError(t, s.Compact(ctx, start.Add(2*time.Hour)))
+	far := start.Add(3 * time.Hour)
+	hours, err := s.GetRollups(ctx, models.GaugeType, "g", time.Hour, start.Add(-time.Hour), far)
+	require.NoError(t, err)
+	require.Len(t, hours, 1)
+	assert.Equal(t, uint64(3), hours[0].Count)
+	assert.Equal(t, 1.0, hours[0].Min)
+	assert.Equal(t, 3.0, hours[0].Max)
+	assert.Equal(t, 2.0, hours[0].Avg())
+	assert.Equal(t, 2.0, hours[0].Last)
+	hours, err = s.GetRollups(ctx, models.CounterType, "c", time.Hour, start.Add(-time.Hour), far)
+	require.NoError(t, err)
+	require.Len(t, hours, 1)
+	assert.Equal(t, 5.0, hours[0].Increase)
+	assert.Equal(t, 10.0, hours[0].Last)
+	minutes, err := s.GetRollups(ctx, models.CounterType, "c", time.Minute, start.Add(-time.Hour), far)
+	require.NoError(t, err)
+	assert.NotEmpty(t, minutes)
+	_, err = s.GetRollups(ctx, models.CounterType, "c", time.Second, start, far)
+	assert.ErrorIs(t, err, ErrNotFound)
+	hours, err = s.GetRollups(ctx, models.HistogramType, "h", time.Hour, start.Add(-time.Hour), far)
+	require.NoError(t, err)
+	assert.Empty(t, hours)
+
+	// повторное сворачивание не дублирует агрегаты
+	require.NoError(t, s.Compact(ctx, start.Add(2*time.Hour)))
+	minutes2, err := s.GetRollups(ctx, models.CounterType, "c", time.Minute, start.Add(-time.Hour), far)
+	require.NoError(t, err)
+	assert.Equal(t, minutes, minutes2)
+
+	// через два дня исходные значения удалены, агрегаты остаются
+	require.NoError(t, s.Compact(ctx, start.Add(48*time.Hour)))
+	samples, err := s.GetRange(ctx, models.GaugeType, "g", start.Add(-time.Hour), far)
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+	minutes, err = s.GetRollups(ctx, models.GaugeType, "g", time.Minute, start.Add(-time.Hour), far)
+	require.NoError(t, err)
+	assert.NotEmpty(t, minutes)
+
+	// через 400 дней удалено всё
+	require.NoError(t, s.Compact(ctx, start.Add(400*24*time.Hour)))
+	hours, err = s.GetRollups(ctx, models.GaugeType, "g", time.Hour, start.Add(-time.Hour), far)
+	require.NoError(t, err)
+	assert.Empty(t, hours)
+}
@@ -0,0 +1,39 @@
+package mem
+
+import (
+	"time"
+
+	"github.com/Nexadis/metalert/internal/storage/retention"
+)
+
+// SetHistorySize Устанавливает количество значений, хранимых для одной метрики.
+func SetHistorySize(size int) func(*Storage) {
+	return func(ms *Storage) {
+		ms.historySize = size
+	}
+}
+
+// SetRetention Устанавливает уровни хранения истории. Агрегаты вычисляются при вызове Compact
+func SetRetention(policy retention.Policy) func(*Storage) {
+	return func(ms *Storage) {
+		ms.policy = policy
+	}
+}
+
+// SetSnapshots Устанавливает количество хранимых поколений снимка.
+// При повреждении последнего снимка Restore использует предыдущие
+func SetSnapshots(n int) func(*Storage) {
+	return func(ms *Storage) {
+		ms.snapshots = n
+	}
+}
+
+// SetWAL Включает журнал предзаписи в каталоге dir. Журнал открывается в StartWAL,
+// interval задаёт период синхронизации для политики SyncInterval
+func SetWAL(dir string, policy SyncPolicy, interval time.Duration) func(*Storage) {
+	return func(ms *Storage) {
+		ms.walDir = dir
+		ms.walPolicy = policy
+		ms.walInterval = interval
+	}
+}
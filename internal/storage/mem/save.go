@@ -1,58 +1,209 @@
 package mem
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
-	"os"
+	"errors"
+	"io"
 	"time"
 
 	"github.com/Nexadis/metalert/internal/models"
 	"github.com/Nexadis/metalert/internal/utils/logger"
 )
 
-// Save Записывает все метрики в файл
+// snapshot - Содержимое снимка хранилища
+type snapshot struct {
+	Metrics models.Metrics `json:"metrics"`
+	// последние накопленные значения counter, от которых считается прирост следующих значений
+	Cumulative models.Metrics `json:"cumulative,omitempty"`
+	// история значений всех серий, для каждой серии от старых к новым
+	History models.Samples `json:"history,omitempty"`
+	// агрегаты истории всех уровней и конец последнего свёрнутого интервала по шагу уровня
+	Rollups   models.Rollups              `json:"rollups,omitempty"`
+	Compacted map[time.Duration]time.Time `json:"compacted,omitempty"`
+}
+
+// Save Записывает все метрики в файл. Снимок сначала записывается во временный файл
+// и атомарно заменяет текущий, предыдущий снимок сохраняется как следующее поколение.
+// Вместе со снимком начинается новый сегмент журнала, а сегменты, которые уже вошли
+// во все хранимые поколения снимка, удаляются
 func (ms *Storage) Save(ctx context.Context, FileStoragePath string) error {
 	fileName := FileStoragePath
 	if fileName == "" {
 		return nil
 	}
 	logger.Info("Write metrics to file")
-	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE, 0666)
+	ms.saveMutex.Lock()
+	defer ms.saveMutex.Unlock()
+	snap, walSeq, err := ms.checkpoint()
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	metrics, err := ms.GetAll(ctx)
+	data, err := json.Marshal(snap)
 	if err != nil {
 		return err
 	}
-	encoder := json.NewEncoder(file)
-	logger.Error(encoder.Encode(metrics))
+	err = writeSnapshot(fileName, data, ms.snapshots, walSeq)
+	if err != nil || ms.wal == nil {
+		return err
+	}
+	seqs := snapshotWALSeqs(fileName, ms.snapshots)
+	if len(seqs) == 0 {
+		return nil
+	}
+	return ms.wal.truncate(seqs[0])
+}
 
-	return nil
+// checkpoint Возвращает снимок хранилища и начинает новый сегмент журнала,
+// чтобы снимок и журнал не пересекались. Возвращает номер нового сегмента
+func (ms *Storage) checkpoint() (snapshot, uint64, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	snap := snapshot{
+		Metrics: ms.getAll(),
+	}
+	for key, value := range ms.cumulative {
+		v := value
+		id, labels := ms.describe(key)
+		snap.Cumulative = append(snap.Cumulative, models.Metric{
+			ID:     id,
+			MType:  models.CounterType,
+			Kind:   models.CumulativeKind,
+			Delta:  &v,
+			Labels: labels,
+		})
+	}
+	ms.snapshotHistory(&snap)
+	if ms.wal == nil {
+		return snap, 0, nil
+	}
+	walSeq, err := ms.wal.rotate()
+	if err != nil {
+		return snapshot{}, 0, err
+	}
+	return snap, walSeq, nil
+}
+
+// snapshotHistory Добавляет в снимок историю значений и агрегаты. Вызывается под блокировкой
+func (ms *Storage) snapshotHistory(snap *snapshot) {
+	for _, r := range ms.history {
+		snap.History = append(snap.History, r.between(time.Time{}, time.Unix(1<<62, 0))...)
+	}
+	for _, tiers := range ms.rollups {
+		for _, rollups := range tiers {
+			snap.Rollups = append(snap.Rollups, rollups...)
+		}
+	}
+	for i, t := range ms.policy.Rollups() {
+		if i >= len(ms.compacted) || ms.compacted[i].IsZero() {
+			continue
+		}
+		if snap.Compacted == nil {
+			snap.Compacted = make(map[time.Duration]time.Time)
+		}
+		snap.Compacted[t.Resolution] = ms.compacted[i]
+	}
+}
+
+// restoreHistory Восстанавливает историю значений и агрегаты из снимка. Если в снимке есть история,
+// она заменяет значения, записанные при восстановлении метрик. Агрегаты уровней,
+// которых нет в текущей политике хранения, пропускаются. Вызывается под блокировкой
+func (ms *Storage) restoreHistory(snap snapshot) {
+	if len(snap.History) != 0 {
+		ms.history = make(map[string]*ring)
+	}
+	for _, s := range snap.History {
+		ms.record(s.Metric, s.Timestamp)
+	}
+	tiers := ms.policy.Rollups()
+	if len(tiers) == 0 {
+		return
+	}
+	if ms.rollups == nil {
+		ms.rollups = make(map[string][]models.Rollups)
+	}
+	for _, r := range snap.Rollups {
+		tier, ok := ms.policy.Tier(r.Resolution)
+		if !ok {
+			continue
+		}
+		key := r.MType + "/" + r.Series()
+		if _, ok := ms.rollups[key]; !ok {
+			ms.rollups[key] = make([]models.Rollups, len(tiers))
+		}
+		ms.rollups[key][tier] = append(ms.rollups[key][tier], r)
+	}
+	ms.compacted = make([]time.Time, len(tiers))
+	for i, t := range tiers {
+		ms.compacted[i] = snap.Compacted[t.Resolution]
+	}
 }
 
-// Restore Восстанавливает состояние хранилища из файла
+// Restore Восстанавливает состояние хранилища из файла и применяет журнал, записанный после снимка.
+// Если последний снимок повреждён, используется предыдущее поколение
 func (ms *Storage) Restore(ctx context.Context, FileStoragePath string) error {
 	fileName := FileStoragePath
 	if fileName == "" {
 		return nil
 	}
 	logger.Info("Read metrics from file")
-	file, err := os.OpenFile(fileName, os.O_RDONLY, 0666)
+	var snap snapshot
+	var walSeq uint64
+	err := readSnapshots(fileName, ms.snapshots, func(data []byte, seq uint64) error {
+		snap, walSeq = snapshot{}, seq
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		// снимок старого формата - массив метрик
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+			return decoder.Decode(&snap.Metrics)
+		}
+		return decoder.Decode(&snap)
+	})
+	if errors.Is(err, io.EOF) {
+		logger.Info("No snapshots in", fileName)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	err = ms.SetMany(ctx, snap.Metrics)
 	if err != nil {
-		logger.Error(err)
+		return err
+	}
+	ms.mutex.Lock()
+	for _, m := range snap.Cumulative {
+		if m.Delta != nil {
+			ms.cumulative[m.Series()] = *m.Delta
+		}
+	}
+	ms.restoreHistory(snap)
+	ms.mutex.Unlock()
+	return ms.replay(ctx, walSeq)
+}
+
+// replay Применяет сегменты журнала, начиная с from. Недописанная запись в конце сегмента
+// остаётся после сбоя, поэтому повреждённый хвост пропускается
+func (ms *Storage) replay(ctx context.Context, from uint64) error {
+	if ms.walDir == "" {
 		return nil
 	}
-	defer file.Close()
-	metrics := make([]*models.Metric, 1)
-	decoder := json.NewDecoder(file)
-	err = decoder.Decode(&metrics)
+	segments, err := walSegments(ms.walDir)
 	if err != nil {
 		return err
 	}
-	for _, m := range metrics {
-		err = ms.Set(ctx, *m)
+	for _, seq := range segments {
+		if seq < from {
+			continue
+		}
+		name := segmentName(ms.walDir, seq)
+		logger.Info("Replay", name)
+		err = readSegment(name, func(batch models.Metrics) error {
+			return ms.SetMany(ctx, batch)
+		})
+		if errors.Is(err, ErrCorruptWAL) {
+			logger.Error("Skip tail of", name, err)
+			continue
+		}
 		if err != nil {
 			return err
 		}
@@ -60,6 +211,65 @@ func (ms *Storage) Restore(ctx context.Context, FileStoragePath string) error {
 	return nil
 }
 
+// StartWAL Открывает журнал предзаписи, если он включён опцией SetWAL.
+// Вызывается после Restore: новые записи попадают в новый сегмент после всех существующих.
+// Для политики SyncInterval журнал синхронизируется в фоне, пока не отменён контекст
+func (ms *Storage) StartWAL(ctx context.Context, FileStoragePath string) error {
+	if ms.walDir == "" {
+		return nil
+	}
+	segments, err := walSegments(ms.walDir)
+	if err != nil {
+		return err
+	}
+	next := uint64(1)
+	if len(segments) != 0 {
+		next = segments[len(segments)-1] + 1
+	}
+	// номер сегмента не должен быть меньше указанного в снимке, иначе сегмент не будет применён
+	if seqs := snapshotWALSeqs(FileStoragePath, ms.snapshots); len(seqs) != 0 && seqs[len(seqs)-1] > next {
+		next = seqs[len(seqs)-1]
+	}
+	w, err := openWAL(ms.walDir, ms.walPolicy, next)
+	if err != nil {
+		return err
+	}
+	ms.mutex.Lock()
+	ms.wal = w
+	ms.mutex.Unlock()
+	go ms.syncWAL(ctx, w)
+	return nil
+}
+
+// syncWAL Синхронизирует журнал с интервалом для политики SyncInterval и при завершении контекста
+func (ms *Storage) syncWAL(ctx context.Context, w *wal) {
+	var tick <-chan time.Time
+	if w.policy == SyncInterval {
+		interval := ms.walInterval
+		if interval <= 0 {
+			interval = time.Second
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-tick:
+			err := w.sync()
+			if err != nil {
+				logger.Error("Can't sync wal:", err)
+			}
+		case <-ctx.Done():
+			err := w.sync()
+			if err != nil {
+				logger.Error("Can't sync wal:", err)
+			}
+			return
+		}
+	}
+}
+
 // SaveTimer Сохраняет текущее состояние хранилища в файл с заданным интервалом. Также сохраняет всё при завершении контекста
 func (ms *Storage) SaveTimer(ctx context.Context, FileStoragePath string, interval int64) {
 	if interval <= 0 {
@@ -3,11 +3,14 @@ package mem
 import (
 	"context"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage/retention"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSaveRestore(t *testing.T) {
@@ -22,7 +25,48 @@ func TestSaveRestore(t *testing.T) {
 	restored := NewMetricsStorage()
 	err = restored.Restore(context.TODO(), f)
 	assert.NoError(t, err)
-	assert.Equal(t, ms, restored)
+	assertSameValues(t, ms, restored)
+}
+
+func TestSaveRestoreHistory(t *testing.T) {
+	policy, err := retention.ParsePolicy("raw:1d,1m:30d,1h:365d")
+	require.NoError(t, err)
+	ctx := context.TODO()
+	ms := NewMetricsStorage()
+	Configure(ms, SetRetention(policy))
+	start := time.Now()
+	for _, v := range []string{"1", "3", "2"} {
+		m, err := models.NewMetric("g", models.GaugeType, v)
+		require.NoError(t, err)
+		require.NoError(t, ms.Set(ctx, m))
+	}
+	require.NoError(t, ms.Compact(ctx, start.Add(2*time.Hour)))
+	f := filepath.Join(t.TempDir(), "history.json")
+	require.NoError(t, ms.Save(ctx, f))
+
+	restored := NewMetricsStorage()
+	Configure(restored, SetRetention(policy))
+	require.NoError(t, restored.Restore(ctx, f))
+	assertSameValues(t, ms, restored)
+	far := start.Add(3 * time.Hour)
+	samples, err := restored.GetRange(ctx, models.GaugeType, "g", start.Add(-time.Hour), far)
+	require.NoError(t, err)
+	require.Len(t, samples, 3)
+	assert.Equal(t, models.Gauge(2), *samples[2].Value)
+	for _, resolution := range []time.Duration{time.Minute, time.Hour} {
+		want, err := ms.GetRollups(ctx, models.GaugeType, "g", resolution, start.Add(-time.Hour), far)
+		require.NoError(t, err)
+		got, err := restored.GetRollups(ctx, models.GaugeType, "g", resolution, start.Add(-time.Hour), far)
+		require.NoError(t, err)
+		require.Len(t, got, len(want))
+		assert.Equal(t, want[0].Count, got[0].Count)
+		assert.True(t, want[0].Start.Equal(got[0].Start))
+	}
+	// свёрнутые до перезапуска интервалы не сворачиваются повторно
+	require.NoError(t, restored.Compact(ctx, start.Add(2*time.Hour)))
+	hours, err := restored.GetRollups(ctx, models.GaugeType, "g", time.Hour, start.Add(-time.Hour), far)
+	require.NoError(t, err)
+	assert.Len(t, hours, 1)
 }
 
 func TestSaveTimer(t *testing.T) {
@@ -39,7 +83,7 @@ func TestSaveTimer(t *testing.T) {
 	time.Sleep(1500 * time.Millisecond)
 	err = restored.Restore(ctx, f)
 	assert.NoError(t, err)
-	assert.Equal(t, ms, restored)
+	assertSameValues(t, ms, restored)
 	m, err = models.NewMetric("test", models.GaugeType, "1")
 	assert.NoError(t, err)
 	err = ms.Set(ctx, m)
@@ -50,5 +94,56 @@ func TestSaveTimer(t *testing.T) {
 	restored = NewMetricsStorage()
 	err = restored.Restore(context.TODO(), f)
 	assert.NoError(t, err)
-	assert.Equal(t, ms, restored)
+	assertSameValues(t, ms, restored)
+}
+
+// assertSameValues Сравнивает текущие значения метрик
+func assertSameValues(t *testing.T, want, got *Storage) {
+	assert.Equal(t, want.Gauges, got.Gauges)
+	assert.Equal(t, want.Counters, got.Counters)
+	assert.Equal(t, want.Histograms, got.Histograms)
+}
+
+func TestSnapshotGenerations(t *testing.T) {
+	ctx := context.TODO()
+	f := filepath.Join(t.TempDir(), "metrics.json")
+	ms := NewMetricsStorage()
+	Configure(ms, SetSnapshots(2))
+	for _, v := range []string{"1", "2", "3"} {
+		m, err := models.NewMetric("id", models.GaugeType, v)
+		require.NoError(t, err)
+		require.NoError(t, ms.Set(ctx, m))
+		require.NoError(t, ms.Save(ctx, f))
+	}
+	files, err := filepath.Glob(f + "*")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{f, f + ".1"}, files)
+
+	restore := func() (*Storage, error) {
+		restored := NewMetricsStorage()
+		Configure(restored, SetSnapshots(2))
+		return restored, restored.Restore(ctx, f)
+	}
+	restored, err := restore()
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, float64(restored.Gauges["id"]))
+
+	// повреждённый последний снимок заменяется предыдущим поколением
+	data, err := os.ReadFile(f)
+	require.NoError(t, err)
+	data[len(data)-3] ^= 0xff
+	require.NoError(t, os.WriteFile(f, data, 0666))
+	restored, err = restore()
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, float64(restored.Gauges["id"]))
+
+	require.NoError(t, os.WriteFile(f+".1", data[:len(data)/2], 0666))
+	_, err = restore()
+	assert.ErrorIs(t, err, ErrCorruptSnapshot)
+
+	// файл старого формата без заголовка читается как раньше
+	require.NoError(t, os.WriteFile(f, []byte(`[{"id":"id","type":"gauge","value":5}]`), 0666))
+	restored, err = restore()
+	require.NoError(t, err)
+	assert.Equal(t, 5.0, float64(restored.Gauges["id"]))
 }
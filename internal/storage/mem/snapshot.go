@@ -0,0 +1,185 @@
+package mem
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Nexadis/metalert/internal/utils/logger"
+)
+
+// DefaultSnapshots - Количество хранимых поколений снимка хранилища
+const DefaultSnapshots = 3
+
+// snapshotMagic - Начало заголовка снимка. Заголовок занимает первую строку файла:
+// "metalert-snapshot v1 <размер данных> <crc32c данных в hex> <первый сегмент журнала>".
+// Номер сегмента журнала может отсутствовать, тогда журнал применяется целиком
+const snapshotMagic = "metalert-snapshot v1"
+
+// ErrCorruptSnapshot - Снимок повреждён: неверный заголовок, размер или контрольная сумма
+var ErrCorruptSnapshot = errors.New("corrupt snapshot")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// generation Возвращает имя файла поколения снимка: 0 - самый новый
+func generation(path string, n int) string {
+	if n == 0 {
+		return path
+	}
+	return path + "." + strconv.Itoa(n)
+}
+
+// writeSnapshot Атомарно записывает снимок в path и сдвигает прошлые поколения.
+// Данные записываются во временный файл в том же каталоге, синхронизируются на диск
+// и только затем заменяют текущий снимок. Хранится не больше keep поколений.
+// walSeq - номер первого сегмента журнала, не вошедшего в снимок
+func writeSnapshot(path string, data []byte, keep int, walSeq uint64) error {
+	if keep <= 0 {
+		keep = 1
+	}
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	_, err = fmt.Fprintf(w, "%s %d %08x %d\n", snapshotMagic, len(data), crc32.Checksum(data, castagnoli), walSeq)
+	if err == nil {
+		_, err = w.Write(data)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	for n := keep - 1; n > 0; n-- {
+		err = os.Rename(generation(path, n-1), generation(path, n))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir Синхронизирует каталог, чтобы переименования пережили сбой
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	err = d.Sync()
+	// не все системы позволяют синхронизировать каталог
+	if errors.Is(err, os.ErrInvalid) {
+		return nil
+	}
+	return err
+}
+
+// readSnapshot Читает снимок, проверяет его контрольную сумму и возвращает данные
+// вместе с номером первого сегмента журнала, который нужно применить после снимка.
+// Файл без заголовка считается снимком старого формата и возвращается без проверки
+func readSnapshot(path string) ([]byte, uint64, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !bytes.HasPrefix(raw, []byte(snapshotMagic)) {
+		return raw, 0, nil
+	}
+	header, data, ok := bytes.Cut(raw, []byte("\n"))
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: no header", ErrCorruptSnapshot)
+	}
+	fields := strings.Fields(string(header[len(snapshotMagic):]))
+	if len(fields) != 2 && len(fields) != 3 {
+		return nil, 0, fmt.Errorf("%w: invalid header", ErrCorruptSnapshot)
+	}
+	size, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+	}
+	sum, err := strconv.ParseUint(fields[1], 16, 32)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+	}
+	var walSeq uint64
+	if len(fields) == 3 {
+		walSeq, err = strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+		}
+	}
+	if len(data) != size {
+		return nil, 0, fmt.Errorf("%w: size %d, want %d", ErrCorruptSnapshot, len(data), size)
+	}
+	if crc32.Checksum(data, castagnoli) != uint32(sum) {
+		return nil, 0, fmt.Errorf("%w: checksum mismatch", ErrCorruptSnapshot)
+	}
+	return data, walSeq, nil
+}
+
+// snapshotWALSeqs Возвращает по возрастанию номера первых сегментов журнала читаемых поколений снимка
+func snapshotWALSeqs(path string, keep int) []uint64 {
+	var seqs []uint64
+	for n := 0; n < keep || n == 0; n++ {
+		_, walSeq, err := readSnapshot(generation(path, n))
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, walSeq)
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i] < seqs[j]
+	})
+	return seqs
+}
+
+// readSnapshots Перебирает поколения снимка от нового к старому и передаёт данные в load,
+// пока одно из поколений не будет прочитано. Возвращает io.EOF, если снимков нет
+func readSnapshots(path string, keep int, load func(data []byte, walSeq uint64) error) error {
+	if keep <= 0 {
+		keep = 1
+	}
+	var errs []error
+	for n := 0; n < keep; n++ {
+		name := generation(path, n)
+		data, walSeq, err := readSnapshot(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err == nil {
+			err = load(data, walSeq)
+		}
+		if err == nil {
+			return nil
+		}
+		err = fmt.Errorf("%s: %w", name, err)
+		logger.Error("Can't read snapshot, try previous generation:", err)
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return io.EOF
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,272 @@
+package mem
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+// SyncPolicy - Политика синхронизации журнала с диском
+type SyncPolicy string
+
+// Политики синхронизации журнала
+const (
+	SyncAlways   SyncPolicy = "always"   // каждая запись синхронизируется до ответа
+	SyncBatch    SyncPolicy = "batch"    // одновременные записи синхронизируются одним fsync до ответа
+	SyncInterval SyncPolicy = "interval" // журнал синхронизируется в фоне с заданным интервалом
+)
+
+// Ошибки журнала предзаписи.
+var (
+	ErrInvalidSyncPolicy = errors.New("invalid wal sync policy")
+	ErrCorruptWAL        = errors.New("corrupt wal")
+)
+
+// ParseSyncPolicy Разбирает политику синхронизации журнала
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch p := SyncPolicy(strings.ToLower(s)); p {
+	case SyncAlways, SyncBatch, SyncInterval:
+		return p, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidSyncPolicy, s)
+}
+
+// walHeaderSize - Размер заголовка записи журнала: длина данных и их crc32c
+const walHeaderSize = 8
+
+// walMaxRecord - Наибольший размер записи журнала. Больший размер в заголовке означает повреждение
+const walMaxRecord = 16 << 20
+
+const walExt = ".wal"
+
+// wal - Журнал предзаписи. Каждая запись - пачка метрик из SetMany в JSON с длиной и контрольной суммой,
+// поэтому пачка применяется при восстановлении целиком или не применяется вовсе.
+// Журнал разбит на сегменты: при сохранении снимка начинается новый сегмент,
+// а сегменты, вошедшие во все хранимые снимки, удаляются
+type wal struct {
+	dir    string
+	policy SyncPolicy
+
+	mutex   sync.Mutex // защищает file, seq и written
+	file    *os.File
+	seq     uint64 // номер текущего сегмента
+	written uint64 // количество записей в журнале
+
+	syncMutex sync.Mutex // упорядочивает fsync
+	synced    uint64     // количество записей, синхронизированных с диском
+}
+
+// openWAL Открывает журнал в каталоге dir и начинает сегмент seq
+func openWAL(dir string, policy SyncPolicy, seq uint64) (*wal, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	w := &wal{
+		dir:    dir,
+		policy: policy,
+		seq:    seq,
+	}
+	w.file, err = w.create(seq)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *wal) create(seq uint64) (*os.File, error) {
+	f, err := os.OpenFile(segmentName(w.dir, seq), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return f, syncDir(w.dir)
+}
+
+// append Записывает пачку метрик в журнал и возвращает номер записи для commit
+func (w *wal) append(ms models.Metrics) (uint64, error) {
+	payload, err := json.Marshal(ms)
+	if err != nil {
+		return 0, err
+	}
+	record := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], crc32.Checksum(payload, castagnoli))
+	copy(record[walHeaderSize:], payload)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, err = w.file.Write(record)
+	if err != nil {
+		return 0, err
+	}
+	w.written++
+	return w.written, nil
+}
+
+// commit Дожидается, пока запись n окажется на диске, в соответствии с политикой синхронизации
+func (w *wal) commit(n uint64) error {
+	switch w.policy {
+	case SyncAlways:
+		w.syncMutex.Lock()
+		defer w.syncMutex.Unlock()
+		return w.syncLocked()
+	case SyncBatch:
+		w.syncMutex.Lock()
+		defer w.syncMutex.Unlock()
+		// запись уже синхронизирована вместе с другой записью
+		if w.synced >= n {
+			return nil
+		}
+		return w.syncLocked()
+	}
+	return nil
+}
+
+// sync Синхронизирует все записанные данные с диском
+func (w *wal) sync() error {
+	w.syncMutex.Lock()
+	defer w.syncMutex.Unlock()
+	return w.syncLocked()
+}
+
+// syncLocked Синхронизирует журнал. Вызывается под syncMutex
+func (w *wal) syncLocked() error {
+	w.mutex.Lock()
+	f, written := w.file, w.written
+	w.mutex.Unlock()
+	err := f.Sync()
+	if err != nil {
+		return err
+	}
+	if written > w.synced {
+		w.synced = written
+	}
+	return nil
+}
+
+// rotate Синхронизирует текущий сегмент и начинает новый. Возвращает номер нового сегмента
+func (w *wal) rotate() (uint64, error) {
+	w.syncMutex.Lock()
+	defer w.syncMutex.Unlock()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	err := w.file.Sync()
+	if err != nil {
+		return 0, err
+	}
+	w.synced = w.written
+	f, err := w.create(w.seq + 1)
+	if err != nil {
+		return 0, err
+	}
+	w.file.Close()
+	w.file = f
+	w.seq++
+	return w.seq, nil
+}
+
+// truncate Удаляет сегменты с номерами меньше seq
+func (w *wal) truncate(seq uint64) error {
+	segments, err := walSegments(w.dir)
+	if err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if s >= seq {
+			break
+		}
+		err = os.Remove(segmentName(w.dir, s))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func segmentName(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%016d%s", seq, walExt))
+}
+
+// walSegments Возвращает номера сегментов журнала в каталоге dir по возрастанию
+func walSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var segments []uint64
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), walExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		seq, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, seq)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i] < segments[j]
+	})
+	return segments, nil
+}
+
+// readSegment Передаёт в fn записи сегмента по порядку.
+// Чтение останавливается на недописанной или повреждённой записи, это возвращается как ErrCorruptWAL
+func readSegment(path string, fn func(ms models.Metrics) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := make([]byte, walHeaderSize)
+	for {
+		_, err = io.ReadFull(f, header)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: torn record header", ErrCorruptWAL)
+		}
+		size := binary.BigEndian.Uint32(header)
+		if size > walMaxRecord {
+			return fmt.Errorf("%w: record of %d bytes", ErrCorruptWAL, size)
+		}
+		payload := make([]byte, size)
+		_, err = io.ReadFull(f, payload)
+		if err != nil {
+			return fmt.Errorf("%w: torn record", ErrCorruptWAL)
+		}
+		if crc32.Checksum(payload, castagnoli) != binary.BigEndian.Uint32(header[4:]) {
+			return fmt.Errorf("%w: record checksum mismatch", ErrCorruptWAL)
+		}
+		var ms models.Metrics
+		// записи первой версии журнала содержат одну метрику
+		if len(payload) != 0 && payload[0] == '{' {
+			ms = make(models.Metrics, 1)
+			err = json.Unmarshal(payload, &ms[0])
+		} else {
+			err = json.Unmarshal(payload, &ms)
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(ms)
+		if err != nil {
+			return err
+		}
+	}
+}
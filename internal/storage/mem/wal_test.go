@@ -0,0 +1,132 @@
+package mem
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+func TestParseSyncPolicy(t *testing.T) {
+	for _, s := range []string{"always", "batch", "Interval"} {
+		_, err := ParseSyncPolicy(s)
+		assert.NoError(t, err)
+	}
+	_, err := ParseSyncPolicy("never")
+	assert.ErrorIs(t, err, ErrInvalidSyncPolicy)
+}
+
+func TestWAL(t *testing.T) {
+	dir := t.TempDir()
+	f := filepath.Join(dir, "metrics.json")
+	walDir := filepath.Join(dir, "wal")
+	for _, policy := range []SyncPolicy{SyncAlways, SyncBatch, SyncInterval} {
+		t.Run(string(policy), func(t *testing.T) {
+			require.NoError(t, os.RemoveAll(walDir))
+			os.Remove(f)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			open := func() *Storage {
+				ms := NewMetricsStorage()
+				Configure(ms, SetWAL(walDir, policy, 0))
+				require.NoError(t, ms.Restore(ctx, f))
+				require.NoError(t, ms.StartWAL(ctx, f))
+				return ms
+			}
+			add := func(ms *Storage, n int) {
+				var wg sync.WaitGroup
+				for i := 0; i < n; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						m, err := models.NewMetric("requests", models.CounterType, "1")
+						assert.NoError(t, err)
+						assert.NoError(t, ms.Set(ctx, m))
+					}()
+				}
+				wg.Wait()
+			}
+
+			ms := open()
+			add(ms, 50)
+			// без снимка состояние полностью восстанавливается из журнала
+			restored := open()
+			assertSameValues(t, ms, restored)
+
+			add(restored, 10)
+			require.NoError(t, restored.Save(ctx, f))
+			add(restored, 5)
+			// после снимка применяется только хвост журнала, счётчики не удваиваются
+			again := open()
+			assert.Equal(t, models.Counter(65), again.Counters["requests"])
+
+			// недописанная запись после сбоя пропускается
+			segments, err := walSegments(walDir)
+			require.NoError(t, err)
+			last := segmentName(walDir, segments[len(segments)-1])
+			add(again, 1)
+			file, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0666)
+			require.NoError(t, err)
+			_, err = file.Write([]byte{0, 0, 0, 10, 1, 2})
+			require.NoError(t, err)
+			require.NoError(t, file.Close())
+			assert.Equal(t, models.Counter(66), open().Counters["requests"])
+		})
+	}
+}
+
+func TestWALTruncate(t *testing.T) {
+	dir := t.TempDir()
+	f := filepath.Join(dir, "metrics.json")
+	walDir := filepath.Join(dir, "wal")
+	ctx := context.TODO()
+	ms := NewMetricsStorage()
+	Configure(ms, SetWAL(walDir, SyncBatch, 0), SetSnapshots(2))
+	require.NoError(t, ms.StartWAL(ctx, f))
+	for i := 0; i < 4; i++ {
+		m, err := models.NewMetric("id", models.GaugeType, "1")
+		require.NoError(t, err)
+		require.NoError(t, ms.Set(ctx, m))
+		require.NoError(t, ms.Save(ctx, f))
+	}
+	// сегменты нужны только для поколений снимка, которые ещё хранятся
+	segments, err := walSegments(walDir)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{4, 5}, segments)
+}
+
+func TestWALSyncError(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.TODO()
+	ms := NewMetricsStorage()
+	Configure(ms, SetWAL(filepath.Join(dir, "wal"), SyncAlways, 0))
+	require.NoError(t, ms.StartWAL(ctx, filepath.Join(dir, "metrics.json")))
+	var notified models.Metrics
+	ms.OnSet(func(ctx context.Context, m models.Metric) {
+		notified = append(notified, m)
+	})
+	// запись в канал проходит, а fsync для него возвращает ошибку
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		r.Close()
+		w.Close()
+	})
+	ms.wal.file.Close()
+	ms.wal.file = w
+
+	m, err := models.NewMetric("requests", models.CounterType, "2")
+	require.NoError(t, err)
+	// запись не подтверждается, пока она не на диске
+	require.Error(t, ms.SetMany(ctx, models.Metrics{m}))
+	got, err := ms.Get(ctx, models.CounterType, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, models.Counter(2), *got.Delta)
+	assert.Len(t, notified, 1)
+}
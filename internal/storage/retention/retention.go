@@ -0,0 +1,256 @@
+// retention описывает уровни хранения истории метрик и сворачивание значений в агрегаты
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+// ErrInvalidPolicy - Неверно заданы уровни хранения
+var ErrInvalidPolicy = errors.New("invalid retention policy")
+
+// Raw - Обозначение уровня исходных значений в текстовом виде политики
+const Raw = "raw"
+
+// Tier - Уровень хранения: значения с шагом Resolution хранятся Retention.
+// Нулевой Resolution обозначает исходные значения
+type Tier struct {
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+// Policy - Уровни хранения от исходных значений к самым крупным агрегатам.
+// Пустая политика хранит историю без ограничения по времени и без агрегатов
+type Policy []Tier
+
+// DefaultPolicy - Исходные значения за сутки, минутные агрегаты за 30 дней и часовые за год
+var DefaultPolicy = Policy{
+	{Resolution: 0, Retention: 24 * time.Hour},
+	{Resolution: time.Minute, Retention: 30 * 24 * time.Hour},
+	{Resolution: time.Hour, Retention: 365 * 24 * time.Hour},
+}
+
+// ParsePolicy Разбирает политику вида "raw:24h,1m:30d,1h:365d"
+func ParsePolicy(s string) (Policy, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Policy{}, nil
+	}
+	var p Policy
+	for _, part := range strings.Split(s, ",") {
+		resolution, retention, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q must be resolution:retention", ErrInvalidPolicy, part)
+		}
+		var t Tier
+		var err error
+		if resolution != Raw {
+			t.Resolution, err = parseDuration(resolution)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+			}
+		}
+		t.Retention, err = parseDuration(retention)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+		}
+		p = append(p, t)
+	}
+	return p, p.Check()
+}
+
+// parseDuration Разбирает длительность как time.ParseDuration, дополнительно понимает дни: "30d"
+func parseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseUint(days, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// formatDuration Записывает длительность в виде, понятном parseDuration
+func formatDuration(d time.Duration) string {
+	day := 24 * time.Hour
+	if d >= day && d%day == 0 {
+		return strconv.FormatInt(int64(d/day), 10) + "d"
+	}
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// Check Проверяет, что первый уровень хранит исходные значения, шаг агрегатов кратен секунде
+// и шагу предыдущего уровня, а время хранения положительно
+func (p Policy) Check() error {
+	for i, t := range p {
+		if t.Retention <= 0 {
+			return fmt.Errorf("%w: retention must be positive", ErrInvalidPolicy)
+		}
+		if i == 0 {
+			if t.Resolution != 0 {
+				return fmt.Errorf("%w: first tier must keep raw samples", ErrInvalidPolicy)
+			}
+			continue
+		}
+		if t.Resolution <= 0 || t.Resolution%time.Second != 0 {
+			return fmt.Errorf("%w: resolution %v must be a positive number of seconds", ErrInvalidPolicy, t.Resolution)
+		}
+		prev := p[i-1].Resolution
+		if i > 1 && (t.Resolution <= prev || t.Resolution%prev != 0) {
+			return fmt.Errorf("%w: resolution %v must be a multiple of %v", ErrInvalidPolicy, t.Resolution, prev)
+		}
+	}
+	return nil
+}
+
+// RawRetention Возвращает время хранения исходных значений, 0 - без ограничения
+func (p Policy) RawRetention() time.Duration {
+	if len(p) == 0 {
+		return 0
+	}
+	return p[0].Retention
+}
+
+// Rollups Возвращает уровни агрегатов
+func (p Policy) Rollups() []Tier {
+	if len(p) < 2 {
+		return nil
+	}
+	return p[1:]
+}
+
+// Tier Возвращает номер уровня агрегатов с шагом resolution среди Rollups
+func (p Policy) Tier(resolution time.Duration) (int, bool) {
+	for i, t := range p.Rollups() {
+		if t.Resolution == resolution {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (p Policy) String() string {
+	parts := make([]string, 0, len(p))
+	for _, t := range p {
+		resolution := Raw
+		if t.Resolution != 0 {
+			resolution = formatDuration(t.Resolution)
+		}
+		parts = append(parts, resolution+":"+formatDuration(t.Retention))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set Разбирает политику из флага командной строки
+func (p *Policy) Set(value string) error {
+	policy, err := ParsePolicy(value)
+	if err != nil {
+		return err
+	}
+	*p = policy
+	return nil
+}
+
+func (p Policy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText Разбирает политику из переменной окружения или файла конфигурации
+func (p *Policy) UnmarshalText(text []byte) error {
+	return p.Set(string(text))
+}
+
+// BucketStart Возвращает начало интервала длиной resolution, в который попадает t.
+// Интервалы отсчитываются от начала эпохи Unix, как и в Postgres
+func BucketStart(t time.Time, resolution time.Duration) time.Time {
+	ns := t.UnixNano()
+	start := ns - ns%int64(resolution)
+	if ns < 0 && ns%int64(resolution) != 0 {
+		start -= int64(resolution)
+	}
+	return time.Unix(0, start)
+}
+
+// Downsample Сворачивает значения серии в агрегаты с шагом resolution.
+// prev - последнее значение перед samples, нужно для прироста counter на границе интервалов
+func Downsample(samples models.Samples, prev *models.Sample, resolution time.Duration) (models.Rollups, error) {
+	var result models.Rollups
+	var last float64
+	hasLast := false
+	if prev != nil {
+		v, err := prev.GetFloat()
+		if err != nil {
+			return nil, err
+		}
+		last, hasLast = v, true
+	}
+	for _, s := range samples {
+		v, err := s.GetFloat()
+		if err != nil {
+			return nil, err
+		}
+		start := BucketStart(s.Timestamp, resolution)
+		if len(result) == 0 || !result[len(result)-1].Start.Equal(start) {
+			result = append(result, models.Rollup{
+				Metric:     models.Metric{ID: s.ID, MType: s.MType, Labels: s.Labels},
+				Start:      start,
+				Resolution: resolution,
+				Min:        math.Inf(1),
+				Max:        math.Inf(-1),
+			})
+		}
+		r := &result[len(result)-1]
+		r.Count++
+		r.Min = math.Min(r.Min, v)
+		r.Max = math.Max(r.Max, v)
+		r.Sum += v
+		r.Last = v
+		if s.MType == models.CounterType && hasLast {
+			if v >= last {
+				r.Increase += v - last
+			} else {
+				// после сброса счётчик начинает отсчёт с нуля
+				r.Increase += v
+			}
+		}
+		last, hasLast = v, true
+	}
+	return result, nil
+}
+
+// Merge Сворачивает агрегаты одной серии в более крупные с шагом resolution
+func Merge(rollups models.Rollups, resolution time.Duration) models.Rollups {
+	var result models.Rollups
+	for _, r := range rollups {
+		start := BucketStart(r.Start, resolution)
+		if len(result) == 0 || !result[len(result)-1].Start.Equal(start) {
+			merged := r
+			merged.Start = start
+			merged.Resolution = resolution
+			result = append(result, merged)
+			continue
+		}
+		m := &result[len(result)-1]
+		m.Count += r.Count
+		m.Min = math.Min(m.Min, r.Min)
+		m.Max = math.Max(m.Max, r.Max)
+		m.Sum += r.Sum
+		m.Last = r.Last
+		m.Increase += r.Increase
+	}
+	return result
+}
@@ -0,0 +1,97 @@
+package retention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("raw:24h, 1m:30d, 1h:365d")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultPolicy, p)
+	assert.Equal(t, "raw:1d,1m:30d,1h:365d", p.String())
+	assert.Equal(t, 24*time.Hour, p.RawRetention())
+	tier, ok := p.Tier(time.Hour)
+	assert.True(t, ok)
+	assert.Equal(t, 1, tier)
+	_, ok = p.Tier(time.Second)
+	assert.False(t, ok)
+
+	p, err = ParsePolicy("raw:90m,30s:2h30m")
+	require.NoError(t, err)
+	assert.Equal(t, "raw:1h30m,30s:2h30m", p.String())
+
+	p, err = ParsePolicy("")
+	require.NoError(t, err)
+	assert.Empty(t, p.Rollups())
+	assert.Zero(t, p.RawRetention())
+
+	for _, s := range []string{
+		"1m:30d",
+		"raw",
+		"raw:0s",
+		"raw:1d,500ms:1d",
+		"raw:1d,1m:1d,90s:1d",
+		"raw:1d,1h:1d,1m:1d",
+		"raw:1d,1m:xd",
+	} {
+		_, err = ParsePolicy(s)
+		assert.ErrorIs(t, err, ErrInvalidPolicy, s)
+	}
+}
+
+func sample(t *testing.T, mtype, value string, ts time.Time) models.Sample {
+	m, err := models.NewMetric("m", mtype, value)
+	require.NoError(t, err)
+	return models.Sample{Metric: m, Timestamp: ts}
+}
+
+func TestDownsample(t *testing.T) {
+	start := time.Unix(1_700_000_040, 0)
+	assert.True(t, start.Equal(BucketStart(start.Add(59*time.Second), time.Minute)))
+
+	gauges := models.Samples{
+		sample(t, models.GaugeType, "1", start),
+		sample(t, models.GaugeType, "3", start.Add(20*time.Second)),
+		sample(t, models.GaugeType, "2", start.Add(40*time.Second)),
+		sample(t, models.GaugeType, "5", start.Add(70*time.Second)),
+	}
+	rollups, err := Downsample(gauges, nil, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, rollups, 2)
+	assert.True(t, start.Equal(rollups[0].Start))
+	assert.Equal(t, uint64(3), rollups[0].Count)
+	assert.Equal(t, 1.0, rollups[0].Min)
+	assert.Equal(t, 3.0, rollups[0].Max)
+	assert.Equal(t, 2.0, rollups[0].Avg())
+	assert.Equal(t, 2.0, rollups[0].Last)
+	assert.Equal(t, 5.0, rollups[1].Last)
+
+	// счётчик сбрасывается после 30
+	prev := sample(t, models.CounterType, "10", start.Add(-time.Second))
+	counters := models.Samples{
+		sample(t, models.CounterType, "20", start),
+		sample(t, models.CounterType, "30", start.Add(30*time.Second)),
+		sample(t, models.CounterType, "4", start.Add(61*time.Second)),
+		sample(t, models.CounterType, "10", start.Add(90*time.Second)),
+	}
+	rollups, err = Downsample(counters, &prev, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, rollups, 2)
+	assert.Equal(t, 20.0, rollups[0].Increase)
+	assert.Equal(t, 10.0, rollups[1].Increase)
+
+	merged := Merge(rollups, time.Hour)
+	require.Len(t, merged, 1)
+	assert.Equal(t, time.Hour, merged[0].Resolution)
+	assert.Equal(t, uint64(4), merged[0].Count)
+	assert.Equal(t, 30.0, merged[0].Increase)
+	assert.Equal(t, 4.0, merged[0].Min)
+	assert.Equal(t, 30.0, merged[0].Max)
+	assert.Equal(t, 10.0, merged[0].Last)
+}
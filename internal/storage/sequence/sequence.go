@@ -0,0 +1,42 @@
+// sequence передаёт изменения хранилища обработчикам OnSet в порядке их применения
+package sequence
+
+import "sync"
+
+// Sequencer Выдаёт номера изменениям и пропускает их к обработчикам по порядку номеров.
+// Нулевое значение готово к работе
+type Sequencer struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	issued uint64 // последний выданный номер
+	done   uint64 // последний номер, обработка которого завершена
+}
+
+// Next Выдаёт номер следующему изменению. Вызывается там, где порядок изменений уже определён:
+// под блокировкой хранилища или при фиксации транзакции
+func (s *Sequencer) Next() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.issued++
+	return s.issued
+}
+
+// Do Дожидается обработки всех изменений с меньшими номерами и вызывает fn.
+// Для каждого выданного номера Do вызывается ровно один раз, иначе следующие изменения не дождутся очереди
+func (s *Sequencer) Do(n uint64, fn func()) {
+	s.mutex.Lock()
+	if s.cond == nil {
+		s.cond = sync.NewCond(&s.mutex)
+	}
+	for s.done+1 != n {
+		s.cond.Wait()
+	}
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		s.done = n
+		s.cond.Broadcast()
+		s.mutex.Unlock()
+	}()
+	fn()
+}
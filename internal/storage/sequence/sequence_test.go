@@ -0,0 +1,34 @@
+package sequence
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSequencer(t *testing.T) {
+	var s Sequencer
+	const n = 50
+	tickets := make([]uint64, n)
+	for i := range tickets {
+		tickets[i] = s.Next()
+	}
+	var mutex sync.Mutex
+	var order []uint64
+	var wg sync.WaitGroup
+	// обработчики запускаются в обратном порядке, но вызываются по номерам
+	for i := n - 1; i >= 0; i-- {
+		wg.Add(1)
+		go func(ticket uint64) {
+			defer wg.Done()
+			s.Do(ticket, func() {
+				mutex.Lock()
+				defer mutex.Unlock()
+				order = append(order, ticket)
+			})
+		}(tickets[i])
+	}
+	wg.Wait()
+	assert.Equal(t, tickets, order)
+}
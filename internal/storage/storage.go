@@ -11,19 +11,52 @@ import (
 	"github.com/Nexadis/metalert/internal/utils/logger"
 )
 
+// Getter Позволяет получить метрики. Условия на метки отбирают серии метрики,
+// если подходит несколько серий, Get возвращает серию с наименьшим набором меток
 type Getter interface {
-	Get(ctx context.Context, mtype, id string) (models.Metric, error)
-	GetAll(ctx context.Context) (models.Metrics, error)
+	Get(ctx context.Context, mtype, id string, matchers ...models.Matcher) (models.Metric, error)
+	GetAll(ctx context.Context, matchers ...models.Matcher) (models.Metrics, error)
+}
+
+// RangeGetter Позволяет получить историю значений метрики за период
+type RangeGetter interface {
+	GetRange(ctx context.Context, mtype, id string, from, to time.Time, matchers ...models.Matcher) (models.Samples, error)
+}
+
+// RollupGetter Позволяет получить агрегаты истории метрики с заданным шагом за период
+type RollupGetter interface {
+	GetRollups(ctx context.Context, mtype, id string, resolution time.Duration, from, to time.Time, matchers ...models.Matcher) (models.Rollups, error)
+}
+
+// Compactor Сворачивает историю в агрегаты и удаляет устаревшие данные
+type Compactor interface {
+	Compact(ctx context.Context, now time.Time) error
 }
 
 type Setter interface {
 	Set(ctx context.Context, m models.Metric) error
 }
 
+// BatchSetter Позволяет атомарно записать пачку метрик: при ошибке не применяется ни одна метрика
+type BatchSetter interface {
+	SetMany(ctx context.Context, ms models.Metrics) error
+}
+
+// Notifier Позволяет подписаться на принятые изменения метрик. Обработчики получают изменения
+// в порядке их применения, поэтому не должны сами записывать метрики в хранилище
+type Notifier interface {
+	OnSet(fn func(ctx context.Context, m models.Metric))
+}
+
 // Storage Интерфейс для хранилищ. Позволяет использовать pg и mem хранилища.
 type Storage interface {
 	Getter
+	RangeGetter
+	RollupGetter
 	Setter
+	BatchSetter
+	Notifier
+	Compactor
 }
 
 func ChooseStorage(ctx context.Context, config *Config) (Storage, error) {
@@ -32,11 +65,13 @@ func ChooseStorage(ctx context.Context, config *Config) (Storage, error) {
 		db.Configure(d,
 			db.SetRetries(config.Retry),
 			db.SetTimeout(time.Duration(config.Timeout)),
+			db.SetRetention(config.Retention),
 		)
 		dbctx, cancel := context.WithTimeout(ctx, time.Duration(time.Second))
 		defer cancel()
 		err := d.Open(dbctx, config.DSN)
 		if err == nil {
+			go RunCompactor(ctx, d, time.Duration(config.CompactInterval)*time.Second)
 			return d, nil
 		}
 		logger.Error(err)
@@ -47,6 +82,21 @@ func ChooseStorage(ctx context.Context, config *Config) (Storage, error) {
 func getMemStorage(ctx context.Context, config *Config) (*mem.Storage, error) {
 	logger.Info("Use in mem storage")
 	metricsStorage := mem.NewMetricsStorage()
+	mem.Configure(metricsStorage,
+		mem.SetHistorySize(config.HistorySize),
+		mem.SetRetention(config.Retention),
+		mem.SetSnapshots(config.Snapshots),
+	)
+	// журнал обрезается после сохранения снимка, без файла для снимков он рос бы бесконечно
+	if config.WAL != "" && config.FileStoragePath != "" {
+		policy, err := mem.ParseSyncPolicy(config.WALSync)
+		if err != nil {
+			return nil, err
+		}
+		mem.Configure(metricsStorage,
+			mem.SetWAL(config.WAL, policy, time.Duration(config.WALSyncInterval)*time.Millisecond),
+		)
+	}
 	if config.Restore {
 		err := metricsStorage.Restore(ctx, config.FileStoragePath)
 		if err != nil {
@@ -54,6 +104,32 @@ func getMemStorage(ctx context.Context, config *Config) (*mem.Storage, error) {
 			return nil, err
 		}
 	}
+	err := metricsStorage.StartWAL(ctx, config.FileStoragePath)
+	if err != nil {
+		return nil, err
+	}
 	go metricsStorage.SaveTimer(ctx, config.FileStoragePath, config.StoreInterval)
+	go RunCompactor(ctx, metricsStorage, time.Duration(config.CompactInterval)*time.Second)
 	return metricsStorage, nil
 }
+
+// RunCompactor Сворачивает историю хранилища с заданным интервалом, пока не отменён контекст
+func RunCompactor(ctx context.Context, c Compactor, interval time.Duration) {
+	if interval <= 0 {
+		logger.Info("Compaction is disabled")
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			err := c.Compact(ctx, now)
+			if err != nil {
+				logger.Error("Can't compact history:", err)
+			}
+		}
+	}
+}
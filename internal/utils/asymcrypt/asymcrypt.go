@@ -1,3 +1,4 @@
+// asymcrypt реализует шифрование трафика между агентом и сервером
 package asymcrypt
 
 import (
@@ -12,7 +13,20 @@ import (
 	"github.com/Nexadis/metalert/internal/utils/logger"
 )
 
+// PemKeyID - Заголовок PEM с ID ключа, по нему сервер выбирает приватный ключ при ротации
+const PemKeyID = "Key-Id"
+
 func NewPem(filename string) error {
+	return NewPemID(filename, "")
+}
+
+// NewPemID Создаёт пару ключей filename_priv.pem и filename_pub.pem с ID в заголовке PemKeyID.
+// Пустой id не записывается
+func NewPemID(filename, id string) error {
+	var headers map[string]string
+	if id != "" {
+		headers = map[string]string{PemKeyID: id}
+	}
 	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
 	if err != nil {
 		return err
@@ -21,8 +35,9 @@ func NewPem(filename string) error {
 	// используется для хранения и обмена криптографическими ключами
 	var privateKeyPEM bytes.Buffer
 	err = pem.Encode(&privateKeyPEM, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
+		Type:    "RSA PRIVATE KEY",
+		Headers: headers,
+		Bytes:   x509.MarshalPKCS1PrivateKey(privateKey),
 	})
 	if err != nil {
 		return err
@@ -30,8 +45,9 @@ func NewPem(filename string) error {
 
 	var publicKeyPEM bytes.Buffer
 	err = pem.Encode(&publicKeyPEM, &pem.Block{
-		Type:  "RSA PUBLIC KEY",
-		Bytes: x509.MarshalPKCS1PublicKey(&privateKey.PublicKey),
+		Type:    "RSA PUBLIC KEY",
+		Headers: headers,
+		Bytes:   x509.MarshalPKCS1PublicKey(&privateKey.PublicKey),
 	})
 	if err != nil {
 		return err
@@ -39,48 +55,69 @@ func NewPem(filename string) error {
 	privname := filename + "_priv.pem"
 	pubname := filename + "_pub.pem"
 
-	err = os.WriteFile(privname, privateKeyPEM.Bytes(), 0777)
+	err = writeKey(privname, privateKeyPEM.Bytes(), PrivateKeyMode)
 	if err != nil {
 		return err
 	}
 	logger.Info("Created Pivate key:", privname)
 	logger.Info("Created PublicKey key:", pubname)
-	return os.WriteFile(pubname, publicKeyPEM.Bytes(), 0777)
+	return writeKey(pubname, publicKeyPEM.Bytes(), PublicKeyMode)
+}
+
+// Права файлов ключей: приватный ключ доступен только владельцу
+const (
+	PrivateKeyMode os.FileMode = 0600
+	PublicKeyMode  os.FileMode = 0644
+)
+
+// writeKey Записывает ключ с правами perm. Права выставляются и у существующего файла,
+// так как WriteFile применяет их только при создании
+func writeKey(name string, data []byte, perm os.FileMode) error {
+	err := os.WriteFile(name, data, perm)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(name, perm)
 }
 
 func ReadPem(filename string) ([]byte, error) {
+	key, _, err := ReadPemID(filename)
+	return key, err
+}
+
+// ReadPemID Читает ключ и его ID из заголовка PemKeyID. У ключей без заголовка ID пустой
+func ReadPemID(filename string) ([]byte, string, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	block, _ := pem.Decode(data)
 	if block == nil {
-		return nil, errors.New("failed to parse PEM file")
+		return nil, "", errors.New("failed to parse PEM file")
 	}
 	logger.Info(block.Headers)
-	return block.Bytes, nil
+	return block.Bytes, block.Headers[PemKeyID], nil
 }
 
+// Decrypt Расшифровывает конверт, созданный Encrypt. Данные без заголовка конверта
+// расшифровываются как raw RSA PKCS1v15 для совместимости со старыми агентами
 func Decrypt(data []byte, privKey []byte) ([]byte, error) {
 	key, err := x509.ParsePKCS1PrivateKey(privKey)
 	if err != nil {
 		return nil, err
 	}
-	decrypted, err := rsa.DecryptPKCS1v15(nil, key, data)
-	if err != nil {
-		return nil, err
+	if !IsEnvelope(data) {
+		return rsa.DecryptPKCS1v15(nil, key, data)
 	}
-	return decrypted, nil
+	return openEnvelope(data, key)
 }
 
+// Encrypt Шифрует body гибридной схемой: случайный ключ AES-256-GCM шифрует данные,
+// RSA-OAEP шифрует ключ. Размер данных не ограничен размером RSA-ключа
 func Encrypt(body []byte, pubKey []byte) ([]byte, error) {
 	key, err := x509.ParsePKCS1PublicKey(pubKey)
 	if err != nil {
 		return nil, err
 	}
-	encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, key, body)
-	if err != nil {
-		return nil, err
-	}
-	return encrypted, nil
+	return sealEnvelope(body, key)
 }
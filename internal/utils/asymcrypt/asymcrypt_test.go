@@ -1,6 +1,10 @@
 package asymcrypt
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"os"
 	"testing"
 
@@ -36,3 +40,48 @@ func TestEncryption(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, s, string(decrypted))
 }
+
+func TestEnvelope(t *testing.T) {
+	keys := newKS(t)
+	body := bytes.Repeat([]byte(`{"id":"name","type":"gauge","value":123.123},`), 2000)
+	encrypted, err := Encrypt(body, keys.public)
+	assert.NoError(t, err)
+	assert.True(t, IsEnvelope(encrypted))
+	decrypted, err := Decrypt(encrypted, keys.private)
+	assert.NoError(t, err)
+	assert.Equal(t, body, decrypted)
+
+	encrypted[len(encrypted)-1] ^= 0xff
+	_, err = Decrypt(encrypted, keys.private)
+	assert.Error(t, err)
+
+	encrypted[3] = 42
+	_, err = Decrypt(encrypted, keys.private)
+	assert.ErrorIs(t, err, ErrEnvelopeVersion)
+}
+
+func TestLegacyDecrypt(t *testing.T) {
+	keys := newKS(t)
+	pub, err := x509.ParsePKCS1PublicKey(keys.public)
+	assert.NoError(t, err)
+	encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, pub, []byte("Hello"))
+	assert.NoError(t, err)
+	decrypted, err := Decrypt(encrypted, keys.private)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello", string(decrypted))
+}
+
+func TestNewPemMode(t *testing.T) {
+	name := t.TempDir() + "/key"
+	// права выставляются и при перезаписи существующих файлов
+	assert.NoError(t, os.WriteFile(name+"_priv.pem", nil, 0777))
+	assert.NoError(t, NewPemID(name, "k1"))
+	for file, mode := range map[string]os.FileMode{
+		name + "_priv.pem": PrivateKeyMode,
+		name + "_pub.pem":  PublicKeyMode,
+	} {
+		info, err := os.Stat(file)
+		assert.NoError(t, err)
+		assert.Equal(t, mode, info.Mode().Perm(), file)
+	}
+}
@@ -0,0 +1,103 @@
+package asymcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Формат конверта:
+//
+//	magic (3 байта) | version (1 байт) | длина ключа (2 байта, big endian) |
+//	ключ AES, зашифрованный RSA-OAEP | nonce AES-GCM | данные, зашифрованные AES-256-GCM
+//
+// Заголовок (magic, version и длина ключа) используется как дополнительные
+// аутентифицируемые данные AES-GCM.
+const (
+	EnvelopeVersion = 1
+	envelopeMagic   = "MAE"
+	dataKeySize     = 32
+	headerSize      = len(envelopeMagic) + 1 + 2
+)
+
+// Ошибки при работе с конвертом
+var (
+	ErrEnvelope        = errors.New("invalid envelope")
+	ErrEnvelopeVersion = errors.New("unsupported envelope version")
+)
+
+// IsEnvelope Проверяет, что данные начинаются с заголовка конверта
+func IsEnvelope(data []byte) bool {
+	return len(data) >= headerSize && string(data[:len(envelopeMagic)]) == envelopeMagic
+}
+
+func sealEnvelope(body []byte, key *rsa.PublicKey) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, key, dataKey, []byte(envelopeMagic))
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize, headerSize+len(wrapped)+len(nonce)+len(body)+gcm.Overhead())
+	copy(header, envelopeMagic)
+	header[len(envelopeMagic)] = EnvelopeVersion
+	binary.BigEndian.PutUint16(header[len(envelopeMagic)+1:], uint16(len(wrapped)))
+	out := append(header, wrapped...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, body, header[:headerSize]), nil
+}
+
+func openEnvelope(data []byte, key *rsa.PrivateKey) ([]byte, error) {
+	if !IsEnvelope(data) {
+		return nil, ErrEnvelope
+	}
+	version := data[len(envelopeMagic)]
+	if version != EnvelopeVersion {
+		return nil, fmt.Errorf("%w: %d", ErrEnvelopeVersion, version)
+	}
+	header := data[:headerSize]
+	keyLen := int(binary.BigEndian.Uint16(data[len(envelopeMagic)+1:]))
+	rest := data[headerSize:]
+	if len(rest) < keyLen {
+		return nil, fmt.Errorf("%w: short key", ErrEnvelope)
+	}
+	dataKey, err := rsa.DecryptOAEP(sha256.New(), nil, key, rest[:keyLen], []byte(envelopeMagic))
+	if err != nil {
+		return nil, err
+	}
+	rest = rest[keyLen:]
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: short nonce", ErrEnvelope)
+	}
+	nonce, ciphertext := rest[:gcm.NonceSize()], rest[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, header)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
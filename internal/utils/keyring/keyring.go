@@ -0,0 +1,245 @@
+// keyring хранит несколько активных ключей подписи и приватных ключей сервера,
+// чтобы ключи можно было менять без одновременного обновления всех агентов
+package keyring
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Nexadis/metalert/internal/utils/asymcrypt"
+)
+
+// Заголовки и метаданные с ID ключей
+const (
+	KeyIDHeader       = `X-Key-Id`        // ID ключа подписи запроса или ответа
+	KeyIDMetadata     = `x-key-id`        // ID ключа подписи в метаданных gRPC
+	CryptoKeyIDHeader = `X-Crypto-Key-Id` // ID публичного ключа, которым зашифровано тело запроса
+)
+
+// Ошибки работы со связкой ключей
+var (
+	ErrUnknownKey = errors.New("unknown key id")
+	ErrInvalidKey = errors.New("invalid key")
+)
+
+// File Формат файла связки ключей
+type File struct {
+	Current    string            `json:"current"`               // ID ключа подписи ответов
+	SignKeys   map[string]string `json:"sign_keys,omitempty"`   // ключи подписи по ID
+	CryptoKeys []string          `json:"crypto_keys,omitempty"` // пути к приватным ключам PEM
+}
+
+// ReadFile Читает файл связки ключей
+func ReadFile(path string) (File, error) {
+	var f File
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return f, fmt.Errorf("keyring %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// Write Записывает файл связки ключей, доступный только владельцу
+func (f File) Write(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// NewSignKey Создаёт случайный ключ подписи
+func NewSignKey() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Keyring Активные ключи подписи и приватные ключи. Ключи из параметров -k и -crypto-key
+// хранятся с пустым ID, ключи из файла связки - с ID из файла
+type Keyring struct {
+	path    string
+	signKey string
+	privKey []byte
+
+	mutex   sync.RWMutex
+	current string
+	sign    map[string][]byte
+	crypto  map[string][]byte
+}
+
+// New Создаёт связку из ключа подписи signKey, приватного ключа privKey и файла path.
+// Любой из параметров может быть пустым
+func New(path, signKey string, privKey []byte) (*Keyring, error) {
+	k := &Keyring{
+		path:    path,
+		signKey: signKey,
+		privKey: privKey,
+	}
+	return k, k.Reload()
+}
+
+// Reload Перечитывает файл связки. При ошибке остаются прежние ключи
+func (k *Keyring) Reload() error {
+	sign := make(map[string][]byte)
+	crypto := make(map[string][]byte)
+	if k.signKey != "" {
+		sign[""] = []byte(k.signKey)
+	}
+	if k.privKey != nil {
+		crypto[""] = k.privKey
+	}
+	current := ""
+	if k.path != "" {
+		f, err := ReadFile(k.path)
+		if err != nil {
+			return err
+		}
+		for id, secret := range f.SignKeys {
+			if id == "" || secret == "" {
+				return fmt.Errorf("%w: empty sign key %q", ErrInvalidKey, id)
+			}
+			sign[id] = []byte(secret)
+		}
+		for _, name := range f.CryptoKeys {
+			id, key, err := readPrivate(name)
+			if err != nil {
+				return err
+			}
+			if _, ok := crypto[id]; ok {
+				return fmt.Errorf("%w: duplicate crypto key %q", ErrInvalidKey, id)
+			}
+			crypto[id] = key
+		}
+		current = f.Current
+	}
+	if _, ok := sign[current]; !ok && len(sign) != 0 {
+		return fmt.Errorf("%w: current %q", ErrUnknownKey, current)
+	}
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.current = current
+	k.sign = sign
+	k.crypto = crypto
+	return nil
+}
+
+// readPrivate Читает приватный ключ. ID берётся из заголовка PEM, без него - из имени файла
+func readPrivate(name string) (string, []byte, error) {
+	key, id, err := asymcrypt.ReadPemID(name)
+	if err != nil {
+		return "", nil, err
+	}
+	_, err = x509.ParsePKCS1PrivateKey(key)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %s: %v", ErrInvalidKey, name, err)
+	}
+	if id == "" {
+		id = strings.TrimSuffix(filepath.Base(name), "_priv.pem")
+	}
+	return id, key, nil
+}
+
+// Signs Проверяет, что есть хотя бы один ключ подписи
+func (k *Keyring) Signs() bool {
+	if k == nil {
+		return false
+	}
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return len(k.sign) != 0
+}
+
+// Decrypts Проверяет, что есть хотя бы один приватный ключ
+func (k *Keyring) Decrypts() bool {
+	if k == nil {
+		return false
+	}
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return len(k.crypto) != 0
+}
+
+// Current Возвращает ID и текущий ключ подписи ответов
+func (k *Keyring) Current() (string, []byte) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return k.current, k.sign[k.current]
+}
+
+// Match Проверяет подпись got ключом id. Без ID подпись проверяется всеми ключами,
+// так принимаются запросы агентов, которые не передают ID. sign создаёт подпись на ключе
+func (k *Keyring) Match(id, got string, sign func(key []byte) (string, error)) (bool, error) {
+	k.mutex.RLock()
+	keys := make([][]byte, 0, len(k.sign))
+	if id != "" {
+		key, ok := k.sign[id]
+		if ok {
+			keys = append(keys, key)
+		}
+	} else {
+		for _, key := range k.sign {
+			keys = append(keys, key)
+		}
+	}
+	k.mutex.RUnlock()
+	if len(keys) == 0 {
+		return false, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	for _, key := range keys {
+		want, err := sign(key)
+		if err != nil {
+			return false, err
+		}
+		if hmac.Equal([]byte(got), []byte(want)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Decrypt Расшифровывает данные приватным ключом id. Без ID пробуются все ключи:
+// конверт asymcrypt аутентифицирован, поэтому чужой ключ не расшифрует данные
+func (k *Keyring) Decrypt(id string, data []byte) ([]byte, error) {
+	k.mutex.RLock()
+	keys := make([][]byte, 0, len(k.crypto))
+	if id != "" {
+		key, ok := k.crypto[id]
+		if ok {
+			keys = append(keys, key)
+		}
+	} else {
+		for _, key := range k.crypto {
+			keys = append(keys, key)
+		}
+	}
+	k.mutex.RUnlock()
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+	var err error
+	for _, key := range keys {
+		var decrypted []byte
+		decrypted, err = asymcrypt.Decrypt(data, key)
+		if err == nil {
+			return decrypted, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,83 @@
+package keyring
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Nexadis/metalert/internal/utils/asymcrypt"
+	"github.com/Nexadis/metalert/internal/utils/verifier"
+)
+
+func sign(data string) func(key []byte) (string, error) {
+	return func(key []byte) (string, error) {
+		return verifier.SignString([]byte(data), key)
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, asymcrypt.NewPemID(dir+"/r1", "r1"))
+	pub, id, err := asymcrypt.ReadPemID(dir + "/r1_pub.pem")
+	require.NoError(t, err)
+	assert.Equal(t, "r1", id)
+
+	path := dir + "/keyring.json"
+	f := File{
+		Current:    "k2",
+		SignKeys:   map[string]string{"k1": "old", "k2": "new"},
+		CryptoKeys: []string{dir + "/r1_priv.pem"},
+	}
+	require.NoError(t, f.Write(path))
+	keys, err := New(path, "legacy", nil)
+	require.NoError(t, err)
+	assert.True(t, keys.Signs())
+	assert.True(t, keys.Decrypts())
+	current, key := keys.Current()
+	assert.Equal(t, "k2", current)
+	assert.Equal(t, []byte("new"), key)
+
+	got, err := verifier.SignString([]byte("data"), []byte("old"))
+	require.NoError(t, err)
+	ok, err := keys.Match("k1", got, sign("data"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = keys.Match("k2", got, sign("data"))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = keys.Match("", got, sign("data"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	_, err = keys.Match("k3", got, sign("data"))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	encrypted, err := asymcrypt.Encrypt([]byte("body"), pub)
+	require.NoError(t, err)
+	for _, id := range []string{"r1", ""} {
+		decrypted, err := keys.Decrypt(id, encrypted)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("body"), decrypted)
+	}
+	_, err = keys.Decrypt("r2", encrypted)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// после перезагрузки удалённый ключ не принимается, ключ из параметров остаётся
+	f.SignKeys = map[string]string{"k2": "new"}
+	require.NoError(t, f.Write(path))
+	require.NoError(t, keys.Reload())
+	_, err = keys.Match("k1", got, sign("data"))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	legacy, err := verifier.SignString([]byte("data"), []byte("legacy"))
+	require.NoError(t, err)
+	ok, err = keys.Match("", legacy, sign("data"))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// ошибка в файле оставляет прежние ключи
+	f.Current = "k5"
+	require.NoError(t, f.Write(path))
+	assert.ErrorIs(t, keys.Reload(), ErrUnknownKey)
+	current, _ = keys.Current()
+	assert.Equal(t, "k2", current)
+}
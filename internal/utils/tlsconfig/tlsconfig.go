@@ -0,0 +1,104 @@
+// tlsconfig собирает настройки TLS для серверов и клиентов из файлов сертификатов
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// DefaultMinVersion - Минимальная версия TLS по умолчанию
+const DefaultMinVersion = "1.2"
+
+// Ошибки настройки TLS
+var (
+	ErrInvalidVersion = errors.New("invalid tls version")
+	ErrNoCertificate  = errors.New("certificate and key must be set together")
+	ErrInvalidCA      = errors.New("no certificates in ca file")
+)
+
+// ParseVersion Разбирает версию TLS вида "1.2". Пустая строка - DefaultMinVersion
+func ParseVersion(s string) (uint16, error) {
+	switch s {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrInvalidVersion, s)
+}
+
+// Server Возвращает настройки TLS сервера с сертификатом cert и ключом key.
+// Если задан clientCA, сервер требует сертификат клиента, подписанный этим CA
+func Server(cert, key, clientCA, minVersion string) (*tls.Config, error) {
+	version, err := ParseVersion(minVersion)
+	if err != nil {
+		return nil, err
+	}
+	if cert == "" || key == "" {
+		return nil, ErrNoCertificate
+	}
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		MinVersion:   version,
+	}
+	if clientCA != "" {
+		config.ClientCAs, err = loadPool(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// Client Возвращает настройки TLS клиента. ca - сертификат CA для проверки сервера,
+// пустой - используются системные. cert и key - сертификат клиента для mTLS, могут быть пустыми
+func Client(ca, cert, key, minVersion string) (*tls.Config, error) {
+	version, err := ParseVersion(minVersion)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion: version,
+	}
+	if ca != "" {
+		config.RootCAs, err = loadPool(ca)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cert != "" || key != "" {
+		if cert == "" || key == "" {
+			return nil, ErrNoCertificate
+		}
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	return config, nil
+}
+
+func loadPool(filename string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCA, filename)
+	}
+	return pool, nil
+}
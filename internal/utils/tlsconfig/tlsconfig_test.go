@@ -0,0 +1,146 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type certFiles struct {
+	cert, key string
+}
+
+// issue Выпускает сертификат, подписанный parent, и записывает его в каталог dir.
+// parent == nil - самоподписанный сертификат CA
+func issue(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (certFiles, *x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	files := certFiles{
+		cert: filepath.Join(dir, name+".crt"),
+		key:  filepath.Join(dir, name+".key"),
+	}
+	require.NoError(t, os.WriteFile(files.cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, os.WriteFile(files.key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return files, cert, key
+}
+
+// handshake Соединяет клиента и сервер и возвращает ошибки рукопожатия с обеих сторон
+func handshake(server, client *tls.Config) (error, error) {
+	s, c := net.Pipe()
+	defer s.Close()
+	defer c.Close()
+	client.ServerName = "127.0.0.1"
+	serverErr := make(chan error, 1)
+	go func() {
+		conn := tls.Server(s, server)
+		err := conn.Handshake()
+		if err == nil {
+			// сервер TLS 1.3 проверяет сертификат клиента уже после завершения рукопожатия клиентом
+			_, err = conn.Write([]byte{1})
+		}
+		serverErr <- err
+		s.Close()
+	}()
+	conn := tls.Client(c, client)
+	err := conn.Handshake()
+	if err == nil {
+		_, err = conn.Read(make([]byte, 1))
+	}
+	c.Close()
+	return <-serverErr, err
+}
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), v)
+	v, err = ParseVersion("1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), v)
+	_, err = ParseVersion("2.0")
+	assert.ErrorIs(t, err, ErrInvalidVersion)
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caCert, caKey := issue(t, dir, "ca", nil, nil)
+	server, _, _ := issue(t, dir, "server", caCert, caKey)
+	agent, _, _ := issue(t, dir, "agent", caCert, caKey)
+	otherCA, otherCert, otherKey := issue(t, dir, "other-ca", nil, nil)
+	stranger, _, _ := issue(t, dir, "stranger", otherCert, otherKey)
+
+	_, err := Server(server.cert, "", "", "")
+	assert.ErrorIs(t, err, ErrNoCertificate)
+	_, err = Server(server.cert, server.key, server.key, "")
+	assert.ErrorIs(t, err, ErrInvalidCA)
+	_, err = Client(ca.cert, agent.cert, "", "")
+	assert.ErrorIs(t, err, ErrNoCertificate)
+
+	serverConfig, err := Server(server.cert, server.key, ca.cert, "1.2")
+	require.NoError(t, err)
+
+	clientConfig, err := Client(ca.cert, agent.cert, agent.key, "")
+	require.NoError(t, err)
+	serverErr, clientErr := handshake(serverConfig, clientConfig)
+	assert.NoError(t, serverErr)
+	assert.NoError(t, clientErr)
+
+	// без сертификата клиента сервер с CA клиентов отклоняет соединение
+	clientConfig, err = Client(ca.cert, "", "", "")
+	require.NoError(t, err)
+	serverErr, _ = handshake(serverConfig, clientConfig)
+	assert.Error(t, serverErr)
+
+	clientConfig, err = Client(ca.cert, stranger.cert, stranger.key, "")
+	require.NoError(t, err)
+	serverErr, _ = handshake(serverConfig, clientConfig)
+	assert.Error(t, serverErr)
+
+	// клиент не доверяет серверу, подписанному другим CA
+	clientConfig, err = Client(otherCA.cert, agent.cert, agent.key, "")
+	require.NoError(t, err)
+	_, clientErr = handshake(serverConfig, clientConfig)
+	assert.Error(t, clientErr)
+
+	// сервер с минимальной версией 1.3 не принимает клиента, ограниченного 1.2
+	serverConfig, err = Server(server.cert, server.key, "", "1.3")
+	require.NoError(t, err)
+	clientConfig, err = Client(ca.cert, "", "", "")
+	require.NoError(t, err)
+	clientConfig.MaxVersion = tls.VersionTLS12
+	serverErr, _ = handshake(serverConfig, clientConfig)
+	assert.Error(t, serverErr)
+}
@@ -0,0 +1,83 @@
+package verifier
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// HashMetadata - Ключ метаданных gRPC с подписью запроса
+const HashMetadata = `hashsha256`
+
+// SignMessage Подписывает время, nonce и сериализованное сообщение protobuf, как SignRequest.
+// Сообщение сериализуется детерминированно, чтобы подписи клиента и сервера совпадали
+func SignMessage(m proto.Message, timestamp, nonce string, key []byte) (string, error) {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return SignRequest(data, timestamp, nonce, key)
+}
+
+// HashField - Поле сообщения потока gRPC с его подписью. Метаданные передаются один раз на поток,
+// поэтому каждое сообщение потока подписывается отдельно
+const HashField = `hash`
+
+// ErrNoHashField - У сообщения потока нет поля для подписи
+var ErrNoHashField = errors.New("message has no hash field")
+
+// SignStreamMessage Подписывает сообщение потока номер n со временем и nonce из метаданных потока.
+// Номер не даёт повторить или переставить сообщения внутри потока, поле HashField в подпись не входит
+func SignStreamMessage(m proto.Message, timestamp, nonce string, n uint64, key []byte) (string, error) {
+	field, err := hashField(m)
+	if err != nil {
+		return "", err
+	}
+	unsigned := proto.Clone(m)
+	unsigned.ProtoReflect().Clear(field)
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(unsigned)
+	if err != nil {
+		return "", err
+	}
+	data = append([]byte(fmt.Sprintf("%d\n", n)), data...)
+	return SignRequest(data, timestamp, nonce, key)
+}
+
+// StreamHash Возвращает подпись из поля HashField сообщения потока
+func StreamHash(m proto.Message) (string, error) {
+	field, err := hashField(m)
+	if err != nil {
+		return "", err
+	}
+	return m.ProtoReflect().Get(field).String(), nil
+}
+
+// SetStreamHash Записывает подпись в поле HashField сообщения потока
+func SetStreamHash(m proto.Message, hash string) error {
+	field, err := hashField(m)
+	if err != nil {
+		return err
+	}
+	m.ProtoReflect().Set(field, protoreflect.ValueOfString(hash))
+	return nil
+}
+
+func hashField(m proto.Message) (protoreflect.FieldDescriptor, error) {
+	field := m.ProtoReflect().Descriptor().Fields().ByName(HashField)
+	if field == nil || field.Kind() != protoreflect.StringKind {
+		return nil, fmt.Errorf("%w: %s", ErrNoHashField, m.ProtoReflect().Descriptor().FullName())
+	}
+	return field, nil
+}
+
+// SignString Подписывает данные и возвращает подпись в base64
+func SignString(data []byte, key []byte) (string, error) {
+	signature, err := Sign(data, key)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,157 @@
+package verifier
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Заголовки защиты от повтора. Подпись запроса покрывает их вместе с телом, см. SignRequest
+const (
+	TimestampHeader = `X-Timestamp` // время создания запроса в секундах Unix
+	NonceHeader     = `X-Nonce`     // случайная строка, уникальная для каждого запроса
+)
+
+// Ключи метаданных gRPC с теми же значениями, что и заголовки
+const (
+	TimestampMetadata = `x-timestamp`
+	NonceMetadata     = `x-nonce`
+)
+
+// Параметры защиты от повтора по умолчанию
+const (
+	DefaultSkew       = 5 * time.Minute
+	DefaultNonceCache = 100000
+)
+
+// Ошибки защиты от повтора
+var (
+	ErrNoSignature = errors.New("signature required")
+	ErrStale       = errors.New("request timestamp is out of allowed clock skew")
+	ErrReplay      = errors.New("request nonce was already used")
+	ErrNonce       = errors.New("invalid nonce")
+)
+
+// SignRequest Подписывает время, nonce и данные запроса и возвращает подпись в base64
+func SignRequest(data []byte, timestamp, nonce string, key []byte) (string, error) {
+	signed := make([]byte, 0, len(timestamp)+len(nonce)+2+len(data))
+	signed = append(signed, timestamp...)
+	signed = append(signed, '\n')
+	signed = append(signed, nonce...)
+	signed = append(signed, '\n')
+	signed = append(signed, data...)
+	return SignString(signed, key)
+}
+
+// HTTPRequest Возвращает данные HTTP-запроса для SignRequest: метод, путь и строку запроса вместе с телом.
+// Так подпись REST-запроса защищает тип, имя, значение и метки метрики из адреса
+func HTTPRequest(method, path, rawQuery string, body []byte) []byte {
+	data := make([]byte, 0, len(method)+len(path)+len(rawQuery)+3+len(body))
+	data = append(data, method...)
+	data = append(data, '\n')
+	data = append(data, path...)
+	data = append(data, '\n')
+	data = append(data, rawQuery...)
+	data = append(data, '\n')
+	return append(data, body...)
+}
+
+// NewNonce Возвращает текущее время в виде для TimestampHeader и случайный nonce
+func NewNonce() (string, string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", "", err
+	}
+	return strconv.FormatInt(time.Now().Unix(), 10), hex.EncodeToString(buf), nil
+}
+
+// ReplayGuard Отклоняет запросы со временем вне допустимого расхождения часов и с уже встречавшимся nonce.
+// Nonce хранятся, пока время их запроса не выйдет из окна. Хранится не больше size nonce,
+// при переполнении вытесняются самые старые
+type ReplayGuard struct {
+	skew time.Duration
+	size int
+
+	mutex  sync.Mutex
+	seen   map[string]time.Time
+	order  []string // nonce в порядке добавления
+	oldest int      // индекс самого старого nonce в order
+}
+
+// NewReplayGuard Создаёт ReplayGuard с окном skew в обе стороны от текущего времени
+func NewReplayGuard(skew time.Duration, size int) *ReplayGuard {
+	if skew <= 0 {
+		skew = DefaultSkew
+	}
+	if size <= 0 {
+		size = DefaultNonceCache
+	}
+	return &ReplayGuard{
+		skew: skew,
+		size: size,
+		seen: make(map[string]time.Time),
+	}
+}
+
+// Check Проверяет время и nonce запроса на момент now и запоминает nonce
+func (g *ReplayGuard) Check(timestamp, nonce string, now time.Time) error {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrStale, timestamp)
+	}
+	if nonce == "" || len(nonce) > 128 {
+		return ErrNonce
+	}
+	ts := time.Unix(sec, 0)
+	if ts.Before(now.Add(-g.skew)) || ts.After(now.Add(g.skew)) {
+		return fmt.Errorf("%w: %v", ErrStale, now.Sub(ts))
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.expire(now)
+	if _, ok := g.seen[nonce]; ok {
+		return ErrReplay
+	}
+	if len(g.seen) >= g.size {
+		g.evict()
+	}
+	g.seen[nonce] = ts
+	g.order = append(g.order, nonce)
+	return nil
+}
+
+// expire Удаляет nonce, время которых вышло из окна. Вызывается под блокировкой
+func (g *ReplayGuard) expire(now time.Time) {
+	for g.oldest < len(g.order) {
+		nonce := g.order[g.oldest]
+		ts, ok := g.seen[nonce]
+		if ok && !ts.Before(now.Add(-g.skew)) {
+			break
+		}
+		delete(g.seen, nonce)
+		g.oldest++
+	}
+	g.compact()
+}
+
+// evict Вытесняет самый старый nonce. Вызывается под блокировкой
+func (g *ReplayGuard) evict() {
+	if g.oldest < len(g.order) {
+		delete(g.seen, g.order[g.oldest])
+		g.oldest++
+	}
+	g.compact()
+}
+
+// compact Освобождает начало order, когда вытесненных nonce становится больше половины
+func (g *ReplayGuard) compact() {
+	if g.oldest > len(g.order)/2 {
+		g.order = append(g.order[:0], g.order[g.oldest:]...)
+		g.oldest = 0
+	}
+}
@@ -0,0 +1,51 @@
+package verifier
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignRequest(t *testing.T) {
+	key := []byte("key")
+	a, err := SignRequest([]byte("data"), "1", "n1", key)
+	require.NoError(t, err)
+	b, err := SignRequest([]byte("data"), "1", "n2", key)
+	require.NoError(t, err)
+	assert.NotEqual(t, a, b)
+	legacy, err := SignString([]byte("data"), key)
+	require.NoError(t, err)
+	assert.NotEqual(t, a, legacy)
+
+	ts, nonce, err := NewNonce()
+	require.NoError(t, err)
+	assert.NotEmpty(t, ts)
+	assert.Len(t, nonce, 32)
+}
+
+func TestReplayGuard(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	g := NewReplayGuard(time.Minute, 2)
+
+	assert.NoError(t, g.Check(ts, "a", now))
+	assert.ErrorIs(t, g.Check(ts, "a", now), ErrReplay)
+	assert.ErrorIs(t, g.Check(ts, "", now), ErrNonce)
+	assert.ErrorIs(t, g.Check("bad", "b", now), ErrStale)
+	assert.ErrorIs(t, g.Check(strconv.FormatInt(now.Unix()-120, 10), "b", now), ErrStale)
+	assert.ErrorIs(t, g.Check(strconv.FormatInt(now.Unix()+120, 10), "b", now), ErrStale)
+
+	// при переполнении вытесняется самый старый nonce
+	assert.NoError(t, g.Check(ts, "b", now))
+	assert.NoError(t, g.Check(ts, "c", now))
+	assert.NoError(t, g.Check(ts, "a", now))
+	assert.ErrorIs(t, g.Check(ts, "c", now), ErrReplay)
+
+	// nonce, вышедшие из окна, забываются
+	later := now.Add(2 * time.Minute)
+	assert.NoError(t, g.Check(strconv.FormatInt(later.Unix(), 10), "d", later))
+	assert.Len(t, g.seen, 1)
+}
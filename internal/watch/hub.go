@@ -0,0 +1,230 @@
+// watch рассылает принятые изменения метрик подписчикам
+package watch
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+// DefaultBuffer - Размер очереди изменений одного подписчика
+const DefaultBuffer = 256
+
+// DefaultReplay - Количество последних изменений, хранимых для возобновления подписки
+const DefaultReplay = 1024
+
+// Ошибки подписки
+var (
+	ErrSlowSubscriber = errors.New("subscriber is too slow")
+	ErrReplayGap      = errors.New("changes since last event are not available")
+)
+
+// Event - Изменение метрики с порядковым номером. Номера начинаются с 1 и растут без пропусков
+type Event struct {
+	ID     uint64
+	Metric models.Metric
+}
+
+// Filter - Условия отбора изменений. Пустые поля не ограничивают выбор
+type Filter struct {
+	MType    string
+	Prefix   string // префикс имени метрики
+	Matchers []models.Matcher
+}
+
+// Match Проверяет, подходит ли метрика под условия
+func (f Filter) Match(m models.Metric) bool {
+	if f.MType != "" && f.MType != m.MType {
+		return false
+	}
+	if !strings.HasPrefix(m.ID, f.Prefix) {
+		return false
+	}
+	return models.MatchLabels(m.Labels, f.Matchers...)
+}
+
+// Subscription - Подписка на изменения метрик
+type Subscription struct {
+	hub    *Hub
+	filter Filter
+	ch     chan Event
+	seq    uint64
+	mutex  sync.Mutex
+	closed bool
+	err    error
+}
+
+// Updates Возвращает канал изменений. Канал закрывается при отключении подписчика
+func (s *Subscription) Updates() <-chan Event {
+	return s.ch
+}
+
+// Seq Возвращает номер последнего изменения на момент подписки.
+// Все изменения с большими номерами придут в Updates
+func (s *Subscription) Seq() uint64 {
+	return s.seq
+}
+
+// Err Возвращает причину отключения подписчика, nil - если подписка закрыта через Close
+func (s *Subscription) Err() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err
+}
+
+// Close Отменяет подписку
+func (s *Subscription) Close() {
+	s.hub.remove(s)
+	s.close(nil)
+}
+
+// send Отправляет изменение, не блокируясь. Возвращает false, если очередь подписчика заполнена
+func (s *Subscription) send(e Event) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return true
+	}
+	select {
+	case s.ch <- e:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Subscription) close(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.err = err
+	close(s.ch)
+}
+
+// Option - Настройка Hub
+type Option func(*Hub)
+
+// SetReplay Задаёт количество последних изменений, хранимых для Resume. 0 - не хранить
+func SetReplay(size int) Option {
+	return func(h *Hub) {
+		h.replay = size
+	}
+}
+
+// Hub Рассылает изменения метрик подписчикам.
+// Отправка не блокирует запись метрик: подписчик с заполненной очередью отключается
+type Hub struct {
+	mutex   sync.RWMutex
+	subs    map[*Subscription]struct{}
+	seq     uint64
+	replay  int
+	history []Event // кольцевой буфер последних изменений
+}
+
+// New Конструктор Hub
+func New(opts ...Option) *Hub {
+	h := &Hub{
+		subs:   make(map[*Subscription]struct{}),
+		replay: DefaultReplay,
+	}
+	for _, o := range opts {
+		o(h)
+	}
+	if h.replay > 0 {
+		h.history = make([]Event, h.replay)
+	}
+	return h
+}
+
+// Subscribe Создаёт подписку на изменения, подходящие под filter
+func (h *Hub) Subscribe(filter Filter, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s := &Subscription{
+		hub:    h,
+		filter: filter,
+		ch:     make(chan Event, buffer),
+		seq:    h.seq,
+	}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+// Resume Создаёт подписку, начиная с изменения, следующего за lastID.
+// Сохранённые изменения сразу помещаются в очередь подписчика.
+// Если часть изменений после lastID уже вытеснена или lastID неизвестен, возвращает ErrReplayGap
+func (h *Hub) Resume(filter Filter, buffer int, lastID uint64) (*Subscription, error) {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	kept := h.seq
+	if kept > uint64(len(h.history)) {
+		kept = uint64(len(h.history))
+	}
+	if lastID > h.seq || lastID < h.seq-kept {
+		return nil, ErrReplayGap
+	}
+	var events []Event
+	for id := lastID + 1; id <= h.seq; id++ {
+		e := h.history[id%uint64(len(h.history))]
+		if filter.Match(e.Metric) {
+			events = append(events, e)
+		}
+	}
+	s := &Subscription{
+		hub:    h,
+		filter: filter,
+		ch:     make(chan Event, buffer+len(events)),
+		seq:    h.seq,
+	}
+	for _, e := range events {
+		s.ch <- e
+	}
+	h.subs[s] = struct{}{}
+	return s, nil
+}
+
+// Len Возвращает количество подписчиков
+func (h *Hub) Len() int {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return len(h.subs)
+}
+
+// Notify Присваивает изменению номер и рассылает его подписчикам. Подходит для storage.Notifier.OnSet
+func (h *Hub) Notify(ctx context.Context, m models.Metric) {
+	var slow []*Subscription
+	h.mutex.Lock()
+	h.seq++
+	e := Event{ID: h.seq, Metric: m}
+	if len(h.history) != 0 {
+		h.history[e.ID%uint64(len(h.history))] = e
+	}
+	for s := range h.subs {
+		if s.filter.Match(m) && !s.send(e) {
+			slow = append(slow, s)
+		}
+	}
+	h.mutex.Unlock()
+	for _, s := range slow {
+		h.remove(s)
+		s.close(ErrSlowSubscriber)
+	}
+}
+
+func (h *Hub) remove(s *Subscription) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.subs, s)
+}
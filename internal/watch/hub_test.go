@@ -0,0 +1,99 @@
+package watch
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+func metric(t *testing.T, id, mtype, value string) models.Metric {
+	m, err := models.NewMetric(id, mtype, value)
+	require.NoError(t, err)
+	return m
+}
+
+func TestHub(t *testing.T) {
+	h := New()
+	all := h.Subscribe(Filter{}, 10)
+	gauges := h.Subscribe(Filter{MType: models.GaugeType, Prefix: "cpu"}, 10)
+	assert.Equal(t, 2, h.Len())
+
+	ctx := context.Background()
+	h.Notify(ctx, metric(t, "cpu1", models.GaugeType, "1"))
+	h.Notify(ctx, metric(t, "cpu2", models.CounterType, "1"))
+	h.Notify(ctx, metric(t, "mem", models.GaugeType, "1"))
+	assert.Len(t, all.Updates(), 3)
+	require.Len(t, gauges.Updates(), 1)
+	assert.Equal(t, "cpu1", (<-gauges.Updates()).Metric.ID)
+
+	gauges.Close()
+	_, ok := <-gauges.Updates()
+	assert.False(t, ok)
+	assert.NoError(t, gauges.Err())
+	assert.Equal(t, 1, h.Len())
+	h.Notify(ctx, metric(t, "cpu1", models.GaugeType, "2"))
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	h := New()
+	s := h.Subscribe(Filter{}, 2)
+	for i := 0; i < 3; i++ {
+		h.Notify(context.Background(), metric(t, "cpu", models.GaugeType, "1"))
+	}
+	assert.Equal(t, 0, h.Len())
+	count := 0
+	for range s.Updates() {
+		count++
+	}
+	assert.Equal(t, 2, count)
+	assert.ErrorIs(t, s.Err(), ErrSlowSubscriber)
+	s.Close()
+}
+
+func TestFilter(t *testing.T) {
+	m := metric(t, "requests", models.CounterType, "1")
+	m.Labels = map[string]string{"host": "a"}
+	hostA, err := models.NewMatcher("host", models.MatchEqual, "a")
+	require.NoError(t, err)
+	hostB, err := models.NewMatcher("host", models.MatchEqual, "b")
+	require.NoError(t, err)
+	assert.True(t, Filter{}.Match(m))
+	assert.True(t, Filter{MType: models.CounterType, Prefix: "req", Matchers: []models.Matcher{hostA}}.Match(m))
+	assert.False(t, Filter{MType: models.GaugeType}.Match(m))
+	assert.False(t, Filter{Prefix: "resp"}.Match(m))
+	assert.False(t, Filter{Matchers: []models.Matcher{hostB}}.Match(m))
+}
+
+func TestResume(t *testing.T) {
+	h := New(SetReplay(3))
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		h.Notify(ctx, metric(t, "cpu", models.GaugeType, "1"))
+	}
+	h.Notify(ctx, metric(t, "mem", models.GaugeType, "1"))
+
+	s, err := h.Resume(Filter{Prefix: "cpu"}, 10, 2)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), s.Seq())
+	require.Len(t, s.Updates(), 2)
+	assert.Equal(t, uint64(3), (<-s.Updates()).ID)
+	assert.Equal(t, uint64(4), (<-s.Updates()).ID)
+	h.Notify(ctx, metric(t, "cpu", models.GaugeType, "2"))
+	e := <-s.Updates()
+	assert.Equal(t, uint64(6), e.ID)
+	assert.Equal(t, metric(t, "cpu", models.GaugeType, "2"), e.Metric)
+	s.Close()
+
+	_, err = h.Resume(Filter{}, 10, 1)
+	assert.ErrorIs(t, err, ErrReplayGap)
+	_, err = h.Resume(Filter{}, 10, 100)
+	assert.ErrorIs(t, err, ErrReplayGap)
+	s, err = h.Resume(Filter{}, 10, 6)
+	require.NoError(t, err)
+	assert.Len(t, s.Updates(), 0)
+	s.Close()
+}
@@ -0,0 +1,207 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/utils/keyring"
+	"github.com/Nexadis/metalert/internal/utils/logger"
+	"github.com/Nexadis/metalert/internal/utils/verifier"
+)
+
+// События, отправляемые получателям
+const (
+	EventStart  = "start"  // условие начало выполняться
+	EventStop   = "stop"   // условие перестало выполняться
+	EventChange = "change" // значение изменилось
+)
+
+// QueueSize - Размер очереди доставки для одного получателя
+const QueueSize = 100
+
+// RetryWait - Пауза перед первой повторной попыткой, каждая следующая пауза удваивается
+var RetryWait = time.Second
+
+// Event - Тело запроса, отправляемого получателю
+type Event struct {
+	Subscription string        `json:"subscription"`
+	Event        string        `json:"event"`
+	Condition    string        `json:"condition"`
+	Metric       models.Metric `json:"metric"`
+	Timestamp    time.Time     `json:"timestamp"`
+}
+
+// Dispatcher Отслеживает изменения метрик и доставляет события подписчикам
+type Dispatcher struct {
+	receivers []*receiver
+	queues    []chan Event
+	client    *http.Client
+	keys      *keyring.Keyring
+	mutex     sync.Mutex
+	states    []map[string]string // последнее состояние условия для каждой метрики
+}
+
+// New Конструктор Dispatcher. Если в подписке не задан свой ключ, события подписываются
+// текущим ключом связки keys, поэтому после перезагрузки связки подпись меняется вместе с ключом сервера
+func New(subs []Subscription, keys *keyring.Keyring) (*Dispatcher, error) {
+	d := &Dispatcher{
+		receivers: make([]*receiver, 0, len(subs)),
+		queues:    make([]chan Event, 0, len(subs)),
+		states:    make([]map[string]string, 0, len(subs)),
+		client:    &http.Client{},
+		keys:      keys,
+	}
+	for _, sub := range subs {
+		r, err := newReceiver(sub)
+		if err != nil {
+			return nil, err
+		}
+		d.receivers = append(d.receivers, r)
+		d.queues = append(d.queues, make(chan Event, QueueSize))
+		d.states = append(d.states, make(map[string]string))
+	}
+	return d, nil
+}
+
+// Len Возвращает количество подписок
+func (d *Dispatcher) Len() int {
+	return len(d.receivers)
+}
+
+// Notify Проверяет условия подписок для принятой метрики и ставит события в очередь.
+// Подходит для storage.Notifier.OnSet
+func (d *Dispatcher) Notify(ctx context.Context, m models.Metric) {
+	value, err := m.GetValue()
+	if err != nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for i, r := range d.receivers {
+		if !r.match(m) {
+			continue
+		}
+		key := m.MType + "/" + m.Series()
+		prev, seen := d.states[i][key]
+		var event string
+		if r.anyChange {
+			d.states[i][key] = value
+			if seen && prev == value {
+				continue
+			}
+			event = EventChange
+		} else {
+			v, err := m.GetFloat()
+			if err != nil {
+				continue
+			}
+			state := EventStop
+			if r.cond.Match(v) {
+				state = EventStart
+			}
+			d.states[i][key] = state
+			if state == prev || (!seen && state == EventStop) {
+				continue
+			}
+			event = state
+		}
+		e := Event{
+			Subscription: r.sub.Name,
+			Event:        event,
+			Condition:    r.sub.Condition,
+			Metric:       m,
+			Timestamp:    time.Now(),
+		}
+		select {
+		case d.queues[i] <- e:
+		default:
+			logger.Error("Webhook queue is full, drop event for", r.sub.Name)
+		}
+	}
+}
+
+// Run Запускает доставку событий. Для каждого получателя события доставляются по порядку
+func (d *Dispatcher) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := range d.receivers {
+		wg.Add(1)
+		go func(r *receiver, queue chan Event) {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case e := <-queue:
+					err := d.deliver(ctx, r, e)
+					if err != nil {
+						logger.Error("Can't deliver webhook to", r.sub.URL, err)
+					}
+				}
+			}
+		}(d.receivers[i], d.queues[i])
+	}
+	wg.Wait()
+	return nil
+}
+
+// deliver Отправляет событие получателю с повторными попытками
+func (d *Dispatcher) deliver(ctx context.Context, r *receiver, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	wait := RetryWait
+	for attempt := 0; ; attempt++ {
+		err = d.post(ctx, r, body)
+		if err == nil || attempt >= r.sub.Retries {
+			return err
+		}
+		logger.Info("Retry webhook", r.sub.URL, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (d *Dispatcher) post(ctx context.Context, r *receiver, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.sub.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-type", "application/json")
+	id, key := "", []byte(r.key)
+	if r.key == "" && d.keys.Signs() {
+		id, key = d.keys.Current()
+	}
+	if len(key) != 0 {
+		signature, err := verifier.Sign(body, key)
+		if err != nil {
+			return err
+		}
+		req.Header.Set(verifier.HashHeader, base64.StdEncoding.EncodeToString(signature))
+		if id != "" {
+			req.Header.Set(keyring.KeyIDHeader, id)
+		}
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
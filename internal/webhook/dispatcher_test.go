@@ -0,0 +1,197 @@
+package webhook
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Nexadis/metalert/internal/models"
+	"github.com/Nexadis/metalert/internal/storage/mem"
+	"github.com/Nexadis/metalert/internal/utils/keyring"
+	"github.com/Nexadis/metalert/internal/utils/verifier"
+)
+
+type receiverLog struct {
+	mutex  sync.Mutex
+	events []Event
+	fails  int
+	key    string
+	keyID  string
+	t      *testing.T
+}
+
+func (l *receiverLog) handler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	assert.NoError(l.t, err)
+	defer r.Body.Close()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.fails > 0 {
+		l.fails--
+		http.Error(w, "try later", http.StatusServiceUnavailable)
+		return
+	}
+	if l.key != "" {
+		signature, err := verifier.Sign(body, []byte(l.key))
+		assert.NoError(l.t, err)
+		assert.Equal(l.t, base64.StdEncoding.EncodeToString(signature), r.Header.Get(verifier.HashHeader))
+		assert.Equal(l.t, l.keyID, r.Header.Get(keyring.KeyIDHeader))
+	}
+	var e Event
+	assert.NoError(l.t, json.Unmarshal(body, &e))
+	l.events = append(l.events, e)
+}
+
+func (l *receiverLog) got() []Event {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]Event(nil), l.events...)
+}
+
+func set(t *testing.T, s *mem.Storage, mtype, id, value string) {
+	m, err := models.NewMetric(id, mtype, value)
+	require.NoError(t, err)
+	require.NoError(t, s.Set(context.TODO(), m))
+}
+
+func TestThresholdCrossing(t *testing.T) {
+	l := &receiverLog{t: t, key: "secret"}
+	ts := httptest.NewServer(http.HandlerFunc(l.handler))
+	defer ts.Close()
+	keys, err := keyring.New("", "secret", nil)
+	require.NoError(t, err)
+
+	d, err := New([]Subscription{
+		{
+			Name:      "cpu",
+			URL:       ts.URL,
+			MType:     models.GaugeType,
+			ID:        "CPU",
+			Condition: "> 90",
+		},
+	}, keys)
+	require.NoError(t, err)
+	s := mem.NewMetricsStorage()
+	s.OnSet(d.Notify)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	set(t, s, models.GaugeType, "CPU", "10")
+	set(t, s, models.GaugeType, "CPU", "95")
+	set(t, s, models.GaugeType, "CPU", "97")
+	set(t, s, models.GaugeType, "Other", "99")
+	set(t, s, models.GaugeType, "CPU", "50")
+
+	assert.Eventually(t, func() bool {
+		return len(l.got()) == 2
+	}, time.Second, 10*time.Millisecond)
+	events := l.got()
+	assert.Equal(t, EventStart, events[0].Event)
+	assert.Equal(t, "cpu", events[0].Subscription)
+	v, err := events[0].Metric.GetValue()
+	assert.NoError(t, err)
+	assert.Equal(t, "95", v)
+	assert.Equal(t, EventStop, events[1].Event)
+}
+
+func TestAnyChangeWithRetries(t *testing.T) {
+	RetryWait = time.Millisecond
+	l := &receiverLog{t: t, fails: 2}
+	ts := httptest.NewServer(http.HandlerFunc(l.handler))
+	defer ts.Close()
+
+	d, err := New([]Subscription{
+		{
+			URL:       ts.URL,
+			Prefix:    "Poll",
+			Condition: AnyChange,
+			Retries:   2,
+		},
+	}, nil)
+	require.NoError(t, err)
+	s := mem.NewMetricsStorage()
+	s.OnSet(d.Notify)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	set(t, s, models.CounterType, "PollCount", "1")
+	set(t, s, models.CounterType, "PollCount", "0")
+	set(t, s, models.CounterType, "PollCount", "2")
+
+	assert.Eventually(t, func() bool {
+		return len(l.got()) == 2
+	}, time.Second, 10*time.Millisecond)
+	events := l.got()
+	assert.Equal(t, EventChange, events[0].Event)
+	v, err := events[1].Metric.GetValue()
+	assert.NoError(t, err)
+	assert.Equal(t, "3", v)
+}
+
+func TestInvalidSubscription(t *testing.T) {
+	tests := []Subscription{
+		{ID: "id", Condition: "> 1"},
+		{URL: "http://localhost", Condition: "> 1"},
+		{URL: "http://localhost", ID: "id", Condition: "bigger than 1"},
+	}
+	for _, sub := range tests {
+		_, err := New([]Subscription{sub}, nil)
+		assert.ErrorIs(t, err, ErrInvalidSubscription)
+	}
+}
+
+func TestKeyringSigning(t *testing.T) {
+	l := &receiverLog{t: t, key: "old", keyID: "k1"}
+	ts := httptest.NewServer(http.HandlerFunc(l.handler))
+	defer ts.Close()
+	path := t.TempDir() + "/keyring.json"
+	f := keyring.File{
+		Current:  "k1",
+		SignKeys: map[string]string{"k1": "old", "k2": "new"},
+	}
+	require.NoError(t, f.Write(path))
+	keys, err := keyring.New(path, "", nil)
+	require.NoError(t, err)
+
+	d, err := New([]Subscription{
+		{
+			URL:       ts.URL,
+			ID:        "PollCount",
+			Condition: AnyChange,
+		},
+	}, keys)
+	require.NoError(t, err)
+	s := mem.NewMetricsStorage()
+	s.OnSet(d.Notify)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	set(t, s, models.CounterType, "PollCount", "1")
+	assert.Eventually(t, func() bool {
+		return len(l.got()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// после перезагрузки связки события подписываются новым текущим ключом
+	f.Current = "k2"
+	require.NoError(t, f.Write(path))
+	require.NoError(t, keys.Reload())
+	l.mutex.Lock()
+	l.key, l.keyID = "new", "k2"
+	l.mutex.Unlock()
+	set(t, s, models.CounterType, "PollCount", "1")
+	assert.Eventually(t, func() bool {
+		return len(l.got()) == 2
+	}, time.Second, 10*time.Millisecond)
+}
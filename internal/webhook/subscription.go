@@ -0,0 +1,93 @@
+// webhook реализует отправку оповещений внешним получателям при изменении метрик
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Nexadis/metalert/internal/alerting"
+	"github.com/Nexadis/metalert/internal/models"
+)
+
+// AnyChange - Условие, которое срабатывает при любом изменении значения метрики
+const AnyChange = "any change"
+
+// Значения по умолчанию для доставки
+var (
+	DefaultTimeout = int64(5)
+	DefaultRetries = 3
+)
+
+var ErrInvalidSubscription = errors.New("invalid subscription")
+
+// Subscription - Подписка на изменения метрик.
+//
+// Метрика выбирается по типу и имени (id) либо по префиксу имени (prefix).
+// Условие задаётся в виде "> 90" или "any change".
+type Subscription struct {
+	Name      string `json:"name,omitempty"`
+	URL       string `json:"url"`
+	MType     string `json:"type,omitempty"`
+	ID        string `json:"id,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	Condition string `json:"condition"`
+	Key       string `json:"key,omitempty"`     // ключ для подписи, по умолчанию используется текущий ключ сервера
+	Timeout   int64  `json:"timeout,omitempty"` // таймаут одной попытки доставки в секундах
+	Retries   int    `json:"retries,omitempty"` // количество повторных попыток
+}
+
+// receiver - Разобранная подписка
+type receiver struct {
+	sub       Subscription
+	cond      alerting.Condition
+	anyChange bool
+	timeout   time.Duration
+	key       string // ключ подписи из подписки
+}
+
+func newReceiver(sub Subscription) (*receiver, error) {
+	if sub.URL == "" {
+		return nil, fmt.Errorf("%w: empty url", ErrInvalidSubscription)
+	}
+	if sub.ID == "" && sub.Prefix == "" {
+		return nil, fmt.Errorf("%w: id or prefix must be set", ErrInvalidSubscription)
+	}
+	sub.MType = strings.ToLower(sub.MType)
+	if sub.Name == "" {
+		sub.Name = sub.URL
+	}
+	if sub.Timeout <= 0 {
+		sub.Timeout = DefaultTimeout
+	}
+	if sub.Retries < 0 {
+		sub.Retries = 0
+	}
+	r := &receiver{
+		sub:     sub,
+		timeout: time.Duration(sub.Timeout) * time.Second,
+		key:     sub.Key,
+	}
+	if strings.TrimSpace(sub.Condition) == AnyChange {
+		r.anyChange = true
+		return r, nil
+	}
+	cond, err := alerting.ParseCondition(sub.Condition)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSubscription, err)
+	}
+	r.cond = cond
+	return r, nil
+}
+
+// match Проверяет, относится ли метрика к подписке
+func (r *receiver) match(m models.Metric) bool {
+	if r.sub.MType != "" && r.sub.MType != m.MType {
+		return false
+	}
+	if r.sub.ID != "" {
+		return r.sub.ID == m.ID
+	}
+	return strings.HasPrefix(m.ID, r.sub.Prefix)
+}
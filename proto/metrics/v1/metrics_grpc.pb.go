@@ -19,8 +19,12 @@ import (
 const _ = grpc.SupportPackageIsVersion7
 
 const (
-	MetricsCollectorService_Get_FullMethodName  = "/proto.metrics.v1.MetricsCollectorService/Get"
-	MetricsCollectorService_Post_FullMethodName = "/proto.metrics.v1.MetricsCollectorService/Post"
+	MetricsCollectorService_Get_FullMethodName        = "/proto.metrics.v1.MetricsCollectorService/Get"
+	MetricsCollectorService_Post_FullMethodName       = "/proto.metrics.v1.MetricsCollectorService/Post"
+	MetricsCollectorService_PostStream_FullMethodName = "/proto.metrics.v1.MetricsCollectorService/PostStream"
+	MetricsCollectorService_Watch_FullMethodName      = "/proto.metrics.v1.MetricsCollectorService/Watch"
+	MetricsCollectorService_GetAlerts_FullMethodName  = "/proto.metrics.v1.MetricsCollectorService/GetAlerts"
+	MetricsCollectorService_Query_FullMethodName      = "/proto.metrics.v1.MetricsCollectorService/Query"
 )
 
 // MetricsCollectorServiceClient is the client API for MetricsCollectorService service.
@@ -29,6 +33,14 @@ const (
 type MetricsCollectorServiceClient interface {
 	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
 	Post(ctx context.Context, in *PostRequest, opts ...grpc.CallOption) (*PostResponse, error)
+	// PostStream принимает пачки метрик в долгоживущем потоке и подтверждает каждую пачку.
+	// Следующая пачка читается только после записи предыдущей.
+	PostStream(ctx context.Context, opts ...grpc.CallOption) (MetricsCollectorService_PostStreamClient, error)
+	// Watch отправляет текущие значения метрик, а затем каждое принятое изменение
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (MetricsCollectorService_WatchClient, error)
+	GetAlerts(ctx context.Context, in *GetAlertsRequest, opts ...grpc.CallOption) (*GetAlertsResponse, error)
+	// Query выполняет запрос на языке выражений над сохранёнными сериями
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
 }
 
 type metricsCollectorServiceClient struct {
@@ -57,12 +69,101 @@ func (c *metricsCollectorServiceClient) Post(ctx context.Context, in *PostReques
 	return out, nil
 }
 
+func (c *metricsCollectorServiceClient) PostStream(ctx context.Context, opts ...grpc.CallOption) (MetricsCollectorService_PostStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MetricsCollectorService_ServiceDesc.Streams[0], MetricsCollectorService_PostStream_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &metricsCollectorServicePostStreamClient{stream}
+	return x, nil
+}
+
+type MetricsCollectorService_PostStreamClient interface {
+	Send(*PostStreamRequest) error
+	Recv() (*PostStreamResponse, error)
+	grpc.ClientStream
+}
+
+type metricsCollectorServicePostStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *metricsCollectorServicePostStreamClient) Send(m *PostStreamRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *metricsCollectorServicePostStreamClient) Recv() (*PostStreamResponse, error) {
+	m := new(PostStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *metricsCollectorServiceClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (MetricsCollectorService_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MetricsCollectorService_ServiceDesc.Streams[1], MetricsCollectorService_Watch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &metricsCollectorServiceWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MetricsCollectorService_WatchClient interface {
+	Recv() (*WatchResponse, error)
+	grpc.ClientStream
+}
+
+type metricsCollectorServiceWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *metricsCollectorServiceWatchClient) Recv() (*WatchResponse, error) {
+	m := new(WatchResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *metricsCollectorServiceClient) GetAlerts(ctx context.Context, in *GetAlertsRequest, opts ...grpc.CallOption) (*GetAlertsResponse, error) {
+	out := new(GetAlertsResponse)
+	err := c.cc.Invoke(ctx, MetricsCollectorService_GetAlerts_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsCollectorServiceClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error) {
+	out := new(QueryResponse)
+	err := c.cc.Invoke(ctx, MetricsCollectorService_Query_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetricsCollectorServiceServer is the server API for MetricsCollectorService service.
 // All implementations must embed UnimplementedMetricsCollectorServiceServer
 // for forward compatibility
 type MetricsCollectorServiceServer interface {
 	Get(context.Context, *GetRequest) (*GetResponse, error)
 	Post(context.Context, *PostRequest) (*PostResponse, error)
+	// PostStream принимает пачки метрик в долгоживущем потоке и подтверждает каждую пачку.
+	// Следующая пачка читается только после записи предыдущей.
+	PostStream(MetricsCollectorService_PostStreamServer) error
+	// Watch отправляет текущие значения метрик, а затем каждое принятое изменение
+	Watch(*WatchRequest, MetricsCollectorService_WatchServer) error
+	GetAlerts(context.Context, *GetAlertsRequest) (*GetAlertsResponse, error)
+	// Query выполняет запрос на языке выражений над сохранёнными сериями
+	Query(context.Context, *QueryRequest) (*QueryResponse, error)
 	mustEmbedUnimplementedMetricsCollectorServiceServer()
 }
 
@@ -76,6 +177,18 @@ func (UnimplementedMetricsCollectorServiceServer) Get(context.Context, *GetReque
 func (UnimplementedMetricsCollectorServiceServer) Post(context.Context, *PostRequest) (*PostResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Post not implemented")
 }
+func (UnimplementedMetricsCollectorServiceServer) PostStream(MetricsCollectorService_PostStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method PostStream not implemented")
+}
+func (UnimplementedMetricsCollectorServiceServer) Watch(*WatchRequest, MetricsCollectorService_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedMetricsCollectorServiceServer) GetAlerts(context.Context, *GetAlertsRequest) (*GetAlertsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAlerts not implemented")
+}
+func (UnimplementedMetricsCollectorServiceServer) Query(context.Context, *QueryRequest) (*QueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Query not implemented")
+}
 func (UnimplementedMetricsCollectorServiceServer) mustEmbedUnimplementedMetricsCollectorServiceServer() {
 }
 
@@ -126,6 +239,89 @@ func _MetricsCollectorService_Post_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MetricsCollectorService_PostStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MetricsCollectorServiceServer).PostStream(&metricsCollectorServicePostStreamServer{stream})
+}
+
+type MetricsCollectorService_PostStreamServer interface {
+	Send(*PostStreamResponse) error
+	Recv() (*PostStreamRequest, error)
+	grpc.ServerStream
+}
+
+type metricsCollectorServicePostStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *metricsCollectorServicePostStreamServer) Send(m *PostStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *metricsCollectorServicePostStreamServer) Recv() (*PostStreamRequest, error) {
+	m := new(PostStreamRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _MetricsCollectorService_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MetricsCollectorServiceServer).Watch(m, &metricsCollectorServiceWatchServer{stream})
+}
+
+type MetricsCollectorService_WatchServer interface {
+	Send(*WatchResponse) error
+	grpc.ServerStream
+}
+
+type metricsCollectorServiceWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *metricsCollectorServiceWatchServer) Send(m *WatchResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MetricsCollectorService_GetAlerts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAlertsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsCollectorServiceServer).GetAlerts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsCollectorService_GetAlerts_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsCollectorServiceServer).GetAlerts(ctx, req.(*GetAlertsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MetricsCollectorService_Query_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsCollectorServiceServer).Query(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MetricsCollectorService_Query_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsCollectorServiceServer).Query(ctx, req.(*QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // MetricsCollectorService_ServiceDesc is the grpc.ServiceDesc for MetricsCollectorService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -141,7 +337,27 @@ var MetricsCollectorService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Post",
 			Handler:    _MetricsCollectorService_Post_Handler,
 		},
+		{
+			MethodName: "GetAlerts",
+			Handler:    _MetricsCollectorService_GetAlerts_Handler,
+		},
+		{
+			MethodName: "Query",
+			Handler:    _MetricsCollectorService_Query_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PostStream",
+			Handler:       _MetricsCollectorService_PostStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _MetricsCollectorService_Watch_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/metrics/v1/metrics.proto",
 }